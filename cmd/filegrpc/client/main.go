@@ -13,6 +13,7 @@ import (
 	"github.com/zikwall/grower/config"
 	"github.com/zikwall/grower/internal/services/filegrpc"
 	stdout "github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
 	"github.com/zikwall/grower/pkg/signal"
 )
 
@@ -32,6 +33,88 @@ func main() {
 				Usage:   "Connect to host",
 				EnvVars: []string{"GRPC_CONN_ADDRESS"},
 			},
+			&cli.StringFlag{
+				Name:    "grpc-protocol",
+				Value:   filegrpc.ProtocolV2,
+				Usage:   "Protocol v2 sends acknowledged batches over one stream, v1 sends lines to old servers and closes stream every batch",
+				EnvVars: []string{"GRPC_PROTOCOL"},
+			},
+			&cli.IntFlag{
+				Name:    "grpc-batch-size",
+				Value:   1000,
+				Usage:   "Max number of lines in batch, v1 stream is closed and acknowledged after this number of lines",
+				EnvVars: []string{"GRPC_BATCH_SIZE"},
+			},
+			&cli.DurationFlag{
+				Name:    "grpc-batch-interval",
+				Value:   time.Second,
+				Usage:   "Incomplete batch is sent after this interval",
+				EnvVars: []string{"GRPC_BATCH_INTERVAL"},
+			},
+			&cli.IntFlag{
+				Name:    "grpc-max-inflight",
+				Value:   10,
+				Usage:   "Max number of unacknowledged batches of one stream",
+				EnvVars: []string{"GRPC_MAX_INFLIGHT"},
+			},
+			&cli.DurationFlag{
+				Name:    "grpc-retransmit-delay",
+				Value:   5 * time.Second,
+				Usage:   "Initial delay before broken stream is re-created, unacknowledged batches and lines are retransmitted over new stream",
+				EnvVars: []string{"GRPC_RETRANSMIT_DELAY"},
+			},
+			&cli.DurationFlag{
+				Name:    "grpc-reconnect-max-delay",
+				Value:   time.Minute,
+				Usage:   "Max delay between attempts to re-create broken stream, delay grows exponentially with jitter",
+				EnvVars: []string{"GRPC_RECONNECT_MAX_DELAY"},
+			},
+			&cli.IntFlag{
+				Name:    "grpc-queue-size",
+				Value:   10000,
+				Usage:   "Max number of read lines waiting for streams, reading is paused only when queue is full",
+				EnvVars: []string{"GRPC_QUEUE_SIZE"},
+			},
+			&cli.BoolFlag{
+				Name:    "grpc-tls",
+				Usage:   "Connect to server over TLS",
+				EnvVars: []string{"GRPC_TLS"},
+			},
+			&cli.StringFlag{
+				Name:    "grpc-tls-cert-file",
+				Usage:   "TLS certificate file of client for mTLS, it is reloaded after change",
+				EnvVars: []string{"GRPC_TLS_CERT_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "grpc-tls-key-file",
+				Usage:   "TLS key file of client for mTLS, it is reloaded after change",
+				EnvVars: []string{"GRPC_TLS_KEY_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "grpc-tls-ca-file",
+				Usage:   "CA file which is the only trusted root of server certificate, system roots are used by default",
+				EnvVars: []string{"GRPC_TLS_CA_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "grpc-tls-server-name",
+				Usage:   "Server name to verify certificate, host of connect address by default",
+				EnvVars: []string{"GRPC_TLS_SERVER_NAME"},
+			},
+			&cli.StringFlag{
+				Name:    "grpc-auth-scheme",
+				Usage:   "Send token of client to server over TLS: bearer or hmac, disabled by default",
+				EnvVars: []string{"GRPC_AUTH_SCHEME"},
+			},
+			&cli.StringFlag{
+				Name:    "grpc-auth-token-file",
+				Usage:   "File of client token, it is reloaded after change",
+				EnvVars: []string{"GRPC_AUTH_TOKEN_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "grpc-auth-client",
+				Usage:   "Client name of token file of server, signed by hmac scheme, hostname by default",
+				EnvVars: []string{"GRPC_AUTH_CLIENT"},
+			},
 			&cli.StringFlag{
 				Name:    "logs-dir",
 				Value:   "/var/log/nginx",
@@ -90,6 +173,12 @@ func main() {
 				EnvVars: []string{"SKIP_NGINX_REOPEN"},
 				Value:   false,
 			},
+			&cli.StringFlag{
+				Name:    "offset-file",
+				Value:   "/var/lib/grower/filegrpc_client_offset.json",
+				Usage:   "File of persisted offsets of sent lines, reading is resumed from them after restart",
+				EnvVars: []string{"OFFSET_FILE"},
+			},
 			&cli.BoolFlag{
 				Name:    "auto-create-target-from-scratch",
 				EnvVars: []string{"AUTO_CREATE_TARGET_FROM_SCRATCH"},
@@ -117,6 +206,13 @@ func Main(ctx *cli.Context) error {
 	}()
 	instance, err := filegrpc.NewClient(appContext, &filegrpc.ClientOpt{
 		ConnectAddress:              ctx.String("grpc-conn-address"),
+		Protocol:                    ctx.String("grpc-protocol"),
+		BatchSize:                   ctx.Int("grpc-batch-size"),
+		BatchInterval:               ctx.Duration("grpc-batch-interval"),
+		MaxInflight:                 ctx.Int("grpc-max-inflight"),
+		RetransmitDelay:             ctx.Duration("grpc-retransmit-delay"),
+		ReconnectMaxDelay:           ctx.Duration("grpc-reconnect-max-delay"),
+		QueueSize:                   ctx.Int("grpc-queue-size"),
 		LogsDir:                     ctx.String("logs-dir"),
 		SourceLogFile:               ctx.String("source-log-file"),
 		ScrapeInterval:              ctx.Duration("scrape-interval"),
@@ -126,10 +222,23 @@ func Main(ctx *cli.Context) error {
 		AutoCreateTargetFromScratch: ctx.Bool("auto-create-target-from-scratch"),
 		RunAtStartup:                ctx.Bool("run-rotating-at-startup"),
 		SkipNginxReopen:             ctx.Bool("skip-nginx-reopen"),
+		OffsetFile:                  ctx.String("offset-file"),
 		Runtime: config.Runtime{
 			Parallelism: ctx.Int("parallelism"),
 			Debug:       ctx.Bool("debug"),
 		},
+		TLS: config.TLS{
+			Enabled:    ctx.Bool("grpc-tls"),
+			CertFile:   ctx.String("grpc-tls-cert-file"),
+			KeyFile:    ctx.String("grpc-tls-key-file"),
+			CAFile:     ctx.String("grpc-tls-ca-file"),
+			ServerName: ctx.String("grpc-tls-server-name"),
+		},
+		Auth: config.Auth{
+			Scheme:    ctx.String("grpc-auth-scheme"),
+			TokenFile: ctx.String("grpc-auth-token-file"),
+			Client:    ctx.String("grpc-auth-client"),
+		},
 	})
 	if err != nil {
 		return err
@@ -153,6 +262,13 @@ func Main(ctx *cli.Context) error {
 			app.Get("/live", func(ctx *fiber.Ctx) error {
 				return ctx.Status(200).SendString("Alive")
 			})
+			app.Get("/ready", func(ctx *fiber.Ctx) error {
+				if !instance.Ready() {
+					return ctx.Status(503).SendString("No open streams to server")
+				}
+				return ctx.Status(200).SendString("Ready")
+			})
+			app.Get("/metrics", metrics.Handler)
 			ln, err := signal.Listener(
 				instance.Context(), signal.ListenerTCP, "", ctx.String("bind-address"),
 			)
@@ -16,7 +16,9 @@ import (
 
 	"github.com/zikwall/grower/config"
 	"github.com/zikwall/grower/internal/services/filegrpc"
+	"github.com/zikwall/grower/pkg/handler"
 	stdout "github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
 	"github.com/zikwall/grower/pkg/signal"
 	"github.com/zikwall/grower/protobuf/filebuf"
 )
@@ -101,6 +103,102 @@ func main() {
 				EnvVars: []string{"RUN_HTTP_SERVER"},
 				Value:   false,
 			},
+			&cli.StringFlag{
+				Name:    "dead-letter-sink",
+				Usage:   "Sink for lines that failed parsing or casting: file, clickhouse, kafka, disabled by default",
+				EnvVars: []string{"DEAD_LETTER_SINK"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-file",
+				Value:   "/var/log/grower/dead_letter.log",
+				Usage:   "Dead letter file path",
+				EnvVars: []string{"DEAD_LETTER_FILE"},
+			},
+			&cli.Int64Flag{
+				Name:    "dead-letter-file-max-size",
+				Value:   100 << 20,
+				Usage:   "Dead letter file max size in bytes before rotation",
+				EnvVars: []string{"DEAD_LETTER_FILE_MAX_SIZE"},
+			},
+			&cli.UintFlag{
+				Name:    "dead-letter-file-backups",
+				Value:   5,
+				Usage:   "Count of dead letter backup files",
+				EnvVars: []string{"DEAD_LETTER_FILE_BACKUPS"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-table",
+				Usage:   "Clickhouse dead letter table, see migrations/dead_letter.sql",
+				EnvVars: []string{"DEAD_LETTER_TABLE"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "dead-letter-kafka-brokers",
+				Usage:   "Dead letter kafka brokers",
+				EnvVars: []string{"DEAD_LETTER_KAFKA_BROKERS"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-kafka-topic",
+				Usage:   "Dead letter kafka topic",
+				EnvVars: []string{"DEAD_LETTER_KAFKA_TOPIC"},
+			},
+			&cli.StringFlag{
+				Name:    "spool-dir",
+				Usage:   "Directory of disk spool for batches which can't be inserted to clickhouse, disabled by default",
+				EnvVars: []string{"SPOOL_DIR"},
+			},
+			&cli.Int64Flag{
+				Name:    "spool-max-size",
+				Value:   1 << 30,
+				Usage:   "Disk spool max size in bytes, the oldest segments are removed when it is exceeded",
+				EnvVars: []string{"SPOOL_MAX_SIZE"},
+			},
+			&cli.DurationFlag{
+				Name:    "spool-max-age",
+				Value:   24 * time.Hour,
+				Usage:   "Disk spool segment max age, older segments are removed",
+				EnvVars: []string{"SPOOL_MAX_AGE"},
+			},
+			&cli.Int64Flag{
+				Name:    "spool-max-pending-rows",
+				Usage:   "Max rows waiting for insert to clickhouse, next batches are written to disk spool, zero means no limit",
+				EnvVars: []string{"SPOOL_MAX_PENDING_ROWS"},
+			},
+			&cli.BoolFlag{
+				Name:    "grpc-tls",
+				Usage:   "Serve gRPC over TLS",
+				EnvVars: []string{"GRPC_TLS"},
+			},
+			&cli.StringFlag{
+				Name:    "grpc-tls-cert-file",
+				Usage:   "TLS certificate file of server, it is reloaded after change",
+				EnvVars: []string{"GRPC_TLS_CERT_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "grpc-tls-key-file",
+				Usage:   "TLS key file of server, it is reloaded after change",
+				EnvVars: []string{"GRPC_TLS_KEY_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "grpc-tls-ca-file",
+				Usage:   "CA file to verify client certificates (mTLS), clients without certificate are rejected if it is provided",
+				EnvVars: []string{"GRPC_TLS_CA_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "grpc-auth-scheme",
+				Usage:   "Authenticate clients by tokens: bearer or hmac, disabled by default",
+				EnvVars: []string{"GRPC_AUTH_SCHEME"},
+			},
+			&cli.StringFlag{
+				Name:    "grpc-auth-token-file",
+				Usage:   "File of client name and token per line, it is reloaded after change",
+				EnvVars: []string{"GRPC_AUTH_TOKEN_FILE"},
+			},
+			&cli.BoolFlag{
+				Name:    "validate-only",
+				Usage:   "Validate columns of config against tables of Clickhouse and exit",
+				EnvVars: []string{"VALIDATE_ONLY"},
+				Value:   false,
+			},
 			&cli.BoolFlag{
 				Name:    "debug",
 				EnvVars: []string{"DEBUG"},
@@ -121,27 +219,36 @@ func Main(ctx *cli.Context) error {
 		<-time.After(time.Second)
 		stdout.Info("app context is canceled, service is down!")
 	}()
-	yamlConfig, err := config.New(ctx.String("config-file"))
+	yamlConfig, err := config.NewSingle(ctx.String("config-file"))
 	if err != nil {
 		return err
 	}
-	instance, err := filegrpc.NewServer(appContext, &filegrpc.ServerOpt{
-		Clickhouse: &clickhouse.Options{
-			Addr: ctx.StringSlice("clickhouse-host"),
-			Auth: clickhouse.Auth{
-				Database: ctx.String("clickhouse-database"),
-				Username: ctx.String("clickhouse-username"),
-				Password: ctx.String("clickhouse-password"),
-			},
-			Settings: clickhouse.Settings{
-				"max_execution_time": 60,
-			},
-			DialTimeout: 5 * time.Second,
-			Compression: &clickhouse.Compression{
-				Method: clickhouse.CompressionLZ4,
-			},
-			Debug: ctx.Bool("debug"),
+	clickhouseOptions := &clickhouse.Options{
+		Addr: ctx.StringSlice("clickhouse-host"),
+		Auth: clickhouse.Auth{
+			Database: ctx.String("clickhouse-database"),
+			Username: ctx.String("clickhouse-user"),
+			Password: ctx.String("clickhouse-password"),
 		},
+		Settings: clickhouse.Settings{
+			"max_execution_time": 60,
+		},
+		DialTimeout: 5 * time.Second,
+		Compression: &clickhouse.Compression{
+			Method: clickhouse.CompressionLZ4,
+		},
+		Debug: ctx.Bool("debug"),
+	}
+	// columns of config are checked against tables before start, so mistakes are not found by failing inserts
+	if err := handler.Validate(appContext, clickhouseOptions, yamlConfig); err != nil {
+		return err
+	}
+	if ctx.Bool("validate-only") {
+		stdout.Info("config is valid")
+		return nil
+	}
+	opt := &filegrpc.ServerOpt{
+		Clickhouse: clickhouseOptions,
 		Runtime: config.Runtime{
 			Parallelism:  ctx.Int("parallelism"),
 			WriteTimeout: ctx.Duration("write-timeout"),
@@ -151,9 +258,39 @@ func Main(ctx *cli.Context) error {
 			BufSize:          ctx.Uint("buffer-size"),
 			BufFlushInterval: ctx.Uint("buffer-flush-interval"),
 		},
+		DeadLetter: config.DeadLetter{
+			Sink:         ctx.String("dead-letter-sink"),
+			File:         ctx.String("dead-letter-file"),
+			FileMaxSize:  ctx.Int64("dead-letter-file-max-size"),
+			FileBackups:  ctx.Uint("dead-letter-file-backups"),
+			Table:        ctx.String("dead-letter-table"),
+			KafkaBrokers: ctx.StringSlice("dead-letter-kafka-brokers"),
+			KafkaTopic:   ctx.String("dead-letter-kafka-topic"),
+		},
+		Spool: config.Spool{
+			Dir:            ctx.String("spool-dir"),
+			MaxSize:        ctx.Int64("spool-max-size"),
+			MaxAge:         ctx.Duration("spool-max-age"),
+			MaxPendingRows: ctx.Int64("spool-max-pending-rows"),
+		},
+		TLS: config.TLS{
+			Enabled:  ctx.Bool("grpc-tls"),
+			CertFile: ctx.String("grpc-tls-cert-file"),
+			KeyFile:  ctx.String("grpc-tls-key-file"),
+			CAFile:   ctx.String("grpc-tls-ca-file"),
+		},
+		Auth: config.Auth{
+			Scheme:    ctx.String("grpc-auth-scheme"),
+			TokenFile: ctx.String("grpc-auth-token-file"),
+		},
 		Config:      yamlConfig,
 		BindAddress: ctx.String("grpc-bind-address"),
-	})
+	}
+	serverOptions, err := filegrpc.ServerOptions(opt)
+	if err != nil {
+		return err
+	}
+	instance, err := filegrpc.NewServer(appContext, opt)
 	if err != nil {
 		return err
 	}
@@ -176,6 +313,7 @@ func Main(ctx *cli.Context) error {
 			app.Get("/live", func(ctx *fiber.Ctx) error {
 				return ctx.Status(200).SendString("Alive")
 			})
+			app.Get("/metrics", metrics.Handler)
 			ln, err := signal.Listener(
 				instance.Context(), signal.ListenerTCP, "", ctx.String("bind-address"),
 			)
@@ -189,7 +327,7 @@ func Main(ctx *cli.Context) error {
 		}()
 	}
 	// register and launch gRPC server
-	server := grpc.NewServer([]grpc.ServerOption{}...)
+	server := grpc.NewServer(serverOptions...)
 	filebuf.RegisterFileBufferServiceServer(server, instance)
 	defer func() {
 		server.Stop()
@@ -13,7 +13,9 @@ import (
 
 	"github.com/zikwall/grower/config"
 	"github.com/zikwall/grower/internal/services/filelog"
+	"github.com/zikwall/grower/pkg/handler"
 	stdout "github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
 	"github.com/zikwall/grower/pkg/signal"
 )
 
@@ -43,7 +45,7 @@ func main() {
 			&cli.StringFlag{
 				Name:    "source-log-file",
 				Value:   "access.log",
-				Usage:   "Source log file name",
+				Usage:   "Source log file name, sources of pipelines section of config are used instead when it is provided",
 				EnvVars: []string{"TARGET_LOG_FILE"},
 			},
 			&cli.IntFlag{
@@ -53,6 +55,24 @@ func main() {
 				Value:    runtime.NumCPU(),
 				EnvVars:  []string{"PARALLELISM"},
 			},
+			&cli.StringFlag{
+				Name:    "read-mode",
+				Value:   filelog.ReadModeRotate,
+				Usage:   "Log file reading mode: rotate - rename file and reopen nginx every scrape interval, tail - follow file like tail -F",
+				EnvVars: []string{"READ_MODE"},
+			},
+			&cli.StringFlag{
+				Name:    "offset-file",
+				Value:   "/var/lib/grower/offset.json",
+				Usage:   "File of persisted offsets of delivered lines, reading is resumed from them after restart",
+				EnvVars: []string{"OFFSET_FILE"},
+			},
+			&cli.DurationFlag{
+				Name:    "poll-interval",
+				Value:   time.Second,
+				Usage:   "Log file poll interval in tail mode, file changes are also tracked by inotify on linux",
+				EnvVars: []string{"POLL_INTERVAL"},
+			},
 			&cli.DurationFlag{
 				Name:    "scrape-interval",
 				Value:   time.Duration(60000) * time.Millisecond,
@@ -142,6 +162,72 @@ func main() {
 				EnvVars: []string{"AUTO_CREATE_TARGET_FROM_SCRATCH"},
 				Value:   false,
 			},
+			&cli.StringFlag{
+				Name:    "dead-letter-sink",
+				Usage:   "Sink for lines that failed parsing or casting: file, clickhouse, kafka, disabled by default",
+				EnvVars: []string{"DEAD_LETTER_SINK"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-file",
+				Value:   "/var/log/grower/dead_letter.log",
+				Usage:   "Dead letter file path",
+				EnvVars: []string{"DEAD_LETTER_FILE"},
+			},
+			&cli.Int64Flag{
+				Name:    "dead-letter-file-max-size",
+				Value:   100 << 20,
+				Usage:   "Dead letter file max size in bytes before rotation",
+				EnvVars: []string{"DEAD_LETTER_FILE_MAX_SIZE"},
+			},
+			&cli.UintFlag{
+				Name:    "dead-letter-file-backups",
+				Value:   5,
+				Usage:   "Count of dead letter backup files",
+				EnvVars: []string{"DEAD_LETTER_FILE_BACKUPS"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-table",
+				Usage:   "Clickhouse dead letter table, see migrations/dead_letter.sql",
+				EnvVars: []string{"DEAD_LETTER_TABLE"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "dead-letter-kafka-brokers",
+				Usage:   "Dead letter kafka brokers",
+				EnvVars: []string{"DEAD_LETTER_KAFKA_BROKERS"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-kafka-topic",
+				Usage:   "Dead letter kafka topic",
+				EnvVars: []string{"DEAD_LETTER_KAFKA_TOPIC"},
+			},
+			&cli.StringFlag{
+				Name:    "spool-dir",
+				Usage:   "Directory of disk spool for batches which can't be inserted to clickhouse, disabled by default",
+				EnvVars: []string{"SPOOL_DIR"},
+			},
+			&cli.Int64Flag{
+				Name:    "spool-max-size",
+				Value:   1 << 30,
+				Usage:   "Disk spool max size in bytes, the oldest segments are removed when it is exceeded",
+				EnvVars: []string{"SPOOL_MAX_SIZE"},
+			},
+			&cli.DurationFlag{
+				Name:    "spool-max-age",
+				Value:   24 * time.Hour,
+				Usage:   "Disk spool segment max age, older segments are removed",
+				EnvVars: []string{"SPOOL_MAX_AGE"},
+			},
+			&cli.Int64Flag{
+				Name:    "spool-max-pending-rows",
+				Usage:   "Max rows waiting for insert to clickhouse, next batches are written to disk spool, zero means no limit",
+				EnvVars: []string{"SPOOL_MAX_PENDING_ROWS"},
+			},
+			&cli.BoolFlag{
+				Name:    "validate-only",
+				Usage:   "Validate columns of config against tables of Clickhouse and exit",
+				EnvVars: []string{"VALIDATE_ONLY"},
+				Value:   false,
+			},
 			&cli.BoolFlag{
 				Name:    "debug",
 				EnvVars: []string{"DEBUG"},
@@ -166,23 +252,32 @@ func Main(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	instance, err := filelog.New(appContext, &filelog.Opt{
-		Clickhouse: &clickhouse.Options{
-			Addr: ctx.StringSlice("clickhouse-host"),
-			Auth: clickhouse.Auth{
-				Database: ctx.String("clickhouse-database"),
-				Username: ctx.String("clickhouse-username"),
-				Password: ctx.String("clickhouse-password"),
-			},
-			Settings: clickhouse.Settings{
-				"max_execution_time": 60,
-			},
-			DialTimeout: 5 * time.Second,
-			Compression: &clickhouse.Compression{
-				Method: clickhouse.CompressionLZ4,
-			},
-			Debug: ctx.Bool("debug"),
+	clickhouseOptions := &clickhouse.Options{
+		Addr: ctx.StringSlice("clickhouse-host"),
+		Auth: clickhouse.Auth{
+			Database: ctx.String("clickhouse-database"),
+			Username: ctx.String("clickhouse-user"),
+			Password: ctx.String("clickhouse-password"),
+		},
+		Settings: clickhouse.Settings{
+			"max_execution_time": 60,
+		},
+		DialTimeout: 5 * time.Second,
+		Compression: &clickhouse.Compression{
+			Method: clickhouse.CompressionLZ4,
 		},
+		Debug: ctx.Bool("debug"),
+	}
+	// columns of config are checked against tables before start, so mistakes are not found by failing inserts
+	if err := handler.Validate(appContext, clickhouseOptions, yamlConfig); err != nil {
+		return err
+	}
+	if ctx.Bool("validate-only") {
+		stdout.Info("config is valid")
+		return nil
+	}
+	instance, err := filelog.New(appContext, &filelog.Opt{
+		Clickhouse: clickhouseOptions,
 		FileLogConfig: &filelog.Cfg{
 			LogsDir:                     ctx.String("logs-dir"),
 			SourceLogFile:               ctx.String("source-log-file"),
@@ -193,6 +288,9 @@ func Main(ctx *cli.Context) error {
 			AutoCreateTargetFromScratch: ctx.Bool("auto-create-target-from-scratch"),
 			RunAtStartup:                ctx.Bool("run-rotating-at-startup"),
 			SkipNginxReopen:             ctx.Bool("skip-nginx-reopen"),
+			ReadMode:                    ctx.String("read-mode"),
+			OffsetFile:                  ctx.String("offset-file"),
+			PollInterval:                ctx.Duration("poll-interval"),
 			Runtime: config.Runtime{
 				Parallelism:  ctx.Int("parallelism"),
 				WriteTimeout: ctx.Duration("write-timeout"),
@@ -202,6 +300,21 @@ func Main(ctx *cli.Context) error {
 				BufSize:          ctx.Uint("buffer-size"),
 				BufFlushInterval: ctx.Uint("buffer-flush-interval"),
 			},
+			DeadLetter: config.DeadLetter{
+				Sink:         ctx.String("dead-letter-sink"),
+				File:         ctx.String("dead-letter-file"),
+				FileMaxSize:  ctx.Int64("dead-letter-file-max-size"),
+				FileBackups:  ctx.Uint("dead-letter-file-backups"),
+				Table:        ctx.String("dead-letter-table"),
+				KafkaBrokers: ctx.StringSlice("dead-letter-kafka-brokers"),
+				KafkaTopic:   ctx.String("dead-letter-kafka-topic"),
+			},
+			Spool: config.Spool{
+				Dir:            ctx.String("spool-dir"),
+				MaxSize:        ctx.Int64("spool-max-size"),
+				MaxAge:         ctx.Duration("spool-max-age"),
+				MaxPendingRows: ctx.Int64("spool-max-pending-rows"),
+			},
 		},
 		Config: yamlConfig,
 	})
@@ -227,6 +340,7 @@ func Main(ctx *cli.Context) error {
 			app.Get("/live", func(ctx *fiber.Ctx) error {
 				return ctx.Status(200).SendString("Alive")
 			})
+			app.Get("/metrics", metrics.Handler)
 			ln, err := signal.Listener(
 				instance.Context(), signal.ListenerTCP, "", ctx.String("bind-address"),
 			)
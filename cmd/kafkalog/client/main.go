@@ -6,10 +6,12 @@ import (
 	"os"
 	"time"
 
+	"github.com/gofiber/fiber/v2"
 	"github.com/urfave/cli/v2"
 
 	"github.com/zikwall/grower/internal/services/kafkalog"
 	stdout "github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
 	"github.com/zikwall/grower/pkg/signal"
 )
 
@@ -51,6 +53,12 @@ func main() {
 				Usage:   "Kafka write timeout",
 				EnvVars: []string{"KAFKA_WRITE_TIMEOUT"},
 			},
+			&cli.StringFlag{
+				Name:    "bind-address",
+				Value:   "0.0.0.0:3000",
+				Usage:   "Run HTTP server in host",
+				EnvVars: []string{"BIND_ADDRESS"},
+			},
 			&cli.StringFlag{
 				Name:    "logs-dir",
 				Value:   "/var/log/nginx",
@@ -113,6 +121,12 @@ func main() {
 				EnvVars: []string{"SKIP_NGINX_REOPEN"},
 				Value:   false,
 			},
+			&cli.StringFlag{
+				Name:    "offset-file",
+				Value:   "/var/lib/grower/kafkalog_client_offset.json",
+				Usage:   "File of persisted offsets of sent lines, reading is resumed from them after restart",
+				EnvVars: []string{"OFFSET_FILE"},
+			},
 			&cli.BoolFlag{
 				Name:    "rewrite-nginx-local-time",
 				EnvVars: []string{"REWRITE_NGINX_LOCAL_TIME"},
@@ -153,6 +167,7 @@ func Main(ctx *cli.Context) error {
 			AutoCreateTargetFromScratch: ctx.Bool("auto-create-target-from-scratch"),
 			RunAtStartup:                ctx.Bool("run-at-startup"),
 			SkipNginxReopen:             ctx.Bool("skip-nginx-reopen"),
+			OffsetFile:                  ctx.String("offset-file"),
 			RewriteNginxLocalTime:       ctx.Bool("rewrite-nginx-local-time"),
 		},
 		KafkaBrokers: ctx.StringSlice("kafka-brokers"),
@@ -169,9 +184,32 @@ func Main(ctx *cli.Context) error {
 		})
 		instance.Stacktrace()
 	}()
-	await, _ := signal.Notifier(func() {
+	await, stop := signal.Notifier(func() {
 		stdout.Info("received a system signal to shut down Kafka writer, start the shutdown process..")
 	})
+	// HTTP server is needed mainly to track viability of the service and for metrics such as prometheus
+	if ctx.Bool("run-http-server") {
+		// run HTTP server
+		go func() {
+			app := fiber.New(fiber.Config{
+				ServerHeader: "Grower KafkaLog Client",
+			})
+			app.Get("/live", func(ctx *fiber.Ctx) error {
+				return ctx.Status(200).SendString("Alive")
+			})
+			app.Get("/metrics", metrics.Handler)
+			ln, err := signal.Listener(
+				instance.Context(), signal.ListenerTCP, "", ctx.String("bind-address"),
+			)
+			if err != nil {
+				stop(err)
+				return
+			}
+			if err := app.Listener(ln); err != nil {
+				stop(err)
+			}
+		}()
+	}
 	stdout.Info("congratulations, kafka writer service has been successfully launched")
 	instance.Run(instance.Context())
 	return await()
@@ -8,12 +8,14 @@ import (
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
-
+	"github.com/gofiber/fiber/v2"
 	"github.com/urfave/cli/v2"
 
 	"github.com/zikwall/grower/config"
 	"github.com/zikwall/grower/internal/services/kafkalog"
+	"github.com/zikwall/grower/pkg/handler"
 	stdout "github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
 	"github.com/zikwall/grower/pkg/signal"
 )
 
@@ -28,6 +30,12 @@ func main() {
 				EnvVars:  []string{"CONFIG_FILE"},
 				FilePath: "/srv/vp_secret/config_file",
 			},
+			&cli.StringFlag{
+				Name:    "bind-address",
+				Value:   "0.0.0.0:3000",
+				Usage:   "Run HTTP server in host",
+				EnvVars: []string{"BIND_ADDRESS"},
+			},
 			&cli.StringFlag{
 				Name:     "kafka-topic",
 				Required: true,
@@ -96,6 +104,77 @@ func main() {
 				EnvVars:  []string{"CLICKHOUSE_DATABASE"},
 				FilePath: "/srv/vp_secret/clickhouse_database",
 			},
+			&cli.StringFlag{
+				Name:    "dead-letter-sink",
+				Usage:   "Sink for lines that failed parsing or casting: file, clickhouse, kafka, disabled by default",
+				EnvVars: []string{"DEAD_LETTER_SINK"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-file",
+				Value:   "/var/log/grower/dead_letter.log",
+				Usage:   "Dead letter file path",
+				EnvVars: []string{"DEAD_LETTER_FILE"},
+			},
+			&cli.Int64Flag{
+				Name:    "dead-letter-file-max-size",
+				Value:   100 << 20,
+				Usage:   "Dead letter file max size in bytes before rotation",
+				EnvVars: []string{"DEAD_LETTER_FILE_MAX_SIZE"},
+			},
+			&cli.UintFlag{
+				Name:    "dead-letter-file-backups",
+				Value:   5,
+				Usage:   "Count of dead letter backup files",
+				EnvVars: []string{"DEAD_LETTER_FILE_BACKUPS"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-table",
+				Usage:   "Clickhouse dead letter table, see migrations/dead_letter.sql",
+				EnvVars: []string{"DEAD_LETTER_TABLE"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "dead-letter-kafka-brokers",
+				Usage:   "Dead letter kafka brokers",
+				EnvVars: []string{"DEAD_LETTER_KAFKA_BROKERS"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-kafka-topic",
+				Usage:   "Dead letter kafka topic",
+				EnvVars: []string{"DEAD_LETTER_KAFKA_TOPIC"},
+			},
+			&cli.StringFlag{
+				Name:    "spool-dir",
+				Usage:   "Directory of disk spool for batches which can't be inserted to clickhouse, disabled by default",
+				EnvVars: []string{"SPOOL_DIR"},
+			},
+			&cli.Int64Flag{
+				Name:    "spool-max-size",
+				Value:   1 << 30,
+				Usage:   "Disk spool max size in bytes, the oldest segments are removed when it is exceeded",
+				EnvVars: []string{"SPOOL_MAX_SIZE"},
+			},
+			&cli.DurationFlag{
+				Name:    "spool-max-age",
+				Value:   24 * time.Hour,
+				Usage:   "Disk spool segment max age, older segments are removed",
+				EnvVars: []string{"SPOOL_MAX_AGE"},
+			},
+			&cli.Int64Flag{
+				Name:    "spool-max-pending-rows",
+				Usage:   "Max rows waiting for insert to clickhouse, next batches are written to disk spool, zero means no limit",
+				EnvVars: []string{"SPOOL_MAX_PENDING_ROWS"},
+			},
+			&cli.BoolFlag{
+				Name:    "run-http-server",
+				EnvVars: []string{"RUN_HTTP_SERVER"},
+				Value:   false,
+			},
+			&cli.BoolFlag{
+				Name:    "validate-only",
+				Usage:   "Validate columns of config against tables of Clickhouse and exit",
+				EnvVars: []string{"VALIDATE_ONLY"},
+				Value:   false,
+			},
 			&cli.BoolFlag{
 				Name:    "debug",
 				EnvVars: []string{"DEBUG"},
@@ -116,32 +195,56 @@ func Main(ctx *cli.Context) error {
 		<-time.After(time.Second)
 		stdout.Info("app context is canceled, service is down!")
 	}()
-	yamlConfig, err := config.New(ctx.String("config-file"))
+	yamlConfig, err := config.NewSingle(ctx.String("config-file"))
 	if err != nil {
 		return err
 	}
+	clickhouseOptions := &clickhouse.Options{
+		Addr: ctx.StringSlice("clickhouse-host"),
+		Auth: clickhouse.Auth{
+			Database: ctx.String("clickhouse-database"),
+			Username: ctx.String("clickhouse-user"),
+			Password: ctx.String("clickhouse-password"),
+		},
+		Settings: clickhouse.Settings{
+			"max_execution_time": 60,
+		},
+		DialTimeout: 5 * time.Second,
+		Compression: &clickhouse.Compression{
+			Method: clickhouse.CompressionLZ4,
+		},
+		Debug: ctx.Bool("debug"),
+	}
+	// columns of config are checked against tables before start, so mistakes are not found by failing inserts
+	if err := handler.Validate(appContext, clickhouseOptions, yamlConfig); err != nil {
+		return err
+	}
+	if ctx.Bool("validate-only") {
+		stdout.Info("config is valid")
+		return nil
+	}
 	instance, err := kafkalog.NewServer(appContext, &kafkalog.Opt{
 		ServerOpt: kafkalog.ServerOpt{
-			KafkaGroupID: ctx.String("kafka-group"),
-			Clickhouse: &clickhouse.Options{
-				Addr: ctx.StringSlice("clickhouse-host"),
-				Auth: clickhouse.Auth{
-					Database: ctx.String("clickhouse-database"),
-					Username: ctx.String("clickhouse-username"),
-					Password: ctx.String("clickhouse-password"),
-				},
-				Settings: clickhouse.Settings{
-					"max_execution_time": 60,
-				},
-				DialTimeout: 5 * time.Second,
-				Compression: &clickhouse.Compression{
-					Method: clickhouse.CompressionLZ4,
-				},
-				Debug: ctx.Bool("debug"),
-			},
+			KafkaGroupID:     ctx.String("kafka-group"),
+			Clickhouse:       clickhouseOptions,
 			BufSize:          ctx.Uint("buffer-size"),
 			BufFlushInterval: ctx.Uint("buffer-flush-interval"),
 			WriteTimeout:     ctx.Duration("write-timeout"),
+			DeadLetter: config.DeadLetter{
+				Sink:         ctx.String("dead-letter-sink"),
+				File:         ctx.String("dead-letter-file"),
+				FileMaxSize:  ctx.Int64("dead-letter-file-max-size"),
+				FileBackups:  ctx.Uint("dead-letter-file-backups"),
+				Table:        ctx.String("dead-letter-table"),
+				KafkaBrokers: ctx.StringSlice("dead-letter-kafka-brokers"),
+				KafkaTopic:   ctx.String("dead-letter-kafka-topic"),
+			},
+			Spool: config.Spool{
+				Dir:            ctx.String("spool-dir"),
+				MaxSize:        ctx.Int64("spool-max-size"),
+				MaxAge:         ctx.Duration("spool-max-age"),
+				MaxPendingRows: ctx.Int64("spool-max-pending-rows"),
+			},
 		},
 		KafkaBrokers: ctx.StringSlice("kafka-brokers"),
 		KafkaTopic:   ctx.String("kafka-topic"),
@@ -158,9 +261,32 @@ func Main(ctx *cli.Context) error {
 		})
 		instance.Stacktrace()
 	}()
-	await, _ := signal.Notifier(func() {
+	await, stop := signal.Notifier(func() {
 		stdout.Info("received a system signal to shut down kafka reader server, start the shutdown process..")
 	})
+	// HTTP server is needed mainly to track viability of the service and for metrics such as prometheus
+	if ctx.Bool("run-http-server") {
+		// run HTTP server
+		go func() {
+			app := fiber.New(fiber.Config{
+				ServerHeader: "Grower KafkaLog Server",
+			})
+			app.Get("/live", func(ctx *fiber.Ctx) error {
+				return ctx.Status(200).SendString("Alive")
+			})
+			app.Get("/metrics", metrics.Handler)
+			ln, err := signal.Listener(
+				instance.Context(), signal.ListenerTCP, "", ctx.String("bind-address"),
+			)
+			if err != nil {
+				stop(err)
+				return
+			}
+			if err := app.Listener(ln); err != nil {
+				stop(err)
+			}
+		}()
+	}
 	stdout.Info("congratulations, kafka reader server has been successfully launched")
 	instance.Run(instance.Context())
 	return await()
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/urfave/cli/v2"
+
+	"github.com/zikwall/grower/internal/services/redislog"
+	stdout "github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+	"github.com/zikwall/grower/pkg/signal"
+)
+
+// nolint:funlen // it's OK
+func main() {
+	application := &cli.App{
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "redis-url",
+				Required: true,
+				Usage:    "Redis URL, e.g. redis://:password@localhost:6379/0",
+				EnvVars:  []string{"REDIS_URL"},
+				FilePath: "/srv/vp_secret/redis_url",
+			},
+			&cli.StringFlag{
+				Name:    "redis-stream",
+				Value:   "grower",
+				Usage:   "Redis stream key of log lines",
+				EnvVars: []string{"REDIS_STREAM"},
+			},
+			&cli.DurationFlag{
+				Name:    "redis-reconnect-delay",
+				Value:   5 * time.Second,
+				Usage:   "Delay before reconnection to Redis after failure",
+				EnvVars: []string{"REDIS_RECONNECT_DELAY"},
+			},
+			&cli.Int64Flag{
+				Name:    "redis-max-len",
+				Value:   1000000,
+				Usage:   "Stream is trimmed approximately to this count of entries by XADD MAXLEN, 0 disables trimming",
+				EnvVars: []string{"REDIS_MAX_LEN"},
+			},
+			&cli.DurationFlag{
+				Name:    "redis-write-timeout",
+				Value:   5 * time.Second,
+				Usage:   "Redis XADD timeout",
+				EnvVars: []string{"REDIS_WRITE_TIMEOUT"},
+			},
+			&cli.StringFlag{
+				Name:    "bind-address",
+				Value:   "0.0.0.0:3000",
+				Usage:   "Run HTTP server in host",
+				EnvVars: []string{"BIND_ADDRESS"},
+			},
+			&cli.StringFlag{
+				Name:    "logs-dir",
+				Value:   "/var/log/nginx",
+				Usage:   "Logs directory",
+				EnvVars: []string{"LOGS_DIR"},
+			},
+			&cli.StringFlag{
+				Name:    "source-log-file",
+				Value:   "access.log",
+				Usage:   "Source log file name",
+				EnvVars: []string{"TARGET_LOG_FILE"},
+			},
+			&cli.UintFlag{
+				Name:    "async-factor",
+				Value:   10,
+				Usage:   "Number of run parallel workers",
+				EnvVars: []string{"ASYNC_FACTOR"},
+			},
+			&cli.DurationFlag{
+				Name:    "scrape-interval",
+				Value:   time.Duration(60000) * time.Millisecond,
+				Usage:   "Scrape interval",
+				EnvVars: []string{"SCRAPE_INTERVAL"},
+			},
+			&cli.UintFlag{
+				Name:     "backup-files",
+				Usage:    "Count of backup files",
+				Required: false,
+				Value:    5,
+				EnvVars:  []string{"BACKUP_FILES"},
+			},
+			&cli.DurationFlag{
+				Name:    "backup-file-max-age",
+				Value:   time.Duration(60000*5) * time.Millisecond,
+				Usage:   "Backup file max age",
+				EnvVars: []string{"BACKUP_FILE_MAX_AGE"},
+			},
+			&cli.BoolFlag{
+				Name:    "auto-create-target-from-scratch",
+				EnvVars: []string{"AUTO_CREATE_TARGET_FROM_SCRATCH"},
+				Value:   false,
+			},
+			&cli.BoolFlag{
+				Name:    "enable-rotating",
+				EnvVars: []string{"ENABLE_ROTATING"},
+				Value:   false,
+			},
+			&cli.BoolFlag{
+				Name:    "run-at-startup",
+				EnvVars: []string{"RUN_AT_STARTUP"},
+				Value:   false,
+			},
+			&cli.BoolFlag{
+				Name:    "run-http-server",
+				EnvVars: []string{"RUN_HTTP_SERVER"},
+				Value:   false,
+			},
+			&cli.BoolFlag{
+				Name:    "skip-nginx-reopen",
+				EnvVars: []string{"SKIP_NGINX_REOPEN"},
+				Value:   false,
+			},
+			&cli.StringFlag{
+				Name:    "offset-file",
+				Value:   "/var/lib/grower/redislog_client_offset.json",
+				Usage:   "File of persisted offsets of sent lines, reading is resumed from them after restart",
+				EnvVars: []string{"OFFSET_FILE"},
+			},
+			&cli.BoolFlag{
+				Name:    "rewrite-nginx-local-time",
+				EnvVars: []string{"REWRITE_NGINX_LOCAL_TIME"},
+				Value:   false,
+			},
+			&cli.BoolFlag{
+				Name:    "debug",
+				EnvVars: []string{"DEBUG"},
+				Value:   false,
+			},
+		},
+		Action: Main,
+	}
+	if err := application.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func Main(ctx *cli.Context) error {
+	appContext, cancel := context.WithCancel(ctx.Context)
+	defer func() {
+		cancel()
+		<-time.After(time.Second)
+		stdout.Info("app context is canceled, service is down!")
+	}()
+	instance, err := redislog.NewClient(appContext, &redislog.Opt{
+		ClientOpt: redislog.ClientOpt{
+			RedisMaxLen:                 ctx.Int64("redis-max-len"),
+			RedisWriteTimeout:           ctx.Duration("redis-write-timeout"),
+			LogsDir:                     ctx.String("logs-dir"),
+			SourceLogFile:               ctx.String("source-log-file"),
+			ScrapeInterval:              ctx.Duration("scrape-interval"),
+			BackupFiles:                 ctx.Uint("backup-files"),
+			BackupFileMaxAge:            ctx.Duration("backup-file-max-age"),
+			EnableRotating:              ctx.Bool("enable-rotating"),
+			AutoCreateTargetFromScratch: ctx.Bool("auto-create-target-from-scratch"),
+			RunAtStartup:                ctx.Bool("run-at-startup"),
+			SkipNginxReopen:             ctx.Bool("skip-nginx-reopen"),
+			OffsetFile:                  ctx.String("offset-file"),
+			RewriteNginxLocalTime:       ctx.Bool("rewrite-nginx-local-time"),
+		},
+		RedisURL:            ctx.String("redis-url"),
+		RedisStream:         ctx.String("redis-stream"),
+		RedisReconnectDelay: ctx.Duration("redis-reconnect-delay"),
+		AsyncFactor:         ctx.Uint("async-factor"),
+		Debug:               ctx.Bool("debug"),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		instance.Shutdown(func(err error) {
+			stdout.Warning(err)
+		})
+		instance.Stacktrace()
+	}()
+	await, stop := signal.Notifier(func() {
+		stdout.Info("received a system signal to shut down Redis writer, start the shutdown process..")
+	})
+	// HTTP server is needed mainly to track viability of the service and for metrics such as prometheus
+	if ctx.Bool("run-http-server") {
+		// run HTTP server
+		go func() {
+			app := fiber.New(fiber.Config{
+				ServerHeader: "Grower RedisLog Client",
+			})
+			app.Get("/live", func(ctx *fiber.Ctx) error {
+				return ctx.Status(200).SendString("Alive")
+			})
+			app.Get("/metrics", metrics.Handler)
+			ln, err := signal.Listener(
+				instance.Context(), signal.ListenerTCP, "", ctx.String("bind-address"),
+			)
+			if err != nil {
+				stop(err)
+				return
+			}
+			if err := app.Listener(ln); err != nil {
+				stop(err)
+			}
+		}()
+	}
+	stdout.Info("congratulations, redis writer service has been successfully launched")
+	instance.Run(instance.Context())
+	return await()
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+
+	"log"
+	"os"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/urfave/cli/v2"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/internal/services/redislog"
+	"github.com/zikwall/grower/pkg/handler"
+	stdout "github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+	"github.com/zikwall/grower/pkg/signal"
+)
+
+// nolint:funlen // it's OK
+func main() {
+	application := &cli.App{
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "config-file",
+				Required: true,
+				Usage:    "YAML config filepath",
+				EnvVars:  []string{"CONFIG_FILE"},
+				FilePath: "/srv/vp_secret/config_file",
+			},
+			&cli.StringFlag{
+				Name:    "bind-address",
+				Value:   "0.0.0.0:3000",
+				Usage:   "Run HTTP server in host",
+				EnvVars: []string{"BIND_ADDRESS"},
+			},
+			&cli.StringFlag{
+				Name:     "redis-url",
+				Required: true,
+				Usage:    "Redis URL, e.g. redis://:password@localhost:6379/0",
+				EnvVars:  []string{"REDIS_URL"},
+				FilePath: "/srv/vp_secret/redis_url",
+			},
+			&cli.StringFlag{
+				Name:    "redis-stream",
+				Value:   "grower",
+				Usage:   "Redis stream key of log lines",
+				EnvVars: []string{"REDIS_STREAM"},
+			},
+			&cli.DurationFlag{
+				Name:    "redis-reconnect-delay",
+				Value:   5 * time.Second,
+				Usage:   "Delay before reconnection to Redis after failure",
+				EnvVars: []string{"REDIS_RECONNECT_DELAY"},
+			},
+			&cli.StringFlag{
+				Name:    "redis-group",
+				Value:   "grower",
+				Usage:   "Redis consumer group, it is created by server and reads stream from the beginning",
+				EnvVars: []string{"REDIS_GROUP"},
+			},
+			&cli.StringFlag{
+				Name:    "redis-consumer",
+				Usage:   "Redis consumer name prefix, host name by default, it should be stable between restarts",
+				EnvVars: []string{"REDIS_CONSUMER"},
+			},
+			&cli.Int64Flag{
+				Name:    "redis-count",
+				Value:   1000,
+				Usage:   "Count of entries of one XREADGROUP and XAUTOCLAIM",
+				EnvVars: []string{"REDIS_COUNT"},
+			},
+			&cli.DurationFlag{
+				Name:    "redis-block",
+				Value:   time.Second,
+				Usage:   "XREADGROUP waits for new entries no longer than this time",
+				EnvVars: []string{"REDIS_BLOCK"},
+			},
+			&cli.DurationFlag{
+				Name:    "redis-claim-min-idle",
+				Value:   5 * time.Minute,
+				Usage:   "Pending entries idle longer than this time are reclaimed from dead consumers by XAUTOCLAIM",
+				EnvVars: []string{"REDIS_CLAIM_MIN_IDLE"},
+			},
+			&cli.DurationFlag{
+				Name:    "redis-claim-interval",
+				Value:   time.Minute,
+				Usage:   "Interval of reclaiming of pending entries",
+				EnvVars: []string{"REDIS_CLAIM_INTERVAL"},
+			},
+			&cli.UintFlag{
+				Name:    "async-factor",
+				Value:   10,
+				Usage:   "Number of run parallel workers",
+				EnvVars: []string{"ASYNC_FACTOR"},
+			},
+			&cli.UintFlag{
+				Name:     "buffer-size",
+				Usage:    "Размер буфера syslog",
+				Required: false,
+				Value:    10000,
+				EnvVars:  []string{"BUFFER_SIZE"},
+			},
+			&cli.UintFlag{
+				Name:     "buffer-flush-interval",
+				Usage:    "Интервал сброса буфера syslog в миллисекундах",
+				Required: false,
+				Value:    5000,
+				EnvVars:  []string{"BUFFER_FLUSH_INTERVAL"},
+			},
+			&cli.DurationFlag{
+				Name:    "write-timeout",
+				Value:   time.Duration(30) * time.Second,
+				Usage:   "Clickhouse Write timout",
+				EnvVars: []string{"WRITE_TIMEOUT"},
+			},
+			&cli.StringSliceFlag{
+				Name:     "clickhouse-host",
+				Usage:    "Hosts",
+				EnvVars:  []string{"CLICKHOUSE_HOST"},
+				FilePath: "/srv/vp_secret/clickhouse_host",
+			},
+			&cli.StringFlag{
+				Name:     "clickhouse-user",
+				Usage:    "Clickhouse server user",
+				EnvVars:  []string{"CLICKHOUSE_USER"},
+				FilePath: "/srv/vp_secret/clickhouse_user",
+			},
+			&cli.StringFlag{
+				Name:     "clickhouse-password",
+				Usage:    "Clickhouse server user password",
+				EnvVars:  []string{"CLICKHOUSE_PASSWORD"},
+				FilePath: "/srv/vp_secret/clickhouse_password",
+			},
+			&cli.StringFlag{
+				Name:     "clickhouse-database",
+				Usage:    "Clickhouse server database name",
+				EnvVars:  []string{"CLICKHOUSE_DATABASE"},
+				FilePath: "/srv/vp_secret/clickhouse_database",
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-sink",
+				Usage:   "Sink for lines that failed parsing or casting: file, clickhouse, kafka, disabled by default",
+				EnvVars: []string{"DEAD_LETTER_SINK"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-file",
+				Value:   "/var/log/grower/dead_letter.log",
+				Usage:   "Dead letter file path",
+				EnvVars: []string{"DEAD_LETTER_FILE"},
+			},
+			&cli.Int64Flag{
+				Name:    "dead-letter-file-max-size",
+				Value:   100 << 20,
+				Usage:   "Dead letter file max size in bytes before rotation",
+				EnvVars: []string{"DEAD_LETTER_FILE_MAX_SIZE"},
+			},
+			&cli.UintFlag{
+				Name:    "dead-letter-file-backups",
+				Value:   5,
+				Usage:   "Count of dead letter backup files",
+				EnvVars: []string{"DEAD_LETTER_FILE_BACKUPS"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-table",
+				Usage:   "Clickhouse dead letter table, see migrations/dead_letter.sql",
+				EnvVars: []string{"DEAD_LETTER_TABLE"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "dead-letter-kafka-brokers",
+				Usage:   "Dead letter kafka brokers",
+				EnvVars: []string{"DEAD_LETTER_KAFKA_BROKERS"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-kafka-topic",
+				Usage:   "Dead letter kafka topic",
+				EnvVars: []string{"DEAD_LETTER_KAFKA_TOPIC"},
+			},
+			&cli.StringFlag{
+				Name:    "spool-dir",
+				Usage:   "Directory of disk spool for batches which can't be inserted to clickhouse, disabled by default",
+				EnvVars: []string{"SPOOL_DIR"},
+			},
+			&cli.Int64Flag{
+				Name:    "spool-max-size",
+				Value:   1 << 30,
+				Usage:   "Disk spool max size in bytes, the oldest segments are removed when it is exceeded",
+				EnvVars: []string{"SPOOL_MAX_SIZE"},
+			},
+			&cli.DurationFlag{
+				Name:    "spool-max-age",
+				Value:   24 * time.Hour,
+				Usage:   "Disk spool segment max age, older segments are removed",
+				EnvVars: []string{"SPOOL_MAX_AGE"},
+			},
+			&cli.Int64Flag{
+				Name:    "spool-max-pending-rows",
+				Usage:   "Max rows waiting for insert to clickhouse, next batches are written to disk spool, zero means no limit",
+				EnvVars: []string{"SPOOL_MAX_PENDING_ROWS"},
+			},
+			&cli.BoolFlag{
+				Name:    "run-http-server",
+				EnvVars: []string{"RUN_HTTP_SERVER"},
+				Value:   false,
+			},
+			&cli.BoolFlag{
+				Name:    "validate-only",
+				Usage:   "Validate columns of config against tables of Clickhouse and exit",
+				EnvVars: []string{"VALIDATE_ONLY"},
+				Value:   false,
+			},
+			&cli.BoolFlag{
+				Name:    "debug",
+				EnvVars: []string{"DEBUG"},
+				Value:   false,
+			},
+		},
+		Action: Main,
+	}
+	if err := application.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func Main(ctx *cli.Context) error {
+	appContext, cancel := context.WithCancel(ctx.Context)
+	defer func() {
+		cancel()
+		<-time.After(time.Second)
+		stdout.Info("app context is canceled, service is down!")
+	}()
+	yamlConfig, err := config.NewSingle(ctx.String("config-file"))
+	if err != nil {
+		return err
+	}
+	clickhouseOptions := &clickhouse.Options{
+		Addr: ctx.StringSlice("clickhouse-host"),
+		Auth: clickhouse.Auth{
+			Database: ctx.String("clickhouse-database"),
+			Username: ctx.String("clickhouse-user"),
+			Password: ctx.String("clickhouse-password"),
+		},
+		Settings: clickhouse.Settings{
+			"max_execution_time": 60,
+		},
+		DialTimeout: 5 * time.Second,
+		Compression: &clickhouse.Compression{
+			Method: clickhouse.CompressionLZ4,
+		},
+		Debug: ctx.Bool("debug"),
+	}
+	// columns of config are checked against tables before start, so mistakes are not found by failing inserts
+	if err := handler.Validate(appContext, clickhouseOptions, yamlConfig); err != nil {
+		return err
+	}
+	if ctx.Bool("validate-only") {
+		stdout.Info("config is valid")
+		return nil
+	}
+	consumer := ctx.String("redis-consumer")
+	if consumer == "" {
+		if consumer, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	instance, err := redislog.NewServer(appContext, &redislog.Opt{
+		ServerOpt: redislog.ServerOpt{
+			RedisGroup:         ctx.String("redis-group"),
+			RedisConsumer:      consumer,
+			RedisCount:         ctx.Int64("redis-count"),
+			RedisBlock:         ctx.Duration("redis-block"),
+			RedisClaimMinIdle:  ctx.Duration("redis-claim-min-idle"),
+			RedisClaimInterval: ctx.Duration("redis-claim-interval"),
+			Clickhouse:         clickhouseOptions,
+			BufSize:            ctx.Uint("buffer-size"),
+			BufFlushInterval:   ctx.Uint("buffer-flush-interval"),
+			WriteTimeout:       ctx.Duration("write-timeout"),
+			DeadLetter: config.DeadLetter{
+				Sink:         ctx.String("dead-letter-sink"),
+				File:         ctx.String("dead-letter-file"),
+				FileMaxSize:  ctx.Int64("dead-letter-file-max-size"),
+				FileBackups:  ctx.Uint("dead-letter-file-backups"),
+				Table:        ctx.String("dead-letter-table"),
+				KafkaBrokers: ctx.StringSlice("dead-letter-kafka-brokers"),
+				KafkaTopic:   ctx.String("dead-letter-kafka-topic"),
+			},
+			Spool: config.Spool{
+				Dir:            ctx.String("spool-dir"),
+				MaxSize:        ctx.Int64("spool-max-size"),
+				MaxAge:         ctx.Duration("spool-max-age"),
+				MaxPendingRows: ctx.Int64("spool-max-pending-rows"),
+			},
+		},
+		RedisURL:            ctx.String("redis-url"),
+		RedisStream:         ctx.String("redis-stream"),
+		RedisReconnectDelay: ctx.Duration("redis-reconnect-delay"),
+		Debug:               ctx.Bool("debug"),
+		AsyncFactor:         ctx.Uint("async-factor"),
+		Config:              yamlConfig,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		instance.Shutdown(func(err error) {
+			stdout.Warning(err)
+		})
+		instance.Stacktrace()
+	}()
+	await, stop := signal.Notifier(func() {
+		stdout.Info("received a system signal to shut down redis reader server, start the shutdown process..")
+	})
+	// HTTP server is needed mainly to track viability of the service and for metrics such as prometheus
+	if ctx.Bool("run-http-server") {
+		// run HTTP server
+		go func() {
+			app := fiber.New(fiber.Config{
+				ServerHeader: "Grower RedisLog Server",
+			})
+			app.Get("/live", func(ctx *fiber.Ctx) error {
+				return ctx.Status(200).SendString("Alive")
+			})
+			app.Get("/metrics", metrics.Handler)
+			ln, err := signal.Listener(
+				instance.Context(), signal.ListenerTCP, "", ctx.String("bind-address"),
+			)
+			if err != nil {
+				stop(err)
+				return
+			}
+			if err := app.Listener(ln); err != nil {
+				stop(err)
+			}
+		}()
+	}
+	stdout.Info("congratulations, redis reader server has been successfully launched")
+	instance.Run(instance.Context())
+	return await()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/urfave/cli/v2"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/internal/services/replay"
+	"github.com/zikwall/grower/pkg/handler"
+	stdout "github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/signal"
+)
+
+// nolint:funlen // it's OK
+func main() {
+	application := &cli.App{
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "config-file",
+				Required: true,
+				Usage:    "YAML config filepath",
+				EnvVars:  []string{"CONFIG_FILE"},
+				FilePath: "/srv/vp_secret/config_file",
+			},
+			&cli.StringSliceFlag{
+				Name:     "file",
+				Required: true,
+				Usage:    "Dead letter files with fixed lines, JSON letters or raw lines",
+				EnvVars:  []string{"REPLAY_FILES"},
+			},
+			&cli.StringFlag{
+				Name:    "rejected-file",
+				Value:   "./rejected.log",
+				Usage:   "File for lines that failed again",
+				EnvVars: []string{"REJECTED_FILE"},
+			},
+			&cli.UintFlag{
+				Name:     "buffer-size",
+				Usage:    "Clickhouse buffer size",
+				Required: false,
+				Value:    5000,
+				EnvVars:  []string{"BUFFER_SIZE"},
+			},
+			&cli.UintFlag{
+				Name:     "buffer-flush-interval",
+				Usage:    "Clickhouse buffer flush interval",
+				Required: false,
+				Value:    2000,
+				EnvVars:  []string{"BUFFER_FLUSH_INTERVAL"},
+			},
+			&cli.DurationFlag{
+				Name:    "write-timeout",
+				Value:   time.Duration(30) * time.Second,
+				Usage:   "Clickhouse Write timout",
+				EnvVars: []string{"WRITE_TIMEOUT"},
+			},
+			&cli.StringSliceFlag{
+				Name:     "clickhouse-host",
+				Usage:    "Clickhouse connect servers",
+				Required: true,
+				EnvVars:  []string{"CLICKHOUSE_HOST"},
+				FilePath: "/srv/vp_secret/clickhouse_host",
+			},
+			&cli.StringFlag{
+				Name:     "clickhouse-user",
+				Usage:    "Clickhouse server user",
+				EnvVars:  []string{"CLICKHOUSE_USER"},
+				FilePath: "/srv/vp_secret/clickhouse_user",
+			},
+			&cli.StringFlag{
+				Name:     "clickhouse-password",
+				Usage:    "Clickhouse server user password",
+				EnvVars:  []string{"CLICKHOUSE_PASSWORD"},
+				FilePath: "/srv/vp_secret/clickhouse_password",
+			},
+			&cli.StringFlag{
+				Name:     "clickhouse-database",
+				Usage:    "Clickhouse server database name",
+				EnvVars:  []string{"CLICKHOUSE_DATABASE"},
+				FilePath: "/srv/vp_secret/clickhouse_database",
+			},
+			&cli.BoolFlag{
+				Name:    "validate-only",
+				Usage:   "Validate columns of config against tables of Clickhouse and exit",
+				EnvVars: []string{"VALIDATE_ONLY"},
+				Value:   false,
+			},
+			&cli.BoolFlag{
+				Name:    "debug",
+				EnvVars: []string{"DEBUG"},
+				Value:   false,
+			},
+		},
+		Action: Main,
+	}
+	if err := application.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func Main(ctx *cli.Context) error {
+	appContext, cancel := context.WithCancel(ctx.Context)
+	defer func() {
+		cancel()
+		<-time.After(time.Second)
+		stdout.Info("app context is canceled, service is down!")
+	}()
+	yamlConfig, err := config.NewSingle(ctx.String("config-file"))
+	if err != nil {
+		return err
+	}
+	clickhouseOptions := &clickhouse.Options{
+		Addr: ctx.StringSlice("clickhouse-host"),
+		Auth: clickhouse.Auth{
+			Database: ctx.String("clickhouse-database"),
+			Username: ctx.String("clickhouse-user"),
+			Password: ctx.String("clickhouse-password"),
+		},
+		Settings: clickhouse.Settings{
+			"max_execution_time": 60,
+		},
+		DialTimeout: 5 * time.Second,
+		Compression: &clickhouse.Compression{
+			Method: clickhouse.CompressionLZ4,
+		},
+		Debug: ctx.Bool("debug"),
+	}
+	// columns of config are checked against tables before start, so mistakes are not found by failing inserts
+	if err := handler.Validate(appContext, clickhouseOptions, yamlConfig); err != nil {
+		return err
+	}
+	if ctx.Bool("validate-only") {
+		stdout.Info("config is valid")
+		return nil
+	}
+	instance, err := replay.New(appContext, &replay.Opt{
+		Clickhouse: clickhouseOptions,
+		ReplayConfig: &replay.Cfg{
+			Files:        ctx.StringSlice("file"),
+			RejectedFile: ctx.String("rejected-file"),
+			Runtime: config.Runtime{
+				WriteTimeout: ctx.Duration("write-timeout"),
+				Debug:        ctx.Bool("debug"),
+			},
+			Buffer: config.Buffer{
+				BufSize:          ctx.Uint("buffer-size"),
+				BufFlushInterval: ctx.Uint("buffer-flush-interval"),
+			},
+		},
+		Config: yamlConfig,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		instance.Shutdown(func(err error) {
+			stdout.Warning(err)
+		})
+	}()
+	await, _ := signal.Notifier(func() {
+		stdout.Info("received a system signal to shut down replay, start the shutdown process..")
+	})
+	// replay is a finite process, signal only interrupts reading of files
+	go func() {
+		_ = await()
+		cancel()
+	}()
+	stat, err := instance.Run(instance.Context())
+	stdout.Infof(
+		"replay is finished, total lines: %d, written: %d, rejected: %d", stat.Total, stat.Written, stat.Rejected,
+	)
+	return err
+}
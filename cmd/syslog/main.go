@@ -13,7 +13,9 @@ import (
 
 	"github.com/zikwall/grower/config"
 	"github.com/zikwall/grower/internal/services/syslog"
+	"github.com/zikwall/grower/pkg/handler"
 	stdout "github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
 	"github.com/zikwall/grower/pkg/signal"
 )
 
@@ -35,8 +37,9 @@ func main() {
 				EnvVars: []string{"BIND_ADDRESS"},
 			},
 			&cli.StringSliceFlag{
-				Name:     "listeners",
-				Usage:    "Run syslog listeners in interfaces",
+				Name: "listeners",
+				Usage: "Run syslog listeners: unix, udp, tcp, tls, with optional format: rfc3164 (default), rfc5424, rfc6587, auto, " +
+					"and optional address, address of transport by default, e.g. tcp:rfc5424@:6514",
 				Required: false,
 				Value:    cli.NewStringSlice(syslog.ListenerUDS),
 				EnvVars:  []string{"LISTENERS"},
@@ -62,6 +65,37 @@ func main() {
 				Usage:    "Syslog server TCP address",
 				EnvVars:  []string{"SYSLOG_TCP_ADDRESS"},
 			},
+			&cli.StringFlag{
+				Name:    "syslog-tls-address",
+				Value:   "0.0.0.0:6514",
+				Usage:   "Syslog server TLS address (RFC5425)",
+				EnvVars: []string{"SYSLOG_TLS_ADDRESS"},
+			},
+			&cli.StringFlag{
+				Name:    "syslog-tls-cert-file",
+				Usage:   "TLS certificate file of syslog server, it is reloaded after change",
+				EnvVars: []string{"SYSLOG_TLS_CERT_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "syslog-tls-key-file",
+				Usage:   "TLS key file of syslog server, it is reloaded after change",
+				EnvVars: []string{"SYSLOG_TLS_KEY_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "syslog-tls-ca-file",
+				Usage:   "CA file to verify client certificates, clients without certificate are rejected if it is provided",
+				EnvVars: []string{"SYSLOG_TLS_CA_FILE"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "syslog-allow-cidr",
+				Usage:   "Accept messages of TCP and UDP listeners only from these networks, all networks by default",
+				EnvVars: []string{"SYSLOG_ALLOW_CIDR"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "syslog-deny-cidr",
+				Usage:   "Reject messages of TCP and UDP listeners from these networks, it has priority over allowed networks",
+				EnvVars: []string{"SYSLOG_DENY_CIDR"},
+			},
 			&cli.UintFlag{
 				Name:     "buffer-size",
 				Usage:    "Clickhouse buffer size",
@@ -119,6 +153,72 @@ func main() {
 				EnvVars: []string{"RUN_HTTP_SERVER"},
 				Value:   false,
 			},
+			&cli.StringFlag{
+				Name:    "dead-letter-sink",
+				Usage:   "Sink for lines that failed parsing or casting: file, clickhouse, kafka, disabled by default",
+				EnvVars: []string{"DEAD_LETTER_SINK"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-file",
+				Value:   "/var/log/grower/dead_letter.log",
+				Usage:   "Dead letter file path",
+				EnvVars: []string{"DEAD_LETTER_FILE"},
+			},
+			&cli.Int64Flag{
+				Name:    "dead-letter-file-max-size",
+				Value:   100 << 20,
+				Usage:   "Dead letter file max size in bytes before rotation",
+				EnvVars: []string{"DEAD_LETTER_FILE_MAX_SIZE"},
+			},
+			&cli.UintFlag{
+				Name:    "dead-letter-file-backups",
+				Value:   5,
+				Usage:   "Count of dead letter backup files",
+				EnvVars: []string{"DEAD_LETTER_FILE_BACKUPS"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-table",
+				Usage:   "Clickhouse dead letter table, see migrations/dead_letter.sql",
+				EnvVars: []string{"DEAD_LETTER_TABLE"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "dead-letter-kafka-brokers",
+				Usage:   "Dead letter kafka brokers",
+				EnvVars: []string{"DEAD_LETTER_KAFKA_BROKERS"},
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-kafka-topic",
+				Usage:   "Dead letter kafka topic",
+				EnvVars: []string{"DEAD_LETTER_KAFKA_TOPIC"},
+			},
+			&cli.StringFlag{
+				Name:    "spool-dir",
+				Usage:   "Directory of disk spool for batches which can't be inserted to clickhouse, disabled by default",
+				EnvVars: []string{"SPOOL_DIR"},
+			},
+			&cli.Int64Flag{
+				Name:    "spool-max-size",
+				Value:   1 << 30,
+				Usage:   "Disk spool max size in bytes, the oldest segments are removed when it is exceeded",
+				EnvVars: []string{"SPOOL_MAX_SIZE"},
+			},
+			&cli.DurationFlag{
+				Name:    "spool-max-age",
+				Value:   24 * time.Hour,
+				Usage:   "Disk spool segment max age, older segments are removed",
+				EnvVars: []string{"SPOOL_MAX_AGE"},
+			},
+			&cli.Int64Flag{
+				Name:    "spool-max-pending-rows",
+				Usage:   "Max rows waiting for insert to clickhouse, next batches are written to disk spool, zero means no limit",
+				EnvVars: []string{"SPOOL_MAX_PENDING_ROWS"},
+			},
+			&cli.BoolFlag{
+				Name:    "validate-only",
+				Usage:   "Validate columns of config against tables of Clickhouse and exit",
+				EnvVars: []string{"VALIDATE_ONLY"},
+				Value:   false,
+			},
 			&cli.BoolFlag{
 				Name:    "debug",
 				EnvVars: []string{"DEBUG"},
@@ -139,32 +239,49 @@ func Main(ctx *cli.Context) error {
 		<-time.After(time.Second)
 		stdout.Info("app context is canceled, service is down!")
 	}()
-	yamlConfig, err := config.New(ctx.String("config-file"))
+	yamlConfig, err := config.NewSingle(ctx.String("config-file"))
 	if err != nil {
 		return err
 	}
-	instance, err := syslog.New(appContext, &syslog.Opt{
-		Clickhouse: &clickhouse.Options{
-			Addr: ctx.StringSlice("clickhouse-host"),
-			Auth: clickhouse.Auth{
-				Database: ctx.String("clickhouse-database"),
-				Username: ctx.String("clickhouse-username"),
-				Password: ctx.String("clickhouse-password"),
-			},
-			Settings: clickhouse.Settings{
-				"max_execution_time": 60,
-			},
-			DialTimeout: 5 * time.Second,
-			Compression: &clickhouse.Compression{
-				Method: clickhouse.CompressionLZ4,
-			},
-			Debug: ctx.Bool("debug"),
+	clickhouseOptions := &clickhouse.Options{
+		Addr: ctx.StringSlice("clickhouse-host"),
+		Auth: clickhouse.Auth{
+			Database: ctx.String("clickhouse-database"),
+			Username: ctx.String("clickhouse-user"),
+			Password: ctx.String("clickhouse-password"),
+		},
+		Settings: clickhouse.Settings{
+			"max_execution_time": 60,
 		},
+		DialTimeout: 5 * time.Second,
+		Compression: &clickhouse.Compression{
+			Method: clickhouse.CompressionLZ4,
+		},
+		Debug: ctx.Bool("debug"),
+	}
+	// columns of config are checked against tables before start, so mistakes are not found by failing inserts
+	if err := handler.Validate(appContext, clickhouseOptions, yamlConfig, syslog.VirtualFields...); err != nil {
+		return err
+	}
+	if ctx.Bool("validate-only") {
+		stdout.Info("config is valid")
+		return nil
+	}
+	instance, err := syslog.New(appContext, &syslog.Opt{
+		Clickhouse: clickhouseOptions,
 		SyslogConfig: &syslog.Cfg{
-			Listeners: ctx.StringSlice("listeners"),
-			Unix:      ctx.String("syslog-unix-socket"),
-			UPD:       ctx.String("syslog-udp-address"),
-			TCP:       ctx.String("syslog-tcp-address"),
+			Listeners:  ctx.StringSlice("listeners"),
+			Unix:       ctx.String("syslog-unix-socket"),
+			UDP:        ctx.String("syslog-udp-address"),
+			TCP:        ctx.String("syslog-tcp-address"),
+			TLSAddress: ctx.String("syslog-tls-address"),
+			Allow:      ctx.StringSlice("syslog-allow-cidr"),
+			Deny:       ctx.StringSlice("syslog-deny-cidr"),
+			TLS: config.TLS{
+				CertFile: ctx.String("syslog-tls-cert-file"),
+				KeyFile:  ctx.String("syslog-tls-key-file"),
+				CAFile:   ctx.String("syslog-tls-ca-file"),
+			},
 			Runtime: config.Runtime{
 				Parallelism:  ctx.Int("parallelism"),
 				WriteTimeout: ctx.Duration("write-timeout"),
@@ -174,6 +291,21 @@ func Main(ctx *cli.Context) error {
 				BufSize:          ctx.Uint("buffer-size"),
 				BufFlushInterval: ctx.Uint("buffer-flush-interval"),
 			},
+			DeadLetter: config.DeadLetter{
+				Sink:         ctx.String("dead-letter-sink"),
+				File:         ctx.String("dead-letter-file"),
+				FileMaxSize:  ctx.Int64("dead-letter-file-max-size"),
+				FileBackups:  ctx.Uint("dead-letter-file-backups"),
+				Table:        ctx.String("dead-letter-table"),
+				KafkaBrokers: ctx.StringSlice("dead-letter-kafka-brokers"),
+				KafkaTopic:   ctx.String("dead-letter-kafka-topic"),
+			},
+			Spool: config.Spool{
+				Dir:            ctx.String("spool-dir"),
+				MaxSize:        ctx.Int64("spool-max-size"),
+				MaxAge:         ctx.Duration("spool-max-age"),
+				MaxPendingRows: ctx.Int64("spool-max-pending-rows"),
+			},
 		},
 		Config: yamlConfig,
 	})
@@ -199,6 +331,7 @@ func Main(ctx *cli.Context) error {
 			app.Get("/live", func(ctx *fiber.Ctx) error {
 				return ctx.Status(200).SendString("Alive")
 			})
+			app.Get("/metrics", metrics.Handler)
 			ln, err := signal.Listener(
 				instance.Context(), signal.ListenerTCP, "", ctx.String("bind-address"),
 			)
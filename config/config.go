@@ -9,6 +9,20 @@ import (
 )
 
 type Config struct {
+	Nginx     Nginx     `yaml:"nginx"`
+	Scheme    Scheme    `yaml:"scheme"`
+	GeoIP     GeoIP     `yaml:"geoip"`
+	UserAgent UserAgent `yaml:"user_agent"`
+	Privacy   Privacy   `yaml:"privacy"`
+	Syslog    Syslog    `yaml:"syslog"`
+	// Pipelines replace nginx and scheme sections, when several sources are read by one process
+	Pipelines []Pipeline `yaml:"pipelines"`
+}
+
+// Pipeline source with its own log format and casts, rows of which are written to its own table
+type Pipeline struct {
+	Name   string `yaml:"name"`
+	Source string `yaml:"source"`
 	Nginx  Nginx  `yaml:"nginx"`
 	Scheme Scheme `yaml:"scheme"`
 }
@@ -23,9 +37,77 @@ type Nginx struct {
 	LogRemoveHyphen      bool              `yaml:"log_remove_hyphen"`
 }
 
+// GeoIP paths to MaxMind DB files, which are used by GeoIP functions of column expressions
+type GeoIP struct {
+	CityDatabase string `yaml:"city_database"`
+	ASNDatabase  string `yaml:"asn_database"`
+}
+
+// UserAgent database of regular expressions of User-Agent parser, embedded database is used if it is empty,
+// parsed User-Agents are kept in LRU cache of cache_size entries
+type UserAgent struct {
+	Database  string `yaml:"database"`
+	CacheSize int    `yaml:"cache_size"`
+}
+
+// Privacy anonymizes fields right after parsing, before they are used by columns, filters and routes,
+// the same rules are applied to raw lines of dead letters and to error messages
+type Privacy struct {
+	// TruncateIP fields of IPv4 addresses are truncated to /24 and of IPv6 addresses to /48
+	TruncateIP []string `yaml:"truncate_ip"`
+	// Pseudonymize fields are replaced by keyed HMAC-SHA256 of values, key is read from key_file if it is set
+	Pseudonymize []string `yaml:"pseudonymize"`
+	Key          string   `yaml:"key"`
+	KeyFile      string   `yaml:"key_file"`
+	Redact       []Redact `yaml:"redact"`
+	// DropQueryArgs names of arguments which are removed from query of request, request_uri, args and http_referer
+	DropQueryArgs []string `yaml:"drop_query_args"`
+}
+
+// Redact replaces matches of regex in fields by replacement, in all fields if fields are empty
+type Redact struct {
+	Fields      []string `yaml:"fields"`
+	Regex       string   `yaml:"regex"`
+	Replacement string   `yaml:"replacement"`
+}
+
+// Syslog routes rows of syslog messages to tables by tag, rows of other tags are written to logs table of scheme
+type Syslog struct {
+	TagTables map[string]string `yaml:"tag_tables"`
+}
+
+// Scheme maps table columns to their sources: field name or expression, e.g. toUInt32(GeoIPAS(remote_addr))
 type Scheme struct {
 	Columns   map[string]string `yaml:"columns"`
 	LogsTable string            `yaml:"logs_table"`
+	Routes    []Route           `yaml:"routes"`
+	Filter    Filter            `yaml:"filter"`
+	Sample    Sample            `yaml:"sample"`
+}
+
+// SampleRateColumn is added to columns of sampled rows, so aggregations can be re-weighted, e.g. sum(1 / _sample_rate)
+const SampleRateColumn = "_sample_rate"
+
+// Filter keeps rows which match any of include predicates, or all rows if there are no include predicates,
+// and match none of exclude predicates, e.g. request_uri =~ '/^/health/'
+type Filter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// Sample keeps part of rows by hash of key expression, e.g. 0.01 of remote_addr,
+// rows with the same key are either all kept or all dropped
+type Sample struct {
+	Key  string  `yaml:"key"`
+	Rate float64 `yaml:"rate"`
+}
+
+// Route sends rows matching predicate to table with the same columns as logs table or drops them,
+// e.g. status >= 500, the first matched route is used, rows which match no route are written to logs table
+type Route struct {
+	Match string `yaml:"match"`
+	Table string `yaml:"table"`
+	Drop  bool   `yaml:"drop"`
 }
 
 func (s *Scheme) MapKeys() (columns []string, scheme map[string]string) {
@@ -36,6 +118,18 @@ func (s *Scheme) MapKeys() (columns []string, scheme map[string]string) {
 	return keys, s.Columns
 }
 
+// Pipeline config of single pipeline, GeoIP and User-Agent databases and privacy rules are shared by all pipelines
+func (c *Config) Pipeline(pipeline *Pipeline) *Config {
+	return &Config{
+		Nginx:     pipeline.Nginx,
+		Scheme:    pipeline.Scheme,
+		GeoIP:     c.GeoIP,
+		UserAgent: c.UserAgent,
+		Privacy:   c.Privacy,
+		Syslog:    c.Syslog,
+	}
+}
+
 func New(filepath string) (*Config, error) {
 	content, err := os.ReadFile(filepath)
 	if err != nil {
@@ -46,14 +140,94 @@ func New(filepath string) (*Config, error) {
 	if err := decoder.Decode(&config); err != nil {
 		return nil, err
 	}
-	if config.Scheme.LogsTable == "" {
-		return nil, fmt.Errorf("logs table is not provided")
+	if err := validatePrivacy(&config.Privacy); err != nil {
+		return nil, err
 	}
-	if len(config.Scheme.Columns) == 0 {
-		return nil, fmt.Errorf("table schema is empty")
+	if len(config.Pipelines) == 0 {
+		if err := validate(&config.Nginx, &config.Scheme); err != nil {
+			return nil, err
+		}
+		return config, nil
 	}
-	if config.Nginx.LogFormat == "" {
-		return nil, fmt.Errorf("log format is empty")
+	names := make(map[string]struct{}, len(config.Pipelines))
+	for i := range config.Pipelines {
+		pipeline := &config.Pipelines[i]
+		if pipeline.Name == "" {
+			return nil, fmt.Errorf("pipeline %d: name is empty", i)
+		}
+		if _, ok := names[pipeline.Name]; ok {
+			return nil, fmt.Errorf("pipeline %s: name is not unique", pipeline.Name)
+		}
+		names[pipeline.Name] = struct{}{}
+		if pipeline.Source == "" {
+			return nil, fmt.Errorf("pipeline %s: source is empty", pipeline.Name)
+		}
+		if err := validate(&pipeline.Nginx, &pipeline.Scheme); err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", pipeline.Name, err)
+		}
 	}
 	return config, nil
 }
+
+// NewSingle reads config of command with single source, pipelines are read only by filelog,
+// so config with pipelines is rejected instead of silently using not validated nginx and scheme sections
+func NewSingle(filepath string) (*Config, error) {
+	config, err := New(filepath)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Pipelines) > 0 {
+		return nil, fmt.Errorf("pipelines are supported only by filelog, use nginx and scheme sections")
+	}
+	return config, nil
+}
+
+func validate(nginx *Nginx, scheme *Scheme) error {
+	if scheme.LogsTable == "" {
+		return fmt.Errorf("logs table is not provided")
+	}
+	if len(scheme.Columns) == 0 {
+		return fmt.Errorf("table schema is empty")
+	}
+	if nginx.LogFormat == "" {
+		return fmt.Errorf("log format is empty")
+	}
+	for i, route := range scheme.Routes {
+		if route.Match == "" {
+			return fmt.Errorf("route %d: predicate is empty", i)
+		}
+		if (route.Table == "") == !route.Drop {
+			return fmt.Errorf("route %d: either table or drop is expected", i)
+		}
+	}
+	for i, predicate := range append(append([]string(nil), scheme.Filter.Include...), scheme.Filter.Exclude...) {
+		if predicate == "" {
+			return fmt.Errorf("filter %d: predicate is empty", i)
+		}
+	}
+	if scheme.Sample.Key == "" {
+		if scheme.Sample.Rate != 0 {
+			return fmt.Errorf("sample key is empty")
+		}
+		return nil
+	}
+	if scheme.Sample.Rate <= 0 || scheme.Sample.Rate > 1 {
+		return fmt.Errorf("sample rate must be greater than 0 and not greater than 1")
+	}
+	if _, ok := scheme.Columns[SampleRateColumn]; ok {
+		return fmt.Errorf("column %s is added by sample", SampleRateColumn)
+	}
+	return nil
+}
+
+func validatePrivacy(privacy *Privacy) error {
+	if len(privacy.Pseudonymize) > 0 && privacy.Key == "" && privacy.KeyFile == "" {
+		return fmt.Errorf("privacy key is not provided for pseudonymization")
+	}
+	for i, redact := range privacy.Redact {
+		if redact.Regex == "" {
+			return fmt.Errorf("privacy redact %d: regex is empty", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestNew(t *testing.T) {
+	t.Run("it should be read pipelines with shared geoip databases", func(t *testing.T) {
+		config, err := New(writeConfig(t, `
+geoip:
+  city_database: /var/lib/GeoLite2-City.mmdb
+pipelines:
+  - name: access
+    source: access.log
+    nginx:
+      log_format: '$remote_addr [$time_local] "$request"'
+    scheme:
+      logs_table: logs.access_log
+      columns:
+        remote_addr: remote_addr
+  - name: api
+    source: api.access.log
+    nginx:
+      log_format: '$remote_addr $status'
+    scheme:
+      logs_table: logs.api_access_log
+      columns:
+        status: status
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(config.Pipelines) != 2 {
+			t.Fatalf("expect 2 pipelines, got %d", len(config.Pipelines))
+		}
+		api := config.Pipeline(&config.Pipelines[1])
+		if api.Scheme.LogsTable != "logs.api_access_log" || api.Nginx.LogFormat != "$remote_addr $status" {
+			t.Fatalf("unexpected config of pipeline %+v", api)
+		}
+		if api.GeoIP.CityDatabase != "/var/lib/GeoLite2-City.mmdb" {
+			t.Fatalf("expect shared geoip database, got %s", api.GeoIP.CityDatabase)
+		}
+	})
+
+	t.Run("it should be failed invalid pipelines", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"no name": `
+pipelines:
+  - source: access.log
+    nginx: {log_format: $status}
+    scheme: {logs_table: logs.access_log, columns: {status: status}}
+`,
+			"no source": `
+pipelines:
+  - name: access
+    nginx: {log_format: $status}
+    scheme: {logs_table: logs.access_log, columns: {status: status}}
+`,
+			"not unique name": `
+pipelines:
+  - name: access
+    source: access.log
+    nginx: {log_format: $status}
+    scheme: {logs_table: logs.access_log, columns: {status: status}}
+  - name: access
+    source: error.log
+    nginx: {log_format: $status}
+    scheme: {logs_table: logs.access_log, columns: {status: status}}
+`,
+			"no table": `
+pipelines:
+  - name: access
+    source: access.log
+    nginx: {log_format: $status}
+    scheme: {columns: {status: status}}
+`,
+			"route without table": `
+nginx: {log_format: $status}
+scheme:
+  logs_table: logs.access_log
+  columns: {status: status}
+  routes:
+    - match: status >= 500
+`,
+			"route with table and drop": `
+nginx: {log_format: $status}
+scheme:
+  logs_table: logs.access_log
+  columns: {status: status}
+  routes:
+    - match: status >= 500
+      table: logs.error_access_log
+      drop: true
+`,
+			"sample without key": `
+nginx: {log_format: $status}
+scheme:
+  logs_table: logs.access_log
+  columns: {status: status}
+  sample: {rate: 0.01}
+`,
+			"sample rate out of range": `
+nginx: {log_format: $status}
+scheme:
+  logs_table: logs.access_log
+  columns: {status: status}
+  sample: {key: remote_addr, rate: 2}
+`,
+			"sample rate column": `
+nginx: {log_format: $status}
+scheme:
+  logs_table: logs.access_log
+  columns: {status: status, _sample_rate: status}
+  sample: {key: remote_addr, rate: 0.01}
+`,
+			"empty filter": `
+nginx: {log_format: $status}
+scheme:
+  logs_table: logs.access_log
+  columns: {status: status}
+  filter: {exclude: ['']}
+`,
+			"pseudonymization without key": `
+nginx: {log_format: $remote_addr}
+scheme: {logs_table: logs.access_log, columns: {remote_addr: remote_addr}}
+privacy: {pseudonymize: [remote_addr]}
+`,
+			"redaction without regex": `
+nginx: {log_format: $remote_addr}
+scheme: {logs_table: logs.access_log, columns: {remote_addr: remote_addr}}
+privacy: {redact: [{fields: [request]}]}
+`,
+			"no pipelines and format": `
+scheme: {logs_table: logs.access_log, columns: {status: status}}
+`,
+		} {
+			if _, err := New(writeConfig(t, content)); err == nil {
+				t.Fatalf("expect error of config with %s, got nil", name)
+			}
+		}
+	})
+
+	t.Run("it should be failed pipelines of command with single source", func(t *testing.T) {
+		file := writeConfig(t, `
+pipelines:
+  - name: access
+    source: access.log
+    nginx: {log_format: $status}
+    scheme: {logs_table: logs.access_log, columns: {status: status}}
+`)
+		if _, err := New(file); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewSingle(file); err == nil {
+			t.Fatal("expect error, got nil")
+		}
+		if _, err := NewSingle(writeConfig(t, `
+nginx: {log_format: $status}
+scheme: {logs_table: logs.access_log, columns: {status: status}}
+`)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
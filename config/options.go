@@ -12,3 +12,41 @@ type Runtime struct {
 	WriteTimeout time.Duration
 	Debug        bool
 }
+
+type DeadLetter struct {
+	Sink         string
+	File         string
+	FileMaxSize  int64
+	FileBackups  uint
+	Table        string
+	KafkaBrokers []string
+	KafkaTopic   string
+}
+
+// Spool keeps batches on disk while clickhouse is not available, it is disabled if directory is empty
+type Spool struct {
+	Dir     string
+	MaxSize int64
+	MaxAge  time.Duration
+	// MaxPendingRows rows waiting for insert, after which new batches are written to disk, zero means no limit
+	MaxPendingRows int64
+}
+
+// TLS certificates of transport, files are reloaded after change without restart.
+// Server enables TLS with certificate and key, CA file enables verification of client certificates.
+// Client uses CA file as the only trusted root, certificate and key are sent to server for mTLS
+type TLS struct {
+	Enabled    bool
+	CertFile   string
+	KeyFile    string
+	CAFile     string
+	ServerName string
+}
+
+// Auth tokens of ingest clients, scheme is bearer or hmac, authentication is disabled if scheme is empty.
+// Server token file contains client name and token per line, client token file contains its token only
+type Auth struct {
+	Scheme    string
+	TokenFile string
+	Client    string
+}
@@ -0,0 +1,62 @@
+package filegrpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/zikwall/grower/pkg/auth"
+	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+)
+
+// authenticator checks token of client in metadata before stream or call is handled
+type authenticator struct {
+	verifier *auth.Verifier
+	debug    bool
+}
+
+func (a *authenticator) stream(
+	srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler,
+) error {
+	if err := a.authenticate(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func (a *authenticator) unary(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if err := a.authenticate(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authenticator) authenticate(ctx context.Context, method string) error {
+	var credentials string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(auth.Header); len(values) > 0 {
+			credentials = values[0]
+		}
+	}
+	client, err := a.verifier.Verify(credentials)
+	address := "unknown"
+	if p, ok := peer.FromContext(ctx); ok {
+		address = p.Addr.String()
+	}
+	if err != nil {
+		metrics.IngestRejected.WithLabelValues(ServerSource, auth.Reason(err)).Inc()
+		log.Warningf("reject %s from %s: %v", method, address, err)
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if a.debug {
+		log.Infof("client %s from %s is authenticated for %s", client, address, method)
+	}
+	return nil
+}
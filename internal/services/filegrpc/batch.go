@@ -0,0 +1,245 @@
+package filegrpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+	"github.com/zikwall/grower/protobuf/filebuf"
+)
+
+// recentBatchesSize number of batch identifiers remembered by server to skip retransmitted batches
+const recentBatchesSize = 1 << 16
+
+// pendingBatch batch of v2 protocol, marks of lines are acknowledged after server acknowledges batch,
+// server acknowledges it after all its lines are written
+type pendingBatch struct {
+	batch *filebuf.Batch
+	marks []*checkpoint.Mark
+}
+
+// batchSender sends lines by batches over v2 stream, unacknowledged batches are kept
+// and retransmitted in the same order over new stream after failure
+type batchSender struct {
+	w        *ClientWorker
+	window   chan struct{}
+	mu       sync.Mutex
+	inflight []*pendingBatch
+	lines    []string
+	marks    []*checkpoint.Mark
+	// acks number of acknowledged batches
+	acks uint64
+}
+
+func newBatchSender(w *ClientWorker) *batchSender {
+	return &batchSender{
+		w:      w,
+		window: make(chan struct{}, w.opt.MaxInflight),
+	}
+}
+
+func (s *batchSender) open(ctx context.Context) (filebuf.FileBufferService_CreateBatchStreamerClient, context.CancelFunc, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := s.w.client.CreateBatchStreamer(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return stream, cancel, nil
+}
+
+// run sends batches until stream is broken, it returns nil after context is done or lines are over
+func (s *batchSender) run(ctx context.Context, stream filebuf.FileBufferService_CreateBatchStreamerClient) error {
+	broken := make(chan error, 1)
+	go func() {
+		for {
+			ack, err := stream.Recv()
+			if err != nil {
+				broken <- err
+				return
+			}
+			s.acknowledge(ack)
+		}
+	}()
+	for _, pending := range s.unacknowledged() {
+		if err := stream.Send(pending.batch); err != nil {
+			return err
+		}
+		metrics.GRPCBatches.WithLabelValues("retransmitted").Inc()
+	}
+	ticker := time.NewTicker(s.w.opt.BatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-broken:
+			return err
+		case <-ticker.C:
+			if len(s.lines) == 0 {
+				continue
+			}
+			if err := s.flush(ctx, stream, broken); err != nil {
+				return err
+			}
+		case str, ok := <-s.w.str:
+			if !ok {
+				return nil
+			}
+			s.lines = append(s.lines, str.Content)
+			s.marks = append(s.marks, str.Mark)
+			if len(s.lines) < s.w.opt.BatchSize {
+				continue
+			}
+			if err := s.flush(ctx, stream, broken); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// flush sends collected lines as new batch, it waits for free slot of inflight batches
+func (s *batchSender) flush(
+	ctx context.Context,
+	stream filebuf.FileBufferService_CreateBatchStreamerClient,
+	broken chan error,
+) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-broken:
+		return err
+	case s.window <- struct{}{}:
+	}
+	sequence := atomic.AddUint64(&s.w.sequence, 1)
+	pending := &pendingBatch{
+		batch: &filebuf.Batch{
+			Id:       fmt.Sprintf("%s-%d", s.w.session, sequence),
+			Host:     s.w.host,
+			File:     s.w.opt.SourceLogFile,
+			Sequence: sequence,
+			Lines:    s.lines,
+		},
+		marks: s.marks,
+	}
+	s.lines, s.marks = nil, nil
+	s.mu.Lock()
+	s.inflight = append(s.inflight, pending)
+	s.mu.Unlock()
+	// batch stays inflight after failure and is retransmitted over new stream
+	if err := stream.Send(pending.batch); err != nil {
+		return err
+	}
+	metrics.GRPCBatches.WithLabelValues("sent").Inc()
+	return nil
+}
+
+func (s *batchSender) acknowledge(ack *filebuf.Ack) {
+	s.mu.Lock()
+	var pending *pendingBatch
+	for i, it := range s.inflight {
+		if it.batch.Sequence == ack.Sequence {
+			pending = it
+			s.inflight = append(s.inflight[:i], s.inflight[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	if pending == nil {
+		// batch is retransmitted and acknowledged twice
+		return
+	}
+	<-s.window
+	atomic.AddUint64(&s.acks, 1)
+	for _, mark := range pending.marks {
+		mark.Ack()
+	}
+	metrics.GRPCBatches.WithLabelValues("acked").Inc()
+	if s.w.opt.Debug {
+		log.Infof("batch %s of %d lines is acknowledged", ack.Id, len(pending.batch.Lines))
+	}
+}
+
+func (s *batchSender) unacknowledged() []*pendingBatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*pendingBatch{}, s.inflight...)
+}
+
+func (s *batchSender) acked() uint64 {
+	return atomic.LoadUint64(&s.acks)
+}
+
+func (s *batchSender) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.inflight)
+}
+
+// recentBatches remembers the latest enqueued batches with trackers of their written lines,
+// so batch retransmitted after lost acknowledgement is not enqueued twice and is acknowledged after it is written
+type recentBatches struct {
+	mu      sync.Mutex
+	batches map[string]*writeTracker
+	ring    []string
+	next    int
+}
+
+func newRecentBatches(size int) *recentBatches {
+	return &recentBatches{
+		batches: make(map[string]*writeTracker, size),
+		ring:    make([]string, size),
+	}
+}
+
+func (r *recentBatches) get(id string) (*writeTracker, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	written, ok := r.batches[id]
+	return written, ok
+}
+
+func (r *recentBatches) add(id string, written *writeTracker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.batches[id]; ok {
+		return
+	}
+	delete(r.batches, r.ring[r.next])
+	r.ring[r.next] = id
+	r.batches[id] = written
+	r.next = (r.next + 1) % len(r.ring)
+}
+
+// remove batch which is not completely enqueued, it is removed only if it is still tracked by the same tracker
+func (r *recentBatches) remove(id string, written *writeTracker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.batches[id] != written {
+		return
+	}
+	delete(r.batches, id)
+	// slot of ring is released, so batch added again with the same identifier is not evicted by it
+	for i := range r.ring {
+		if r.ring[i] == id {
+			r.ring[i] = ""
+			return
+		}
+	}
+}
+
+// newSession random identifier of client session, it is prefix of batch identifiers
+func newSession() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
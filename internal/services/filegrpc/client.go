@@ -1,22 +1,34 @@
 package filegrpc
 
 import (
-	"bufio"
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 
+	"github.com/zikwall/grower/pkg/auth"
+	"github.com/zikwall/grower/pkg/backoff"
+	"github.com/zikwall/grower/pkg/checkpoint"
 	"github.com/zikwall/grower/pkg/drop"
 	"github.com/zikwall/grower/pkg/fileio"
 	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+	"github.com/zikwall/grower/pkg/tlsconfig"
 	"github.com/zikwall/grower/protobuf/filebuf"
 )
 
+var errClientClosed = errors.New("client is closed")
+
+// ClientSource name of gRPC client in metrics
+const ClientSource = "filegrpc_client"
+
 type Client struct {
 	*drop.Impl
 	worker *ClientWorker
@@ -40,29 +52,87 @@ func (c *Client) Run(ctx context.Context) {
 	c.worker.runContext(ctx)
 }
 
+// Ready client has at least one open stream to server
+func (c *Client) Ready() bool {
+	return c.worker.ready()
+}
+
 type ClientWorker struct {
 	wg       *sync.WaitGroup
-	str      chan string
+	str      chan fileio.Line
 	opt      *ClientOpt
 	client   filebuf.FileBufferServiceClient
 	conn     *grpc.ClientConn
 	rotate   fileio.Rotator
 	isClosed uint32
-	senders  uint32
+	// streams number of open streams, client is ready while there is at least one
+	streams       uint32
+	done          <-chan struct{}
+	removeBacklog func()
+	// session, host and sequence identify batches of v2 protocol
+	session  string
+	host     string
+	sequence uint64
 }
 
 func NewClientWorker(opt *ClientOpt) (*ClientWorker, error) {
-	conn, err := grpc.Dial(opt.ConnectAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	switch opt.Protocol {
+	case ProtocolV1, ProtocolV2:
+	default:
+		return nil, fmt.Errorf("unknown protocol %q, expected %s or %s", opt.Protocol, ProtocolV1, ProtocolV2)
+	}
+	if opt.BatchSize <= 0 || opt.BatchInterval <= 0 {
+		return nil, errors.New("batch size and batch interval should be positive")
+	}
+	if opt.Protocol == ProtocolV2 && opt.MaxInflight <= 0 {
+		return nil, errors.New("max inflight batches should be positive")
+	}
+	if opt.QueueSize < 0 {
+		return nil, errors.New("queue size should not be negative")
+	}
+	if opt.Auth.Scheme != "" && !opt.TLS.Enabled {
+		return nil, errors.New("auth tokens are sent only over TLS, TLS should be enabled")
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	creds := insecure.NewCredentials()
+	if opt.TLS.Enabled {
+		tlsConfig, err := tlsconfig.Client(&opt.TLS, opt.ConnectAddress)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	options := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if opt.Auth.Scheme != "" {
+		client := opt.Auth.Client
+		if client == "" {
+			client = host
+		}
+		tokens, err := auth.NewCredentials(opt.Auth.Scheme, client, opt.Auth.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.WithPerRPCCredentials(tokens))
+	}
+	conn, err := grpc.Dial(opt.ConnectAddress, options...)
 	if err != nil {
 		return nil, err
 	}
 	w := &ClientWorker{
-		wg:     &sync.WaitGroup{},
-		opt:    opt,
-		str:    make(chan string),
-		client: filebuf.NewFileBufferServiceClient(conn),
-		conn:   conn,
+		wg:      &sync.WaitGroup{},
+		opt:     opt,
+		str:     make(chan fileio.Line, opt.QueueSize),
+		client:  filebuf.NewFileBufferServiceClient(conn),
+		conn:    conn,
+		session: newSession(),
+		host:    host,
 	}
+	w.removeBacklog = metrics.ChannelBacklog.Func(func() float64 {
+		return float64(len(w.str))
+	}, ClientSource)
 	w.rotate = fileio.New(
 		opt.SourceLogFile,
 		opt.LogsDir,
@@ -71,7 +141,8 @@ func NewClientWorker(opt *ClientOpt) (*ClientWorker, error) {
 		opt.AutoCreateTargetFromScratch,
 		opt.EnableRotating,
 		opt.SkipNginxReopen,
-		w.handleFile,
+		opt.OffsetFile,
+		w.handleLine,
 	)
 	return w, nil
 }
@@ -81,6 +152,9 @@ func (w *ClientWorker) Drop() error {
 	atomic.StoreUint32(&w.isClosed, 1)
 	// wait all workers
 	w.wg.Wait()
+	// save offsets of sent lines
+	w.rotate.Save()
+	w.removeBacklog()
 	// close gRPC client connection
 	return w.conn.Close()
 }
@@ -93,12 +167,31 @@ func (w *ClientWorker) DropMsg() string {
 func (w *ClientWorker) preparePool(ctx context.Context) {
 	for i := 1; i <= w.opt.Parallelism; i++ {
 		w.wg.Add(1)
-		go w.makeSender(ctx, i)
+		if w.opt.Protocol == ProtocolV1 {
+			go w.makeSender(ctx, i)
+		} else {
+			go w.makeBatchSender(ctx, i)
+		}
 	}
-	// wait for ready, refactor in the future, temporary way
-	<-time.After(500 * time.Millisecond)
 }
 
+// ready client has at least one open stream
+func (w *ClientWorker) ready() bool {
+	return atomic.LoadUint32(&w.streams) > 0
+}
+
+func (w *ClientWorker) streamOpened() {
+	atomic.AddUint32(&w.streams, 1)
+	metrics.GRPCStreams.WithLabelValues("client").Inc()
+}
+
+func (w *ClientWorker) streamClosed() {
+	atomic.AddUint32(&w.streams, ^uint32(0))
+	metrics.GRPCStreams.WithLabelValues("client").Dec()
+}
+
+// makeSender streams lines of v1 protocol, stream is closed every batch of lines and lines are acknowledged
+// only after server responds, broken stream is re-created with backoff and unacknowledged lines are sent again over new stream
 func (w *ClientWorker) makeSender(ctx context.Context, worker int) {
 	defer func() {
 		w.wg.Done()
@@ -109,83 +202,199 @@ func (w *ClientWorker) makeSender(ctx context.Context, worker int) {
 	if w.opt.Debug {
 		log.Infof("run client gRPC worker %d", worker)
 	}
-	stream, err := w.client.CreateDataStreamer(ctx)
-	if err != nil {
-		log.Warningf("stream create: %v", err)
-		return
+	retry := backoff.New(w.opt.RetransmitDelay, w.opt.ReconnectMaxDelay)
+	var unacked []fileio.Line
+	for {
+		var err error
+		if unacked, err = w.send(ctx, unacked, retry); err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warningf("data stream of worker %d: %v, %d unacknowledged lines are sent again over new stream", worker, err, len(unacked))
+		if !retry.Wait(ctx) {
+			return
+		}
 	}
+}
+
+// send streams lines until context is done or lines are over, stream is closed and re-created
+// every batch size lines or batch interval, it returns lines which are sent but not acknowledged by server
+func (w *ClientWorker) send(ctx context.Context, unacked []fileio.Line, retry *backoff.Backoff) ([]fileio.Line, error) {
+	ticker := time.NewTicker(w.opt.BatchInterval)
+	defer ticker.Stop()
+	opened := false
 	defer func() {
-		if resp, err := stream.CloseAndRecv(); err != nil {
-			log.Warningf("stream close and receive: response %s with error: %v", resp.String(), err)
+		if opened {
+			w.streamClosed()
 		}
 	}()
-	atomic.AddUint32(&w.senders, 1)
-	defer func() {
-		atomic.AddUint32(&w.senders, ^uint32(0))
-	}()
 	for {
+		stream, err := w.client.CreateDataStreamer(ctx)
+		if err != nil {
+			return unacked, err
+		}
+		// client stays ready while streams are re-created after every batch
+		if !opened {
+			opened = true
+			w.streamOpened()
+		}
+		unacked, err = w.stream(ctx, stream, unacked, ticker)
+		if err != nil || ctx.Err() != nil {
+			return unacked, err
+		}
+		if len(unacked) == 0 {
+			// lines are over
+			return nil, nil
+		}
+		// response confirms that server has written all lines of stream
+		if _, err := stream.CloseAndRecv(); err != nil {
+			return unacked, err
+		}
+		for _, line := range unacked {
+			line.Mark.Ack()
+		}
+		unacked = nil
+		retry.Reset()
+	}
+}
+
+// stream sends unacknowledged lines and new lines until batch is full, batch interval passes,
+// context is done or lines are over, stream is left open to be closed by caller
+func (w *ClientWorker) stream(
+	ctx context.Context,
+	stream filebuf.FileBufferService_CreateDataStreamerClient,
+	unacked []fileio.Line,
+	ticker *time.Ticker,
+) ([]fileio.Line, error) {
+	for _, line := range unacked {
+		if err := stream.Send(&filebuf.Request{Data: line.Content}); err != nil {
+			return unacked, streamError(stream, err)
+		}
+	}
+	for len(unacked) < w.opt.BatchSize {
 		select {
 		case <-ctx.Done():
-			return
-		case str := <-w.str:
-			if err := stream.Send(&filebuf.Request{Data: str}); err != nil {
-				log.Warningf("send stream: %s", err.Error())
+			return unacked, nil
+		case <-ticker.C:
+			if len(unacked) > 0 {
+				return unacked, nil
+			}
+		case line, ok := <-w.str:
+			if !ok {
+				if len(unacked) == 0 {
+					if _, err := stream.CloseAndRecv(); err != nil {
+						log.Warningf("stream close and receive: %v", err)
+					}
+				}
+				return unacked, nil
+			}
+			unacked = append(unacked, line)
+			if err := stream.Send(&filebuf.Request{Data: line.Content}); err != nil {
+				return unacked, streamError(stream, err)
 			}
 		}
 	}
+	return unacked, nil
+}
+
+// streamError status of broken stream is received after send is closed
+func streamError(stream filebuf.FileBufferService_CreateDataStreamerClient, err error) error {
+	if _, recvErr := stream.CloseAndRecv(); recvErr != nil {
+		return recvErr
+	}
+	return err
 }
 
-// runContext main loop for read and rotating logs
+// makeBatchSender sends lines by acknowledged batches of v2 protocol,
+// lines are acknowledged only after server enqueues their batch,
+// broken stream is re-created with backoff and unacknowledged batches are retransmitted over it
+func (w *ClientWorker) makeBatchSender(ctx context.Context, worker int) {
+	defer func() {
+		w.wg.Done()
+		if w.opt.Debug {
+			log.Infof("stop client gRPC batch worker %d", worker)
+		}
+	}()
+	if w.opt.Debug {
+		log.Infof("run client gRPC batch worker %d", worker)
+	}
+	sender := newBatchSender(w)
+	retry := backoff.New(w.opt.RetransmitDelay, w.opt.ReconnectMaxDelay)
+	for {
+		stream, cancel, err := sender.open(ctx)
+		if err == nil {
+			acked := sender.acked()
+			w.streamOpened()
+			err = sender.run(ctx, stream)
+			w.streamClosed()
+			cancel()
+			if err == nil {
+				return
+			}
+			if sender.acked() != acked {
+				retry.Reset()
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warningf(
+			"batch stream of worker %d: %v, %d unacknowledged batches are retransmitted over new stream",
+			worker, err, sender.pending(),
+		)
+		if !retry.Wait(ctx) {
+			return
+		}
+	}
+}
+
+// runContext main loop for read and rotating logs, lines are read to queue even if all streams are broken
 func (w *ClientWorker) runContext(ctx context.Context) {
+	w.done = ctx.Done()
 	w.preparePool(ctx)
-	// run it only if and only if there is at least one writer on the server,
-	// otherwise a deadlock may occur
-	if atomic.LoadUint32(&w.senders) > 0 {
-		w.wg.Add(1)
-		go func() {
-			ticker := time.NewTicker(w.opt.ScrapeInterval)
-			defer func() {
-				ticker.Stop()
-				close(w.str)
-				w.wg.Done()
-				log.Info("stop rotate worker")
-			}()
-			if w.opt.RunAtStartup {
+	w.wg.Add(1)
+	go func() {
+		ticker := time.NewTicker(w.opt.ScrapeInterval)
+		defer func() {
+			ticker.Stop()
+			close(w.str)
+			w.wg.Done()
+			log.Info("stop rotate worker")
+		}()
+		if err := w.rotate.Resume(); err != nil {
+			log.Warning(err)
+		}
+		if w.opt.RunAtStartup {
+			if err := w.rotate.Rotate(); err != nil {
+				log.Warning(err)
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
 				if err := w.rotate.Rotate(); err != nil {
 					log.Warning(err)
 				}
 			}
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-ticker.C:
-					if err := w.rotate.Rotate(); err != nil {
-						log.Warning(err)
-					}
-				}
-			}
-		}()
-	} else {
-		log.Warning("main worker not running, service is useless, please restart")
-	}
+		}
+	}()
 }
 
-// handleFile rotate target file and handle all rows
-func (w *ClientWorker) handleFile(file *os.File) error {
-	// Optionally, resize scanner's capacity for lines over 64K.
-	// Problem is Scanner.Scan() is limited in a 4096 []byte buffer size per line.
-	// We will get bufio.ErrTooLong error, which is bufio.Scanner: token too long if the line is too long.
-	// In which case, you'll have to use bufio.ReaderLine() or ReadString()
-	scanner := bufio.NewScanner(bufio.NewReader(file))
-	for scanner.Scan() {
-		if atomic.LoadUint32(&w.isClosed) == 1 {
-			break
-		}
-		w.str <- scanner.Text()
+// handleLine puts line to queue of senders, line is acknowledged after it is successfully sent.
+// Reading waits for free space only when queue is full, lines are not lost since offsets are committed after sending
+func (w *ClientWorker) handleLine(content string, mark *checkpoint.Mark) error {
+	if atomic.LoadUint32(&w.isClosed) == 1 {
+		return errClientClosed
 	}
-	if scanner.Err() != nil {
-		return scanner.Err()
+	select {
+	case w.str <- fileio.Line{Content: content, Mark: mark}:
+	case <-w.done:
+		return errClientClosed
 	}
+	metrics.LinesRead.WithLabelValues(ClientSource).Inc()
 	return nil
 }
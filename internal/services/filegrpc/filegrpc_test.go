@@ -0,0 +1,366 @@
+package filegrpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/drop"
+	"github.com/zikwall/grower/pkg/fileio"
+	"github.com/zikwall/grower/pkg/handler"
+	"github.com/zikwall/grower/protobuf/filebuf"
+)
+
+type fakeHandler struct{}
+
+func (f *fakeHandler) Handle(content string) (*handler.Row, error) {
+	return &handler.Row{Vector: cx.Vector{content}}, nil
+}
+
+// fakeWriter acknowledges marks of written rows like clickhouse after insert, marks are held while hold is set
+type fakeWriter struct {
+	mu    sync.Mutex
+	lines []string
+	hold  bool
+	held  []*checkpoint.Mark
+}
+
+func (f *fakeWriter) Write(row *handler.Row, mark *checkpoint.Mark) {
+	f.mu.Lock()
+	f.lines = append(f.lines, row.Vector[0].(string))
+	if f.hold {
+		f.held = append(f.held, mark)
+		mark = nil
+	}
+	f.mu.Unlock()
+	mark.Ack()
+}
+
+func (f *fakeWriter) setHold(hold bool) {
+	f.mu.Lock()
+	f.hold = hold
+	held := f.held
+	f.held = nil
+	f.mu.Unlock()
+	for _, mark := range held {
+		mark.Ack()
+	}
+}
+
+func (f *fakeWriter) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.lines)
+}
+
+// flakyServer breaks the first batch stream before acknowledgement
+type flakyServer struct {
+	*Server
+	broken int32
+}
+
+func (f *flakyServer) CreateBatchStreamer(server filebuf.FileBufferService_CreateBatchStreamerServer) error {
+	if atomic.CompareAndSwapInt32(&f.broken, 0, 1) {
+		if _, err := server.Recv(); err != nil {
+			return err
+		}
+		return status.Error(codes.Unavailable, "stream is broken")
+	}
+	return f.Server.CreateBatchStreamer(server)
+}
+
+// flakyClient fails creation of the first data stream, sending over the second one
+// and response of the third one, acked lines are counted when the working stream is created
+type flakyClient struct {
+	filebuf.FileBufferServiceClient
+	calls       int32
+	acked       *int32
+	ackedBefore int32
+}
+
+func (f *flakyClient) CreateDataStreamer(
+	ctx context.Context, opts ...grpc.CallOption,
+) (filebuf.FileBufferService_CreateDataStreamerClient, error) {
+	switch atomic.AddInt32(&f.calls, 1) {
+	case 1:
+		return nil, status.Error(codes.Unavailable, "connection refused")
+	case 2:
+		return &brokenDataStream{}, nil
+	case 3:
+		return &unansweredDataStream{}, nil
+	case 4:
+		atomic.StoreInt32(&f.ackedBefore, atomic.LoadInt32(f.acked))
+	}
+	return f.FileBufferServiceClient.CreateDataStreamer(ctx, opts...)
+}
+
+type brokenDataStream struct {
+	filebuf.FileBufferService_CreateDataStreamerClient
+}
+
+func (b *brokenDataStream) Send(*filebuf.Request) error {
+	return io.EOF
+}
+
+func (b *brokenDataStream) CloseAndRecv() (*filebuf.Response, error) {
+	return nil, status.Error(codes.Unavailable, "stream is broken")
+}
+
+// unansweredDataStream accepts lines, but breaks before response
+type unansweredDataStream struct {
+	filebuf.FileBufferService_CreateDataStreamerClient
+}
+
+func (u *unansweredDataStream) Send(*filebuf.Request) error {
+	return nil
+}
+
+func (u *unansweredDataStream) CloseAndRecv() (*filebuf.Response, error) {
+	return nil, status.Error(codes.Unavailable, "stream is broken")
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout of condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBatchStreamer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer := &fakeWriter{}
+	server := &Server{
+		Impl:    drop.NewContext(ctx),
+		worker:  NewWorker(&fakeHandler{}, writer, &ServerOpt{Runtime: config.Runtime{Parallelism: 2}}),
+		batches: newRecentBatches(16),
+	}
+	server.worker.preparePool(ctx)
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	filebuf.RegisterFileBufferServiceServer(grpcServer, &flakyServer{Server: server})
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	defer grpcServer.Stop()
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	client := filebuf.NewFileBufferServiceClient(conn)
+
+	t.Run("it should be retransmitted and acknowledged batches", func(t *testing.T) {
+		w := &ClientWorker{
+			wg: &sync.WaitGroup{},
+			opt: &ClientOpt{
+				Protocol:        ProtocolV2,
+				BatchSize:       2,
+				BatchInterval:   20 * time.Millisecond,
+				MaxInflight:     2,
+				RetransmitDelay: 10 * time.Millisecond,
+				SourceLogFile:   "access.log",
+			},
+			str:     make(chan fileio.Line),
+			client:  client,
+			session: "test",
+			host:    "localhost",
+		}
+		senderCtx, stopSender := context.WithCancel(ctx)
+		w.wg.Add(1)
+		go w.makeBatchSender(senderCtx, 1)
+		var acked int32
+		for _, line := range []string{"GET /", "POST /", "PUT /", "DELETE /", "HEAD /"} {
+			w.str <- fileio.Line{Content: line, Mark: checkpoint.NewMark(func() {
+				atomic.AddInt32(&acked, 1)
+			})}
+		}
+		waitFor(t, func() bool {
+			return atomic.LoadInt32(&acked) == 5
+		})
+		stopSender()
+		w.wg.Wait()
+		// broken stream did not enqueue the first batch, so lines are written once
+		if writer.len() != 5 {
+			t.Fatalf("expect 5 written lines, got %d", writer.len())
+		}
+	})
+
+	t.Run("it should be acknowledged duplicate batch without enqueueing", func(t *testing.T) {
+		stream, err := client.CreateBatchStreamer(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch := &filebuf.Batch{Id: "duplicate-1", Sequence: 1, Lines: []string{"GET /duplicate"}}
+		for i := 0; i < 2; i++ {
+			if err := stream.Send(batch); err != nil {
+				t.Fatal(err)
+			}
+			ack, err := stream.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ack.Id != batch.Id || ack.Sequence != batch.Sequence {
+				t.Fatalf("unexpected acknowledgement %s", ack.String())
+			}
+		}
+		if err := stream.CloseSend(); err != nil {
+			t.Fatal(err)
+		}
+		if writer.len() != 6 {
+			t.Fatalf("expect duplicate batch is skipped, got %d lines", writer.len())
+		}
+	})
+
+	t.Run("it should be acknowledged batch only after its lines are written", func(t *testing.T) {
+		writer.setHold(true)
+		stream, err := client.CreateBatchStreamer(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch := &filebuf.Batch{Id: "written-1", Sequence: 1, Lines: []string{"GET /a", "GET /b"}}
+		if err := stream.Send(batch); err != nil {
+			t.Fatal(err)
+		}
+		// retransmitted batch waits for the first copy
+		if err := stream.Send(batch); err != nil {
+			t.Fatal(err)
+		}
+		acks := make(chan *filebuf.Ack, 2)
+		go func() {
+			for {
+				ack, err := stream.Recv()
+				if err != nil {
+					return
+				}
+				acks <- ack
+			}
+		}()
+		waitFor(t, func() bool {
+			return writer.len() == 8
+		})
+		select {
+		case ack := <-acks:
+			t.Fatalf("expect no acknowledgement before write, got %s", ack.String())
+		case <-time.After(50 * time.Millisecond):
+		}
+		writer.setHold(false)
+		for i := 0; i < 2; i++ {
+			select {
+			case ack := <-acks:
+				if ack.Id != batch.Id {
+					t.Fatalf("unexpected acknowledgement %s", ack.String())
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("expect acknowledgement after write")
+			}
+		}
+		if err := stream.CloseSend(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("it should be re-created data stream and sent again unacknowledged lines", func(t *testing.T) {
+		w := &ClientWorker{
+			wg: &sync.WaitGroup{},
+			opt: &ClientOpt{
+				Protocol:          ProtocolV1,
+				BatchSize:         2,
+				BatchInterval:     20 * time.Millisecond,
+				RetransmitDelay:   10 * time.Millisecond,
+				ReconnectMaxDelay: 20 * time.Millisecond,
+			},
+			str: make(chan fileio.Line, 4),
+		}
+		var acked int32
+		flaky := &flakyClient{FileBufferServiceClient: client, acked: &acked}
+		w.client = flaky
+		senderCtx, stopSender := context.WithCancel(ctx)
+		w.wg.Add(1)
+		go w.makeSender(senderCtx, 1)
+		for _, line := range []string{"GET /v1", "POST /v1", "PUT /v1"} {
+			w.str <- fileio.Line{Content: line, Mark: checkpoint.NewMark(func() {
+				atomic.AddInt32(&acked, 1)
+			})}
+		}
+		waitFor(t, func() bool {
+			return atomic.LoadInt32(&acked) == 3
+		})
+		if before := atomic.LoadInt32(&flaky.ackedBefore); before != 0 {
+			t.Fatalf("expect lines are not acknowledged without response, got %d", before)
+		}
+		// lines sent over broken streams are written once by server
+		if writer.len() != 11 {
+			t.Fatalf("expect 11 written lines, got %d", writer.len())
+		}
+		if !w.ready() {
+			t.Fatal("expect client is ready with open stream")
+		}
+		stopSender()
+		w.wg.Wait()
+		if w.ready() {
+			t.Fatal("expect client is not ready without streams")
+		}
+	})
+}
+
+func TestRecentBatches(t *testing.T) {
+	t.Run("it should be forgotten the oldest batches", func(t *testing.T) {
+		recent := newRecentBatches(2)
+		has := func(id string) bool {
+			_, ok := recent.get(id)
+			return ok
+		}
+		recent.add("a", newWriteTracker())
+		recent.add("b", newWriteTracker())
+		recent.add("b", newWriteTracker())
+		if !has("a") || !has("b") {
+			t.Fatal("expect batches are remembered")
+		}
+		recent.add("c", newWriteTracker())
+		if has("a") || !has("b") || !has("c") {
+			t.Fatal("expect the oldest batch is forgotten")
+		}
+	})
+
+	t.Run("it should be removed abandoned batch without evicting it again", func(t *testing.T) {
+		recent := newRecentBatches(2)
+		abandoned := newWriteTracker()
+		recent.add("a", abandoned)
+		recent.remove("a", newWriteTracker())
+		if _, ok := recent.get("a"); !ok {
+			t.Fatal("expect batch of another tracker is kept")
+		}
+		recent.remove("a", abandoned)
+		retransmitted := newWriteTracker()
+		recent.add("b", newWriteTracker())
+		recent.add("a", retransmitted)
+		if written, ok := recent.get("a"); !ok || written != retransmitted {
+			t.Fatal("expect retransmitted batch is remembered")
+		}
+	})
+}
@@ -8,9 +8,28 @@ import (
 	"github.com/zikwall/grower/config"
 )
 
+// protocols of client, v1 streams lines which are acknowledged by response of closed stream,
+// v2 streams acknowledged batches over one stream
+const (
+	ProtocolV1 = "v1"
+	ProtocolV2 = "v2"
+)
+
 type ClientOpt struct {
 	config.Runtime
-	ConnectAddress              string
+	config.TLS
+	config.Auth
+	ConnectAddress string
+	Protocol       string
+	BatchSize      int
+	BatchInterval  time.Duration
+	// MaxInflight number of unacknowledged batches of one stream, sending is blocked when it is reached
+	MaxInflight int
+	// RetransmitDelay initial delay before broken stream is re-created, it grows up to ReconnectMaxDelay
+	RetransmitDelay   time.Duration
+	ReconnectMaxDelay time.Duration
+	// QueueSize number of read lines waiting for senders, reading waits only when queue is full
+	QueueSize                   int
 	LogsDir                     string
 	SourceLogFile               string
 	ScrapeInterval              time.Duration
@@ -20,11 +39,16 @@ type ClientOpt struct {
 	AutoCreateTargetFromScratch bool
 	RunAtStartup                bool
 	SkipNginxReopen             bool
+	OffsetFile                  string
 }
 
 type ServerOpt struct {
 	config.Runtime
 	config.Buffer
+	config.DeadLetter
+	config.Spool
+	config.TLS
+	config.Auth
 	Config      *config.Config
 	BindAddress string
 	Clickhouse  *clickhouse.Options
@@ -4,89 +4,239 @@ import (
 	"context"
 	"io"
 	"sync"
+	"sync/atomic"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	clickhousebuffer "github.com/zikwall/clickhouse-buffer/v4"
 	"github.com/zikwall/clickhouse-buffer/v4/src/buffer/cxmem"
 	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
 	"github.com/zikwall/clickhouse-buffer/v4/src/db/cxnative"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 
+	"github.com/zikwall/grower/pkg/auth"
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/deadletter"
 	"github.com/zikwall/grower/pkg/drop"
+	"github.com/zikwall/grower/pkg/fileio"
 	"github.com/zikwall/grower/pkg/handler"
 	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
 	"github.com/zikwall/grower/pkg/nginx"
+	"github.com/zikwall/grower/pkg/spool"
+	"github.com/zikwall/grower/pkg/tlsconfig"
 	"github.com/zikwall/grower/pkg/wrap"
 	"github.com/zikwall/grower/protobuf/filebuf"
 )
 
+// ServerSource name of gRPC server in metrics and dead letters
+const ServerSource = "filegrpc"
+
 type Server struct {
 	filebuf.UnimplementedFileBufferServiceServer
 	*drop.Impl
 	bufferWrapper *wrap.BufferWrapper
 	clientWrapper *wrap.ClientWrapper
 	worker        *FileServerWorker
+	batches       *recentBatches
 }
 
 func NewServer(ctx context.Context, opt *ServerOpt) (*Server, error) {
-	var err error
+	parser, err := nginx.NewParser(opt.Config.Nginx.LogType, opt.Config.Nginx.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+	rowHandler, err := handler.New(opt.Config, parser)
+	if err != nil {
+		return nil, err
+	}
+	if err := spool.Check(&opt.Spool, rowHandler.Types()); err != nil {
+		return nil, err
+	}
 	ch, _, err := cxnative.NewClickhouse(ctx, opt.Clickhouse, &cx.RuntimeOptions{
 		WriteTimeout: opt.WriteTimeout,
 	})
 	if err != nil {
 		return nil, err
 	}
+	ch, err = spool.New(metrics.NewClickhouse(ch), &opt.Spool)
+	if err != nil {
+		return nil, err
+	}
+	// batches and streams are acknowledged to clients only after their lines are written to clickhouse
+	ch = checkpoint.NewClickhouse(ch)
 	client := clickhousebuffer.NewClientWithOptions(ctx, ch, clickhousebuffer.NewOptions(
 		clickhousebuffer.WithFlushInterval(opt.BufFlushInterval),
 		clickhousebuffer.WithBatchSize(opt.BufSize),
 		clickhousebuffer.WithDebugMode(opt.Debug),
 		clickhousebuffer.WithRetry(true),
 	))
+	deadLetterSink, err := deadletter.New(&opt.DeadLetter, client)
+	if err != nil {
+		return nil, err
+	}
 	s := &Server{
 		Impl:          drop.NewContext(ctx),
 		bufferWrapper: wrap.NewBufferWrapper(ch),
 		clientWrapper: wrap.NewClientWrapper(client),
+		batches:       newRecentBatches(recentBatchesSize),
 	}
-	columns, scheme := opt.Config.Scheme.MapKeys()
-	writerAPI := s.Buffer().Writer(
-		clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
-			"max_execution_time": opt.WriteTimeout.Seconds(),
-		})),
-		cx.NewView(opt.Config.Scheme.LogsTable, columns),
-		cxmem.NewBuffer(s.Buffer().Options().BatchSize()),
-	)
+	columns := rowHandler.Columns()
+	newWriter := func(table string) clickhousebuffer.Writer {
+		return s.Buffer().Writer(
+			clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
+				"max_execution_time": opt.WriteTimeout.Seconds(),
+			})),
+			cx.NewView(table, columns),
+			cxmem.NewBuffer(s.Buffer().Options().BatchSize()),
+		)
+	}
+	writerAPI := handler.NewWriter(rowHandler, newWriter(opt.Config.Scheme.LogsTable), newWriter)
 	s.worker = NewWorker(
-		handler.NewRowHandler(
-			columns, scheme,
-			nginx.NewTemplate(opt.Config.Nginx.LogFormat),
-			nginx.NewTypeCaster(&nginx.CasterCfg{
-				CustomCasts:       opt.Config.Nginx.LogCustomCasts,
-				LocalTimeFormat:   opt.Config.Nginx.LogTimeFormat,
-				CustomCastsEnable: opt.Config.Nginx.LogCustomCastsEnable,
-				RemoveHyphen:      opt.Config.Nginx.LogRemoveHyphen,
-			}),
-		),
+		deadletter.NewHandler(rowHandler, deadLetterSink, ServerSource),
 		writerAPI,
 		opt,
 	)
 	s.AddDroppers(
 		s.worker,
+		deadLetterSink,
 		s.clientWrapper,
 		s.bufferWrapper,
 	)
 	return s, nil
 }
 
-// CreateDataStreamer creates a constant stream receiving data from the client
+// ServerOptions options of gRPC server, TLS credentials are added if TLS is enabled,
+// streams and calls are authenticated by tokens if auth scheme is set
+func ServerOptions(opt *ServerOpt) ([]grpc.ServerOption, error) {
+	var options []grpc.ServerOption
+	if opt.TLS.Enabled {
+		tlsConfig, err := tlsconfig.Server(&opt.TLS)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if opt.Auth.Scheme != "" {
+		verifier, err := auth.NewVerifier(opt.Auth.Scheme, opt.Auth.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		a := &authenticator{verifier: verifier, debug: opt.Debug}
+		options = append(options, grpc.StreamInterceptor(a.stream), grpc.UnaryInterceptor(a.unary))
+	}
+	return options, nil
+}
+
+// CreateDataStreamer creates a constant stream receiving data from the client,
+// response is sent after all lines of stream are written, so client acknowledges them only then
 func (s *Server) CreateDataStreamer(server filebuf.FileBufferService_CreateDataStreamerServer) error {
+	streams := metrics.GRPCStreams.WithLabelValues("server")
+	streams.Inc()
+	defer streams.Dec()
+	linesRead := metrics.LinesRead.WithLabelValues(ServerSource)
+	written := newWriteTracker()
 	for {
 		req, err := server.Recv()
 		if err == io.EOF {
+			written.close()
+			if err := s.wait(server.Context(), written); err != nil {
+				return err
+			}
 			return server.SendAndClose(&filebuf.Response{})
 		}
 		if err != nil {
 			return err
 		}
-		s.worker.str <- req.Data
+		if err := s.enqueue(server.Context(), req.Data, written.mark()); err != nil {
+			return err
+		}
+		linesRead.Inc()
+	}
+}
+
+// CreateBatchStreamer creates a stream of batches, every batch is acknowledged after all its lines are written,
+// retransmitted batch which is already enqueued is acknowledged after the first copy is written without enqueueing
+func (s *Server) CreateBatchStreamer(server filebuf.FileBufferService_CreateBatchStreamerServer) error {
+	streams := metrics.GRPCStreams.WithLabelValues("server")
+	streams.Inc()
+	defer streams.Dec()
+	ctx, cancel := context.WithCancel(server.Context())
+	acks := &ackSender{stream: server, accept: s.accept, debug: s.worker.opt.Debug}
+	defer func() {
+		cancel()
+		acks.wait()
+	}()
+	for {
+		batch, err := server.Recv()
+		if err == io.EOF {
+			// client closes stream after its batches are acknowledged
+			acks.wait()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		written, err := s.accept(ctx, batch)
+		if err != nil {
+			return err
+		}
+		if s.worker.opt.Debug {
+			log.Infof("batch %s #%d of %d lines from %s %s is enqueued", batch.Id, batch.Sequence, len(batch.Lines), batch.Host, batch.File)
+		}
+		acks.send(ctx, batch, written)
+	}
+}
+
+// accept enqueues lines of batch and returns tracker of their writing,
+// retransmitted batch is not enqueued again, tracker of its first copy is returned
+func (s *Server) accept(ctx context.Context, batch *filebuf.Batch) (*writeTracker, error) {
+	written, ok := s.batches.get(batch.Id)
+	if ok {
+		metrics.GRPCBatches.WithLabelValues("duplicate").Inc()
+		return written, nil
+	}
+	written = newWriteTracker()
+	// batch is remembered before its lines are enqueued, so concurrent retransmission waits for them
+	s.batches.add(batch.Id, written)
+	linesRead := metrics.LinesRead.WithLabelValues(ServerSource)
+	for _, line := range batch.Lines {
+		if err := s.enqueue(ctx, line, written.mark()); err != nil {
+			// partially enqueued batch is forgotten and enqueued again by retransmission
+			s.batches.remove(batch.Id, written)
+			written.abandon()
+			return nil, err
+		}
+		linesRead.Inc()
+	}
+	written.close()
+	metrics.GRPCBatches.WithLabelValues("enqueued").Inc()
+	return written, nil
+}
+
+// enqueue puts line to worker queue, it fails if stream is closed or server is shutting down
+func (s *Server) enqueue(ctx context.Context, content string, mark *checkpoint.Mark) error {
+	select {
+	case s.worker.str <- fileio.Line{Content: content, Mark: mark}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.Context().Done():
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+}
+
+// wait for all lines of tracker are written
+func (s *Server) wait(ctx context.Context, written *writeTracker) error {
+	select {
+	case <-written.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.Context().Done():
+		return status.Error(codes.Unavailable, "server is shutting down")
 	}
 }
 
@@ -107,19 +257,19 @@ func (s *Server) Run(ctx context.Context) {
 
 type FileServerWorker struct {
 	handler handler.Handler
-	writer  clickhousebuffer.Writer
+	writer  handler.RowWriter
 	wg      *sync.WaitGroup
 	opt     *ServerOpt
-	str     chan string
+	str     chan fileio.Line
 }
 
-func NewWorker(hand handler.Handler, writer clickhousebuffer.Writer, opt *ServerOpt) *FileServerWorker {
+func NewWorker(hand handler.Handler, writer handler.RowWriter, opt *ServerOpt) *FileServerWorker {
 	w := &FileServerWorker{
 		handler: hand,
 		writer:  writer,
 		wg:      &sync.WaitGroup{},
 		opt:     opt,
-		str:     make(chan string),
+		str:     make(chan fileio.Line),
 	}
 	return w
 }
@@ -154,13 +304,104 @@ func (w *FileServerWorker) makeReceiver(ctx context.Context, worker int) {
 		select {
 		case <-ctx.Done():
 			return
-		case str := <-w.str:
-			vector, err := w.handler.Handle(str)
+		case line := <-w.str:
+			row, err := w.handler.Handle(line.Content)
 			if err != nil {
 				log.Warning(err)
+				// rejected line is acknowledged, so batch is not retransmitted forever
+				line.Mark.Ack()
 				continue
 			}
-			w.writer.WriteVector(vector)
+			w.writer.Write(row, line.Mark)
+		}
+	}
+}
+
+// writeTracker counts lines of batch or stream which are not written yet, done is closed after all of them are written,
+// abandoned is closed if not all lines of batch are enqueued
+type writeTracker struct {
+	// pending lines and one more until tracker is closed
+	pending   int64
+	done      chan struct{}
+	abandoned chan struct{}
+}
+
+func newWriteTracker() *writeTracker {
+	return &writeTracker{
+		pending:   1,
+		done:      make(chan struct{}),
+		abandoned: make(chan struct{}),
+	}
+}
+
+// mark of new line, it is acknowledged after line is written
+func (t *writeTracker) mark() *checkpoint.Mark {
+	atomic.AddInt64(&t.pending, 1)
+	return checkpoint.NewMark(t.written)
+}
+
+// close no more lines are tracked
+func (t *writeTracker) close() {
+	t.written()
+}
+
+func (t *writeTracker) abandon() {
+	close(t.abandoned)
+}
+
+func (t *writeTracker) written() {
+	if atomic.AddInt64(&t.pending, -1) == 0 {
+		close(t.done)
+	}
+}
+
+// ackSender sends acknowledgements of batches after they are written, sends are serialized
+// because gRPC stream does not support concurrent sends
+type ackSender struct {
+	stream filebuf.FileBufferService_CreateBatchStreamerServer
+	accept func(ctx context.Context, batch *filebuf.Batch) (*writeTracker, error)
+	debug  bool
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+}
+
+// send acknowledgement after batch is written, it is not sent if context is done before,
+// batch abandoned by another stream is enqueued again
+func (a *ackSender) send(ctx context.Context, batch *filebuf.Batch, written *writeTracker) {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-written.abandoned:
+				var err error
+				if written, err = a.accept(ctx, batch); err != nil {
+					return
+				}
+			case <-written.done:
+				a.ack(batch)
+				return
+			}
 		}
+	}()
+}
+
+func (a *ackSender) ack(batch *filebuf.Batch) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.stream.Send(&filebuf.Ack{Id: batch.Id, Sequence: batch.Sequence}); err != nil {
+		// client retransmits unacknowledged batch over new stream
+		log.Warningf("failed to acknowledge batch %s: %v", batch.Id, err)
+		return
+	}
+	if a.debug {
+		log.Infof("batch %s is written and acknowledged", batch.Id)
 	}
 }
+
+// wait for all pending acknowledgements are sent or given up
+func (a *ackSender) wait() {
+	a.wg.Wait()
+}
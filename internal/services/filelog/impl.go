@@ -1,9 +1,10 @@
 package filelog
 
 import (
-	"bufio"
 	"context"
-	"os"
+	"fmt"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,19 +15,34 @@ import (
 	"github.com/zikwall/clickhouse-buffer/v4/src/db/cxnative"
 
 	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/deadletter"
 	"github.com/zikwall/grower/pkg/drop"
 	"github.com/zikwall/grower/pkg/fileio"
 	"github.com/zikwall/grower/pkg/handler"
 	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
 	"github.com/zikwall/grower/pkg/nginx"
+	"github.com/zikwall/grower/pkg/spool"
 	"github.com/zikwall/grower/pkg/wrap"
 )
 
+// Source name of service in metrics
+const Source = "filelog"
+
+// Modes of reading log file
+const (
+	// ReadModeRotate log file is renamed, nginx reopens it, and renamed file is read once every scrape interval
+	ReadModeRotate = "rotate"
+	// ReadModeTail log file is followed like `tail -F`, it may be rotated by logrotate
+	ReadModeTail = "tail"
+)
+
 type FileLog struct {
 	*drop.Impl
 	bufferWrapper *wrap.BufferWrapper
 	clientWrapper *wrap.ClientWrapper
-	worker        *Worker
+	workers       []*Worker
 }
 
 type Opt struct {
@@ -38,6 +54,8 @@ type Opt struct {
 type Cfg struct {
 	config.Runtime
 	config.Buffer
+	config.DeadLetter
+	config.Spool
 	LogsDir                     string
 	SourceLogFile               string
 	ScrapeInterval              time.Duration
@@ -47,57 +65,112 @@ type Cfg struct {
 	AutoCreateTargetFromScratch bool
 	RunAtStartup                bool
 	SkipNginxReopen             bool
+	ReadMode                    string
+	OffsetFile                  string
+	PollInterval                time.Duration
 }
 
 func New(ctx context.Context, opt *Opt) (*FileLog, error) {
-	var err error
+	switch opt.FileLogConfig.ReadMode {
+	case "":
+		opt.FileLogConfig.ReadMode = ReadModeRotate
+	case ReadModeRotate, ReadModeTail:
+	default:
+		return nil, fmt.Errorf("unknown read mode %s, expected %s or %s",
+			opt.FileLogConfig.ReadMode, ReadModeRotate, ReadModeTail)
+	}
 	ch, _, err := cxnative.NewClickhouse(ctx, opt.Clickhouse, &cx.RuntimeOptions{
 		WriteTimeout: opt.FileLogConfig.WriteTimeout,
 	})
 	if err != nil {
 		return nil, err
 	}
+	ch, err = spool.New(metrics.NewClickhouse(ch), &opt.FileLogConfig.Spool)
+	if err != nil {
+		return nil, err
+	}
+	ch = checkpoint.NewClickhouse(ch)
 	client := clickhousebuffer.NewClientWithOptions(ctx, ch, clickhousebuffer.NewOptions(
 		clickhousebuffer.WithFlushInterval(opt.FileLogConfig.BufFlushInterval),
 		clickhousebuffer.WithBatchSize(opt.FileLogConfig.BufSize),
 		clickhousebuffer.WithDebugMode(opt.FileLogConfig.Debug),
 		clickhousebuffer.WithRetry(true),
 	))
-	columns, scheme := opt.Config.Scheme.MapKeys()
+	deadLetterSink, err := deadletter.New(&opt.FileLogConfig.DeadLetter, client)
+	if err != nil {
+		return nil, err
+	}
 	f := &FileLog{
 		Impl:          drop.NewContext(ctx),
 		bufferWrapper: wrap.NewBufferWrapper(ch),
 		clientWrapper: wrap.NewClientWrapper(client),
 	}
-	writerAPI := f.Buffer().Writer(
-		clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
-			"max_execution_time": opt.FileLogConfig.WriteTimeout.Seconds(),
-		})),
-		cx.NewView(opt.Config.Scheme.LogsTable, columns),
-		cxmem.NewBuffer(f.Buffer().Options().BatchSize()),
-	)
-	f.worker = NewWorker(
-		handler.NewRowHandler(
-			columns, scheme,
-			nginx.NewTemplate(opt.Config.Nginx.LogFormat),
-			nginx.NewTypeCaster(&nginx.CasterCfg{
-				CustomCasts:       opt.Config.Nginx.LogCustomCasts,
-				LocalTimeFormat:   opt.Config.Nginx.LogTimeFormat,
-				CustomCastsEnable: opt.Config.Nginx.LogCustomCastsEnable,
-				RemoveHyphen:      opt.Config.Nginx.LogRemoveHyphen,
-			}),
-		),
-		writerAPI,
-		opt.FileLogConfig,
-	)
+	// connection and client of clickhouse are shared by pipelines, every pipeline has its own buffer
+	for _, pipeline := range pipelines(opt) {
+		pipelineCfg := opt.Config.Pipeline(&pipeline)
+		parser, err := nginx.NewParser(pipelineCfg.Nginx.LogType, pipelineCfg.Nginx.LogFormat)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", pipeline.Name, err)
+		}
+		rowHandler, err := handler.New(pipelineCfg, parser)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", pipeline.Name, err)
+		}
+		if err := spool.Check(&opt.FileLogConfig.Spool, rowHandler.Types()); err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", pipeline.Name, err)
+		}
+		columns := rowHandler.Columns()
+		newWriter := func(table string) clickhousebuffer.Writer {
+			return f.Buffer().Writer(
+				clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
+					"max_execution_time": opt.FileLogConfig.WriteTimeout.Seconds(),
+				})),
+				cx.NewView(table, columns),
+				cxmem.NewBuffer(f.Buffer().Options().BatchSize()),
+			)
+		}
+		writerAPI := handler.NewWriter(rowHandler, newWriter(pipeline.Scheme.LogsTable), newWriter)
+		cfg := *opt.FileLogConfig
+		cfg.SourceLogFile = pipeline.Source
+		if len(opt.Config.Pipelines) > 0 {
+			cfg.OffsetFile = pipelineOffsetFile(cfg.OffsetFile, pipeline.Name)
+		}
+		f.workers = append(f.workers, NewWorker(
+			deadletter.NewHandler(rowHandler, deadLetterSink, path.Join(cfg.LogsDir, cfg.SourceLogFile)),
+			writerAPI,
+			&cfg,
+		))
+	}
+	for _, worker := range f.workers {
+		f.AddDroppers(worker)
+	}
 	f.AddDroppers(
-		f.worker,
+		deadLetterSink,
 		f.clientWrapper,
+		&checkpointSaver{workers: f.workers},
 		f.bufferWrapper,
 	)
 	return f, nil
 }
 
+// pipelines of config, single pipeline of nginx and scheme sections reads source log file of arguments
+func pipelines(opt *Opt) []config.Pipeline {
+	if len(opt.Config.Pipelines) > 0 {
+		return opt.Config.Pipelines
+	}
+	return []config.Pipeline{{
+		Source: opt.FileLogConfig.SourceLogFile,
+		Nginx:  opt.Config.Nginx,
+		Scheme: opt.Config.Scheme,
+	}}
+}
+
+// pipelineOffsetFile offsets of every pipeline are persisted to their own file, e.g. offset.access.json
+func pipelineOffsetFile(offsetFile, name string) string {
+	ext := path.Ext(offsetFile)
+	return strings.TrimSuffix(offsetFile, ext) + "." + name + ext
+}
+
 // Context get root service level context
 func (f *FileLog) Context() context.Context {
 	return f.Impl.Context()
@@ -110,16 +183,20 @@ func (f *FileLog) Buffer() clickhousebuffer.Client {
 
 // Run service
 func (f *FileLog) Run(ctx context.Context) {
-	f.worker.runContext(ctx)
+	for _, worker := range f.workers {
+		worker.runContext(ctx)
+	}
 }
 
 type Worker struct {
 	wg         *sync.WaitGroup
 	cfg        *Cfg
 	rowHandler handler.Handler
-	writer     clickhousebuffer.Writer
-	raw        chan string
+	writer     handler.RowWriter
+	raw        chan fileio.Line
 	rotator    fileio.Rotator
+	tail       *fileio.Tail
+	done       <-chan struct{}
 }
 
 func (w *Worker) Drop() error {
@@ -131,13 +208,13 @@ func (w *Worker) DropMsg() string {
 	return "kill file log server"
 }
 
-func NewWorker(rowHandler handler.Handler, writer clickhousebuffer.Writer, cfg *Cfg) *Worker {
+func NewWorker(rowHandler handler.Handler, writer handler.RowWriter, cfg *Cfg) *Worker {
 	w := &Worker{
 		wg:         &sync.WaitGroup{},
 		cfg:        cfg,
 		rowHandler: rowHandler,
 		writer:     writer,
-		raw:        make(chan string),
+		raw:        make(chan fileio.Line),
 	}
 	w.rotator = fileio.New(
 		cfg.SourceLogFile,
@@ -147,8 +224,17 @@ func NewWorker(rowHandler handler.Handler, writer clickhousebuffer.Writer, cfg *
 		cfg.AutoCreateTargetFromScratch,
 		cfg.EnableRotating,
 		cfg.SkipNginxReopen,
-		w.handleFile,
+		cfg.OffsetFile,
+		w.handleLine,
 	)
+	if cfg.ReadMode == ReadModeTail {
+		w.tail = fileio.NewTail(
+			path.Join(cfg.LogsDir, cfg.SourceLogFile),
+			cfg.OffsetFile,
+			cfg.PollInterval,
+			w.handleLine,
+		)
+	}
 	return w
 }
 
@@ -175,11 +261,16 @@ func (w *Worker) worker(ctx context.Context, worker int) {
 		select {
 		case <-ctx.Done():
 			return
-		case raw := <-w.raw:
-			if vector, err := w.rowHandler.Handle(raw); err != nil {
+		case raw, ok := <-w.raw:
+			if !ok {
+				return
+			}
+			if row, err := w.rowHandler.Handle(raw.Content); err != nil {
 				log.Warning(err)
+				// rejected line will not be delivered anyway, so reading may go further
+				raw.Mark.Ack()
 			} else {
-				w.writer.WriteVector(vector)
+				w.writer.Write(row, raw.Mark)
 			}
 		}
 	}
@@ -187,7 +278,12 @@ func (w *Worker) worker(ctx context.Context, worker int) {
 
 // runContext main loop for read and rotating logs
 func (w *Worker) runContext(ctx context.Context) {
+	w.done = ctx.Done()
 	w.preparePool(ctx)
+	if w.tail != nil {
+		w.followContext(ctx)
+		return
+	}
 	w.wg.Add(1)
 	go func() {
 		ticker := time.NewTicker(w.cfg.ScrapeInterval)
@@ -197,6 +293,9 @@ func (w *Worker) runContext(ctx context.Context) {
 			w.wg.Done()
 			log.Info("stop scrapper worker")
 		}()
+		if err := w.rotator.Resume(); err != nil {
+			log.Warning(err)
+		}
 		if w.cfg.RunAtStartup {
 			if err := w.rotator.Rotate(); err != nil {
 				log.Warning(err)
@@ -215,18 +314,53 @@ func (w *Worker) runContext(ctx context.Context) {
 	}()
 }
 
-// handleFile rotate target file and handle all rows
-func (w *Worker) handleFile(file *os.File) error {
-	// Optionally, resize scanner's capacity for lines over 64K.
-	// Problem is Scanner.Scan() is limited in a 4096 []byte buffer size per line.
-	// We will get bufio.ErrTooLong error, which is bufio.Scanner: token too long if the line is too long.
-	// In which case, you'll have to use bufio.ReaderLine() or ReadString()
-	scanner := bufio.NewScanner(bufio.NewReader(file))
-	for scanner.Scan() {
-		w.raw <- scanner.Text()
+// followContext reads new lines of log file as soon as they are written
+func (w *Worker) followContext(ctx context.Context) {
+	w.wg.Add(1)
+	go func() {
+		defer func() {
+			close(w.raw)
+			w.wg.Done()
+			log.Info("stop tail worker")
+		}()
+		if err := w.tail.Follow(ctx); err != nil {
+			log.Warning(err)
+		}
+	}()
+}
+
+// handleLine does not accept line after shutdown, so it stays after saved offset
+func (w *Worker) handleLine(content string, mark *checkpoint.Mark) error {
+	select {
+	case w.raw <- fileio.Line{Content: content, Mark: mark}:
+		metrics.LinesRead.WithLabelValues(Source).Inc()
+		return nil
+	case <-w.done:
+		return context.Canceled
+	}
+}
+
+// saveCheckpoints persists offsets of delivered lines
+func (w *Worker) saveCheckpoints() {
+	if w.tail != nil {
+		w.tail.Save()
+		return
 	}
-	if scanner.Err() != nil {
-		return scanner.Err()
+	w.rotator.Save()
+}
+
+// checkpointSaver saves offsets after clickhouse buffer is flushed on shutdown
+type checkpointSaver struct {
+	workers []*Worker
+}
+
+func (c *checkpointSaver) Drop() error {
+	for _, worker := range c.workers {
+		worker.saveCheckpoints()
 	}
 	return nil
 }
+
+func (c *checkpointSaver) DropMsg() string {
+	return "save file log checkpoints"
+}
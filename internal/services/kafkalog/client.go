@@ -1,19 +1,32 @@
 package kafkalog
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
-	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 
+	"github.com/zikwall/grower/pkg/backoff"
+	"github.com/zikwall/grower/pkg/checkpoint"
 	"github.com/zikwall/grower/pkg/drop"
 	"github.com/zikwall/grower/pkg/fileio"
 	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+)
+
+var errClientClosed = errors.New("client is closed")
+
+// ClientSource name of kafka client in metrics
+const ClientSource = "kafkalog_client"
+
+// delays between retries of failed write of line
+const (
+	writeRetryMinDelay = 500 * time.Millisecond
+	writeRetryMaxDelay = 30 * time.Second
 )
 
 type Client struct {
@@ -42,7 +55,7 @@ type ClientWorker struct {
 	writer   *kafka.Writer
 	rotator  fileio.Rotator
 	opt      *Opt
-	str      chan string
+	str      chan fileio.Line
 	wg       *sync.WaitGroup
 	isClosed uint32
 }
@@ -69,7 +82,7 @@ func NewClientWorker(ctx context.Context, opt *Opt) (*ClientWorker, error) {
 	c := &ClientWorker{
 		writer: w,
 		opt:    opt,
-		str:    make(chan string),
+		str:    make(chan fileio.Line),
 		wg:     &sync.WaitGroup{},
 	}
 	c.rotator = fileio.New(
@@ -80,7 +93,8 @@ func NewClientWorker(ctx context.Context, opt *Opt) (*ClientWorker, error) {
 		opt.AutoCreateTargetFromScratch,
 		opt.EnableRotating,
 		opt.SkipNginxReopen,
-		c.handleFile,
+		opt.OffsetFile,
+		c.handleLine,
 	)
 	// nolint:staticcheck // it's ok
 	if opt.KafkaCreateTopic {
@@ -103,6 +117,8 @@ func (w *ClientWorker) Drop() error {
 	log.Info("stop all workers")
 	err := w.writer.Close()
 	log.Info("close kafka writer")
+	// save offsets of written lines
+	w.rotator.Save()
 	return err
 }
 
@@ -129,14 +145,35 @@ func (w *ClientWorker) makeWriteListener(ctx context.Context, worker uint) {
 	if w.opt.Debug {
 		log.Infof("run kafka writer %d", worker)
 	}
+	retry := backoff.New(writeRetryMinDelay, writeRetryMaxDelay)
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case str := <-w.str:
-			if err := w.Write(ctx, str); err != nil {
-				log.Warningf("write to kafka: %s", err.Error())
+		case str, ok := <-w.str:
+			if !ok {
+				return
+			}
+			if !w.writeLine(ctx, str.Content, retry) {
+				return
 			}
+			str.Mark.Ack()
+		}
+	}
+}
+
+// writeLine retries failed write until context is done, so the line is not skipped by checkpoint,
+// line which is not written on shutdown stays after saved offset and is read again
+func (w *ClientWorker) writeLine(ctx context.Context, content string, retry *backoff.Backoff) bool {
+	defer retry.Reset()
+	for {
+		err := w.Write(ctx, content)
+		if err == nil {
+			return true
+		}
+		log.Warningf("write to kafka: %s", err.Error())
+		if !retry.Wait(ctx) {
+			return false
 		}
 	}
 }
@@ -153,6 +190,9 @@ func (w *ClientWorker) runContext(ctx context.Context) {
 			w.wg.Done()
 			log.Info("stop rotate worker")
 		}()
+		if err := w.rotator.Resume(); err != nil {
+			log.Warning(err)
+		}
 		if w.opt.RunAtStartup {
 			if err := w.rotator.Rotate(); err != nil {
 				log.Warning(err)
@@ -171,17 +211,12 @@ func (w *ClientWorker) runContext(ctx context.Context) {
 	}()
 }
 
-// handleFile rotate target file and handle all rows
-func (w *ClientWorker) handleFile(file *os.File) error {
-	scanner := bufio.NewScanner(bufio.NewReader(file))
-	for scanner.Scan() {
-		if atomic.LoadUint32(&w.isClosed) == 1 {
-			break
-		}
-		w.str <- scanner.Text()
-	}
-	if scanner.Err() != nil {
-		return scanner.Err()
+// handleLine sends line to senders, line is acknowledged after it is successfully sent
+func (w *ClientWorker) handleLine(content string, mark *checkpoint.Mark) error {
+	if atomic.LoadUint32(&w.isClosed) == 1 {
+		return errClientClosed
 	}
+	w.str <- fileio.Line{Content: content, Mark: mark}
+	metrics.LinesRead.WithLabelValues(ClientSource).Inc()
 	return nil
 }
@@ -34,9 +34,12 @@ type ClientOpt struct {
 	RunAtStartup                bool
 	SkipNginxReopen             bool
 	RewriteNginxLocalTime       bool
+	OffsetFile                  string
 }
 
 type ServerOpt struct {
+	config.DeadLetter
+	config.Spool
 	KafkaGroupID     string
 	Clickhouse       *clickhouse.Options
 	BufSize          uint
@@ -3,6 +3,7 @@ package kafkalog
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,13 +15,21 @@ import (
 	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
 	"github.com/zikwall/clickhouse-buffer/v4/src/db/cxnative"
 
+	"github.com/zikwall/grower/pkg/deadletter"
 	"github.com/zikwall/grower/pkg/drop"
 	"github.com/zikwall/grower/pkg/handler"
 	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
 	"github.com/zikwall/grower/pkg/nginx"
+	"github.com/zikwall/grower/pkg/spool"
 	"github.com/zikwall/grower/pkg/wrap"
 )
 
+// ServerSource name of kafka server in metrics and dead letters
+const ServerSource = "kafkalog"
+
+const lagUpdateInterval = 5 * time.Second
+
 type Server struct {
 	*drop.Impl
 	worker        *ServerWorker
@@ -29,44 +38,56 @@ type Server struct {
 }
 
 func NewServer(ctx context.Context, opt *Opt) (*Server, error) {
-	var err error
+	parser, err := nginx.NewParser(opt.Config.Nginx.LogType, opt.Config.Nginx.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+	rowHandler, err := handler.New(opt.Config, parser)
+	if err != nil {
+		return nil, err
+	}
+	if err := spool.Check(&opt.Spool, rowHandler.Types()); err != nil {
+		return nil, err
+	}
 	ch, _, err := cxnative.NewClickhouse(ctx, opt.Clickhouse, &cx.RuntimeOptions{
 		WriteTimeout: opt.WriteTimeout,
 	})
 	if err != nil {
 		return nil, err
 	}
+	ch, err = spool.New(metrics.NewClickhouse(ch), &opt.Spool)
+	if err != nil {
+		return nil, err
+	}
 	client := clickhousebuffer.NewClientWithOptions(ctx, ch, clickhousebuffer.NewOptions(
 		clickhousebuffer.WithFlushInterval(opt.BufFlushInterval),
 		clickhousebuffer.WithBatchSize(opt.BufSize),
 		clickhousebuffer.WithDebugMode(opt.Debug),
 		clickhousebuffer.WithRetry(true),
 	))
+	deadLetterSink, err := deadletter.New(&opt.DeadLetter, client)
+	if err != nil {
+		return nil, err
+	}
 	s := &Server{
 		Impl:          drop.NewContext(ctx),
 		bufferWrapper: wrap.NewBufferWrapper(ch),
 		clientWrapper: wrap.NewClientWrapper(client),
 	}
-	columns, scheme := opt.Config.Scheme.MapKeys()
-	writerAPI := s.clientWrapper.Client().Writer(
-		clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
-			"max_execution_time": opt.ServerOpt.WriteTimeout.Seconds(),
-		})),
-		cx.NewView(opt.Config.Scheme.LogsTable, columns),
-		cxmem.NewBuffer(s.clientWrapper.Client().Options().BatchSize()),
-	)
+	columns := rowHandler.Columns()
+	newWriter := func(table string) clickhousebuffer.Writer {
+		return s.clientWrapper.Client().Writer(
+			clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
+				"max_execution_time": opt.ServerOpt.WriteTimeout.Seconds(),
+			})),
+			cx.NewView(table, columns),
+			cxmem.NewBuffer(s.clientWrapper.Client().Options().BatchSize()),
+		)
+	}
+	writerAPI := handler.NewWriter(rowHandler, newWriter(opt.Config.Scheme.LogsTable), newWriter)
 	server, err := NewServerWorker(
 		ctx,
-		handler.NewRowHandler(
-			columns, scheme,
-			nginx.NewTemplate(opt.Config.Nginx.LogFormat),
-			nginx.NewTypeCaster(&nginx.CasterCfg{
-				CustomCasts:       opt.Config.Nginx.LogCustomCasts,
-				LocalTimeFormat:   opt.Config.Nginx.LogTimeFormat,
-				CustomCastsEnable: opt.Config.Nginx.LogCustomCastsEnable,
-				RemoveHyphen:      opt.Config.Nginx.LogRemoveHyphen,
-			}),
-		),
+		deadletter.NewHandler(rowHandler, deadLetterSink, ServerSource+":"+opt.KafkaTopic),
 		writerAPI,
 		opt,
 	)
@@ -76,6 +97,7 @@ func NewServer(ctx context.Context, opt *Opt) (*Server, error) {
 	s.worker = server
 	s.AddDroppers(
 		s.worker,
+		deadLetterSink,
 		s.clientWrapper,
 		s.bufferWrapper,
 	)
@@ -90,7 +112,7 @@ type ServerWorker struct {
 	opt      *Opt
 	wg       *sync.WaitGroup
 	handler  handler.Handler
-	writer   clickhousebuffer.Writer
+	writer   handler.RowWriter
 	isClosed uint32
 }
 
@@ -132,29 +154,38 @@ func (s *ServerWorker) makeReaderListener(ctx context.Context, worker uint) {
 	if s.opt.Debug {
 		log.Infof("run kafka reader %d", worker)
 	}
+	linesRead := metrics.LinesRead.WithLabelValues(ServerSource)
+	lag := metrics.KafkaLag.WithLabelValues(strconv.FormatUint(uint64(worker), 10))
+	lagUpdatedAt := time.Time{}
 	for {
 		m, err := r.ReadMessage(ctx)
 		if err != nil {
 			break
 		}
+		linesRead.Inc()
+		// reader statistics is not free, so lag is updated periodically
+		if time.Since(lagUpdatedAt) > lagUpdateInterval {
+			lag.Set(float64(r.Stats().Lag))
+			lagUpdatedAt = time.Now()
+		}
 		if s.opt.Debug {
 			fmt.Printf("kafka [reader %d] [partiton %d] at offset %d: %s\n",
 				worker, m.Partition, m.Offset, string(m.Key),
 			)
 		}
-		vector, err := s.handler.Handle(string(m.Value))
+		row, err := s.handler.Handle(string(m.Value))
 		if err != nil {
 			log.Warning(err)
 			continue
 		}
-		s.writer.WriteVector(vector)
+		s.writer.Write(row, nil)
 	}
 }
 
 func NewServerWorker(
 	ctx context.Context,
 	rowHandler handler.Handler,
-	writer clickhousebuffer.Writer,
+	writer handler.RowWriter,
 	opt *Opt,
 ) (*ServerWorker, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
@@ -0,0 +1,246 @@
+package natslog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/drop"
+	"github.com/zikwall/grower/pkg/fileio"
+	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+)
+
+var errClientClosed = errors.New("client is closed")
+
+// ClientSource name of nats client in metrics
+const ClientSource = "natslog_client"
+
+const dialTimeout = 5 * time.Second
+
+type Client struct {
+	*drop.Impl
+	worker *ClientWorker
+}
+
+func NewClient(ctx context.Context, opt *Opt) (*Client, error) {
+	worker, err := NewClientWorker(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		Impl:   drop.NewContext(ctx),
+		worker: worker,
+	}
+	client.AddDropper(client.worker)
+	return client, nil
+}
+
+func (c *Client) Run(ctx context.Context) {
+	c.worker.runContext(ctx)
+}
+
+type ClientWorker struct {
+	rotator  fileio.Rotator
+	opt      *Opt
+	subject  string
+	str      chan fileio.Line
+	wg       *sync.WaitGroup
+	isClosed uint32
+}
+
+func NewClientWorker(ctx context.Context, opt *Opt) (*ClientWorker, error) {
+	subject := opt.subject()
+	// check connection
+	p := &publisher{opt: opt, subject: subject}
+	if _, err := p.connect(); err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	p.close()
+	log.Infof("nats is successfully connected, publish to %s", subject)
+	c := &ClientWorker{
+		opt:     opt,
+		subject: subject,
+		str:     make(chan fileio.Line),
+		wg:      &sync.WaitGroup{},
+	}
+	c.rotator = fileio.New(
+		opt.SourceLogFile,
+		opt.LogsDir,
+		opt.BackupFiles,
+		opt.BackupFileMaxAge,
+		opt.AutoCreateTargetFromScratch,
+		opt.EnableRotating,
+		opt.SkipNginxReopen,
+		opt.OffsetFile,
+		c.handleLine,
+	)
+	return c, nil
+}
+
+func (w *ClientWorker) Drop() error {
+	atomic.StoreUint32(&w.isClosed, 1)
+	w.wg.Wait()
+	log.Info("stop all workers")
+	// save offsets of published lines
+	w.rotator.Save()
+	return nil
+}
+
+func (w *ClientWorker) DropMsg() string {
+	return "kill nats client"
+}
+
+// create worker pool for publishing lines
+func (w *ClientWorker) preparePool(ctx context.Context) {
+	var i uint
+	for i = 1; i <= w.opt.AsyncFactor; i++ {
+		w.wg.Add(1)
+		go w.makeWriteListener(ctx, i)
+	}
+}
+
+func (w *ClientWorker) makeWriteListener(ctx context.Context, worker uint) {
+	p := &publisher{opt: w.opt, subject: w.subject}
+	defer func() {
+		p.close()
+		w.wg.Done()
+		if w.opt.Debug {
+			log.Infof("stop nats publisher %d", worker)
+		}
+	}()
+	if w.opt.Debug {
+		log.Infof("run nats publisher %d", worker)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case str, ok := <-w.str:
+			if !ok {
+				return
+			}
+			if err := p.publishRetry(ctx, str.Content); err != nil {
+				// line is not acknowledged, so it is read again after restart
+				log.Warningf("publish to nats: %s", err.Error())
+				continue
+			}
+			str.Mark.Ack()
+		}
+	}
+}
+
+// runContext main loop for read and rotating logs
+func (w *ClientWorker) runContext(ctx context.Context) {
+	w.preparePool(ctx)
+	w.wg.Add(1)
+	go func() {
+		ticker := time.NewTicker(w.opt.ScrapeInterval)
+		defer func() {
+			ticker.Stop()
+			close(w.str)
+			w.wg.Done()
+			log.Info("stop rotate worker")
+		}()
+		if err := w.rotator.Resume(); err != nil {
+			log.Warning(err)
+		}
+		if w.opt.RunAtStartup {
+			if err := w.rotator.Rotate(); err != nil {
+				log.Warning(err)
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := w.rotator.Rotate(); err != nil {
+					log.Warning(err)
+				}
+			}
+		}
+	}()
+}
+
+// handleLine sends line to publishers, line is acknowledged after it is stored by stream or flushed to nats
+func (w *ClientWorker) handleLine(content string, mark *checkpoint.Mark) error {
+	if atomic.LoadUint32(&w.isClosed) == 1 {
+		return errClientClosed
+	}
+	w.str <- fileio.Line{Content: content, Mark: mark}
+	metrics.LinesRead.WithLabelValues(ClientSource).Inc()
+	return nil
+}
+
+// publisher own connection of worker, it is reconnected after failures
+type publisher struct {
+	opt     *Opt
+	subject string
+	conn    *nats.Conn
+}
+
+func (p *publisher) connect() (*nats.Conn, error) {
+	if p.conn != nil && !p.conn.IsClosed() {
+		return p.conn, nil
+	}
+	p.close()
+	conn, err := p.opt.connect(ClientSource)
+	if err != nil {
+		return nil, err
+	}
+	p.conn = conn
+	return conn, nil
+}
+
+func (p *publisher) publish(ctx context.Context, message string) error {
+	conn, err := p.connect()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.opt.NatsPublishTimeout)
+	defer cancel()
+	if p.opt.NatsMode == ModeCore {
+		// there is no acknowledgement in core nats, flush only guarantees that server received message
+		if err := conn.Publish(p.subject, []byte(message)); err != nil {
+			return err
+		}
+		return conn.FlushWithContext(ctx)
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		return err
+	}
+	_, err = js.Publish(p.subject, []byte(message), nats.Context(ctx))
+	return err
+}
+
+// publishRetry publishes line until success, so lines are not skipped while nats or stream is unavailable
+func (p *publisher) publishRetry(ctx context.Context, message string) error {
+	for {
+		err := p.publish(ctx, message)
+		if err == nil {
+			return nil
+		}
+		log.Warningf("publish to nats: %s, retry in %s", err.Error(), p.opt.NatsReconnectDelay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(p.opt.NatsReconnectDelay):
+		}
+	}
+}
+
+func (p *publisher) close() {
+	if p.conn == nil {
+		return
+	}
+	p.conn.Close()
+	p.conn = nil
+}
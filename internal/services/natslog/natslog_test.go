@@ -0,0 +1,492 @@
+package natslog
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/handler"
+)
+
+type fakeHandler struct{}
+
+func (f *fakeHandler) Handle(content string) (*handler.Row, error) {
+	if content == "broken" {
+		return nil, errors.New("parse error")
+	}
+	return &handler.Row{Vector: cx.Vector{content}}, nil
+}
+
+// fakeWriter acknowledges marks immediately, as if every row is flushed to clickhouse, marks are held while hold is set
+type fakeWriter struct {
+	mu      sync.Mutex
+	vectors []cx.Vector
+	hold    bool
+	held    []*checkpoint.Mark
+}
+
+func (f *fakeWriter) Write(row *handler.Row, mark *checkpoint.Mark) {
+	f.mu.Lock()
+	f.vectors = append(f.vectors, row.Vector)
+	if f.hold {
+		f.held = append(f.held, mark)
+		mark = nil
+	}
+	f.mu.Unlock()
+	mark.Ack()
+}
+
+func (f *fakeWriter) setHold(hold bool) {
+	f.mu.Lock()
+	f.hold = hold
+	held := f.held
+	f.held = nil
+	f.mu.Unlock()
+	for _, mark := range held {
+		mark.Ack()
+	}
+}
+
+func (f *fakeWriter) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.vectors)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout of condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// natsURL of NATS server with JetStream for integration tests, e.g. nats://localhost:4222, tests are skipped without it
+func natsURL(t *testing.T) string {
+	t.Helper()
+	url := os.Getenv("GROWER_TEST_NATS_URL")
+	if url == "" {
+		t.Skip("GROWER_TEST_NATS_URL is not set")
+	}
+	return url
+}
+
+// newOpt options with unique stream and subjects, so tests do not share state of server
+func newOpt(url, mode string) *Opt {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return &Opt{
+		ClientOpt: ClientOpt{
+			NatsSubject:        "logs" + suffix + ".{host}.{file}",
+			NatsPublishTimeout: time.Second,
+			SourceLogFile:      "access.log",
+		},
+		ServerOpt: ServerOpt{
+			NatsFilterSubject: "logs" + suffix + ".>",
+			NatsDurable:       "grower",
+			NatsQueueGroup:    "grower",
+			NatsBatch:         10,
+			NatsMaxAckPending: 100,
+			NatsAckWait:       time.Second,
+		},
+		AsyncFactor:        2,
+		NatsURL:            url,
+		NatsMode:           mode,
+		NatsStream:         "LOGS_" + suffix,
+		NatsReconnectDelay: 10 * time.Millisecond,
+	}
+}
+
+// inspector is a separate connection to check state of stream and consumer
+type inspector struct {
+	conn *nats.Conn
+	js   nats.JetStreamContext
+}
+
+func newInspector(t *testing.T, url string) *inspector {
+	t.Helper()
+	conn, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &inspector{conn: conn, js: js}
+}
+
+func (i *inspector) stored(t *testing.T, stream string) uint64 {
+	t.Helper()
+	info, err := i.js.StreamInfo(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.State.Msgs
+}
+
+// pending messages which are not delivered yet or not acknowledged
+func (i *inspector) pending(t *testing.T, stream, durable string) uint64 {
+	t.Helper()
+	info, err := i.js.ConsumerInfo(stream, durable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.NumPending + uint64(info.NumAckPending)
+}
+
+func (i *inspector) cleanup(stream string) {
+	_ = i.js.DeleteStream(stream)
+	i.conn.Close()
+}
+
+func TestSubject(t *testing.T) {
+	t.Run("it should be resolved template with sanitized tokens", func(t *testing.T) {
+		opt := &Opt{ClientOpt: ClientOpt{NatsSubject: "logs.{file}", SourceLogFile: "/var/log/nginx/access.v2.log"}}
+		if subject := opt.subject(); subject != "logs.access_v2" {
+			t.Fatalf("expect logs.access_v2, got %s", subject)
+		}
+		if token := subjectToken("web 1.local>*"); token != "web_1_local__" {
+			t.Fatalf("unexpected token %s", token)
+		}
+	})
+}
+
+func TestNewServerWorker(t *testing.T) {
+	t.Run("it should be error of unknown mode", func(t *testing.T) {
+		if _, err := NewServerWorker(context.Background(), &fakeHandler{}, &fakeWriter{}, newOpt("", "push")); err == nil {
+			t.Fatal("expect error of unknown mode")
+		}
+	})
+}
+
+// fakeMessage message of fake stream
+type fakeMessage struct {
+	data       string
+	deliveries int
+}
+
+// fakeStream is in-process stream with durable consumer: messages are delivered until they are acknowledged
+// or terminated, message which is not acknowledged within ack wait is redelivered,
+// messages of core nats are delivered once without acknowledgements
+type fakeStream struct {
+	mu          sync.Mutex
+	ready       []*fakeMessage
+	pending     map[*fakeMessage]time.Time
+	acked       int
+	redelivered int
+	terminated  []string
+	consumers   []*fakeConsumer
+}
+
+func newFakeStream() *fakeStream {
+	return &fakeStream{pending: map[*fakeMessage]time.Time{}}
+}
+
+func (s *fakeStream) dial(_ context.Context, opt *Opt) (consumer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &fakeConsumer{stream: s, core: opt.NatsMode == ModeCore, ackWait: opt.NatsAckWait}
+	s.consumers = append(s.consumers, c)
+	return c, nil
+}
+
+func (s *fakeStream) publish(lines ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, line := range lines {
+		s.ready = append(s.ready, &fakeMessage{data: line})
+	}
+}
+
+// state of stream: numbers of messages which are not acknowledged yet, acknowledged and redelivered messages
+func (s *fakeStream) state() (pending, acked, redelivered int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.ready) + len(s.pending), s.acked, s.redelivered
+}
+
+func (s *fakeStream) terminatedMessages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.terminated...)
+}
+
+type fakeConsumer struct {
+	stream  *fakeStream
+	core    bool
+	ackWait time.Duration
+	closed  bool
+}
+
+func (c *fakeConsumer) receive(ctx context.Context) ([]*delivery, error) {
+	s := c.stream
+	s.mu.Lock()
+	if c.closed {
+		s.mu.Unlock()
+		return nil, nats.ErrConnectionClosed
+	}
+	now := time.Now()
+	for message, deadline := range s.pending {
+		if now.After(deadline) {
+			delete(s.pending, message)
+			s.ready = append(s.ready, message)
+		}
+	}
+	deliveries := make([]*delivery, 0, len(s.ready))
+	for _, message := range s.ready {
+		message.deliveries++
+		if message.deliveries > 1 {
+			s.redelivered++
+		}
+		if !c.core {
+			s.pending[message] = now.Add(c.ackWait)
+		}
+		deliveries = append(deliveries, c.delivery(message))
+	}
+	s.ready = nil
+	s.mu.Unlock()
+	// like fetch, receive waits for messages a while
+	if len(deliveries) == 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	return deliveries, nil
+}
+
+// delivery of message, acknowledgements of closed connection fail, message is settled by the first of them
+func (c *fakeConsumer) delivery(message *fakeMessage) *delivery {
+	settle := func(terminate bool) error {
+		s := c.stream
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if c.closed {
+			return nats.ErrConnectionClosed
+		}
+		if _, ok := s.pending[message]; !ok {
+			return nil
+		}
+		delete(s.pending, message)
+		if terminate {
+			s.terminated = append(s.terminated, message.data)
+		} else {
+			s.acked++
+		}
+		return nil
+	}
+	return &delivery{
+		data:    []byte(message.data),
+		subject: "logs.web.access",
+		ack: func() error {
+			return settle(false)
+		},
+		term: func() error {
+			return settle(true)
+		},
+	}
+}
+
+func (c *fakeConsumer) close() {
+	c.stream.mu.Lock()
+	c.closed = true
+	c.stream.mu.Unlock()
+}
+
+func TestServerWorker(t *testing.T) {
+	run := func(t *testing.T, stream *fakeStream, writer *fakeWriter, opt *Opt) func() {
+		t.Helper()
+		worker, err := newServerWorker(context.Background(), &fakeHandler{}, writer, opt, stream.dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		worker.preparePool(ctx)
+		return func() {
+			cancel()
+			if err := worker.Drop(); err != nil {
+				t.Fatal(err)
+			}
+			worker.closeConnections()
+		}
+	}
+
+	t.Run("it should be acknowledged written messages and terminated broken", func(t *testing.T) {
+		stream := newFakeStream()
+		writer := &fakeWriter{}
+		stop := run(t, stream, writer, newOpt("", ModeJetStream))
+		defer stop()
+		stream.publish("GET /", "broken", "POST /")
+		waitFor(t, func() bool {
+			pending, acked, _ := stream.state()
+			return pending == 0 && acked == 2
+		})
+		if terminated := stream.terminatedMessages(); len(terminated) != 1 || terminated[0] != "broken" {
+			t.Fatalf("expect broken message is terminated, got %v", terminated)
+		}
+		if writer.len() != 2 {
+			t.Fatalf("expect 2 written messages, got %d", writer.len())
+		}
+	})
+
+	t.Run("it should be redelivered message which is not acknowledged in time", func(t *testing.T) {
+		stream := newFakeStream()
+		writer := &fakeWriter{hold: true}
+		opt := newOpt("", ModeJetStream)
+		opt.AsyncFactor = 1
+		opt.NatsAckWait = 50 * time.Millisecond
+		stop := run(t, stream, writer, opt)
+		defer stop()
+		stream.publish("GET /")
+		waitFor(t, func() bool {
+			return writer.len() == 1
+		})
+		// consumer reconnects, mark of lost connection can't be acknowledged
+		stream.mu.Lock()
+		lost := stream.consumers[len(stream.consumers)-1]
+		stream.mu.Unlock()
+		lost.close()
+		waitFor(t, func() bool {
+			return writer.len() == 2
+		})
+		writer.setHold(false)
+		waitFor(t, func() bool {
+			pending, acked, _ := stream.state()
+			return pending == 0 && acked == 1
+		})
+		if _, _, redelivered := stream.state(); redelivered != 1 {
+			t.Fatalf("expect message is redelivered once, got %d", redelivered)
+		}
+	})
+
+	t.Run("it should be written messages of core nats without acknowledgements", func(t *testing.T) {
+		stream := newFakeStream()
+		writer := &fakeWriter{}
+		stop := run(t, stream, writer, newOpt("", ModeCore))
+		defer stop()
+		stream.publish("GET /", "broken", "POST /")
+		waitFor(t, func() bool {
+			return writer.len() == 2
+		})
+		time.Sleep(20 * time.Millisecond)
+		if pending, acked, _ := stream.state(); pending != 0 || acked != 0 || len(stream.terminatedMessages()) != 0 {
+			t.Fatalf("expect no acknowledgements, got %d pending, %d acked", pending, acked)
+		}
+	})
+}
+
+func TestNatsLogJetStream(t *testing.T) {
+	url := natsURL(t)
+	opt := newOpt(url, ModeJetStream)
+	server := newInspector(t, url)
+	defer server.cleanup(opt.NatsStream)
+	writer := &fakeWriter{}
+	worker, err := NewServerWorker(context.Background(), &fakeHandler{}, writer, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.js.ConsumerInfo(opt.NatsStream, opt.NatsDurable); err != nil {
+		t.Fatalf("expect durable consumer, got %v", err)
+	}
+
+	t.Run("it should be stored in stream", func(t *testing.T) {
+		p := &publisher{opt: opt, subject: opt.subject()}
+		defer p.close()
+		if !strings.HasSuffix(p.subject, ".access") {
+			t.Fatalf("unexpected subject %s", p.subject)
+		}
+		for _, line := range []string{"GET /", "broken", "POST /"} {
+			if err := p.publishRetry(context.Background(), line); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if stored := server.stored(t, opt.NatsStream); stored != 3 {
+			t.Fatalf("expect 3 messages, got %d", stored)
+		}
+	})
+
+	t.Run("it should be acknowledged written messages and terminated broken", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		worker.preparePool(ctx)
+		// broken message is terminated, otherwise it is redelivered after ack wait and stays pending
+		waitFor(t, func() bool {
+			return writer.len() == 2 && server.pending(t, opt.NatsStream, opt.NatsDurable) == 0
+		})
+
+		// consumers are reconnected after connections are closed
+		worker.closeConnections()
+		p := &publisher{opt: opt, subject: opt.subject()}
+		defer p.close()
+		if err := p.publishRetry(context.Background(), "PUT /"); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool {
+			return writer.len() == 3
+		})
+
+		cancel()
+		if err := worker.Drop(); err != nil {
+			t.Fatal(err)
+		}
+		worker.closeConnections()
+		if server.pending(t, opt.NatsStream, opt.NatsDurable) != 0 {
+			t.Fatal("expect all messages are handled")
+		}
+	})
+}
+
+func TestNatsLogCore(t *testing.T) {
+	url := natsURL(t)
+	opt := newOpt(url, ModeCore)
+	server := newInspector(t, url)
+	defer server.cleanup(opt.NatsStream)
+	writer := &fakeWriter{}
+	worker, err := NewServerWorker(context.Background(), &fakeHandler{}, writer, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it should be delivered once to queue group", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		worker.preparePool(ctx)
+		p := &publisher{opt: opt, subject: opt.subject()}
+		defer p.close()
+		// subscriptions are asynchronous, so messages are published until the first one is received
+		waitFor(t, func() bool {
+			if err := p.publishRetry(ctx, "GET /"); err != nil {
+				t.Fatal(err)
+			}
+			return writer.len() > 0
+		})
+		received := writer.len()
+		for _, line := range []string{"broken", "POST /"} {
+			if err := p.publishRetry(ctx, line); err != nil {
+				t.Fatal(err)
+			}
+		}
+		waitFor(t, func() bool {
+			return writer.len() == received+1
+		})
+		cancel()
+		if err := worker.Drop(); err != nil {
+			t.Fatal(err)
+		}
+		worker.closeConnections()
+		if _, err := server.js.StreamInfo(opt.NatsStream); err == nil {
+			t.Fatal("expect no stream in core mode")
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package natslog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/nats-io/nats.go"
+
+	"github.com/zikwall/grower/config"
+)
+
+// Delivery modes of NATS
+const (
+	// ModeJetStream lines are stored in stream and acknowledged by server after they are written to clickhouse
+	ModeJetStream = "jetstream"
+	// ModeCore fire-and-forget, lines are lost while server is not subscribed
+	ModeCore = "core"
+)
+
+type Opt struct {
+	ClientOpt
+	ServerOpt
+	Config             *config.Config
+	AsyncFactor        uint
+	Debug              bool
+	NatsURL            string
+	NatsMode           string
+	NatsStream         string
+	NatsReconnectDelay time.Duration
+}
+
+type ClientOpt struct {
+	// NatsSubject template of subject, {host} and {file} are replaced by host name and name of log file
+	NatsSubject                 string
+	NatsPublishTimeout          time.Duration
+	LogsDir                     string
+	SourceLogFile               string
+	ScrapeInterval              time.Duration
+	BackupFiles                 uint
+	BackupFileMaxAge            time.Duration
+	EnableRotating              bool
+	AutoCreateTargetFromScratch bool
+	RunAtStartup                bool
+	SkipNginxReopen             bool
+	RewriteNginxLocalTime       bool
+	OffsetFile                  string
+}
+
+type ServerOpt struct {
+	config.DeadLetter
+	config.Spool
+	// NatsFilterSubject subjects of stream and consumer, e.g. logs.>
+	NatsFilterSubject string
+	NatsDurable       string
+	NatsQueueGroup    string
+	NatsBatch         uint
+	NatsMaxAckPending uint
+	NatsAckWait       time.Duration
+	Clickhouse        *clickhouse.Options
+	BufSize           uint
+	BufFlushInterval  uint
+	WriteTimeout      time.Duration
+}
+
+// connect opens nats connection, it is reconnected by client after failures until it is closed
+func (o *Opt) connect(name string) (*nats.Conn, error) {
+	return nats.Connect(o.NatsURL,
+		nats.Name(name),
+		nats.Timeout(dialTimeout),
+		nats.ReconnectWait(o.NatsReconnectDelay),
+		nats.MaxReconnects(-1),
+	)
+}
+
+// subject resolves template of client subject
+func (o *Opt) subject() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	file := strings.TrimSuffix(filepath.Base(o.SourceLogFile), filepath.Ext(o.SourceLogFile))
+	return strings.NewReplacer(
+		"{host}", subjectToken(host),
+		"{file}", subjectToken(file),
+	).Replace(o.NatsSubject)
+}
+
+// subjectToken replaces separators and wildcards of NATS subjects in token
+func subjectToken(token string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, token)
+}
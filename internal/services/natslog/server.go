@@ -0,0 +1,430 @@
+package natslog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/nats-io/nats.go"
+	clickhousebuffer "github.com/zikwall/clickhouse-buffer/v4"
+	"github.com/zikwall/clickhouse-buffer/v4/src/buffer/cxmem"
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+	"github.com/zikwall/clickhouse-buffer/v4/src/db/cxnative"
+
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/deadletter"
+	"github.com/zikwall/grower/pkg/drop"
+	"github.com/zikwall/grower/pkg/handler"
+	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+	"github.com/zikwall/grower/pkg/nginx"
+	"github.com/zikwall/grower/pkg/spool"
+	"github.com/zikwall/grower/pkg/wrap"
+)
+
+// ServerSource name of nats server in metrics and dead letters
+const ServerSource = "natslog"
+
+// fetchExpires how long pull request waits for messages of stream
+const fetchExpires = time.Second
+
+type Server struct {
+	*drop.Impl
+	worker        *ServerWorker
+	bufferWrapper *wrap.BufferWrapper
+	clientWrapper *wrap.ClientWrapper
+}
+
+func NewServer(ctx context.Context, opt *Opt) (*Server, error) {
+	parser, err := nginx.NewParser(opt.Config.Nginx.LogType, opt.Config.Nginx.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+	rowHandler, err := handler.New(opt.Config, parser)
+	if err != nil {
+		return nil, err
+	}
+	if err := spool.Check(&opt.Spool, rowHandler.Types()); err != nil {
+		return nil, err
+	}
+	ch, _, err := cxnative.NewClickhouse(ctx, opt.Clickhouse, &cx.RuntimeOptions{
+		WriteTimeout: opt.WriteTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch, err = spool.New(metrics.NewClickhouse(ch), &opt.Spool)
+	if err != nil {
+		return nil, err
+	}
+	// messages are acknowledged in stream only after they are written to clickhouse
+	ch = checkpoint.NewClickhouse(ch)
+	client := clickhousebuffer.NewClientWithOptions(ctx, ch, clickhousebuffer.NewOptions(
+		clickhousebuffer.WithFlushInterval(opt.BufFlushInterval),
+		clickhousebuffer.WithBatchSize(opt.BufSize),
+		clickhousebuffer.WithDebugMode(opt.Debug),
+		clickhousebuffer.WithRetry(true),
+	))
+	deadLetterSink, err := deadletter.New(&opt.DeadLetter, client)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Impl:          drop.NewContext(ctx),
+		bufferWrapper: wrap.NewBufferWrapper(ch),
+		clientWrapper: wrap.NewClientWrapper(client),
+	}
+	columns := rowHandler.Columns()
+	newWriter := func(table string) clickhousebuffer.Writer {
+		return s.clientWrapper.Client().Writer(
+			clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
+				"max_execution_time": opt.ServerOpt.WriteTimeout.Seconds(),
+			})),
+			cx.NewView(table, columns),
+			cxmem.NewBuffer(s.clientWrapper.Client().Options().BatchSize()),
+		)
+	}
+	writerAPI := handler.NewWriter(rowHandler, newWriter(opt.Config.Scheme.LogsTable), newWriter)
+	server, err := NewServerWorker(
+		ctx,
+		deadletter.NewHandler(rowHandler, deadLetterSink, ServerSource+":"+opt.NatsFilterSubject),
+		writerAPI,
+		opt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.worker = server
+	s.AddDroppers(
+		s.worker,
+		deadLetterSink,
+		s.clientWrapper,
+		&consumerCloser{worker: s.worker},
+		s.bufferWrapper,
+	)
+	return s, nil
+}
+
+func (w *Server) Run(ctx context.Context) {
+	w.worker.preparePool(ctx)
+}
+
+// delivery message received by consumer, acknowledgements are not used in core nats
+type delivery struct {
+	data    []byte
+	subject string
+	ack     func() error
+	term    func() error
+}
+
+// consumer receives messages of durable consumer of stream or of queue group of core nats,
+// it is implemented by natsConsumer and replaced by in-process stream in tests
+type consumer interface {
+	// receive waits for the next messages, it is not an error if there are no messages
+	receive(ctx context.Context) ([]*delivery, error)
+	close()
+}
+
+// dialer connects consumer of server, stream and durable consumer are created in jetstream mode
+type dialer func(ctx context.Context, opt *Opt) (consumer, error)
+
+type ServerWorker struct {
+	opt      *Opt
+	wg       *sync.WaitGroup
+	handler  handler.Handler
+	writer   handler.RowWriter
+	dial     dialer
+	isClosed uint32
+	mu       sync.Mutex
+	conns    []consumer
+}
+
+func (s *ServerWorker) Drop() error {
+	atomic.StoreUint32(&s.isClosed, 1)
+	s.wg.Wait()
+	log.Info("stop all consumers")
+	return nil
+}
+
+func (s *ServerWorker) DropMsg() string {
+	return "kill nats server"
+}
+
+// create worker pool for consuming messages
+func (s *ServerWorker) preparePool(ctx context.Context) {
+	var i uint
+	for i = 1; i <= s.opt.AsyncFactor; i++ {
+		s.wg.Add(1)
+		go s.makeConsumerListener(ctx, i)
+	}
+}
+
+func (s *ServerWorker) makeConsumerListener(ctx context.Context, worker uint) {
+	defer func() {
+		s.wg.Done()
+		if s.opt.Debug {
+			log.Infof("stop nats consumer %d", worker)
+		}
+	}()
+	if s.opt.Debug {
+		log.Infof("run nats consumer %d", worker)
+	}
+	for {
+		err := s.consume(ctx, worker)
+		if ctx.Err() != nil || atomic.LoadUint32(&s.isClosed) == 1 {
+			return
+		}
+		log.Warningf("nats consumer %d: %v, reconnect in %s", worker, err, s.opt.NatsReconnectDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.opt.NatsReconnectDelay):
+		}
+	}
+}
+
+// consume receives messages until context is done or connection is closed,
+// connection stays open after return, so messages can be acknowledged after the last flush
+func (s *ServerWorker) consume(ctx context.Context, worker uint) error {
+	c, err := s.connect(ctx, worker)
+	if err != nil {
+		return err
+	}
+	linesRead := metrics.LinesRead.WithLabelValues(ServerSource)
+	for ctx.Err() == nil && atomic.LoadUint32(&s.isClosed) == 0 {
+		deliveries, err := c.receive(ctx)
+		for _, d := range deliveries {
+			linesRead.Inc()
+			if s.opt.Debug {
+				fmt.Printf("nats [consumer %d] message %s\n", worker, d.subject)
+			}
+			s.handle(d)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (s *ServerWorker) handle(d *delivery) {
+	row, err := s.handler.Handle(string(d.data))
+	if err != nil {
+		log.Warning(err)
+		if s.opt.NatsMode == ModeCore {
+			return
+		}
+		// unparsable message is never redelivered, it is kept by dead letter sink, if it is set
+		if err := d.term(); err != nil {
+			log.Warningf("failed to terminate nats message: %v", err)
+		}
+		return
+	}
+	if s.opt.NatsMode == ModeCore {
+		s.writer.Write(row, nil)
+	} else {
+		s.writer.Write(row, checkpoint.NewMark(func() {
+			if err := d.ack(); err != nil {
+				log.Warningf("failed to acknowledge nats message: %v", err)
+			}
+		}))
+	}
+}
+
+// connect opens connection of worker, previous connection of worker is closed
+func (s *ServerWorker) connect(ctx context.Context, worker uint) (consumer, error) {
+	c, err := s.dial(ctx, s.opt)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	previous := s.conns[worker-1]
+	s.conns[worker-1] = c
+	s.mu.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+	return c, nil
+}
+
+func (s *ServerWorker) closeConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, c := range s.conns {
+		if c == nil {
+			continue
+		}
+		c.close()
+		s.conns[i] = nil
+	}
+}
+
+// natsConsumer pulls messages of durable consumer in jetstream mode or receives messages of queue group in core mode,
+// subscription is created by the first receive
+type natsConsumer struct {
+	conn *nats.Conn
+	sub  *nats.Subscription
+	opt  *Opt
+}
+
+// dial connects to nats and creates stream and durable consumer of server in jetstream mode
+func dial(ctx context.Context, opt *Opt) (consumer, error) {
+	conn, err := opt.connect(ServerSource)
+	if err != nil {
+		return nil, err
+	}
+	if opt.NatsMode == ModeJetStream {
+		ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+		if err := declare(ctx, conn, opt); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return &natsConsumer{conn: conn, opt: opt}, nil
+}
+
+func (c *natsConsumer) subscribe() error {
+	var err error
+	if c.opt.NatsMode == ModeCore {
+		c.sub, err = c.conn.QueueSubscribeSync(c.opt.NatsFilterSubject, c.opt.NatsQueueGroup)
+		return err
+	}
+	js, err := c.conn.JetStream()
+	if err != nil {
+		return err
+	}
+	c.sub, err = js.PullSubscribe(c.opt.NatsFilterSubject, c.opt.NatsDurable, nats.BindStream(c.opt.NatsStream))
+	return err
+}
+
+// receive waits for the next batch of messages no longer than fetchExpires in jetstream mode,
+// message of core nats is waited until context is done or connection is closed
+func (c *natsConsumer) receive(ctx context.Context) ([]*delivery, error) {
+	if c.sub == nil {
+		if err := c.subscribe(); err != nil {
+			return nil, err
+		}
+	}
+	if c.opt.NatsMode == ModeCore {
+		msg, err := c.sub.NextMsgWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []*delivery{newDelivery(msg)}, nil
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchExpires)
+	defer cancel()
+	messages, err := c.sub.Fetch(int(c.opt.NatsBatch), nats.Context(fetchCtx))
+	if err != nil && ctx.Err() == nil && (errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout)) {
+		err = nil
+	}
+	deliveries := make([]*delivery, 0, len(messages))
+	for _, msg := range messages {
+		deliveries = append(deliveries, newDelivery(msg))
+	}
+	return deliveries, err
+}
+
+func (c *natsConsumer) close() {
+	c.conn.Close()
+}
+
+func newDelivery(msg *nats.Msg) *delivery {
+	return &delivery{
+		data:    msg.Data,
+		subject: msg.Subject,
+		ack: func() error {
+			return msg.Ack()
+		},
+		term: func() error {
+			return msg.Term()
+		},
+	}
+}
+
+// declare creates stream or updates it if stream exists with another configuration,
+// it is not an error if the same durable consumer exists
+func declare(ctx context.Context, conn *nats.Conn, opt *Opt) error {
+	js, err := conn.JetStream(nats.Context(ctx))
+	if err != nil {
+		return err
+	}
+	stream := &nats.StreamConfig{
+		Name:     opt.NatsStream,
+		Subjects: []string{opt.NatsFilterSubject},
+		Storage:  nats.FileStorage,
+	}
+	if _, err := js.AddStream(stream); err != nil {
+		if _, updateErr := js.UpdateStream(stream); updateErr != nil {
+			return err
+		}
+	}
+	_, err = js.AddConsumer(opt.NatsStream, &nats.ConsumerConfig{
+		Durable:       opt.NatsDurable,
+		DeliverPolicy: nats.DeliverAllPolicy,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       opt.NatsAckWait,
+		MaxAckPending: int(opt.NatsMaxAckPending),
+		FilterSubject: opt.NatsFilterSubject,
+	})
+	return err
+}
+
+func NewServerWorker(
+	ctx context.Context,
+	rowHandler handler.Handler,
+	writer handler.RowWriter,
+	opt *Opt,
+) (*ServerWorker, error) {
+	switch opt.NatsMode {
+	case ModeJetStream, ModeCore:
+	default:
+		return nil, fmt.Errorf("unknown nats mode %q", opt.NatsMode)
+	}
+	return newServerWorker(ctx, rowHandler, writer, opt, dial)
+}
+
+func newServerWorker(
+	ctx context.Context,
+	rowHandler handler.Handler,
+	writer handler.RowWriter,
+	opt *Opt,
+	dial dialer,
+) (*ServerWorker, error) {
+	// check connection and create stream
+	c, err := dial(ctx, opt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	c.close()
+	log.Info("nats is successfully connected")
+	s := &ServerWorker{
+		handler: rowHandler,
+		writer:  writer,
+		dial:    dial,
+		opt:     opt,
+		wg:      &sync.WaitGroup{},
+		conns:   make([]consumer, opt.AsyncFactor),
+	}
+	return s, nil
+}
+
+// consumerCloser closes nats connections after clickhouse buffer is flushed on shutdown,
+// so written messages are acknowledged and the rest are redelivered after ack wait
+type consumerCloser struct {
+	worker *ServerWorker
+}
+
+func (c *consumerCloser) Drop() error {
+	c.worker.closeConnections()
+	return nil
+}
+
+func (c *consumerCloser) DropMsg() string {
+	return "close nats consumers"
+}
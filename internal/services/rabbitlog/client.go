@@ -0,0 +1,282 @@
+package rabbitlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/drop"
+	"github.com/zikwall/grower/pkg/fileio"
+	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+)
+
+var errClientClosed = errors.New("client is closed")
+
+// ClientSource name of rabbit client in metrics
+const ClientSource = "rabbitlog_client"
+
+const dialTimeout = 5 * time.Second
+
+type Client struct {
+	*drop.Impl
+	worker *ClientWorker
+}
+
+func NewClient(ctx context.Context, opt *Opt) (*Client, error) {
+	worker, err := NewClientWorker(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		Impl:   drop.NewContext(ctx),
+		worker: worker,
+	}
+	client.AddDropper(client.worker)
+	return client, nil
+}
+
+func (c *Client) Run(ctx context.Context) {
+	c.worker.runContext(ctx)
+}
+
+type ClientWorker struct {
+	rotator  fileio.Rotator
+	opt      *Opt
+	str      chan fileio.Line
+	wg       *sync.WaitGroup
+	isClosed uint32
+}
+
+func NewClientWorker(ctx context.Context, opt *Opt) (*ClientWorker, error) {
+	// check connection and declare exchange
+	p := &publisher{opt: opt}
+	if _, err := p.channel(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to rabbit: %w", err)
+	}
+	p.close()
+	log.Info("rabbit is successfully connected")
+	c := &ClientWorker{
+		opt: opt,
+		str: make(chan fileio.Line),
+		wg:  &sync.WaitGroup{},
+	}
+	c.rotator = fileio.New(
+		opt.SourceLogFile,
+		opt.LogsDir,
+		opt.BackupFiles,
+		opt.BackupFileMaxAge,
+		opt.AutoCreateTargetFromScratch,
+		opt.EnableRotating,
+		opt.SkipNginxReopen,
+		opt.OffsetFile,
+		c.handleLine,
+	)
+	return c, nil
+}
+
+func (w *ClientWorker) Drop() error {
+	atomic.StoreUint32(&w.isClosed, 1)
+	w.wg.Wait()
+	log.Info("stop all workers")
+	// save offsets of published lines
+	w.rotator.Save()
+	return nil
+}
+
+func (w *ClientWorker) DropMsg() string {
+	return "kill rabbit client"
+}
+
+// create worker pool for publishing lines
+func (w *ClientWorker) preparePool(ctx context.Context) {
+	var i uint
+	for i = 1; i <= w.opt.AsyncFactor; i++ {
+		w.wg.Add(1)
+		go w.makeWriteListener(ctx, i)
+	}
+}
+
+func (w *ClientWorker) makeWriteListener(ctx context.Context, worker uint) {
+	p := &publisher{opt: w.opt}
+	defer func() {
+		p.close()
+		w.wg.Done()
+		if w.opt.Debug {
+			log.Infof("stop rabbit publisher %d", worker)
+		}
+	}()
+	if w.opt.Debug {
+		log.Infof("run rabbit publisher %d", worker)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case str, ok := <-w.str:
+			if !ok {
+				return
+			}
+			if err := p.publishRetry(ctx, str.Content); err != nil {
+				// line is not acknowledged, so it is read again after restart
+				log.Warningf("publish to rabbit: %s", err.Error())
+				continue
+			}
+			str.Mark.Ack()
+		}
+	}
+}
+
+// runContext main loop for read and rotating logs
+func (w *ClientWorker) runContext(ctx context.Context) {
+	w.preparePool(ctx)
+	w.wg.Add(1)
+	go func() {
+		ticker := time.NewTicker(w.opt.ScrapeInterval)
+		defer func() {
+			ticker.Stop()
+			close(w.str)
+			w.wg.Done()
+			log.Info("stop rotate worker")
+		}()
+		if err := w.rotator.Resume(); err != nil {
+			log.Warning(err)
+		}
+		if w.opt.RunAtStartup {
+			if err := w.rotator.Rotate(); err != nil {
+				log.Warning(err)
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := w.rotator.Rotate(); err != nil {
+					log.Warning(err)
+				}
+			}
+		}
+	}()
+}
+
+// handleLine sends line to publishers, line is acknowledged after it is confirmed by rabbit
+func (w *ClientWorker) handleLine(content string, mark *checkpoint.Mark) error {
+	if atomic.LoadUint32(&w.isClosed) == 1 {
+		return errClientClosed
+	}
+	w.str <- fileio.Line{Content: content, Mark: mark}
+	metrics.LinesRead.WithLabelValues(ClientSource).Inc()
+	return nil
+}
+
+// publisher own connection and channel of worker, it is reconnected after failures,
+// messages are published one by one, so the next confirmation belongs to the last message
+type publisher struct {
+	opt      *Opt
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+func (p *publisher) channel(ctx context.Context) (*amqp.Channel, error) {
+	if p.ch != nil {
+		return p.ch, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	conn, err := p.opt.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err == nil {
+		err = p.opt.declareExchange(ch)
+	}
+	if err == nil && p.opt.RabbitConfirm {
+		err = ch.Confirm(false)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	p.conn, p.ch = conn, ch
+	if p.opt.RabbitConfirm {
+		p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	}
+	return ch, nil
+}
+
+func (p *publisher) publish(ctx context.Context, message string) error {
+	ch, err := p.channel(ctx)
+	if err != nil {
+		return err
+	}
+	deliveryMode := amqp.Transient
+	if p.opt.RabbitDurable {
+		deliveryMode = amqp.Persistent
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.opt.RabbitPublishTimeout)
+	defer cancel()
+	err = ch.PublishWithContext(ctx, p.opt.RabbitExchange, p.opt.RabbitRoutingKey, false, false, amqp.Publishing{
+		ContentType:  "text/plain",
+		DeliveryMode: deliveryMode,
+		Body:         []byte(message),
+	})
+	if err == nil && p.opt.RabbitConfirm {
+		err = p.confirm(ctx)
+	}
+	if err != nil {
+		// state of channel is unknown after failure, so it is opened again
+		p.close()
+	}
+	return err
+}
+
+// confirm waits confirmation of the last published message
+func (p *publisher) confirm(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("rabbit confirmation: %w", ctx.Err())
+	case confirmation, ok := <-p.confirms:
+		if !ok {
+			return errors.New("rabbit channel is closed before confirmation")
+		}
+		if !confirmation.Ack {
+			return errors.New("message is rejected by rabbit")
+		}
+		return nil
+	}
+}
+
+// publishRetry publishes line until success, so lines are not skipped while rabbit is unavailable
+func (p *publisher) publishRetry(ctx context.Context, message string) error {
+	for {
+		err := p.publish(ctx, message)
+		if err == nil {
+			return nil
+		}
+		log.Warningf("publish to rabbit: %s, retry in %s", err.Error(), p.opt.RabbitReconnectDelay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(p.opt.RabbitReconnectDelay):
+		}
+	}
+}
+
+func (p *publisher) close() {
+	if p.conn == nil {
+		return
+	}
+	if err := p.conn.Close(); err != nil {
+		log.Warningf("failed to close rabbit connection: %v", err)
+	}
+	p.conn, p.ch, p.confirms = nil, nil, nil
+}
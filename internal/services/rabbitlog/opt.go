@@ -0,0 +1,132 @@
+package rabbitlog
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/tlsconfig"
+)
+
+// rabbitHeartbeat interval of heartbeats proposed to rabbit
+const rabbitHeartbeat = 10 * time.Second
+
+type Opt struct {
+	ClientOpt
+	ServerOpt
+	Config               *config.Config
+	AsyncFactor          uint
+	Debug                bool
+	RabbitURL            string
+	RabbitTLS            config.TLS
+	RabbitExchange       string
+	RabbitExchangeType   string
+	RabbitRoutingKey     string
+	RabbitDurable        bool
+	RabbitReconnectDelay time.Duration
+}
+
+type ClientOpt struct {
+	RabbitConfirm               bool
+	RabbitPublishTimeout        time.Duration
+	LogsDir                     string
+	SourceLogFile               string
+	ScrapeInterval              time.Duration
+	BackupFiles                 uint
+	BackupFileMaxAge            time.Duration
+	EnableRotating              bool
+	AutoCreateTargetFromScratch bool
+	RunAtStartup                bool
+	SkipNginxReopen             bool
+	RewriteNginxLocalTime       bool
+	OffsetFile                  string
+}
+
+type ServerOpt struct {
+	config.DeadLetter
+	config.Spool
+	RabbitQueue              string
+	RabbitPrefetch           uint
+	RabbitDeadLetterExchange string
+	RabbitDeadLetterQueue    string
+	Clickhouse               *clickhouse.Options
+	BufSize                  uint
+	BufFlushInterval         uint
+	WriteTimeout             time.Duration
+}
+
+// declareExchange exchange of log lines is declared by both client and server
+func (o *Opt) declareExchange(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(o.RabbitExchange, o.RabbitExchangeType, o.RabbitDurable, false, false, false, nil)
+}
+
+// declareQueue queue of server is bound to exchange by routing key,
+// messages rejected by server are routed to dead-letter exchange, if it is set
+func (o *Opt) declareQueue(ch *amqp.Channel) error {
+	if err := o.declareExchange(ch); err != nil {
+		return err
+	}
+	args := amqp.Table{}
+	if o.RabbitDeadLetterExchange != "" {
+		if err := ch.ExchangeDeclare(o.RabbitDeadLetterExchange, amqp.ExchangeFanout, o.RabbitDurable, false, false, false, nil); err != nil {
+			return err
+		}
+		args["x-dead-letter-exchange"] = o.RabbitDeadLetterExchange
+		if o.RabbitDeadLetterQueue != "" {
+			if _, err := ch.QueueDeclare(o.RabbitDeadLetterQueue, o.RabbitDurable, false, false, false, nil); err != nil {
+				return err
+			}
+			if err := ch.QueueBind(o.RabbitDeadLetterQueue, "", o.RabbitDeadLetterExchange, false, nil); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := ch.QueueDeclare(o.RabbitQueue, o.RabbitDurable, false, false, false, args); err != nil {
+		return err
+	}
+	return ch.QueueBind(o.RabbitQueue, o.RabbitRoutingKey, o.RabbitExchange, false, nil)
+}
+
+// dial connects to rabbit until context is done, amqps URL enables TLS with certificates of options
+func (o *Opt) dial(ctx context.Context) (*amqp.Connection, error) {
+	uri, err := amqp.ParseURI(o.RabbitURL)
+	if err != nil {
+		return nil, err
+	}
+	// heartbeat and locale are defaults of amqp.Dial
+	cfg := amqp.Config{
+		Heartbeat: rabbitHeartbeat,
+		Locale:    "en_US",
+		Dial: func(network, address string) (net.Conn, error) {
+			dialer := &net.Dialer{}
+			conn, err := dialer.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			// deadline of handshake, it is cleared by client after connection is opened
+			if deadline, ok := ctx.Deadline(); ok {
+				if err := conn.SetDeadline(deadline); err != nil {
+					_ = conn.Close()
+					return nil, err
+				}
+			}
+			return conn, nil
+		},
+	}
+	switch {
+	case uri.Scheme == "amqps":
+		cfg.TLSClientConfig, err = tlsconfig.Client(&o.RabbitTLS, net.JoinHostPort(uri.Host, strconv.Itoa(uri.Port)))
+		if err != nil {
+			return nil, err
+		}
+	case o.RabbitTLS.CertFile != "" || o.RabbitTLS.CAFile != "":
+		return nil, errors.New("rabbit TLS certificates require amqps URL")
+	}
+	return amqp.DialConfig(o.RabbitURL, cfg)
+}
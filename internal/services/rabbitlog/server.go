@@ -0,0 +1,346 @@
+package rabbitlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	amqp "github.com/rabbitmq/amqp091-go"
+	clickhousebuffer "github.com/zikwall/clickhouse-buffer/v4"
+	"github.com/zikwall/clickhouse-buffer/v4/src/buffer/cxmem"
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+	"github.com/zikwall/clickhouse-buffer/v4/src/db/cxnative"
+
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/deadletter"
+	"github.com/zikwall/grower/pkg/drop"
+	"github.com/zikwall/grower/pkg/handler"
+	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+	"github.com/zikwall/grower/pkg/nginx"
+	"github.com/zikwall/grower/pkg/spool"
+	"github.com/zikwall/grower/pkg/wrap"
+)
+
+// ServerSource name of rabbit server in metrics and dead letters
+const ServerSource = "rabbitlog"
+
+type Server struct {
+	*drop.Impl
+	worker        *ServerWorker
+	bufferWrapper *wrap.BufferWrapper
+	clientWrapper *wrap.ClientWrapper
+}
+
+func NewServer(ctx context.Context, opt *Opt) (*Server, error) {
+	parser, err := nginx.NewParser(opt.Config.Nginx.LogType, opt.Config.Nginx.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+	rowHandler, err := handler.New(opt.Config, parser)
+	if err != nil {
+		return nil, err
+	}
+	if err := spool.Check(&opt.Spool, rowHandler.Types()); err != nil {
+		return nil, err
+	}
+	ch, _, err := cxnative.NewClickhouse(ctx, opt.Clickhouse, &cx.RuntimeOptions{
+		WriteTimeout: opt.WriteTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch, err = spool.New(metrics.NewClickhouse(ch), &opt.Spool)
+	if err != nil {
+		return nil, err
+	}
+	// messages are acknowledged in rabbit only after they are written to clickhouse
+	ch = checkpoint.NewClickhouse(ch)
+	client := clickhousebuffer.NewClientWithOptions(ctx, ch, clickhousebuffer.NewOptions(
+		clickhousebuffer.WithFlushInterval(opt.BufFlushInterval),
+		clickhousebuffer.WithBatchSize(opt.BufSize),
+		clickhousebuffer.WithDebugMode(opt.Debug),
+		clickhousebuffer.WithRetry(true),
+	))
+	deadLetterSink, err := deadletter.New(&opt.DeadLetter, client)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Impl:          drop.NewContext(ctx),
+		bufferWrapper: wrap.NewBufferWrapper(ch),
+		clientWrapper: wrap.NewClientWrapper(client),
+	}
+	columns := rowHandler.Columns()
+	newWriter := func(table string) clickhousebuffer.Writer {
+		return s.clientWrapper.Client().Writer(
+			clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
+				"max_execution_time": opt.ServerOpt.WriteTimeout.Seconds(),
+			})),
+			cx.NewView(table, columns),
+			cxmem.NewBuffer(s.clientWrapper.Client().Options().BatchSize()),
+		)
+	}
+	writerAPI := handler.NewWriter(rowHandler, newWriter(opt.Config.Scheme.LogsTable), newWriter)
+	server, err := NewServerWorker(
+		ctx,
+		deadletter.NewHandler(rowHandler, deadLetterSink, ServerSource+":"+opt.RabbitQueue),
+		writerAPI,
+		opt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.worker = server
+	s.AddDroppers(
+		s.worker,
+		deadLetterSink,
+		s.clientWrapper,
+		&consumerCloser{worker: s.worker},
+		s.bufferWrapper,
+	)
+	return s, nil
+}
+
+func (w *Server) Run(ctx context.Context) {
+	w.worker.preparePool(ctx)
+}
+
+// channel of consumer, it is implemented by amqp.Channel
+type channel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+}
+
+// connection of consumer, it is implemented by amqp.Connection
+type connection interface {
+	Close() error
+}
+
+// dialer connects to rabbit and declares queue of server, it is replaced by in-process broker in tests
+type dialer func(ctx context.Context, opt *Opt) (connection, channel, error)
+
+type ServerWorker struct {
+	opt      *Opt
+	wg       *sync.WaitGroup
+	handler  handler.Handler
+	writer   handler.RowWriter
+	dial     dialer
+	isClosed uint32
+	mu       sync.Mutex
+	conns    []connection
+}
+
+func (s *ServerWorker) Drop() error {
+	atomic.StoreUint32(&s.isClosed, 1)
+	s.wg.Wait()
+	log.Info("stop all consumers")
+	return nil
+}
+
+func (s *ServerWorker) DropMsg() string {
+	return "kill rabbit server"
+}
+
+// create worker pool for consuming messages
+func (s *ServerWorker) preparePool(ctx context.Context) {
+	var i uint
+	for i = 1; i <= s.opt.AsyncFactor; i++ {
+		s.wg.Add(1)
+		go s.makeConsumerListener(ctx, i)
+	}
+}
+
+func (s *ServerWorker) makeConsumerListener(ctx context.Context, worker uint) {
+	defer func() {
+		s.wg.Done()
+		if s.opt.Debug {
+			log.Infof("stop rabbit consumer %d", worker)
+		}
+	}()
+	if s.opt.Debug {
+		log.Infof("run rabbit consumer %d", worker)
+	}
+	for {
+		err := s.consume(ctx, worker)
+		if ctx.Err() != nil || atomic.LoadUint32(&s.isClosed) == 1 {
+			return
+		}
+		log.Warningf("rabbit consumer %d: %v, reconnect in %s", worker, err, s.opt.RabbitReconnectDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.opt.RabbitReconnectDelay):
+		}
+	}
+}
+
+// consume reads messages until context is done or connection is lost,
+// connection stays open after return, so messages can be acknowledged after the last flush
+func (s *ServerWorker) consume(ctx context.Context, worker uint) error {
+	ch, err := s.connect(ctx, worker)
+	if err != nil {
+		return err
+	}
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	deliveries, err := ch.Consume(s.opt.RabbitQueue, fmt.Sprintf("grower-%d", worker), false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	linesRead := metrics.LinesRead.WithLabelValues(ServerSource)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case d, ok := <-deliveries:
+			if !ok {
+				return closeReason(closed)
+			}
+			linesRead.Inc()
+			if s.opt.Debug {
+				fmt.Printf("rabbit [consumer %d] delivery %d, redelivered %v: %s\n",
+					worker, d.DeliveryTag, d.Redelivered, d.RoutingKey,
+				)
+			}
+			s.handle(d)
+		}
+	}
+}
+
+func (s *ServerWorker) handle(d amqp.Delivery) {
+	row, err := s.handler.Handle(string(d.Body))
+	if err != nil {
+		log.Warning(err)
+		// unparsable message is routed to dead-letter exchange of queue or dropped, if it is not set
+		if err := d.Nack(false, false); err != nil {
+			log.Warningf("failed to reject rabbit message: %v", err)
+		}
+		return
+	}
+	s.writer.Write(row, checkpoint.NewMark(func() {
+		if err := d.Ack(false); err != nil {
+			log.Warningf("failed to acknowledge rabbit message: %v", err)
+		}
+	}))
+}
+
+// connect opens connection of worker, previous connection of worker is closed
+func (s *ServerWorker) connect(ctx context.Context, worker uint) (channel, error) {
+	conn, ch, err := s.dial(ctx, s.opt)
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Qos(int(s.opt.RabbitPrefetch), 0, false); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	s.mu.Lock()
+	previous := s.conns[worker-1]
+	s.conns[worker-1] = conn
+	s.mu.Unlock()
+	if previous != nil {
+		_ = previous.Close()
+	}
+	return ch, nil
+}
+
+// closeReason error of closed channel, reason is empty if channel is closed by client
+func closeReason(closed <-chan *amqp.Error) error {
+	select {
+	case reason, ok := <-closed:
+		if ok && reason != nil {
+			return reason
+		}
+	default:
+	}
+	return errors.New("rabbit channel is closed")
+}
+
+func (s *ServerWorker) closeConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, conn := range s.conns {
+		if conn == nil {
+			continue
+		}
+		if err := conn.Close(); err != nil {
+			log.Warningf("failed to close rabbit connection: %v", err)
+		}
+		s.conns[i] = nil
+	}
+}
+
+// dial connects to rabbit and declares queue of server
+func dial(ctx context.Context, opt *Opt) (connection, channel, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	conn, err := opt.dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, err := conn.Channel()
+	if err == nil {
+		err = opt.declareQueue(ch)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return conn, ch, nil
+}
+
+func NewServerWorker(
+	ctx context.Context,
+	rowHandler handler.Handler,
+	writer handler.RowWriter,
+	opt *Opt,
+) (*ServerWorker, error) {
+	return newServerWorker(ctx, rowHandler, writer, opt, dial)
+}
+
+func newServerWorker(
+	ctx context.Context,
+	rowHandler handler.Handler,
+	writer handler.RowWriter,
+	opt *Opt,
+	dial dialer,
+) (*ServerWorker, error) {
+	// check connection and declare queue
+	conn, _, err := dial(ctx, opt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to rabbit: %w", err)
+	}
+	if err := conn.Close(); err != nil {
+		log.Warningf("failed to close connection: %v", err)
+	}
+	log.Info("rabbit is successfully connected")
+	s := &ServerWorker{
+		handler: rowHandler,
+		writer:  writer,
+		dial:    dial,
+		opt:     opt,
+		wg:      &sync.WaitGroup{},
+		conns:   make([]connection, opt.AsyncFactor),
+	}
+	return s, nil
+}
+
+// consumerCloser closes rabbit connections after clickhouse buffer is flushed on shutdown,
+// so written messages are acknowledged and the rest are requeued
+type consumerCloser struct {
+	worker *ServerWorker
+}
+
+func (c *consumerCloser) Drop() error {
+	c.worker.closeConnections()
+	return nil
+}
+
+func (c *consumerCloser) DropMsg() string {
+	return "close rabbit consumers"
+}
@@ -0,0 +1,242 @@
+package redislog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/drop"
+	"github.com/zikwall/grower/pkg/fileio"
+	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+)
+
+var errClientClosed = errors.New("client is closed")
+
+// ClientSource name of redis client in metrics
+const ClientSource = "redislog_client"
+
+const dialTimeout = 5 * time.Second
+
+type Client struct {
+	*drop.Impl
+	worker *ClientWorker
+}
+
+func NewClient(ctx context.Context, opt *Opt) (*Client, error) {
+	worker, err := NewClientWorker(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		Impl:   drop.NewContext(ctx),
+		worker: worker,
+	}
+	client.AddDropper(client.worker)
+	return client, nil
+}
+
+func (c *Client) Run(ctx context.Context) {
+	c.worker.runContext(ctx)
+}
+
+type ClientWorker struct {
+	rotator  fileio.Rotator
+	opt      *Opt
+	str      chan fileio.Line
+	wg       *sync.WaitGroup
+	isClosed uint32
+}
+
+func NewClientWorker(ctx context.Context, opt *Opt) (*ClientWorker, error) {
+	// check connection
+	p := &publisher{opt: opt}
+	if _, err := p.connect(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	p.close()
+	log.Info("redis is successfully connected")
+	c := &ClientWorker{
+		opt: opt,
+		str: make(chan fileio.Line),
+		wg:  &sync.WaitGroup{},
+	}
+	c.rotator = fileio.New(
+		opt.SourceLogFile,
+		opt.LogsDir,
+		opt.BackupFiles,
+		opt.BackupFileMaxAge,
+		opt.AutoCreateTargetFromScratch,
+		opt.EnableRotating,
+		opt.SkipNginxReopen,
+		opt.OffsetFile,
+		c.handleLine,
+	)
+	return c, nil
+}
+
+func (w *ClientWorker) Drop() error {
+	atomic.StoreUint32(&w.isClosed, 1)
+	w.wg.Wait()
+	log.Info("stop all workers")
+	// save offsets of published lines
+	w.rotator.Save()
+	return nil
+}
+
+func (w *ClientWorker) DropMsg() string {
+	return "kill redis client"
+}
+
+// create worker pool for publishing lines
+func (w *ClientWorker) preparePool(ctx context.Context) {
+	var i uint
+	for i = 1; i <= w.opt.AsyncFactor; i++ {
+		w.wg.Add(1)
+		go w.makeWriteListener(ctx, i)
+	}
+}
+
+func (w *ClientWorker) makeWriteListener(ctx context.Context, worker uint) {
+	p := &publisher{opt: w.opt}
+	defer func() {
+		p.close()
+		w.wg.Done()
+		if w.opt.Debug {
+			log.Infof("stop redis publisher %d", worker)
+		}
+	}()
+	if w.opt.Debug {
+		log.Infof("run redis publisher %d", worker)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case str, ok := <-w.str:
+			if !ok {
+				return
+			}
+			if err := p.publishRetry(ctx, str.Content); err != nil {
+				// line is not acknowledged, so it is read again after restart
+				log.Warningf("publish to redis: %s", err.Error())
+				continue
+			}
+			str.Mark.Ack()
+		}
+	}
+}
+
+// runContext main loop for read and rotating logs
+func (w *ClientWorker) runContext(ctx context.Context) {
+	w.preparePool(ctx)
+	w.wg.Add(1)
+	go func() {
+		ticker := time.NewTicker(w.opt.ScrapeInterval)
+		defer func() {
+			ticker.Stop()
+			close(w.str)
+			w.wg.Done()
+			log.Info("stop rotate worker")
+		}()
+		if err := w.rotator.Resume(); err != nil {
+			log.Warning(err)
+		}
+		if w.opt.RunAtStartup {
+			if err := w.rotator.Rotate(); err != nil {
+				log.Warning(err)
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := w.rotator.Rotate(); err != nil {
+					log.Warning(err)
+				}
+			}
+		}
+	}()
+}
+
+// handleLine sends line to publishers, line is acknowledged after it is appended to stream
+func (w *ClientWorker) handleLine(content string, mark *checkpoint.Mark) error {
+	if atomic.LoadUint32(&w.isClosed) == 1 {
+		return errClientClosed
+	}
+	w.str <- fileio.Line{Content: content, Mark: mark}
+	metrics.LinesRead.WithLabelValues(ClientSource).Inc()
+	return nil
+}
+
+// publisher own client of worker, its connection is reconnected after failures
+type publisher struct {
+	opt    *Opt
+	client *redis.Client
+}
+
+func (p *publisher) connect(ctx context.Context) (*redis.Client, error) {
+	if p.client != nil {
+		return p.client, nil
+	}
+	client, err := p.opt.newClient(1)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	p.client = client
+	return client, nil
+}
+
+func (p *publisher) publish(ctx context.Context, message string) error {
+	client, err := p.connect(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.opt.RedisWriteTimeout)
+	defer cancel()
+	return client.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.opt.RedisStream,
+		MaxLen: p.opt.RedisMaxLen,
+		Approx: true,
+		Values: []string{lineField, message},
+	}).Err()
+}
+
+// publishRetry publishes line until success, so lines are not skipped while redis is unavailable
+func (p *publisher) publishRetry(ctx context.Context, message string) error {
+	for {
+		err := p.publish(ctx, message)
+		if err == nil {
+			return nil
+		}
+		log.Warningf("publish to redis: %s, retry in %s", err.Error(), p.opt.RedisReconnectDelay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(p.opt.RedisReconnectDelay):
+		}
+	}
+}
+
+func (p *publisher) close() {
+	if p.client == nil {
+		return
+	}
+	if err := p.client.Close(); err != nil {
+		log.Warningf("failed to close redis client: %v", err)
+	}
+	p.client = nil
+}
@@ -0,0 +1,70 @@
+package redislog
+
+import (
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/zikwall/grower/config"
+)
+
+// lineField field of stream entry with log line
+const lineField = "line"
+
+type Opt struct {
+	ClientOpt
+	ServerOpt
+	Config              *config.Config
+	AsyncFactor         uint
+	Debug               bool
+	RedisURL            string
+	RedisStream         string
+	RedisReconnectDelay time.Duration
+}
+
+type ClientOpt struct {
+	// RedisMaxLen stream is trimmed approximately to this count of entries, zero disables trimming
+	RedisMaxLen                 int64
+	RedisWriteTimeout           time.Duration
+	LogsDir                     string
+	SourceLogFile               string
+	ScrapeInterval              time.Duration
+	BackupFiles                 uint
+	BackupFileMaxAge            time.Duration
+	EnableRotating              bool
+	AutoCreateTargetFromScratch bool
+	RunAtStartup                bool
+	SkipNginxReopen             bool
+	RewriteNginxLocalTime       bool
+	OffsetFile                  string
+}
+
+type ServerOpt struct {
+	config.DeadLetter
+	config.Spool
+	RedisGroup    string
+	RedisConsumer string
+	RedisCount    int64
+	RedisBlock    time.Duration
+	// RedisClaimMinIdle pending entries of dead consumers are reclaimed after this idle time
+	RedisClaimMinIdle  time.Duration
+	RedisClaimInterval time.Duration
+	Clickhouse         *clickhouse.Options
+	BufSize            uint
+	BufFlushInterval   uint
+	WriteTimeout       time.Duration
+}
+
+// newClient creates redis client of URL, pool has at least poolSize connections, they are reconnected by client after failures
+func (o *Opt) newClient(poolSize int) (*redis.Client, error) {
+	options, err := redis.ParseURL(o.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	options.DialTimeout = dialTimeout
+	if options.PoolSize < poolSize {
+		options.PoolSize = poolSize
+	}
+	return redis.NewClient(options), nil
+}
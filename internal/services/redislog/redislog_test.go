@@ -0,0 +1,158 @@
+package redislog
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/handler"
+)
+
+type fakeHandler struct{}
+
+func (f *fakeHandler) Handle(content string) (*handler.Row, error) {
+	if content == "broken" {
+		return nil, errors.New("parse error")
+	}
+	return &handler.Row{Vector: cx.Vector{content}}, nil
+}
+
+// fakeWriter acknowledges marks immediately, as if every row is flushed to clickhouse
+type fakeWriter struct {
+	mu      sync.Mutex
+	vectors []cx.Vector
+}
+
+func (f *fakeWriter) Write(row *handler.Row, mark *checkpoint.Mark) {
+	f.mu.Lock()
+	f.vectors = append(f.vectors, row.Vector)
+	f.mu.Unlock()
+	mark.Ack()
+}
+
+func (f *fakeWriter) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.vectors)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout of condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// pending entries of consumer group which are not acknowledged
+func pending(t *testing.T, client *redis.Client) int64 {
+	t.Helper()
+	info, err := client.XPending(context.Background(), "access_log", "grower").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Count
+}
+
+func TestRedisLog(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer func() {
+		_ = client.Close()
+	}()
+	opt := &Opt{
+		ClientOpt: ClientOpt{
+			RedisMaxLen:       100,
+			RedisWriteTimeout: time.Second,
+		},
+		ServerOpt: ServerOpt{
+			RedisGroup:    "grower",
+			RedisConsumer: "test",
+			RedisCount:    10,
+			RedisBlock:    50 * time.Millisecond,
+			// entries are acknowledged every ackInterval, so they are not reclaimed before it
+			RedisClaimMinIdle:  500 * time.Millisecond,
+			RedisClaimInterval: 50 * time.Millisecond,
+		},
+		AsyncFactor:         2,
+		RedisURL:            "redis://" + server.Addr(),
+		RedisStream:         "access_log",
+		RedisReconnectDelay: 10 * time.Millisecond,
+	}
+
+	t.Run("it should be appended to stream before server is started", func(t *testing.T) {
+		p := &publisher{opt: opt}
+		defer p.close()
+		for _, line := range []string{"GET /", "broken", "POST /"} {
+			if err := p.publishRetry(context.Background(), line); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if length := client.XLen(context.Background(), "access_log").Val(); length != 3 {
+			t.Fatalf("expect 3 entries, got %d", length)
+		}
+	})
+
+	writer := &fakeWriter{}
+	worker, err := NewServerWorker(context.Background(), &fakeHandler{}, writer, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it should be reclaimed entries of dead consumer", func(t *testing.T) {
+		p := &publisher{opt: opt}
+		defer p.close()
+		if err := p.publishRetry(context.Background(), "DELETE /"); err != nil {
+			t.Fatal(err)
+		}
+		// dead consumer reads all entries and never acknowledges them
+		streams, err := client.XReadGroup(context.Background(), &redis.XReadGroupArgs{
+			Group:    "grower",
+			Consumer: "dead",
+			Streams:  []string{"access_log", ">"},
+			Count:    10,
+			Block:    -1,
+		}).Result()
+		if err != nil || len(streams) != 1 || len(streams[0].Messages) != 4 {
+			t.Fatalf("expect 4 entries of dead consumer, got %v %v", streams, err)
+		}
+	})
+
+	t.Run("it should be acknowledged written and broken entries", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		worker.preparePool(ctx)
+		waitFor(t, func() bool {
+			return writer.len() == 3 && pending(t, client) == 0
+		})
+
+		// consumer group is created again after redis lost data
+		server.FlushAll()
+		p := &publisher{opt: opt}
+		defer p.close()
+		if err := p.publishRetry(context.Background(), "PUT /"); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool {
+			return writer.len() == 4
+		})
+
+		cancel()
+		if err := worker.Drop(); err != nil {
+			t.Fatal(err)
+		}
+		worker.closeConnections()
+		if pending(t, client) != 0 {
+			t.Fatal("expect all entries are acknowledged")
+		}
+	})
+}
@@ -0,0 +1,362 @@
+package redislog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/redis/go-redis/v9"
+	clickhousebuffer "github.com/zikwall/clickhouse-buffer/v4"
+	"github.com/zikwall/clickhouse-buffer/v4/src/buffer/cxmem"
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+	"github.com/zikwall/clickhouse-buffer/v4/src/db/cxnative"
+
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/deadletter"
+	"github.com/zikwall/grower/pkg/drop"
+	"github.com/zikwall/grower/pkg/handler"
+	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+	"github.com/zikwall/grower/pkg/nginx"
+	"github.com/zikwall/grower/pkg/spool"
+	"github.com/zikwall/grower/pkg/wrap"
+)
+
+// ServerSource name of redis server in metrics and dead letters
+const ServerSource = "redislog"
+
+// ackInterval acknowledgements of written entries are sent by one XACK in this interval
+const ackInterval = 100 * time.Millisecond
+
+type Server struct {
+	*drop.Impl
+	worker        *ServerWorker
+	bufferWrapper *wrap.BufferWrapper
+	clientWrapper *wrap.ClientWrapper
+}
+
+func NewServer(ctx context.Context, opt *Opt) (*Server, error) {
+	parser, err := nginx.NewParser(opt.Config.Nginx.LogType, opt.Config.Nginx.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+	rowHandler, err := handler.New(opt.Config, parser)
+	if err != nil {
+		return nil, err
+	}
+	if err := spool.Check(&opt.Spool, rowHandler.Types()); err != nil {
+		return nil, err
+	}
+	ch, _, err := cxnative.NewClickhouse(ctx, opt.Clickhouse, &cx.RuntimeOptions{
+		WriteTimeout: opt.WriteTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch, err = spool.New(metrics.NewClickhouse(ch), &opt.Spool)
+	if err != nil {
+		return nil, err
+	}
+	// entries are acknowledged in consumer group only after they are written to clickhouse
+	ch = checkpoint.NewClickhouse(ch)
+	client := clickhousebuffer.NewClientWithOptions(ctx, ch, clickhousebuffer.NewOptions(
+		clickhousebuffer.WithFlushInterval(opt.BufFlushInterval),
+		clickhousebuffer.WithBatchSize(opt.BufSize),
+		clickhousebuffer.WithDebugMode(opt.Debug),
+		clickhousebuffer.WithRetry(true),
+	))
+	deadLetterSink, err := deadletter.New(&opt.DeadLetter, client)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Impl:          drop.NewContext(ctx),
+		bufferWrapper: wrap.NewBufferWrapper(ch),
+		clientWrapper: wrap.NewClientWrapper(client),
+	}
+	columns := rowHandler.Columns()
+	newWriter := func(table string) clickhousebuffer.Writer {
+		return s.clientWrapper.Client().Writer(
+			clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
+				"max_execution_time": opt.ServerOpt.WriteTimeout.Seconds(),
+			})),
+			cx.NewView(table, columns),
+			cxmem.NewBuffer(s.clientWrapper.Client().Options().BatchSize()),
+		)
+	}
+	writerAPI := handler.NewWriter(rowHandler, newWriter(opt.Config.Scheme.LogsTable), newWriter)
+	server, err := NewServerWorker(
+		ctx,
+		deadletter.NewHandler(rowHandler, deadLetterSink, ServerSource+":"+opt.RedisStream),
+		writerAPI,
+		opt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.worker = server
+	s.AddDroppers(
+		s.worker,
+		deadLetterSink,
+		s.clientWrapper,
+		&consumerCloser{worker: s.worker},
+		s.bufferWrapper,
+	)
+	return s, nil
+}
+
+func (w *Server) Run(ctx context.Context) {
+	w.worker.preparePool(ctx)
+}
+
+type ServerWorker struct {
+	opt      *Opt
+	wg       *sync.WaitGroup
+	handler  handler.Handler
+	writer   handler.RowWriter
+	acker    *acker
+	isClosed uint32
+	client   *redis.Client
+}
+
+func (s *ServerWorker) Drop() error {
+	atomic.StoreUint32(&s.isClosed, 1)
+	s.wg.Wait()
+	log.Info("stop all consumers")
+	return nil
+}
+
+func (s *ServerWorker) DropMsg() string {
+	return "kill redis server"
+}
+
+// create worker pool for consuming entries
+func (s *ServerWorker) preparePool(ctx context.Context) {
+	go s.acker.run(ctx)
+	var i uint
+	for i = 1; i <= s.opt.AsyncFactor; i++ {
+		s.wg.Add(1)
+		go s.makeConsumerListener(ctx, i)
+	}
+}
+
+func (s *ServerWorker) makeConsumerListener(ctx context.Context, worker uint) {
+	defer func() {
+		s.wg.Done()
+		if s.opt.Debug {
+			log.Infof("stop redis consumer %d", worker)
+		}
+	}()
+	if s.opt.Debug {
+		log.Infof("run redis consumer %d", worker)
+	}
+	for {
+		err := s.consume(ctx, worker)
+		if ctx.Err() != nil || atomic.LoadUint32(&s.isClosed) == 1 {
+			return
+		}
+		log.Warningf("redis consumer %d: %v, reconnect in %s", worker, err, s.opt.RedisReconnectDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.opt.RedisReconnectDelay):
+		}
+	}
+}
+
+// consume reads new entries of consumer group until context is done or redis fails,
+// pending entries of dead consumers are reclaimed every claim interval
+func (s *ServerWorker) consume(ctx context.Context, worker uint) error {
+	// group is created again, if redis is restarted without data
+	if err := createGroup(ctx, s.client, s.opt); err != nil {
+		return err
+	}
+	consumer := fmt.Sprintf("%s-%d", s.opt.RedisConsumer, worker)
+	var claimedAt time.Time
+	cursor := "0-0"
+	for ctx.Err() == nil && atomic.LoadUint32(&s.isClosed) == 0 {
+		if time.Since(claimedAt) >= s.opt.RedisClaimInterval {
+			messages, next, err := s.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   s.opt.RedisStream,
+				Group:    s.opt.RedisGroup,
+				MinIdle:  s.opt.RedisClaimMinIdle,
+				Start:    cursor,
+				Count:    s.opt.RedisCount,
+				Consumer: consumer,
+			}).Result()
+			if err != nil {
+				return err
+			}
+			if len(messages) > 0 {
+				log.Infof("redis consumer %d: reclaimed %d pending entries", worker, len(messages))
+			}
+			s.handleAll(worker, messages)
+			// all pending entries are scanned, the next scan is after claim interval
+			if cursor = next; cursor == "0-0" {
+				claimedAt = time.Now()
+			}
+			continue
+		}
+		streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.opt.RedisGroup,
+			Consumer: consumer,
+			Streams:  []string{s.opt.RedisStream, ">"},
+			Count:    s.opt.RedisCount,
+			Block:    s.opt.RedisBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			// there are no new entries during block time
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, stream := range streams {
+			s.handleAll(worker, stream.Messages)
+		}
+	}
+	return ctx.Err()
+}
+
+func (s *ServerWorker) handleAll(worker uint, messages []redis.XMessage) {
+	linesRead := metrics.LinesRead.WithLabelValues(ServerSource)
+	for _, message := range messages {
+		linesRead.Inc()
+		if s.opt.Debug {
+			fmt.Printf("redis [consumer %d] entry %s\n", worker, message.ID)
+		}
+		s.handle(message)
+	}
+}
+
+func (s *ServerWorker) handle(message redis.XMessage) {
+	line, ok := message.Values[lineField].(string)
+	if !ok {
+		log.Warningf("redis entry %s has no field %s", message.ID, lineField)
+		s.acker.add(message.ID)
+		return
+	}
+	row, err := s.handler.Handle(line)
+	if err != nil {
+		log.Warning(err)
+		// unparsable entry is never read again, it is kept by dead letter sink, if it is set
+		s.acker.add(message.ID)
+		return
+	}
+	id := message.ID
+	s.writer.Write(row, checkpoint.NewMark(func() {
+		s.acker.add(id)
+	}))
+}
+
+func (s *ServerWorker) closeConnections() {
+	s.acker.flush()
+	if err := s.client.Close(); err != nil {
+		log.Warningf("failed to close redis client: %v", err)
+	}
+}
+
+// createGroup creates consumer group of server and stream, it is not an error if group exists,
+// new group reads stream from the beginning, so lines published before the first start of server are not lost
+func createGroup(ctx context.Context, client *redis.Client, opt *Opt) error {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	err := client.XGroupCreateMkStream(ctx, opt.RedisStream, opt.RedisGroup, "0").Err()
+	if err != nil && redis.HasErrorPrefix(err, "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+func NewServerWorker(
+	ctx context.Context,
+	rowHandler handler.Handler,
+	writer handler.RowWriter,
+	opt *Opt,
+) (*ServerWorker, error) {
+	// blocking reads of consumers hold own connections, the last one is for acknowledgements
+	client, err := opt.newClient(int(opt.AsyncFactor) + 1)
+	if err != nil {
+		return nil, err
+	}
+	// check connection and create consumer group
+	if err := createGroup(ctx, client, opt); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	log.Info("redis is successfully connected")
+	s := &ServerWorker{
+		handler: rowHandler,
+		writer:  writer,
+		opt:     opt,
+		acker:   &acker{opt: opt, client: client},
+		wg:      &sync.WaitGroup{},
+		client:  client,
+	}
+	return s, nil
+}
+
+// acker collects ids of written entries and acknowledges them in batches
+type acker struct {
+	opt     *Opt
+	client  *redis.Client
+	mu      sync.Mutex
+	ids     []string
+	flushMu sync.Mutex
+}
+
+func (a *acker) add(id string) {
+	a.mu.Lock()
+	a.ids = append(a.ids, id)
+	a.mu.Unlock()
+}
+
+func (a *acker) run(ctx context.Context) {
+	ticker := time.NewTicker(ackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+// flush acknowledges collected entries, entries which are failed to acknowledge stay pending and are reclaimed later
+func (a *acker) flush() {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+	a.mu.Lock()
+	ids := a.ids
+	a.ids = nil
+	a.mu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	if err := a.client.XAck(ctx, a.opt.RedisStream, a.opt.RedisGroup, ids...).Err(); err != nil {
+		log.Warningf("failed to acknowledge %d redis entries: %v", len(ids), err)
+	}
+}
+
+// consumerCloser acknowledges entries and closes redis connections after clickhouse buffer is flushed on shutdown,
+// entries which are not written stay pending and are reclaimed after restart
+type consumerCloser struct {
+	worker *ServerWorker
+}
+
+func (c *consumerCloser) Drop() error {
+	c.worker.closeConnections()
+	return nil
+}
+
+func (c *consumerCloser) DropMsg() string {
+	return "close redis consumers"
+}
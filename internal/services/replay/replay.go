@@ -0,0 +1,169 @@
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	clickhousebuffer "github.com/zikwall/clickhouse-buffer/v4"
+	"github.com/zikwall/clickhouse-buffer/v4/src/buffer/cxmem"
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+	"github.com/zikwall/clickhouse-buffer/v4/src/db/cxnative"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/deadletter"
+	"github.com/zikwall/grower/pkg/drop"
+	"github.com/zikwall/grower/pkg/handler"
+	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/nginx"
+	"github.com/zikwall/grower/pkg/wrap"
+)
+
+// Replay re-ingests dead letter files after manual fixing,
+// lines that still can't be handled are written to rejected file
+type Replay struct {
+	*drop.Impl
+	bufferWrapper *wrap.BufferWrapper
+	clientWrapper *wrap.ClientWrapper
+	rowHandler    handler.Handler
+	writer        handler.RowWriter
+	rejected      deadletter.Sink
+	cfg           *Cfg
+}
+
+type Opt struct {
+	Config       *config.Config
+	Clickhouse   *clickhouse.Options
+	ReplayConfig *Cfg
+}
+
+type Cfg struct {
+	config.Runtime
+	config.Buffer
+	Files        []string
+	RejectedFile string
+}
+
+// Stat replay result
+type Stat struct {
+	Total    uint64
+	Written  uint64
+	Rejected uint64
+}
+
+func New(ctx context.Context, opt *Opt) (*Replay, error) {
+	parser, err := nginx.NewParser(opt.Config.Nginx.LogType, opt.Config.Nginx.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+	rowHandler, err := handler.New(opt.Config, parser)
+	if err != nil {
+		return nil, err
+	}
+	rejected, err := deadletter.NewFileSink(opt.ReplayConfig.RejectedFile, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	ch, _, err := cxnative.NewClickhouse(ctx, opt.Clickhouse, &cx.RuntimeOptions{
+		WriteTimeout: opt.ReplayConfig.WriteTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client := clickhousebuffer.NewClientWithOptions(ctx, ch, clickhousebuffer.NewOptions(
+		clickhousebuffer.WithFlushInterval(opt.ReplayConfig.BufFlushInterval),
+		clickhousebuffer.WithBatchSize(opt.ReplayConfig.BufSize),
+		clickhousebuffer.WithDebugMode(opt.ReplayConfig.Debug),
+		clickhousebuffer.WithRetry(true),
+	))
+	columns := rowHandler.Columns()
+	r := &Replay{
+		Impl:          drop.NewContext(ctx),
+		bufferWrapper: wrap.NewBufferWrapper(ch),
+		clientWrapper: wrap.NewClientWrapper(client),
+		rejected:      rejected,
+		cfg:           opt.ReplayConfig,
+		rowHandler:    rowHandler,
+	}
+	newWriter := func(table string) clickhousebuffer.Writer {
+		return client.Writer(
+			clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
+				"max_execution_time": opt.ReplayConfig.WriteTimeout.Seconds(),
+			})),
+			cx.NewView(table, columns),
+			cxmem.NewBuffer(client.Options().BatchSize()),
+		)
+	}
+	r.writer = handler.NewWriter(rowHandler, newWriter(opt.Config.Scheme.LogsTable), newWriter)
+	// client wrapper flushes all buffered rows on close
+	r.AddDroppers(
+		r.rejected,
+		r.clientWrapper,
+		r.bufferWrapper,
+	)
+	return r, nil
+}
+
+// maxLetterSize is the limit of one line of dead letter file, letters with long lines or errors
+// exceed the default limit of scanner
+const maxLetterSize = 16 * 1024 * 1024
+
+// Run replay all files one by one
+func (r *Replay) Run(ctx context.Context) (Stat, error) {
+	stat := Stat{}
+	for _, file := range r.cfg.Files {
+		if err := r.replayFile(ctx, file, &stat); err != nil {
+			return stat, err
+		}
+		log.Infof("file %s is replayed", file)
+	}
+	return stat, nil
+}
+
+func (r *Replay) replayFile(ctx context.Context, filepath string, stat *Stat) error {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("failed open dead letter file: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warning(err)
+		}
+	}()
+	scanner := bufio.NewScanner(bufio.NewReader(f))
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLetterSize)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		letter := decodeLetter(scanner.Text(), filepath)
+		stat.Total++
+		row, err := r.rowHandler.Handle(letter.Line)
+		if err != nil {
+			stat.Rejected++
+			if r.cfg.Debug {
+				log.Warning(err)
+			}
+			if err := r.rejected.Write(deadletter.NewLetter(letter.Line, letter.Source, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		r.writer.Write(row, nil)
+		stat.Written++
+	}
+	return scanner.Err()
+}
+
+// decodeLetter dead letter files contain JSON letters, but raw lines are also allowed,
+// in this case the source of line is the file itself
+func decodeLetter(line, filepath string) *deadletter.Letter {
+	letter := &deadletter.Letter{}
+	if err := json.Unmarshal([]byte(line), letter); err != nil || letter.Line == "" {
+		return &deadletter.Letter{Line: line, Source: filepath}
+	}
+	return letter
+}
@@ -0,0 +1,65 @@
+package syslog
+
+import (
+	"strconv"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+// virtual fields of syslog message, they can be mapped to columns like fields of parsed content,
+// e.g. $syslog_hostname or syslog_hostname, since leading $ of field name is optional
+const (
+	FieldHostname  = "syslog_hostname"
+	FieldTag       = "syslog_tag"
+	FieldPriority  = "syslog_priority"
+	FieldFacility  = "syslog_facility"
+	FieldSeverity  = "syslog_severity"
+	FieldTimestamp = "syslog_timestamp"
+	FieldProcID    = "syslog_proc_id"
+	FieldMsgID     = "syslog_msg_id"
+)
+
+// VirtualFields are added to fields of parsed content, sd. is prefix of fields of structured data
+var VirtualFields = []string{
+	FieldHostname, FieldTag, FieldPriority, FieldFacility, FieldSeverity, FieldTimestamp, FieldProcID, FieldMsgID, "sd.",
+}
+
+// timestampLayout is layout of DateTime cast, timestamp is converted to UTC
+const timestampLayout = "2006-01-02T15:04:05"
+
+// metadata virtual fields of message header, tag of RFC5424 message is its APP-NAME
+func metadata(parts format.LogParts) nginx.Fields {
+	fields := nginx.Fields{
+		FieldHostname: stringPart(parts, "hostname"),
+		FieldTag:      stringPart(parts, "tag"),
+		FieldPriority: intPart(parts, "priority"),
+		FieldFacility: intPart(parts, "facility"),
+		FieldSeverity: intPart(parts, "severity"),
+		FieldProcID:   stringPart(parts, "proc_id"),
+		FieldMsgID:    stringPart(parts, "msg_id"),
+	}
+	if fields[FieldTag] == "" {
+		fields[FieldTag] = stringPart(parts, "app_name")
+	}
+	if timestamp, ok := parts["timestamp"].(time.Time); ok && !timestamp.IsZero() {
+		fields[FieldTimestamp] = timestamp.UTC().Format(timestampLayout)
+	} else {
+		fields[FieldTimestamp] = ""
+	}
+	return fields
+}
+
+func stringPart(parts format.LogParts, name string) string {
+	value, _ := parts[name].(string)
+	return value
+}
+
+func intPart(parts format.LogParts, name string) string {
+	if value, ok := parts[name].(int); ok {
+		return strconv.Itoa(value)
+	}
+	return ""
+}
@@ -2,7 +2,6 @@ package syslog
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	clickhousebuffer "github.com/zikwall/clickhouse-buffer/v4"
@@ -12,19 +11,25 @@ import (
 	"gopkg.in/mcuadros/go-syslog.v2/format"
 
 	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/deadletter"
 	"github.com/zikwall/grower/pkg/drop"
 	"github.com/zikwall/grower/pkg/handler"
 	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
 	"github.com/zikwall/grower/pkg/nginx"
+	"github.com/zikwall/grower/pkg/spool"
 	"github.com/zikwall/grower/pkg/wrap"
 )
 
+// Source name of service in metrics and dead letters
+const Source = "syslog"
+
 type Syslog struct {
 	*drop.Impl
 	syslog        *server
 	bufferWrapper *wrap.BufferWrapper
 	clientWrapper *wrap.ClientWrapper
-	rowHandler    handler.Handler
+	rowHandler    handler.FieldsHandler
 }
 
 type Opt struct {
@@ -36,65 +41,125 @@ type Opt struct {
 type Cfg struct {
 	config.Runtime
 	config.Buffer
-	Listeners []string
-	Unix      string
-	UPD       string
-	TCP       string
+	config.DeadLetter
+	config.Spool
+	// TLS certificates of TLS listener
+	config.TLS
+	// Listeners transports with optional format of messages and address, e.g. tcp:rfc5424@:6514, udp, tls:auto,
+	// listeners without address bind address of their transport
+	Listeners  []string
+	Unix       string
+	UDP        string
+	TCP        string
+	TLSAddress string
+	// Allow and Deny CIDR lists of TCP and UDP sources, deny list has priority, all sources are allowed by default
+	Allow []string
+	Deny  []string
 }
 
 func New(ctx context.Context, opt *Opt) (*Syslog, error) {
+	parser, err := nginx.NewParser(opt.Config.Nginx.LogType, opt.Config.Nginx.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+	rowHandler, err := handler.New(opt.Config, parser)
+	if err != nil {
+		return nil, err
+	}
+	if err := spool.Check(&opt.SyslogConfig.Spool, rowHandler.Types()); err != nil {
+		return nil, err
+	}
+	syslogServer, err := newServer(opt.SyslogConfig)
+	if err != nil {
+		return nil, err
+	}
 	ch, _, err := cxnative.NewClickhouse(ctx, opt.Clickhouse, &cx.RuntimeOptions{
 		WriteTimeout: opt.SyslogConfig.WriteTimeout,
 	})
 	if err != nil {
 		return nil, err
 	}
+	ch, err = spool.New(metrics.NewClickhouse(ch), &opt.SyslogConfig.Spool)
+	if err != nil {
+		return nil, err
+	}
 	client := clickhousebuffer.NewClientWithOptions(ctx, ch, clickhousebuffer.NewOptions(
 		clickhousebuffer.WithFlushInterval(opt.SyslogConfig.BufFlushInterval),
 		clickhousebuffer.WithBatchSize(opt.SyslogConfig.BufSize),
 		clickhousebuffer.WithDebugMode(opt.SyslogConfig.Debug),
 		clickhousebuffer.WithRetry(true),
 	))
-	columns, scheme := opt.Config.Scheme.MapKeys()
+	deadLetterSink, err := deadletter.New(&opt.SyslogConfig.DeadLetter, client)
+	if err != nil {
+		return nil, err
+	}
+	columns := rowHandler.Columns()
 	s := &Syslog{
 		Impl:          drop.NewContext(ctx),
 		bufferWrapper: wrap.NewBufferWrapper(ch),
 		clientWrapper: wrap.NewClientWrapper(client),
-		rowHandler: handler.NewRowHandler(
-			columns, scheme,
-			nginx.NewTemplate(opt.Config.Nginx.LogFormat),
-			nginx.NewTypeCaster(&nginx.CasterCfg{
-				CustomCasts:       opt.Config.Nginx.LogCustomCasts,
-				LocalTimeFormat:   opt.Config.Nginx.LogTimeFormat,
-				CustomCastsEnable: opt.Config.Nginx.LogCustomCastsEnable,
-				RemoveHyphen:      opt.Config.Nginx.LogRemoveHyphen,
-			}),
-		),
-		syslog: newServer(opt.SyslogConfig),
+		rowHandler:    deadletter.NewHandler(rowHandler, deadLetterSink, Source),
+		syslog:        syslogServer,
 	}
 	s.AddDroppers(
 		s.syslog,
+		deadLetterSink,
 		s.clientWrapper,
 		s.bufferWrapper,
 	)
-	writerAPI := s.Buffer().Writer(
-		clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
-			"max_execution_time": opt.SyslogConfig.WriteTimeout.Seconds(),
-		})),
-		cx.NewView(opt.Config.Scheme.LogsTable, columns),
-		cxmem.NewBuffer(
-			s.Buffer().Options().BatchSize(),
-		),
-	)
+	newWriter := func(table string) clickhousebuffer.Writer {
+		return s.Buffer().Writer(
+			clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
+				"max_execution_time": opt.SyslogConfig.WriteTimeout.Seconds(),
+			})),
+			cx.NewView(table, columns),
+			cxmem.NewBuffer(
+				s.Buffer().Options().BatchSize(),
+			),
+		)
+	}
+	// writers of tables are shared by tags and routes of scheme
+	tableWriters := map[string]clickhousebuffer.Writer{}
+	tableWriter := func(table string) clickhousebuffer.Writer {
+		if _, ok := tableWriters[table]; !ok {
+			tableWriters[table] = newWriter(table)
+		}
+		return tableWriters[table]
+	}
+	writerAPI := handler.NewWriter(rowHandler, tableWriter(opt.Config.Scheme.LogsTable), tableWriter)
+	// rows are routed by tag, tables of tags have the same columns as logs table, routes of scheme have priority
+	tagWriters := make(map[string]handler.RowWriter, len(opt.Config.Syslog.TagTables))
+	for tag, table := range opt.Config.Syslog.TagTables {
+		tagWriters[tag] = handler.NewWriter(rowHandler, tableWriter(table), tableWriter)
+	}
+	linesRead := metrics.LinesRead.WithLabelValues(Source)
 	s.syslog.setHandler(func(parts format.LogParts) {
-		if value, ok := parts["content"]; ok && value != "" {
-			vector, err := s.rowHandler.Handle(fmt.Sprintf("%v", parts["content"]))
-			if err != nil {
-				log.Warning(err)
-				return
-			}
-			writerAPI.WriteVector(vector)
+		// sources are checked by CIDR lists before messages are parsed
+		content := messageContent(parts)
+		if content == "" {
+			return
+		}
+		linesRead.Inc()
+		// header and structured data of RFC5424 message are added to fields of parsed content
+		fields := metadata(parts)
+		data, _ := parts["structured_data"].(string)
+		structured, err := structuredData(data)
+		if err != nil {
+			log.Warning(err)
+		}
+		for name, value := range structured {
+			fields[name] = value
+		}
+		row, err := s.rowHandler.HandleFields(content, fields)
+		if err != nil {
+			log.Warning(err)
+			return
+		}
+		writer, ok := tagWriters[fields[FieldTag]]
+		if !ok {
+			writer = writerAPI
 		}
+		writer.Write(row, nil)
 	})
 	return s, nil
 }
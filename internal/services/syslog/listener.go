@@ -0,0 +1,168 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+// transports of listeners
+const (
+	ListenerTCP = "tcp"
+	ListenerUDP = "udp"
+	ListenerUDS = "unix"
+	// ListenerTLS TCP listener with TLS of RFC5425
+	ListenerTLS = "tls"
+	// ListenerUPD misspelled name of UDP listener, it is kept for compatibility
+	ListenerUPD = "upd"
+)
+
+// formats of messages, RFC6587 is octet-counted framing of RFC5424 messages over TCP,
+// automatic format detects RFC3164 and RFC5424 messages and octet-counted framing of every message
+const (
+	FormatRFC3164 = "rfc3164"
+	FormatRFC5424 = "rfc5424"
+	FormatRFC6587 = "rfc6587"
+	FormatAuto    = "auto"
+)
+
+// listener transport, format of messages and optional address, e.g. tcp:rfc5424@0.0.0.0:6514,
+// format is RFC3164 by default, address is address of transport by default
+type listener struct {
+	transport string
+	format    string
+	address   string
+}
+
+func parseListener(spec string) (listener, error) {
+	kind, address, _ := strings.Cut(strings.TrimSpace(spec), "@")
+	transport, messageFormat, ok := strings.Cut(strings.ToLower(kind), ":")
+	if !ok {
+		messageFormat = FormatRFC3164
+	}
+	if transport == ListenerUPD {
+		transport = ListenerUDP
+	}
+	switch transport {
+	case ListenerTCP, ListenerTLS:
+	case ListenerUDP, ListenerUDS:
+		if messageFormat == FormatRFC6587 {
+			return listener{}, fmt.Errorf("listener %s: octet-counted framing is supported only by stream transports", spec)
+		}
+	default:
+		return listener{}, fmt.Errorf("listener %s: unknown transport %q", spec, transport)
+	}
+	if _, err := syslogFormat(messageFormat); err != nil {
+		return listener{}, fmt.Errorf("listener %s: %w", spec, err)
+	}
+	return listener{transport: transport, format: messageFormat, address: address}, nil
+}
+
+func syslogFormat(messageFormat string) (format.Format, error) {
+	switch messageFormat {
+	case FormatRFC3164:
+		return syslog.RFC3164, nil
+	case FormatRFC5424:
+		return syslog.RFC5424, nil
+	case FormatRFC6587:
+		return syslog.RFC6587, nil
+	case FormatAuto:
+		return syslog.Automatic, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", messageFormat)
+}
+
+// tlsPeer name of client is common name of certificate, client without certificate is also accepted,
+// certificates are required by TLS config when CA file is provided
+func tlsPeer(conn *tls.Conn) string {
+	if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		return certs[0].Subject.CommonName
+	}
+	return ""
+}
+
+// messageContent content of RFC3164 message or message of RFC5424 message
+func messageContent(parts format.LogParts) string {
+	if content, ok := parts["content"].(string); ok && content != "" {
+		return content
+	}
+	if message, ok := parts["message"].(string); ok {
+		return message
+	}
+	return ""
+}
+
+// structuredData parses structured data of RFC5424 message to fields named sd.<SD-ID>.<PARAM-NAME>,
+// characters which are not allowed in field names are replaced by underscore,
+// e.g. [exampleSDID@32473 iut="3"] is field sd.exampleSDID_32473.iut
+func structuredData(data string) (nginx.Fields, error) {
+	if data == "" || data == "-" {
+		return nil, nil
+	}
+	fields := nginx.Fields{}
+	for pos := 0; pos < len(data); {
+		if data[pos] != '[' {
+			return nil, fmt.Errorf("structured data: expected '[' at %d", pos)
+		}
+		pos++
+		end := strings.IndexAny(data[pos:], " ]")
+		if end <= 0 {
+			return nil, fmt.Errorf("structured data: expected SD-ID at %d", pos)
+		}
+		id := fieldName(data[pos : pos+end])
+		pos += end
+		for pos < len(data) && data[pos] == ' ' {
+			pos++
+			eq := strings.IndexByte(data[pos:], '=')
+			if eq <= 0 || pos+eq+1 >= len(data) || data[pos+eq+1] != '"' {
+				return nil, fmt.Errorf("structured data: expected parameter of %s at %d", id, pos)
+			}
+			name := fieldName(data[pos : pos+eq])
+			value, next, err := paramValue(data, pos+eq+2)
+			if err != nil {
+				return nil, err
+			}
+			fields["sd."+id+"."+name] = value
+			pos = next
+		}
+		if pos >= len(data) || data[pos] != ']' {
+			return nil, fmt.Errorf("structured data: element %s is not closed", id)
+		}
+		pos++
+	}
+	return fields, nil
+}
+
+// paramValue unescapes value until closing quote, it returns position after the quote
+func paramValue(data string, pos int) (string, int, error) {
+	value := strings.Builder{}
+	for ; pos < len(data); pos++ {
+		switch c := data[pos]; c {
+		case '"':
+			return value.String(), pos + 1, nil
+		case '\\':
+			if pos+1 < len(data) && (data[pos+1] == '"' || data[pos+1] == '\\' || data[pos+1] == ']') {
+				pos++
+				c = data[pos]
+			}
+			value.WriteByte(c)
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", pos, fmt.Errorf("structured data: parameter value is not closed")
+}
+
+func fieldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,238 @@
+package syslog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+
+	"github.com/zikwall/grower/pkg/log"
+)
+
+const (
+	// datagramSize max size of UDP datagram
+	datagramSize = 64 * 1024
+	// handshakeTimeout TLS handshake of connection should be completed within this time
+	handshakeTimeout = 10 * time.Second
+	// retryDelay delay after temporary failure of accept or read, e.g. too many open files
+	retryDelay = 10 * time.Millisecond
+)
+
+// receiver receives messages of one listener, sources are checked by CIDR lists when connection is accepted
+// or datagram is read, so messages of denied sources are not parsed, messages are parsed by go-syslog formats
+type receiver struct {
+	server    *server
+	listener  listener
+	address   string
+	format    format.Format
+	tlsConfig *tls.Config
+	stream    net.Listener
+	packets   net.PacketConn
+	mu        sync.Mutex
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+func (r *receiver) listen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var err error
+	switch r.listener.transport {
+	case ListenerTCP, ListenerTLS:
+		r.stream, err = net.Listen("tcp", r.address)
+	case ListenerUDP:
+		r.packets, err = net.ListenPacket("udp", r.address)
+	case ListenerUDS:
+		r.packets, err = net.ListenPacket("unixgram", r.address)
+	}
+	if err != nil {
+		return err
+	}
+	if conn, ok := r.packets.(interface{ SetReadBuffer(int) error }); ok {
+		if err := conn.SetReadBuffer(datagramSize); err != nil {
+			log.Warningf("failed to set read buffer of %s: %v", r.address, err)
+		}
+	}
+	log.Infof("listen %s on: %s, format %s", r.listener.transport, r.address, r.listener.format)
+	return nil
+}
+
+func (r *receiver) serve() {
+	r.wg.Add(1)
+	if r.stream != nil {
+		go r.accept()
+		return
+	}
+	go r.read()
+}
+
+func (r *receiver) accept() {
+	defer r.wg.Done()
+	for {
+		conn, err := r.stream.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Warningf("failed to accept connection on %s: %v", r.address, err)
+			time.Sleep(retryDelay)
+			continue
+		}
+		client := conn.RemoteAddr().String()
+		// connection of denied source is closed before TLS handshake
+		if !r.server.allowed(client) || !r.track(conn) {
+			_ = conn.Close()
+			continue
+		}
+		r.wg.Add(1)
+		go r.scan(conn, client)
+	}
+}
+
+func (r *receiver) scan(conn net.Conn, client string) {
+	defer func() {
+		r.untrack(conn)
+		_ = conn.Close()
+		r.wg.Done()
+	}()
+	var peer string
+	if r.tlsConfig != nil {
+		tlsConn := tls.Server(conn, r.tlsConfig)
+		_ = tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			log.Warningf("TLS handshake with %s: %v", client, err)
+			return
+		}
+		_ = tlsConn.SetDeadline(time.Time{})
+		peer = tlsPeer(tlsConn)
+		conn = tlsConn
+	}
+	scanner := bufio.NewScanner(conn)
+	if split := r.format.GetSplitFunc(); split != nil {
+		scanner.Split(split)
+	}
+	for scanner.Scan() {
+		if !r.server.receive(r.parse(scanner.Bytes(), client, peer)) {
+			return
+		}
+	}
+}
+
+func (r *receiver) read() {
+	defer r.wg.Done()
+	buf := make([]byte, datagramSize)
+	split := r.format.GetSplitFunc()
+	for {
+		n, addr, err := r.packets.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Warningf("failed to read datagram on %s: %v", r.address, err)
+			time.Sleep(retryDelay)
+			continue
+		}
+		var client string
+		if addr != nil {
+			client = addr.String()
+		}
+		if !r.server.allowed(client) {
+			continue
+		}
+		// trailing control characters and NULs are ignored
+		for n > 0 && buf[n-1] < ' ' {
+			n--
+		}
+		if n == 0 {
+			continue
+		}
+		message := buf[:n]
+		if split != nil {
+			if _, message, err = split(message, true); err != nil || len(message) == 0 {
+				continue
+			}
+		}
+		if !r.server.receive(r.parse(message, client, "")) {
+			return
+		}
+	}
+}
+
+// parse message like go-syslog server does, parts of malformed message are handled as well,
+// hostname of RFC3164 message without hostname is host of client
+func (r *receiver) parse(message []byte, client, peer string) format.LogParts {
+	// buffer of message is reused by scanner and reader
+	parser := r.format.GetParser(append([]byte(nil), message...))
+	_ = parser.Parse()
+	parts := parser.Dump()
+	parts["client"] = client
+	parts["tls_peer"] = peer
+	if parts["hostname"] == "" && (r.listener.format == FormatRFC3164 || r.listener.format == FormatAuto) {
+		host, _, err := net.SplitHostPort(client)
+		if err != nil {
+			host = client
+		}
+		parts["hostname"] = host
+	}
+	return parts
+}
+
+// addr local address of listener, it is nil before listening
+func (r *receiver) addr() net.Addr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stream != nil {
+		return r.stream.Addr()
+	}
+	if r.packets != nil {
+		return r.packets.LocalAddr()
+	}
+	return nil
+}
+
+// track connection to close it on shutdown, connection is not tracked after receiver is closed
+func (r *receiver) track(conn net.Conn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	r.conns[conn] = struct{}{}
+	return true
+}
+
+func (r *receiver) untrack(conn net.Conn) {
+	r.mu.Lock()
+	delete(r.conns, conn)
+	r.mu.Unlock()
+}
+
+func (r *receiver) connections() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// close stops listener and closes connections, it waits until all of them are stopped
+func (r *receiver) close() error {
+	r.mu.Lock()
+	r.closed = true
+	var err error
+	if r.stream != nil {
+		err = r.stream.Close()
+	}
+	if r.packets != nil {
+		err = r.packets.Close()
+	}
+	for conn := range r.conns {
+		_ = conn.Close()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return err
+}
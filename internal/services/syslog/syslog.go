@@ -2,53 +2,96 @@ package syslog
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
 	"sync"
+	"time"
 
-	"gopkg.in/mcuadros/go-syslog.v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"gopkg.in/mcuadros/go-syslog.v2/format"
 
+	"github.com/zikwall/grower/pkg/auth"
 	"github.com/zikwall/grower/pkg/log"
-)
-
-const (
-	ListenerTCP = "tcp"
-	ListenerUPD = "upd"
-	ListenerUDS = "unix"
+	"github.com/zikwall/grower/pkg/metrics"
+	"github.com/zikwall/grower/pkg/tlsconfig"
 )
 
 type Handler func(format.LogParts)
 
+// server runs receiver per listener, since format of messages is set per listener,
+// all receivers send messages to the same channel
 type server struct {
-	cfg     *Cfg
-	handler Handler
-	server  *syslog.Server
-	channel syslog.LogPartsChannel
-	wg      *sync.WaitGroup
+	cfg        *Cfg
+	handler    Handler
+	receivers  []*receiver
+	channel    chan format.LogParts
+	done       chan struct{}
+	wg         *sync.WaitGroup
+	acl        *auth.ACL
+	rejections *rejections
+	rejected   prometheus.Counter
+	// removeMetrics unregisters gauges of server
+	removeMetrics []func()
 }
 
 func (s *server) setHandler(handler Handler) {
 	s.handler = handler
 }
 
+// address of listener, listener without address binds address of its transport
+func (s *server) address(l listener) string {
+	if l.address != "" {
+		return l.address
+	}
+	switch l.transport {
+	case ListenerTCP:
+		return s.cfg.TCP
+	case ListenerUDP:
+		return s.cfg.UDP
+	case ListenerUDS:
+		return s.cfg.Unix
+	}
+	return s.cfg.TLSAddress
+}
+
+// allowed checks source by CIDR lists, rejected source is counted and logged once per window
+func (s *server) allowed(client string) bool {
+	if s.acl.Allowed(client) {
+		return true
+	}
+	s.rejected.Inc()
+	if s.rejections.first(client) {
+		log.Warningf("reject messages from %s by CIDR lists", client)
+	}
+	return false
+}
+
+// connections number of open connections of stream listeners
+func (s *server) connections() float64 {
+	var count int
+	for _, r := range s.receivers {
+		count += r.connections()
+	}
+	return float64(count)
+}
+
+// receive puts parsed message to channel of handlers, it returns false after server is stopped
+func (s *server) receive(parts format.LogParts) bool {
+	select {
+	case s.channel <- parts:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
 func (s *server) runContext(ctx context.Context) error {
 	defer log.Info("syslog process successfully finished")
-	for _, listener := range s.cfg.Listeners {
-		switch listener {
-		case ListenerTCP:
-			if err := s.server.ListenTCP(s.cfg.TCP); err != nil {
-				return err
-			}
-			log.Infof("listen TCP on: %s", s.cfg.TCP)
-		case ListenerUPD:
-			if err := s.server.ListenUDP(s.cfg.UPD); err != nil {
-				return err
-			}
-			log.Infof("listen UDP on: %s", s.cfg.UPD)
-		case ListenerUDS:
-			if err := s.server.ListenUnixgram(s.cfg.Unix); err != nil {
-				return err
-			}
-			log.Infof("listen UNIX socket on: %s", s.cfg.Unix)
+	for _, r := range s.receivers {
+		if err := r.listen(); err != nil {
+			return err
 		}
 	}
 	for i := 1; i <= s.cfg.Parallelism; i++ {
@@ -69,21 +112,32 @@ func (s *server) runContext(ctx context.Context) error {
 			}
 		}(i)
 	}
-	if err := s.server.Boot(); err != nil {
-		return err
+	for _, r := range s.receivers {
+		r.serve()
 	}
 	log.Info("syslog server is ready to receive messages...")
-	s.server.Wait()
+	for _, r := range s.receivers {
+		r.wg.Wait()
+	}
 	return nil
 }
 
 // Drop method implements drop.Drop interface
 // Drop method cleans up all resources, closes channels and waits for completion of all goroutines
 func (s *server) Drop() error {
-	// first, stop syslog daemon to avoid cases when writing to a closed channel will be performed
-	err := s.server.Kill()
+	// first, stop receivers, so nothing is written to channel after it is closed
+	close(s.done)
+	var err error
+	for _, r := range s.receivers {
+		if closeErr := r.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
 	// finally, waiting for the completion of all goroutines
 	s.wg.Wait()
+	for _, remove := range s.removeMetrics {
+		remove()
+	}
 	// close channel
 	close(s.channel)
 	// return an error, if any
@@ -96,15 +150,85 @@ func (s *server) DropMsg() string {
 	return "syslog server was successfully destroyed"
 }
 
-func newServer(cfg *Cfg) *server {
+func newServer(cfg *Cfg) (*server, error) {
+	acl, err := auth.NewACL(cfg.Allow, cfg.Deny)
+	if err != nil {
+		return nil, err
+	}
 	s := &server{
-		cfg: cfg,
-		wg:  &sync.WaitGroup{},
-	}
-	s.channel = make(syslog.LogPartsChannel, cfg.BufSize+1)
-	handler := syslog.NewChannelHandler(s.channel)
-	s.server = syslog.NewServer()
-	s.server.SetFormat(syslog.RFC3164)
-	s.server.SetHandler(handler)
-	return s
+		cfg:        cfg,
+		wg:         &sync.WaitGroup{},
+		done:       make(chan struct{}),
+		acl:        acl,
+		rejections: &rejections{lastLogged: map[string]time.Time{}},
+		rejected:   metrics.IngestRejected.WithLabelValues(Source, "denied"),
+	}
+	var tlsConfig *tls.Config
+	// listeners of stream and datagram transports may share the same port
+	bound := map[string]string{}
+	for _, spec := range cfg.Listeners {
+		l, err := parseListener(spec)
+		if err != nil {
+			return nil, err
+		}
+		address := s.address(l)
+		network := "stream " + address
+		if l.transport == ListenerUDP || l.transport == ListenerUDS {
+			network = "datagram " + address
+		}
+		// random port of :0 address does not conflict
+		if previous, ok := bound[network]; ok && !strings.HasSuffix(address, ":0") {
+			return nil, fmt.Errorf("listeners %s and %s bind the same address %s, set address of listener: %s:%s@<address>",
+				previous, spec, address, l.transport, l.format)
+		}
+		bound[network] = spec
+		r := &receiver{server: s, listener: l, address: address, conns: map[net.Conn]struct{}{}}
+		if l.transport == ListenerTLS {
+			if tlsConfig == nil {
+				if tlsConfig, err = tlsconfig.Server(&cfg.TLS); err != nil {
+					return nil, err
+				}
+			}
+			r.tlsConfig = tlsConfig
+		}
+		// format is already checked by listener parsing
+		r.format, _ = syslogFormat(l.format)
+		s.receivers = append(s.receivers, r)
+	}
+	s.channel = make(chan format.LogParts, cfg.BufSize+1)
+	s.removeMetrics = []func(){
+		metrics.ChannelBacklog.Func(func() float64 {
+			return float64(len(s.channel))
+		}, "syslog"),
+		metrics.SyslogClients.Func(s.connections),
+	}
+	return s, nil
+}
+
+// rejectionsWindow rejected source is logged once per window
+const rejectionsWindow = time.Minute
+
+// rejections source address is logged once per window, so flood of rejected messages does not flood log
+type rejections struct {
+	mu         sync.Mutex
+	lastLogged map[string]time.Time
+}
+
+func (r *rejections) first(client string) bool {
+	if ip := auth.ParseIP(client); ip != nil {
+		client = ip.String()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for address, lastLogged := range r.lastLogged {
+		if now.Sub(lastLogged) > rejectionsWindow {
+			delete(r.lastLogged, address)
+		}
+	}
+	if _, ok := r.lastLogged[client]; ok {
+		return false
+	}
+	r.lastLogged[client] = now
+	return true
 }
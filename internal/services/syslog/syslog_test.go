@@ -0,0 +1,288 @@
+package syslog
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+func TestParseListener(t *testing.T) {
+	t.Run("it should be parsed transport and format of listener", func(t *testing.T) {
+		cases := map[string]listener{
+			"tcp":                        {transport: ListenerTCP, format: FormatRFC3164},
+			"upd":                        {transport: ListenerUDP, format: FormatRFC3164},
+			"UDP:rfc5424":                {transport: ListenerUDP, format: FormatRFC5424},
+			"tcp:rfc6587":                {transport: ListenerTCP, format: FormatRFC6587},
+			"tls:auto":                   {transport: ListenerTLS, format: FormatAuto},
+			"unix:rfc5424":               {transport: ListenerUDS, format: FormatRFC5424},
+			"tcp:rfc5424@127.0.0.1:6514": {transport: ListenerTCP, format: FormatRFC5424, address: "127.0.0.1:6514"},
+			"udp@[::1]:514":              {transport: ListenerUDP, format: FormatRFC3164, address: "[::1]:514"},
+		}
+		for spec, expect := range cases {
+			l, err := parseListener(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l != expect {
+				t.Fatalf("expect %+v for %s, got %+v", expect, spec, l)
+			}
+		}
+	})
+
+	t.Run("it should be failed unknown transport, format and framing of datagrams", func(t *testing.T) {
+		for _, spec := range []string{"http", "tcp:rfc1", "udp:rfc6587"} {
+			if _, err := parseListener(spec); err == nil {
+				t.Fatalf("expect error for %s, got nil", spec)
+			}
+		}
+	})
+}
+
+func TestNewServer(t *testing.T) {
+	t.Run("it should be failed listeners of the same address", func(t *testing.T) {
+		_, err := newServer(&Cfg{Listeners: []string{"tcp", "tcp:rfc5424"}, TCP: ":514"})
+		if err == nil {
+			t.Fatal("expect error, got nil")
+		}
+	})
+
+	t.Run("it should be bound every listener to its own address", func(t *testing.T) {
+		s, err := newServer(&Cfg{
+			Listeners: []string{"tcp", "tcp:rfc5424@:6514", "udp"},
+			TCP:       ":514",
+			UDP:       ":514",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses := []string{s.receivers[0].address, s.receivers[1].address, s.receivers[2].address}
+		if !reflect.DeepEqual(addresses, []string{":514", ":6514", ":514"}) {
+			t.Fatalf("unexpected addresses %v", addresses)
+		}
+	})
+}
+
+func TestStructuredData(t *testing.T) {
+	t.Run("it should be parsed structured data to fields", func(t *testing.T) {
+		fields, err := structuredData(
+			`[exampleSDID@32473 iut="3" eventSource="Application"][origin ip="10.0.0.1" note="a \"quoted\" \] value"]`,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := nginx.Fields{
+			"sd.exampleSDID_32473.iut":         "3",
+			"sd.exampleSDID_32473.eventSource": "Application",
+			"sd.origin.ip":                     "10.0.0.1",
+			"sd.origin.note":                   `a "quoted" ] value`,
+		}
+		if !reflect.DeepEqual(fields, expect) {
+			t.Fatalf("expect %v, got %v", expect, fields)
+		}
+		if fields, err = structuredData("-"); err != nil || fields != nil {
+			t.Fatalf("expect no fields, got %v, %v", fields, err)
+		}
+	})
+
+	t.Run("it should be failed malformed structured data", func(t *testing.T) {
+		for _, data := range []string{`[id`, `[id a=1]`, `[id a="1"`, `id a="1"]`} {
+			if _, err := structuredData(data); err == nil {
+				t.Fatalf("expect error for %s, got nil", data)
+			}
+		}
+	})
+
+	t.Run("it should be taken content of RFC3164 and message of RFC5424", func(t *testing.T) {
+		if content := messageContent(format.LogParts{"content": "GET /"}); content != "GET /" {
+			t.Fatalf("expect content, got %s", content)
+		}
+		if content := messageContent(format.LogParts{"message": "POST /"}); content != "POST /" {
+			t.Fatalf("expect message, got %s", content)
+		}
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	t.Run("it should be exposed header of RFC3164 message as virtual fields", func(t *testing.T) {
+		fields := metadata(format.LogParts{
+			"timestamp": time.Date(2022, 7, 21, 18, 41, 45, 0, time.FixedZone("MSK", 3*60*60)),
+			"hostname":  "nginx-1",
+			"tag":       "nginx",
+			"content":   "GET /",
+			"priority":  190,
+			"facility":  23,
+			"severity":  6,
+		})
+		expect := nginx.Fields{
+			FieldHostname:  "nginx-1",
+			FieldTag:       "nginx",
+			FieldPriority:  "190",
+			FieldFacility:  "23",
+			FieldSeverity:  "6",
+			FieldTimestamp: "2022-07-21T15:41:45",
+			FieldProcID:    "",
+			FieldMsgID:     "",
+		}
+		if !reflect.DeepEqual(fields, expect) {
+			t.Fatalf("expect %v, got %v", expect, fields)
+		}
+	})
+
+	t.Run("it should be tag of RFC5424 message its app name", func(t *testing.T) {
+		fields := metadata(format.LogParts{
+			"hostname": "nginx-2",
+			"app_name": "nginx",
+			"proc_id":  "1024",
+			"msg_id":   "access",
+		})
+		if fields[FieldTag] != "nginx" || fields[FieldProcID] != "1024" || fields[FieldMsgID] != "access" {
+			t.Fatalf("unexpected fields %v", fields)
+		}
+		if fields[FieldTimestamp] != "" {
+			t.Fatalf("expect empty timestamp, got %s", fields[FieldTimestamp])
+		}
+	})
+}
+
+// runServer runs syslog server on local addresses, messages are sent to returned channel
+func runServer(t *testing.T, cfg *Cfg) (*server, chan format.LogParts) {
+	t.Helper()
+	cfg.Runtime = config.Runtime{Parallelism: 1}
+	s, err := newServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan format.LogParts, 16)
+	s.setHandler(func(parts format.LogParts) {
+		received <- parts
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.runContext(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := s.Drop(); err != nil {
+			t.Error(err)
+		}
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	for _, r := range s.receivers {
+		r := r
+		waitFor(t, func() bool {
+			return r.addr() != nil
+		})
+	}
+	return s, received
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout of condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	t.Run("it should be received messages of allowed sources", func(t *testing.T) {
+		s, received := runServer(t, &Cfg{
+			Listeners: []string{"tcp", "udp:rfc5424", "tcp:rfc5424@127.0.0.1:0"},
+			TCP:       "127.0.0.1:0",
+			UDP:       "127.0.0.1:0",
+			Allow:     []string{"127.0.0.0/8"},
+		})
+		tcp, err := net.Dial("tcp", s.receivers[0].addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tcp.Close()
+		if _, err := tcp.Write([]byte("<190>Jul 21 15:41:45 nginx-1 nginx: GET /\n")); err != nil {
+			t.Fatal(err)
+		}
+		udp, err := net.Dial("udp", s.receivers[1].addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer udp.Close()
+		if _, err := udp.Write([]byte("<190>1 2022-07-21T15:41:45Z nginx-2 nginx 1024 access - POST /\n")); err != nil {
+			t.Fatal(err)
+		}
+		rfc5424, err := net.Dial("tcp", s.receivers[2].addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rfc5424.Close()
+		if _, err := rfc5424.Write([]byte("<190>1 2022-07-21T15:41:45Z nginx-3 nginx 1024 access - PUT /\n")); err != nil {
+			t.Fatal(err)
+		}
+		contents := map[string]bool{}
+		for i := 0; i < 3; i++ {
+			select {
+			case parts := <-received:
+				contents[messageContent(parts)] = true
+			case <-time.After(5 * time.Second):
+				t.Fatal("expect message is received")
+			}
+		}
+		if !contents["GET /"] || !contents["POST /"] || !contents["PUT /"] {
+			t.Fatalf("unexpected messages %v", contents)
+		}
+		if connections := s.connections(); connections != 2 {
+			t.Fatalf("expect 2 open connections, got %v", connections)
+		}
+		_ = tcp.Close()
+		waitFor(t, func() bool {
+			return s.connections() == 1
+		})
+	})
+
+	t.Run("it should be closed connection and dropped datagram of denied source before parsing", func(t *testing.T) {
+		s, received := runServer(t, &Cfg{
+			Listeners: []string{"tcp", "udp"},
+			TCP:       "127.0.0.1:0",
+			UDP:       "127.0.0.1:0",
+			Deny:      []string{"127.0.0.1"},
+		})
+		tcp, err := net.Dial("tcp", s.receivers[0].addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tcp.Close()
+		_ = tcp.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if s.connections() != 0 {
+			t.Fatal("expect connection of denied source is not counted")
+		}
+		if _, err := tcp.Read(make([]byte, 1)); err == nil {
+			t.Fatal("expect connection is closed by server")
+		} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Fatal("expect connection is closed by server, got timeout")
+		}
+		udp, err := net.Dial("udp", s.receivers[1].addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer udp.Close()
+		if _, err := udp.Write([]byte("<190>Jul 21 15:41:45 nginx-1 nginx: GET /\n")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case parts := <-received:
+			t.Fatalf("expect message is dropped, got %v", parts)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
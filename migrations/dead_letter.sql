@@ -0,0 +1,11 @@
+CREATE TABLE dead_letter (
+    time DateTime,
+    source String,
+    error String,
+    line String,
+    insert_date Date DEFAULT toDate(time)
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMM(insert_date)
+ORDER BY (source, time)
+TTL insert_date + INTERVAL 30 DAY;
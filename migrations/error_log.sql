@@ -0,0 +1,19 @@
+CREATE TABLE error_log (
+    time DateTime,
+    level LowCardinality(String),
+    pid UInt32,
+    tid UInt64,
+    connection UInt64,
+    message String,
+    client String,
+    server String,
+    request String,
+    upstream String,
+    host String,
+    http_referer String,
+    insert_date Date DEFAULT toDate(time)
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMM(insert_date)
+ORDER BY (level, time)
+TTL insert_date + INTERVAL 90 DAY;
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ACL allows sources by CIDR lists, deny list has priority over allow list,
+// all sources are allowed if allow list is empty
+type ACL struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewACL parses CIDRs, single IP address is also accepted
+func NewACL(allow, deny []string) (*ACL, error) {
+	a := &ACL{}
+	var err error
+	if a.allow, err = parseNetworks(allow); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parseNetworks(deny); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Empty ACL allows all sources
+func (a *ACL) Empty() bool {
+	return len(a.allow) == 0 && len(a.deny) == 0
+}
+
+// Allowed checks address of source, which is IP or IP with port,
+// address without IP, e.g. of unix socket, is allowed
+func (a *ACL) Allowed(address string) bool {
+	ip := ParseIP(address)
+	if ip == nil {
+		return true
+	}
+	for _, network := range a.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, network := range a.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseIP parses IP of address with or without port
+func ParseIP(address string) net.IP {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return net.ParseIP(address)
+}
+
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", value)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -0,0 +1,229 @@
+// Package auth authenticates ingest clients: gRPC clients by bearer or HMAC tokens of per-client token file,
+// syslog sources by CIDR allow and deny lists
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header metadata key of credentials
+const Header = "authorization"
+
+// schemes of tokens, bearer token is sent as is, HMAC token signs client name, time and random nonce,
+// so token itself is not sent and signature can not be replayed
+const (
+	SchemeBearer = "bearer"
+	SchemeHMAC   = "hmac"
+)
+
+// MaxSkew max difference between time of HMAC signature and server time
+const MaxSkew = 5 * time.Minute
+
+var (
+	ErrMissing = errors.New("credentials are missing")
+	ErrInvalid = errors.New("credentials are invalid")
+	ErrExpired = errors.New("credentials are expired")
+	ErrReplay  = errors.New("credentials are replayed")
+)
+
+// Reason label of rejection in metrics
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrMissing):
+		return "missing"
+	case errors.Is(err, ErrExpired):
+		return "expired"
+	case errors.Is(err, ErrReplay):
+		return "replayed"
+	default:
+		return "invalid"
+	}
+}
+
+func checkScheme(scheme string) error {
+	if scheme != SchemeBearer && scheme != SchemeHMAC {
+		return fmt.Errorf("unknown auth scheme %q, expected %s or %s", scheme, SchemeBearer, SchemeHMAC)
+	}
+	return nil
+}
+
+// Verifier checks credentials of clients by tokens of token file
+type Verifier struct {
+	scheme string
+	tokens *tokenFile
+	nonces *nonceCache
+	now    func() time.Time
+}
+
+func NewVerifier(scheme, tokenFile string) (*Verifier, error) {
+	if err := checkScheme(scheme); err != nil {
+		return nil, err
+	}
+	tokens := newTokenFile(tokenFile, parseClients)
+	if _, err := tokens.load(); err != nil {
+		return nil, err
+	}
+	return &Verifier{scheme: scheme, tokens: tokens, nonces: newNonceCache(), now: time.Now}, nil
+}
+
+// Verify returns name of client, whose token matches credentials
+func (v *Verifier) Verify(credentials string) (string, error) {
+	if credentials == "" {
+		return "", ErrMissing
+	}
+	tokens, err := v.tokens.load()
+	if err != nil {
+		return "", err
+	}
+	prefix, value, ok := strings.Cut(credentials, " ")
+	if !ok || !strings.EqualFold(prefix, v.scheme) {
+		return "", ErrInvalid
+	}
+	if v.scheme == SchemeBearer {
+		for client, token := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(value)) == 1 {
+				return client, nil
+			}
+		}
+		return "", ErrInvalid
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 {
+		return "", ErrInvalid
+	}
+	client, timestamp, nonce, signature := parts[0], parts[1], parts[2], parts[3]
+	token, ok := tokens[client]
+	if !ok {
+		return "", ErrInvalid
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(client, timestamp, nonce, token))) {
+		return "", ErrInvalid
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	now := v.now()
+	if skew := now.Sub(time.Unix(unix, 0)); skew > MaxSkew || skew < -MaxSkew {
+		return "", fmt.Errorf("%w: time of signature differs by %s", ErrExpired, skew)
+	}
+	// signature is valid within max skew, so its nonce is remembered for this time
+	if !v.nonces.add(client+":"+nonce, now) {
+		return "", ErrReplay
+	}
+	return client, nil
+}
+
+func sign(client, timestamp, nonce, token string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(client + "\n" + timestamp + "\n" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// nonceCache remembers nonces of accepted signatures, nonce is forgotten after twice max skew,
+// when signature with it is expired in any case
+type nonceCache struct {
+	mu     sync.Mutex
+	seen   map[string]struct{}
+	queue  []string
+	expiry []time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: map[string]struct{}{}}
+}
+
+// add returns false if nonce is already seen
+func (c *nonceCache) add(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.queue) > 0 && c.expiry[0].Before(now) {
+		delete(c.seen, c.queue[0])
+		c.queue, c.expiry = c.queue[1:], c.expiry[1:]
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = struct{}{}
+	c.queue = append(c.queue, nonce)
+	c.expiry = append(c.expiry, now.Add(2*MaxSkew))
+	return true
+}
+
+// Credentials of client, token is read from token file and signed for every new stream.
+// It implements per RPC credentials of gRPC
+type Credentials struct {
+	scheme string
+	client string
+	token  *tokenFile
+	now    func() time.Time
+}
+
+func NewCredentials(scheme, client, tokenFile string) (*Credentials, error) {
+	if err := checkScheme(scheme); err != nil {
+		return nil, err
+	}
+	if scheme == SchemeHMAC && !validClient(client) {
+		return nil, fmt.Errorf("invalid client name %q", client)
+	}
+	token := newTokenFile(tokenFile, parseToken)
+	if _, err := token.load(); err != nil {
+		return nil, err
+	}
+	return &Credentials{scheme: scheme, client: client, token: token, now: time.Now}, nil
+}
+
+// Value of credentials header
+func (c *Credentials) Value() (string, error) {
+	tokens, err := c.token.load()
+	if err != nil {
+		return "", err
+	}
+	token := tokens[""]
+	if c.scheme == SchemeBearer {
+		return "Bearer " + token, nil
+	}
+	timestamp := strconv.FormatInt(c.now().Unix(), 10)
+	nonce, err := newNonce()
+	if err != nil {
+		return "", err
+	}
+	return "HMAC " + c.client + ":" + timestamp + ":" + nonce + ":" + sign(c.client, timestamp, nonce, token), nil
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (c *Credentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	value, err := c.Value()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{Header: value}, nil
+}
+
+// RequireTransportSecurity tokens are sent only over TLS connections, otherwise stream could be hijacked
+// and bearer token could be sniffed
+func (c *Credentials) RequireTransportSecurity() bool {
+	return true
+}
+
+func validClient(client string) bool {
+	return client != "" && !strings.ContainsAny(client, ": \t")
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	t.Run("it should be forgotten nonce after signature is expired", func(t *testing.T) {
+		cache := newNonceCache()
+		now := time.Now()
+		if !cache.add("a", now) || cache.add("a", now.Add(MaxSkew)) {
+			t.Fatal("expect nonce is accepted once")
+		}
+		if !cache.add("a", now.Add(2*MaxSkew+time.Second)) {
+			t.Fatal("expect nonce is forgotten")
+		}
+		if len(cache.seen) != 1 || len(cache.queue) != 1 {
+			t.Fatalf("expect one remembered nonce, got %d", len(cache.seen))
+		}
+	})
+}
+
+func TestVerifier(t *testing.T) {
+	dir := t.TempDir()
+	serverFile := filepath.Join(dir, "tokens")
+	clientFile := filepath.Join(dir, "token")
+	writeFile(t, serverFile, "# client token\nnginx-1 secret-1\n\nnginx-2 secret-2\n", time.Now())
+	writeFile(t, clientFile, "secret-2\n", time.Now())
+
+	t.Run("it should be accepted bearer token of known client", func(t *testing.T) {
+		verifier, err := NewVerifier(SchemeBearer, serverFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		credentials, err := NewCredentials(SchemeBearer, "nginx-2", clientFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := credentials.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := verifier.Verify(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client != "nginx-2" {
+			t.Fatalf("expect nginx-2, got %s", client)
+		}
+		if _, err := verifier.Verify("Bearer unknown"); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("expect invalid, got %v", err)
+		}
+		if _, err := verifier.Verify(""); !errors.Is(err, ErrMissing) {
+			t.Fatalf("expect missing, got %v", err)
+		}
+	})
+
+	t.Run("it should be accepted HMAC signature of client within max skew", func(t *testing.T) {
+		verifier, err := NewVerifier(SchemeHMAC, serverFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		credentials, err := NewCredentials(SchemeHMAC, "nginx-2", clientFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := credentials.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(value, "secret-2") {
+			t.Fatalf("token is sent in %s", value)
+		}
+		client, err := verifier.Verify(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client != "nginx-2" {
+			t.Fatalf("expect nginx-2, got %s", client)
+		}
+		// signature of other client is not valid
+		if _, err := verifier.Verify(strings.Replace(value, "nginx-2", "nginx-1", 1)); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("expect invalid, got %v", err)
+		}
+		// the same signature is not accepted twice
+		_, err = verifier.Verify(value)
+		if !errors.Is(err, ErrReplay) {
+			t.Fatalf("expect replay, got %v", err)
+		}
+		if Reason(err) != "replayed" {
+			t.Fatalf("expect replayed reason, got %s", Reason(err))
+		}
+		if value, err = credentials.Value(); err != nil {
+			t.Fatal(err)
+		}
+		if !credentials.RequireTransportSecurity() {
+			t.Fatal("expect credentials require TLS")
+		}
+		verifier.now = func() time.Time {
+			return time.Now().Add(MaxSkew + time.Minute)
+		}
+		_, err = verifier.Verify(value)
+		if !errors.Is(err, ErrExpired) {
+			t.Fatalf("expect expired, got %v", err)
+		}
+		if Reason(err) != "expired" {
+			t.Fatalf("expect expired reason, got %s", Reason(err))
+		}
+	})
+
+	t.Run("it should be reloaded token file after change", func(t *testing.T) {
+		file := filepath.Join(dir, "reload")
+		writeFile(t, file, "nginx-1 old\n", time.Now())
+		verifier, err := NewVerifier(SchemeBearer, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := verifier.Verify("Bearer old"); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, file, "nginx-1 new\n", time.Now().Add(time.Minute))
+		if _, err := verifier.Verify("Bearer old"); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("expect invalid, got %v", err)
+		}
+		if _, err := verifier.Verify("Bearer new"); err != nil {
+			t.Fatal(err)
+		}
+		// invalid file keeps previous tokens
+		writeFile(t, file, "nginx-1\n", time.Now().Add(2*time.Minute))
+		if _, err := verifier.Verify("Bearer new"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("it should be failed with invalid options", func(t *testing.T) {
+		if _, err := NewVerifier("basic", serverFile); err == nil {
+			t.Fatal("expect error, got nil")
+		}
+		if _, err := NewVerifier(SchemeBearer, filepath.Join(dir, "not_exists")); err == nil {
+			t.Fatal("expect error, got nil")
+		}
+		if _, err := NewVerifier(SchemeBearer, clientFile); err == nil {
+			t.Fatal("expect error of token file without client names, got nil")
+		}
+		if _, err := NewCredentials(SchemeHMAC, "nginx 2", clientFile); err == nil {
+			t.Fatal("expect error, got nil")
+		}
+	})
+}
+
+func TestACL(t *testing.T) {
+	acl, err := NewACL([]string{"10.0.0.0/8", "192.168.1.10", "fd00::/8"}, []string{"10.0.5.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"10.1.2.3:514":    true,
+		"10.0.5.7:514":    false,
+		"192.168.1.10":    true,
+		"192.168.1.11":    false,
+		"[fd00::1]:514":   true,
+		"[2001:db8::1]:1": false,
+		"":                true,
+	}
+	for address, expect := range cases {
+		if acl.Allowed(address) != expect {
+			t.Fatalf("expect %v for %q", expect, address)
+		}
+	}
+	deny, err := NewACL(nil, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deny.Allowed("127.0.0.1:5000") || !deny.Allowed("127.0.0.2:5000") {
+		t.Fatal("expect only denied address is rejected")
+	}
+	if _, err := NewACL([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Fatal("expect error, got nil")
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zikwall/grower/pkg/log"
+)
+
+// tokenFile tokens read from file, file is read again when its modification time or size is changed,
+// previous tokens are kept while new file is invalid
+type tokenFile struct {
+	path    string
+	parse   func(content []byte) (map[string]string, error)
+	mu      sync.Mutex
+	loaded  bool
+	modTime time.Time
+	size    int64
+	tokens  map[string]string
+}
+
+func newTokenFile(path string, parse func([]byte) (map[string]string, error)) *tokenFile {
+	return &tokenFile{path: path, parse: parse}
+}
+
+func (f *tokenFile) load() (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, err := os.Stat(f.path)
+	if err == nil && f.loaded && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.tokens, nil
+	}
+	if err == nil {
+		var content []byte
+		if content, err = os.ReadFile(f.path); err == nil {
+			var tokens map[string]string
+			if tokens, err = f.parse(content); err == nil {
+				if f.loaded {
+					log.Infof("token file %s is reloaded", f.path)
+				}
+				f.loaded, f.modTime, f.size, f.tokens = true, info.ModTime(), info.Size(), tokens
+				return tokens, nil
+			}
+		}
+	}
+	if !f.loaded {
+		return nil, fmt.Errorf("token file %s: %w", f.path, err)
+	}
+	log.Warningf("reload token file %s, previous tokens are used: %v", f.path, err)
+	return f.tokens, nil
+}
+
+// parseClients parses lines of client name and its token separated by whitespace, empty lines and # comments are skipped
+func parseClients(content []byte) (map[string]string, error) {
+	tokens := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !validClient(fields[0]) {
+			return nil, fmt.Errorf("line %d: expected client name and token", number)
+		}
+		if _, ok := tokens[fields[0]]; ok {
+			return nil, fmt.Errorf("line %d: duplicate client %s", number, fields[0])
+		}
+		tokens[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens")
+	}
+	return tokens, nil
+}
+
+// parseToken parses single token of client
+func parseToken(content []byte) (map[string]string, error) {
+	token := strings.TrimSpace(string(content))
+	if token == "" || strings.ContainsAny(token, " \t\n") {
+		return nil, fmt.Errorf("expected single token")
+	}
+	return map[string]string{"": token}, nil
+}
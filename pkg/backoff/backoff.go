@@ -0,0 +1,67 @@
+// Package backoff calculates exponentially growing delays with jitter between reconnection attempts
+package backoff
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	factor = 2
+	// jitter part of delay which is randomized, so clients do not reconnect at the same time
+	jitter = 0.2
+)
+
+// Backoff delay starts from min, doubles after every attempt up to max and is reset after success
+type Backoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+	random  *rand.Rand
+}
+
+func New(min, max time.Duration) *Backoff {
+	if max < min {
+		max = min
+	}
+	return &Backoff{
+		min:    min,
+		max:    max,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Next delay of attempt
+func (b *Backoff) Next() time.Duration {
+	switch {
+	case b.current == 0:
+		b.current = b.min
+	case b.current*factor > b.max:
+		b.current = b.max
+	default:
+		b.current *= factor
+	}
+	spread := time.Duration(float64(b.current) * jitter)
+	if spread <= 0 {
+		return b.current
+	}
+	return b.current - spread + time.Duration(b.random.Int63n(int64(2*spread)))
+}
+
+// Reset delay to min after successful attempt
+func (b *Backoff) Reset() {
+	b.current = 0
+}
+
+// Wait next delay, it returns false if context is done earlier
+func (b *Backoff) Wait(ctx context.Context) bool {
+	timer := time.NewTimer(b.Next())
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,43 @@
+package backoff
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	t.Run("it should be grown delays up to max with jitter", func(t *testing.T) {
+		b := New(100*time.Millisecond, time.Second)
+		for _, expect := range []time.Duration{
+			100 * time.Millisecond,
+			200 * time.Millisecond,
+			400 * time.Millisecond,
+			800 * time.Millisecond,
+			time.Second,
+			time.Second,
+		} {
+			delay := b.Next()
+			spread := time.Duration(float64(expect) * jitter)
+			if delay < expect-spread || delay > expect+spread {
+				t.Fatalf("expect %s with jitter, got %s", expect, delay)
+			}
+		}
+		b.Reset()
+		if delay := b.Next(); delay > 120*time.Millisecond {
+			t.Fatalf("expect min delay after reset, got %s", delay)
+		}
+	})
+
+	t.Run("it should be interrupted wait by context", func(t *testing.T) {
+		b := New(time.Hour, time.Hour)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if b.Wait(ctx) {
+			t.Fatal("expect interrupted wait")
+		}
+		if !New(time.Millisecond, time.Millisecond).Wait(context.Background()) {
+			t.Fatal("expect completed wait")
+		}
+	})
+}
@@ -0,0 +1,213 @@
+// Package checkpoint tracks which lines of log files are delivered to ClickHouse,
+// so read position can be persisted only after successful flush and reading is resumed without losses
+package checkpoint
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+
+	"github.com/zikwall/grower/pkg/log"
+)
+
+// Mark identifies line of file, it is attached to vector and acknowledged after the vector is inserted
+type Mark struct {
+	file  *File
+	end   int64
+	ack   func()
+	acked uint32
+}
+
+// Ack line is delivered or rejected, e.g. it is sent to dead letters,
+// mark is acknowledged only once, nil mark is ignored
+func (m *Mark) Ack() {
+	if m == nil || !atomic.CompareAndSwapUint32(&m.acked, 0, 1) {
+		return
+	}
+	if m.ack != nil {
+		m.ack()
+		return
+	}
+	m.file.ack(m.end)
+}
+
+// NewMark mark of message which is not a line of file, e.g. message of broker, it is acknowledged by callback
+func NewMark(ack func()) *Mark {
+	return &Mark{ack: ack}
+}
+
+// File tracks read lines of one file, committed position moves only over acknowledged lines,
+// lines may be acknowledged in any order, because they are handled by parallel workers
+type File struct {
+	mu        sync.Mutex
+	read      int64
+	committed int64
+	pending   []int64
+	acked     map[int64]struct{}
+	closed    bool
+	onCommit  func()
+	onDone    func()
+}
+
+// Next returns mark of the next line with length in bytes, line is tracked only after Track call
+func (f *File) Next(length int64) *Mark {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &Mark{file: f, end: f.read + length}
+}
+
+// Track line is accepted for delivery
+func (f *File) Track(mark *Mark) {
+	f.mu.Lock()
+	f.read = mark.end
+	f.pending = append(f.pending, mark.end)
+	callback := f.advance()
+	f.mu.Unlock()
+	if callback != nil {
+		callback()
+	}
+}
+
+func (f *File) ack(end int64) {
+	f.mu.Lock()
+	f.acked[end] = struct{}{}
+	callback := f.advance()
+	f.mu.Unlock()
+	if callback != nil {
+		callback()
+	}
+}
+
+// advance moves committed position over acknowledged lines, returns callback, which should be called without lock
+func (f *File) advance() func() {
+	moved := false
+	for len(f.pending) > 0 {
+		if _, ok := f.acked[f.pending[0]]; !ok {
+			break
+		}
+		delete(f.acked, f.pending[0])
+		f.committed = f.pending[0]
+		f.pending = f.pending[1:]
+		moved = true
+	}
+	if f.closed && len(f.pending) == 0 && f.onDone != nil {
+		onDone := f.onDone
+		f.onDone = nil
+		return onDone
+	}
+	if moved {
+		return f.onCommit
+	}
+	return nil
+}
+
+// Committed position after the last line, which is delivered together with all previous lines
+func (f *File) Committed() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.committed
+}
+
+// Done file is closed and all tracked lines are delivered
+func (f *File) Done() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed && len(f.pending) == 0
+}
+
+// Close no more lines will be tracked, onDone is called once all tracked lines are delivered
+func (f *File) Close(onDone func()) {
+	f.mu.Lock()
+	f.closed = true
+	f.onDone = onDone
+	callback := f.advance()
+	f.mu.Unlock()
+	if callback != nil {
+		callback()
+	}
+}
+
+// NewFile starts tracking from position, onCommit is called every time committed position moves, it may be nil
+func NewFile(position int64, onCommit func()) *File {
+	return &File{
+		read:      position,
+		committed: position,
+		acked:     map[int64]struct{}{},
+		onCommit:  onCommit,
+	}
+}
+
+// Attach adds mark to the end of vector, mark is removed by Clickhouse wrapper before insert
+func Attach(vector cx.Vector, mark *Mark) cx.Vector {
+	return append(vector, mark)
+}
+
+// Default attempts of insert of batch with marks and delay before the first retry, delay doubles after every attempt
+const (
+	DefaultAttempts = 3
+	DefaultDelay    = time.Second
+)
+
+// Clickhouse removes marks from vectors and acknowledges them only after successful insert,
+// failed batch is retried, when all attempts fail marks are not acknowledged, so committed position does not move
+// over lines of batch and messages of brokers are redelivered, retry of clickhouse buffer inserts batch with marks again,
+// disk spool keeps failed batches and acknowledges them once they are written to disk
+type Clickhouse struct {
+	cx.Clickhouse
+	attempts int
+	delay    time.Duration
+}
+
+func (c *Clickhouse) Insert(ctx context.Context, view cx.View, rows []cx.Vector) (uint64, error) {
+	marks := make([]*Mark, 0, len(rows))
+	stripped := make([]cx.Vector, len(rows))
+	for i, row := range rows {
+		stripped[i] = row
+		if len(row) == 0 {
+			continue
+		}
+		if mark, ok := row[len(row)-1].(*Mark); ok {
+			stripped[i] = row[:len(row)-1]
+			marks = append(marks, mark)
+		}
+	}
+	if len(marks) == 0 {
+		return c.Clickhouse.Insert(ctx, view, stripped)
+	}
+	affected, err := c.insert(ctx, view, stripped)
+	if err != nil {
+		log.Warningf("batch of %d rows of %s is not written, its lines are not acknowledged: %v", len(rows), view.Name, err)
+		return affected, err
+	}
+	for _, mark := range marks {
+		mark.Ack()
+	}
+	return affected, err
+}
+
+func (c *Clickhouse) insert(ctx context.Context, view cx.View, rows []cx.Vector) (uint64, error) {
+	delay := c.delay
+	for attempt := 1; ; attempt++ {
+		affected, err := c.Clickhouse.Insert(ctx, view, rows)
+		if err == nil || attempt >= c.attempts {
+			return affected, err
+		}
+		select {
+		case <-ctx.Done():
+			return affected, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func NewClickhouse(conn cx.Clickhouse) *Clickhouse {
+	return &Clickhouse{
+		Clickhouse: conn,
+		attempts:   DefaultAttempts,
+		delay:      DefaultDelay,
+	}
+}
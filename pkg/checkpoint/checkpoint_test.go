@@ -0,0 +1,156 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+)
+
+type fakeClickhouse struct {
+	rows []cx.Vector
+	err  error
+	// failures number of the first inserts, which fail with err
+	failures int
+	inserts  int
+}
+
+func (f *fakeClickhouse) Insert(_ context.Context, _ cx.View, rows []cx.Vector) (uint64, error) {
+	f.inserts++
+	if f.inserts <= f.failures {
+		return 0, f.err
+	}
+	f.rows = append(f.rows, rows...)
+	return uint64(len(rows)), nil
+}
+
+func (f *fakeClickhouse) Close() error {
+	return nil
+}
+
+func TestFile(t *testing.T) {
+	t.Run("it should be committed only continuous acknowledged lines", func(t *testing.T) {
+		commits := 0
+		file := NewFile(10, func() {
+			commits++
+		})
+		marks := make([]*Mark, 0, 3)
+		for i := 0; i < 3; i++ {
+			mark := file.Next(5)
+			file.Track(mark)
+			marks = append(marks, mark)
+		}
+		marks[1].Ack()
+		marks[2].Ack()
+		if file.Committed() != 10 {
+			t.Fatalf("expect 10, got %d", file.Committed())
+		}
+		marks[0].Ack()
+		if file.Committed() != 25 {
+			t.Fatalf("expect 25, got %d", file.Committed())
+		}
+		if commits != 1 {
+			t.Fatalf("expect one commit, got %d", commits)
+		}
+	})
+
+	t.Run("it should be done after close and delivery of all lines", func(t *testing.T) {
+		file := NewFile(0, nil)
+		mark := file.Next(5)
+		// line may be acknowledged before it is tracked
+		mark.Ack()
+		file.Track(mark)
+		last := file.Next(5)
+		file.Track(last)
+		done := false
+		file.Close(func() {
+			done = true
+		})
+		if done || file.Done() {
+			t.Fatal("expect file is not done")
+		}
+		last.Ack()
+		if !done || !file.Done() {
+			t.Fatal("expect file is done")
+		}
+	})
+}
+
+func TestClickhouse(t *testing.T) {
+	t.Run("it should be acknowledged after successful retry of insert", func(t *testing.T) {
+		file := NewFile(0, nil)
+		mark := file.Next(4)
+		file.Track(mark)
+		fake := &fakeClickhouse{err: errors.New("connection refused"), failures: 1}
+		conn := newTestClickhouse(fake)
+		rows := []cx.Vector{Attach(cx.Vector{"GET", 200}, mark)}
+		if _, err := conn.Insert(context.Background(), cx.NewView("logs", []string{"method", "status"}), rows); err != nil {
+			t.Fatal(err)
+		}
+		if file.Committed() != 4 {
+			t.Fatalf("expect 4, got %d", file.Committed())
+		}
+		if fake.inserts != 2 {
+			t.Fatalf("expect 2 inserts, got %d", fake.inserts)
+		}
+		if len(fake.rows) != 1 || len(fake.rows[0]) != 2 {
+			t.Fatalf("expect mark is removed, got %v", fake.rows)
+		}
+	})
+	t.Run("it should not be acknowledged when all attempts fail", func(t *testing.T) {
+		file := NewFile(0, nil)
+		mark := file.Next(4)
+		file.Track(mark)
+		done := false
+		file.Close(func() {
+			done = true
+		})
+		fake := &fakeClickhouse{err: errors.New("connection refused"), failures: DefaultAttempts}
+		conn := newTestClickhouse(fake)
+		rows := []cx.Vector{Attach(cx.Vector{"GET", 200}, mark)}
+		if _, err := conn.Insert(context.Background(), cx.NewView("logs", []string{"method", "status"}), rows); err == nil {
+			t.Fatal("expect error")
+		}
+		if fake.inserts != DefaultAttempts {
+			t.Fatalf("expect %d inserts, got %d", DefaultAttempts, fake.inserts)
+		}
+		if done || file.Committed() != 0 {
+			t.Fatalf("expect file is not done and not committed, got %d", file.Committed())
+		}
+		// retry of clickhouse buffer inserts batch with marks again
+		if _, err := conn.Insert(context.Background(), cx.NewView("logs", []string{"method", "status"}), rows); err != nil {
+			t.Fatal(err)
+		}
+		if !done || file.Committed() != 4 {
+			t.Fatalf("expect done file committed at 4, got %d", file.Committed())
+		}
+	})
+	t.Run("it should be acknowledged message mark by callback", func(t *testing.T) {
+		acked := 0
+		conn := NewClickhouse(&fakeClickhouse{})
+		rows := []cx.Vector{Attach(cx.Vector{"GET"}, NewMark(func() {
+			acked++
+		}))}
+		if _, err := conn.Insert(context.Background(), cx.NewView("logs", []string{"method"}), rows); err != nil {
+			t.Fatal(err)
+		}
+		if acked != 1 {
+			t.Fatalf("expect 1, got %d", acked)
+		}
+	})
+}
+
+func TestMark(t *testing.T) {
+	t.Run("it should be ignored acknowledgement of nil mark", func(t *testing.T) {
+		var mark *Mark
+		mark.Ack()
+	})
+}
+
+func newTestClickhouse(conn cx.Clickhouse) *Clickhouse {
+	c := NewClickhouse(conn)
+	c.delay = time.Millisecond
+	return c
+}
@@ -0,0 +1,36 @@
+package deadletter
+
+import (
+	"context"
+
+	clickhousebuffer "github.com/zikwall/clickhouse-buffer/v4"
+	"github.com/zikwall/clickhouse-buffer/v4/src/buffer/cxmem"
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+)
+
+// Columns of dead letter table, see migrations/dead_letter.sql
+var Columns = []string{"time", "source", "error", "line"}
+
+// ClickhouseSink writes dead letters to clickhouse errors table through the same buffer client as main rows
+type ClickhouseSink struct {
+	writer clickhousebuffer.Writer
+}
+
+func (c *ClickhouseSink) Write(letter *Letter) error {
+	c.writer.WriteVector(cx.Vector{letter.Time, letter.Source, letter.Error, letter.Line})
+	return nil
+}
+
+func (c *ClickhouseSink) Drop() error {
+	return nil
+}
+
+func (c *ClickhouseSink) DropMsg() string {
+	return "close dead letter clickhouse sink"
+}
+
+func NewClickhouseSink(ctx context.Context, client clickhousebuffer.Client, view cx.View) *ClickhouseSink {
+	return &ClickhouseSink{
+		writer: client.Writer(ctx, view, cxmem.NewBuffer(client.Options().BatchSize())),
+	}
+}
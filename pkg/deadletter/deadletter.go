@@ -0,0 +1,125 @@
+package deadletter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	clickhousebuffer "github.com/zikwall/clickhouse-buffer/v4"
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/handler"
+	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+// Supported dead letter sinks
+const (
+	SinkNone       = ""
+	SinkFile       = "file"
+	SinkClickhouse = "clickhouse"
+	SinkKafka      = "kafka"
+)
+
+// Letter is a raw line that could not be parsed or cast, saved for manual processing
+type Letter struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Error  string    `json:"error"`
+	Line   string    `json:"line"`
+}
+
+func NewLetter(line, source string, err error) *Letter {
+	return &Letter{
+		Time:   time.Now(),
+		Source: source,
+		Error:  err.Error(),
+		Line:   line,
+	}
+}
+
+// Sink stores dead letters, Sink also implements drop.Drop and drop.Debug interfaces
+// to flush and close underlying resources on shutdown
+type Sink interface {
+	Write(letter *Letter) error
+	Drop() error
+	DropMsg() string
+}
+
+// New creates dead letter sink by configuration,
+// clickhouse client is used only for clickhouse sink and can be nil for others
+func New(cfg *config.DeadLetter, client clickhousebuffer.Client) (Sink, error) {
+	switch cfg.Sink {
+	case SinkNone:
+		return &nop{}, nil
+	case SinkFile:
+		return NewFileSink(cfg.File, cfg.FileMaxSize, cfg.FileBackups)
+	case SinkClickhouse:
+		if cfg.Table == "" {
+			return nil, fmt.Errorf("dead letter table is not provided")
+		}
+		return NewClickhouseSink(context.Background(), client, cx.NewView(cfg.Table, Columns)), nil
+	case SinkKafka:
+		if len(cfg.KafkaBrokers) == 0 || cfg.KafkaTopic == "" {
+			return nil, fmt.Errorf("dead letter kafka brokers or topic is not provided")
+		}
+		return NewKafkaSink(cfg.KafkaBrokers, cfg.KafkaTopic), nil
+	}
+	return nil, fmt.Errorf("unsupported dead letter sink '%s'", cfg.Sink)
+}
+
+// Handler decorates row handler, all lines that failed parsing or casting are sent to the dead letter sink
+type Handler struct {
+	handler handler.Handler
+	sink    Sink
+	source  string
+}
+
+func (h *Handler) Handle(content string) (*handler.Row, error) {
+	return h.HandleFields(content, nil)
+}
+
+// HandleFields passes fields to decorated handler if it supports them, otherwise fields are ignored
+func (h *Handler) HandleFields(content string, fields nginx.Fields) (*handler.Row, error) {
+	var row *handler.Row
+	var err error
+	if fieldsHandler, ok := h.handler.(handler.FieldsHandler); ok {
+		row, err = fieldsHandler.HandleFields(content, fields)
+	} else {
+		row, err = h.handler.Handle(content)
+	}
+	if err != nil {
+		// personal data of line is hidden by privacy rules of handler
+		if redactor, ok := h.handler.(handler.Redactor); ok {
+			content = redactor.Redact(content)
+		}
+		if sinkErr := h.sink.Write(NewLetter(content, h.source, err)); sinkErr != nil {
+			log.Warningf("failed to write dead letter: %v", sinkErr)
+		}
+		return nil, err
+	}
+	return row, nil
+}
+
+func NewHandler(rowHandler handler.Handler, sink Sink, source string) *Handler {
+	return &Handler{
+		handler: rowHandler,
+		sink:    sink,
+		source:  source,
+	}
+}
+
+type nop struct{}
+
+func (n *nop) Write(_ *Letter) error {
+	return nil
+}
+
+func (n *nop) Drop() error {
+	return nil
+}
+
+func (n *nop) DropMsg() string {
+	return "close dead letter sink"
+}
@@ -0,0 +1,224 @@
+package deadletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/handler"
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+type failHandler struct{}
+
+func (f *failHandler) Handle(content string) (*handler.Row, error) {
+	if content == "broken" {
+		return nil, errors.New("access log line does not match given format")
+	}
+	return &handler.Row{Vector: cx.Vector{content}}, nil
+}
+
+// redactHandler fails all lines and hides digits of them
+type redactHandler struct{}
+
+func (r *redactHandler) Handle(_ string) (*handler.Row, error) {
+	return nil, errors.New("access log line does not match given format")
+}
+
+func (r *redactHandler) Redact(line string) string {
+	return strings.Map(func(c rune) rune {
+		if c >= '0' && c <= '9' {
+			return '*'
+		}
+		return c
+	}, line)
+}
+
+func readLetters(t *testing.T, filepath string) []Letter {
+	f, err := os.Open(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var letters []Letter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		letter := Letter{}
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatal(err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("it should be successfully write failed lines to dead letter file", func(t *testing.T) {
+		filepath := path.Join(t.TempDir(), "dead_letter.log")
+		sink, err := NewFileSink(filepath, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := NewHandler(&failHandler{}, sink, "access.log")
+		if _, err := h.Handle("valid"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.Handle("broken"); err == nil {
+			t.Fatal("expected error, receive nil")
+		}
+		if err := sink.Drop(); err != nil {
+			t.Fatal(err)
+		}
+		letters := readLetters(t, filepath)
+		if len(letters) != 1 {
+			t.Fatalf("failed, expect 1 letter, receive %d", len(letters))
+		}
+		if letters[0].Line != "broken" || letters[0].Source != "access.log" || letters[0].Error == "" {
+			t.Fatalf("failed, receive unexpected letter %+v", letters[0])
+		}
+		if letters[0].Time.IsZero() {
+			t.Fatal("failed, letter time is empty")
+		}
+	})
+}
+
+func TestRedactedHandler(t *testing.T) {
+	t.Run("it should be written redacted lines to dead letter file", func(t *testing.T) {
+		filepath := path.Join(t.TempDir(), "dead_letter.log")
+		sink, err := NewFileSink(filepath, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := NewHandler(&redactHandler{}, sink, "access.log")
+		if _, err := h.Handle("broken 192.168.1.1"); err == nil {
+			t.Fatal("expected error, receive nil")
+		}
+		if err := sink.Drop(); err != nil {
+			t.Fatal(err)
+		}
+		letters := readLetters(t, filepath)
+		if len(letters) != 1 || letters[0].Line != "broken ***.***.*.*" {
+			t.Fatalf("failed, receive unexpected letters %+v", letters)
+		}
+	})
+}
+
+func TestPseudonymizedHandler(t *testing.T) {
+	t.Run("it should be written pseudonyms instead of values of pseudonymized fields", func(t *testing.T) {
+		filepath := path.Join(t.TempDir(), "dead_letter.log")
+		sink, err := NewFileSink(filepath, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parser, err := nginx.NewParser(nginx.LogTypeJSON, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rowHandler, err := handler.New(&config.Config{
+			Scheme:  config.Scheme{LogsTable: "logs", Columns: map[string]string{"status": "status"}},
+			Privacy: config.Privacy{Pseudonymize: []string{nginx.RemoteUser}, Key: "secret"},
+		}, parser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := NewHandler(rowHandler, sink, "access.log")
+		// status can't be casted, so the parsed line is dead-lettered
+		for _, line := range []string{`{"remote_user":"alice","status":"unknown"}`, `{"remote_user":"alice"`} {
+			if _, err := h.Handle(line); err == nil || strings.Contains(err.Error(), "alice") {
+				t.Fatalf("expect error without pseudonymized value, got %v", err)
+			}
+		}
+		if err := sink.Drop(); err != nil {
+			t.Fatal(err)
+		}
+		letters := readLetters(t, filepath)
+		if len(letters) != 2 {
+			t.Fatalf("failed, expect 2 letters, receive %d", len(letters))
+		}
+		for _, letter := range letters {
+			if strings.Contains(letter.Line, "alice") || strings.Contains(letter.Error, "alice") {
+				t.Fatalf("failed, pseudonymized value is written to sink %+v", letter)
+			}
+		}
+		if !strings.Contains(letters[0].Line, `"status":"unknown"`) {
+			t.Fatalf("failed, expect parsed line is kept except pseudonymized values, receive %s", letters[0].Line)
+		}
+	})
+}
+
+func TestFileSink(t *testing.T) {
+	t.Run("it should be successfully rotate dead letter file", func(t *testing.T) {
+		filepath := path.Join(t.TempDir(), "dead_letter.log")
+		letter := NewLetter("broken", "access.log", errors.New("error"))
+		line, _ := json.Marshal(letter)
+		// two letters per file
+		sink, err := NewFileSink(filepath, int64(len(line)+1)*2, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 7; i++ {
+			if err := sink.Write(letter); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := sink.Drop(); err != nil {
+			t.Fatal(err)
+		}
+		for file, expect := range map[string]int{
+			filepath:        1,
+			filepath + ".1": 2,
+			filepath + ".2": 2,
+		} {
+			if receive := len(readLetters(t, file)); receive != expect {
+				t.Fatalf("failed for %s, expect %d letters, receive %d", file, expect, receive)
+			}
+		}
+		if _, err := os.Stat(filepath + ".3"); !os.IsNotExist(err) {
+			t.Fatal("failed, expect only two backup files")
+		}
+	})
+	t.Run("it should be keep writing dead letters when rotation fails", func(t *testing.T) {
+		dir := t.TempDir()
+		filepath := path.Join(dir, "dead_letter.log")
+		letter := NewLetter("broken", "access.log", errors.New("error"))
+		line, _ := json.Marshal(letter)
+		sink, err := NewFileSink(filepath, int64(len(line)+1), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// backup can not be renamed to not empty directory
+		if err := os.MkdirAll(path.Join(filepath+".1", "busy"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Write(letter); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Write(letter); err == nil {
+			t.Fatal("failed, expect rotation error")
+		}
+		if err := os.RemoveAll(filepath + ".1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Write(letter); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Drop(); err != nil {
+			t.Fatal(err)
+		}
+		if receive := len(readLetters(t, filepath+".1")); receive != 2 {
+			t.Fatalf("failed, expect 2 letters in backup, receive %d", receive)
+		}
+		if receive := len(readLetters(t, filepath)); receive != 1 {
+			t.Fatalf("failed, expect 1 letter, receive %d", receive)
+		}
+	})
+}
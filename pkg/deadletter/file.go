@@ -0,0 +1,115 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileSink writes dead letters as JSON lines to local file,
+// when file size exceeds maximum, file is rotated: access.log.dead -> access.log.dead.1 -> access.log.dead.2
+type FileSink struct {
+	mu          sync.Mutex
+	file        *os.File
+	filepath    string
+	maxSize     int64
+	maxBackups  uint
+	currentSize int64
+}
+
+func (f *FileSink) Write(letter *Letter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var rotateErr error
+	if f.maxSize > 0 && f.currentSize+int64(len(line)) > f.maxSize && f.currentSize > 0 {
+		// letter is written even if rotation fails, rotation is retried by the next letter
+		rotateErr = f.rotate()
+	}
+	n, err := f.file.Write(line)
+	f.currentSize += int64(n)
+	if err != nil {
+		return err
+	}
+	if rotateErr != nil {
+		return fmt.Errorf("failed rotate dead letter file: %w", rotateErr)
+	}
+	return nil
+}
+
+func (f *FileSink) Drop() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+func (f *FileSink) DropMsg() string {
+	return "close dead letter file sink"
+}
+
+// rotate keeps current file open until the next one is opened,
+// so a failed rotation does not break writes of following letters
+func (f *FileSink) rotate() error {
+	if f.maxBackups == 0 {
+		if err := f.file.Truncate(0); err != nil {
+			return fmt.Errorf("failed truncate dead letter file: %w", err)
+		}
+		f.currentSize = 0
+		return nil
+	}
+	// shift old backups, the oldest one is overwritten
+	for i := f.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(backupName(f.filepath, i), backupName(f.filepath, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.filepath, backupName(f.filepath, 1)); err != nil {
+		return err
+	}
+	current := f.file
+	if err := f.open(); err != nil {
+		// letters are still written to the renamed file until the next rotation
+		return err
+	}
+	return current.Close()
+}
+
+func (f *FileSink) open() error {
+	file, err := os.OpenFile(f.filepath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed open dead letter file: %w", err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.currentSize = stat.Size()
+	return nil
+}
+
+func backupName(filepath string, index uint) string {
+	return fmt.Sprintf("%s.%d", filepath, index)
+}
+
+// NewFileSink maxSize in bytes, zero value disables rotation
+func NewFileSink(filepath string, maxSize int64, maxBackups uint) (*FileSink, error) {
+	if filepath == "" {
+		return nil, fmt.Errorf("dead letter file is not provided")
+	}
+	f := &FileSink{
+		filepath:   filepath,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,76 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/zikwall/grower/pkg/log"
+)
+
+// KafkaSink writes dead letters as JSON messages to kafka topic, message key is the source of line
+type KafkaSink struct {
+	writer *kafka.Writer
+	mu     sync.Mutex
+	err    error
+}
+
+// Write does not wait for the broker, error of previous asynchronous write is returned by the next call
+func (k *KafkaSink) Write(letter *Letter) error {
+	value, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	if err := k.writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   []byte(letter.Source),
+		Value: value,
+	}); err != nil {
+		return err
+	}
+	return k.asyncErr()
+}
+
+func (k *KafkaSink) Drop() error {
+	if err := k.writer.Close(); err != nil {
+		return err
+	}
+	return k.asyncErr()
+}
+
+func (k *KafkaSink) DropMsg() string {
+	return "close dead letter kafka sink"
+}
+
+func (k *KafkaSink) asyncErr() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	err := k.err
+	k.err = nil
+	return err
+}
+
+func (k *KafkaSink) complete(messages []kafka.Message, err error) {
+	if err == nil {
+		return
+	}
+	log.Warningf("failed to write %d dead letters to kafka: %v", len(messages), err)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.err = fmt.Errorf("failed to write %d dead letters to kafka: %w", len(messages), err)
+}
+
+func NewKafkaSink(brokers []string, topic string) *KafkaSink {
+	k := &KafkaSink{}
+	k.writer = &kafka.Writer{
+		Addr:     kafka.TCP(brokers...),
+		Topic:    topic,
+		Balancer: &kafka.LeastBytes{},
+		// ingest workers must not wait for the broker, errors are reported by completion
+		Async:      true,
+		Completion: k.complete,
+	}
+	return k
+}
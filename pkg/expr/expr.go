@@ -0,0 +1,200 @@
+// Package expr implements expressions for the sources of table columns,
+// expression is either name of log field or call of function, functions can be nested, e.g.:
+//
+//	toUInt32(GeoIPAS(remote_addr))
+//	JSONStringField('user.name', request_body)
+//	RegExp('/([0-9]{3})-([0-9]{4})/', http_x_phone, 2)
+//
+// Expressions are compiled once, regular expressions and other constant arguments are prepared at compile time.
+package expr
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+// Expression evaluates value of column from log entry,
+// value is string, if expression is not wrapped by cast function
+type Expression interface {
+	Eval(entry *nginx.LogEntry) (interface{}, error)
+	String() string
+}
+
+// GeoIP looks up location and autonomous system of IP address
+type GeoIP interface {
+	Country(ip net.IP) (string, error)
+	Region(ip net.IP) (string, error)
+	City(ip net.IP) (string, error)
+	AS(ip net.IP) (number uint, organization string, err error)
+}
+
+// Env keeps external dependencies of functions
+type Env struct {
+	GeoIP GeoIP
+	// Caster options of scheme, time format and hyphens are applied by cast functions as well as by columns
+	Caster nginx.CasterCfg
+}
+
+// Field refers to the value of log field as is
+type Field struct {
+	Name string
+}
+
+func (f *Field) Eval(entry *nginx.LogEntry) (interface{}, error) {
+	return entry.Field(f.Name)
+}
+
+func (f *Field) String() string {
+	return f.Name
+}
+
+// Literal is constant string or number argument of function
+type Literal struct {
+	Value string
+}
+
+func (l *Literal) Eval(_ *nginx.LogEntry) (interface{}, error) {
+	return l.Value, nil
+}
+
+func (l *Literal) String() string {
+	return strconv.Quote(l.Value)
+}
+
+// Call is prepared call of function
+type Call struct {
+	Name string
+	Args []Expression
+	call func(entry *nginx.LogEntry) (interface{}, error)
+}
+
+func (c *Call) Eval(entry *nginx.LogEntry) (interface{}, error) {
+	value, err := c.call(entry)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.String(), err)
+	}
+	return value, nil
+}
+
+func (c *Call) String() string {
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		args = append(args, arg.String())
+	}
+	return c.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// Compile parses and prepares expression, env can be nil if GeoIP functions are not used
+func Compile(source string, env *Env) (Expression, error) {
+	if env == nil {
+		env = &Env{}
+	}
+	node, err := parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("column expression '%s': %w", source, err)
+	}
+	expression, err := compile(node, env)
+	if err != nil {
+		return nil, fmt.Errorf("column expression '%s': %w", source, err)
+	}
+	return expression, nil
+}
+
+// CompileScheme compiles sources of all columns in scheme
+func CompileScheme(scheme map[string]string, env *Env) (map[string]Expression, error) {
+	sources := make(map[string]Expression, len(scheme))
+	for column, source := range scheme {
+		expression, err := Compile(source, env)
+		if err != nil {
+			return nil, fmt.Errorf("column '%s': %w", column, err)
+		}
+		sources[column] = expression
+	}
+	return sources, nil
+}
+
+func compile(n *node, env *Env) (Expression, error) {
+	switch n.kind {
+	case nodeField:
+		return &Field{Name: n.value}, nil
+	case nodeLiteral:
+		return &Literal{Value: n.value}, nil
+	}
+	args := make([]Expression, 0, len(n.args))
+	for _, arg := range n.args {
+		expression, err := compile(arg, env)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, expression)
+	}
+	builder, ok := functions[n.value]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", n.value)
+	}
+	call, err := builder(args, env)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.value, err)
+	}
+	return &Call{Name: n.value, Args: args, call: call}, nil
+}
+
+// types of values of functions which are not strings, strings are casted by column name like values of fields
+var types = map[string]string{
+	"JSONUInt64Field":  nginx.UInt64,
+	"JSONInt64Field":   nginx.Int64,
+	"JSONFloat64Field": nginx.Float64,
+	"toUInt8":          nginx.UInt8,
+	"toUInt16":         nginx.UInt16,
+	"toUInt32":         nginx.UInt32,
+	"toUInt64":         nginx.UInt64,
+	"toInt8":           nginx.Int8,
+	"toInt16":          nginx.Int16,
+	"toInt32":          nginx.Int32,
+	"toInt64":          nginx.Int64,
+	"toFloat32":        nginx.Float32,
+	"toFloat64":        nginx.Float64,
+	"toDate":           nginx.Date,
+	"toDateTime":       nginx.DateTime,
+}
+
+// Type of value of expression, it is empty if value is string, which is casted by column name
+func Type(expression Expression) string {
+	if call, ok := expression.(*Call); ok {
+		return types[call.Name]
+	}
+	return ""
+}
+
+// Fields names of log fields which are referred by expression and its arguments
+func Fields(expression Expression) []string {
+	switch it := expression.(type) {
+	case *Field:
+		return []string{it.Name}
+	case *Call:
+		var fields []string
+		for _, arg := range it.Args {
+			fields = append(fields, Fields(arg)...)
+		}
+		return fields
+	}
+	return nil
+}
+
+// stringify converts result of nested cast to string argument of outer function
+func stringify(value interface{}) string {
+	switch it := value.(type) {
+	case string:
+		return it
+	case time.Time:
+		return it.Format("2006-01-02T15:04:05")
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,166 @@
+package expr
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+type fakeGeoIP struct{}
+
+func (f fakeGeoIP) Country(_ net.IP) (string, error) {
+	return "RU", nil
+}
+
+func (f fakeGeoIP) Region(_ net.IP) (string, error) {
+	return "Moscow Oblast", nil
+}
+
+func (f fakeGeoIP) City(ip net.IP) (string, error) {
+	if ip.Equal(net.ParseIP("5.45.207.1")) {
+		return "Moscow", nil
+	}
+	return "", nil
+}
+
+func (f fakeGeoIP) AS(_ net.IP) (number uint, organization string, err error) {
+	return 13238, "YANDEX LLC", nil
+}
+
+func TestCompile(t *testing.T) {
+	entry := nginx.NewEntry()
+	entry.SetField("remote_addr", "5.45.207.1")
+	entry.SetField("http_x_forwarded_for", "5.45.207.1, 10.0.0.1")
+	entry.SetField("request_body", `{"user":{"name":"grower","id":42},"tags":["a","b"]}`)
+	entry.SetField("http_x_phone", "call me: 495-123-4567")
+	entry.SetField("status", "200")
+	env := &Env{GeoIP: fakeGeoIP{}}
+
+	t.Run("it should be successfully evaluated", func(t *testing.T) {
+		for source, expected := range map[string]interface{}{
+			"remote_addr":                                      "5.45.207.1",
+			"$remote_addr":                                     "5.45.207.1",
+			"GeoIPCity(remote_addr)":                           "Moscow",
+			"GeoIPCity(http_x_forwarded_for)":                  "Moscow",
+			"GeoIPCountry(remote_addr)":                        "RU",
+			"GeoIPRegion(remote_addr)":                         "Moscow Oblast",
+			"GeoIPAS(remote_addr)":                             "13238",
+			"GeoIPASOrg(remote_addr)":                          "YANDEX LLC",
+			"toUInt32(GeoIPAS(remote_addr))":                   uint32(13238),
+			"JSONStringField('user.name', request_body)":       "grower",
+			"JSONStringField('tags', request_body)":            `["a","b"]`,
+			"JSONStringField('unknown', request_body)":         "",
+			"JSONUInt64Field('user.id', request_body)":         uint64(42),
+			"JSONUInt64Field('user.id', remote_addr)":          uint64(0),
+			"RegExp('/([0-9]{3})-([0-9]{4})/', http_x_phone)":  "123",
+			"RegExp('([0-9]{3})-([0-9]{4})', http_x_phone, 2)": "4567",
+			"RegExp('\\d+-\\d+-\\d+', http_x_phone)":           "495-123-4567",
+			"RegExp('[a-z]+@', http_x_phone)":                  "",
+			"toUInt16( status )":                               uint16(200),
+			"toString(toUInt8(status))":                        "200",
+		} {
+			expression, err := Compile(source, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, err := expression.Eval(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != expected {
+				t.Fatalf("%s: expect %v (%T), got %v (%T)", source, expected, expected, value, value)
+			}
+		}
+	})
+
+	t.Run("it should be cast by options of scheme", func(t *testing.T) {
+		entry.SetField("time_local", "08/Mar/2022:00:48:57 +0300")
+		expression, err := Compile("toDateTime(time_local)", &Env{Caster: nginx.CasterCfg{
+			LocalTimeFormat: "02/Jan/2006:15:04:05 -0700",
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := expression.Eval(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value.(time.Time).Unix() != 1646689737 {
+			t.Fatalf("failed, receive unexpected time %v", value)
+		}
+		expression, err = Compile("toDateTime(time_local)", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := expression.Eval(entry); err == nil {
+			t.Fatal("failed, expect error without local time format")
+		}
+	})
+
+	t.Run("it should be compilation error", func(t *testing.T) {
+		for _, source := range []string{
+			"",
+			"toUInt32(",
+			"toUInt32(status",
+			"toUInt32(status))",
+			"unknownFunc(status)",
+			"toUInt32(status, status)",
+			"RegExp(http_x_phone, http_x_phone)",
+			"RegExp('([0-9]+', http_x_phone)",
+			"RegExp('([0-9]+)', http_x_phone, 2)",
+			"JSONStringField('name)",
+		} {
+			if _, err := Compile(source, env); err == nil {
+				t.Fatalf("%s: expect error", source)
+			}
+		}
+		if _, err := Compile("GeoIPCity(remote_addr)", nil); err == nil {
+			t.Fatal("expect error when GeoIP database is not configured")
+		}
+	})
+
+	t.Run("it should be evaluation error", func(t *testing.T) {
+		for _, source := range []string{
+			"unknown_field",
+			"GeoIPCity(unknown_field)",
+			"toUInt8(remote_addr)",
+		} {
+			expression, err := Compile(source, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := expression.Eval(entry); err == nil {
+				t.Fatalf("%s: expect error", source)
+			}
+		}
+	})
+}
+
+func TestTypeAndFields(t *testing.T) {
+	t.Run("it should be typed casts and referred fields of expressions", func(t *testing.T) {
+		for source, expect := range map[string]struct {
+			valueType string
+			fields    []string
+		}{
+			"remote_addr":                        {"", []string{"remote_addr"}},
+			"toUInt32(GeoIPAS(remote_addr))":     {nginx.UInt32, []string{"remote_addr"}},
+			"JSONUInt64Field('user.id', body)":   {nginx.UInt64, []string{"body"}},
+			"RegExp('/([0-9]+)/', http_x_phone)": {"", []string{"http_x_phone"}},
+			"toString(toUInt8(status))":          {"", []string{"status"}},
+		} {
+			expression, err := Compile(source, &Env{GeoIP: fakeGeoIP{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valueType := Type(expression); valueType != expect.valueType {
+				t.Fatalf("%s: expect type %q, got %q", source, expect.valueType, valueType)
+			}
+			if fields := Fields(expression); !reflect.DeepEqual(fields, expect.fields) {
+				t.Fatalf("%s: expect fields %v, got %v", source, expect.fields, fields)
+			}
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package expr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+type evaluator func(entry *nginx.LogEntry) (interface{}, error)
+
+// builder checks arguments and prepares function once at compile time
+type builder func(args []Expression, env *Env) (evaluator, error)
+
+var functions = map[string]builder{
+	"GeoIPCountry": geoIPFunc(func(g GeoIP, ip net.IP) (string, error) {
+		return g.Country(ip)
+	}),
+	"GeoIPRegion": geoIPFunc(func(g GeoIP, ip net.IP) (string, error) {
+		return g.Region(ip)
+	}),
+	"GeoIPCity": geoIPFunc(func(g GeoIP, ip net.IP) (string, error) {
+		return g.City(ip)
+	}),
+	"GeoIPAS": geoIPFunc(func(g GeoIP, ip net.IP) (string, error) {
+		number, _, err := g.AS(ip)
+		if err != nil || number == 0 {
+			return "", err
+		}
+		return strconv.FormatUint(uint64(number), 10), nil
+	}),
+	"GeoIPASOrg": geoIPFunc(func(g GeoIP, ip net.IP) (string, error) {
+		_, organization, err := g.AS(ip)
+		return organization, err
+	}),
+	"JSONStringField":  jsonFieldFunc(""),
+	"JSONUInt64Field":  jsonFieldFunc(nginx.UInt64),
+	"JSONInt64Field":   jsonFieldFunc(nginx.Int64),
+	"JSONFloat64Field": jsonFieldFunc(nginx.Float64),
+	"RegExp":           regExpFunc,
+	"toUInt8":          castFunc(nginx.UInt8),
+	"toUInt16":         castFunc(nginx.UInt16),
+	"toUInt32":         castFunc(nginx.UInt32),
+	"toUInt64":         castFunc(nginx.UInt64),
+	"toInt8":           castFunc(nginx.Int8),
+	"toInt16":          castFunc(nginx.Int16),
+	"toInt32":          castFunc(nginx.Int32),
+	"toInt64":          castFunc(nginx.Int64),
+	"toFloat32":        castFunc(nginx.Float32),
+	"toFloat64":        castFunc(nginx.Float64),
+	"toString":         castFunc(nginx.String),
+	"toDate":           castFunc(nginx.Date),
+	"toDateTime":       castFunc(nginx.DateTime),
+}
+
+var errGeoIPNotConfigured = errors.New("GeoIP database is not configured")
+
+func argsCount(args []Expression, min, max int) error {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return fmt.Errorf("expected %d arguments, got %d", min, len(args))
+		}
+		return fmt.Errorf("expected from %d to %d arguments, got %d", min, max, len(args))
+	}
+	return nil
+}
+
+func literal(arg Expression) (string, error) {
+	if it, ok := arg.(*Literal); ok {
+		return it.Value, nil
+	}
+	return "", fmt.Errorf("argument %s must be constant", arg.String())
+}
+
+func evalString(arg Expression, entry *nginx.LogEntry) (string, error) {
+	value, err := arg.Eval(entry)
+	if err != nil {
+		return "", err
+	}
+	return stringify(value), nil
+}
+
+// geoIPFunc returns empty string for empty or invalid addresses as well as for not found addresses,
+// only the first address is used from lists like X-Forwarded-For
+func geoIPFunc(lookup func(g GeoIP, ip net.IP) (string, error)) builder {
+	return func(args []Expression, env *Env) (evaluator, error) {
+		if err := argsCount(args, 1, 1); err != nil {
+			return nil, err
+		}
+		if env.GeoIP == nil {
+			return nil, errGeoIPNotConfigured
+		}
+		return func(entry *nginx.LogEntry) (interface{}, error) {
+			value, err := evalString(args[0], entry)
+			if err != nil {
+				return nil, err
+			}
+			if i := strings.IndexByte(value, ','); i != -1 {
+				value = value[:i]
+			}
+			ip := net.ParseIP(strings.TrimSpace(value))
+			if ip == nil {
+				return "", nil
+			}
+			return lookup(env.GeoIP, ip)
+		}, nil
+	}
+}
+
+// jsonFieldFunc extracts value by key from JSON object, nested keys are separated by dot,
+// like ClickHouse JSON functions default value is returned if JSON is invalid or key does not exist
+func jsonFieldFunc(castType string) builder {
+	return func(args []Expression, env *Env) (evaluator, error) {
+		if err := argsCount(args, 2, 2); err != nil {
+			return nil, err
+		}
+		key, err := literal(args[0])
+		if err != nil {
+			return nil, err
+		}
+		path := strings.Split(key, ".")
+		if castType == "" {
+			return func(entry *nginx.LogEntry) (interface{}, error) {
+				return jsonField(path, args[1], entry)
+			}, nil
+		}
+		caster := newCaster(castType, &env.Caster)
+		return func(entry *nginx.LogEntry) (interface{}, error) {
+			value, err := jsonField(path, args[1], entry)
+			if err != nil {
+				return nil, err
+			}
+			return caster.TryCast(castKey, value)
+		}, nil
+	}
+}
+
+func jsonField(path []string, arg Expression, entry *nginx.LogEntry) (string, error) {
+	content, err := evalString(arg, entry)
+	if err != nil {
+		return "", err
+	}
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", nil
+	}
+	for _, key := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", nil
+		}
+		if value, ok = object[key]; !ok {
+			return "", nil
+		}
+	}
+	switch it := value.(type) {
+	case string:
+		return it, nil
+	case json.Number:
+		return it.String(), nil
+	case nil:
+		return "", nil
+	case bool:
+		return strconv.FormatBool(it), nil
+	}
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// regExpFunc returns capture group of the first match, by default the first group,
+// or the whole match if there are no groups, pattern may be wrapped into slashes: /pattern/
+func regExpFunc(args []Expression, _ *Env) (evaluator, error) {
+	if err := argsCount(args, 2, 3); err != nil {
+		return nil, err
+	}
+	pattern, err := literal(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(pattern) > 1 && pattern[0] == '/' && pattern[len(pattern)-1] == '/' {
+		pattern = pattern[1 : len(pattern)-1]
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	group := 0
+	if re.NumSubexp() > 0 {
+		group = 1
+	}
+	if len(args) == 3 {
+		index, err := literal(args[2])
+		if err != nil {
+			return nil, err
+		}
+		if group, err = strconv.Atoi(index); err != nil || group < 0 || group > re.NumSubexp() {
+			return nil, fmt.Errorf("invalid capture group %s, pattern has %d groups", index, re.NumSubexp())
+		}
+	}
+	return func(entry *nginx.LogEntry) (interface{}, error) {
+		value, err := evalString(args[1], entry)
+		if err != nil {
+			return nil, err
+		}
+		match := re.FindStringSubmatch(value)
+		if match == nil {
+			return "", nil
+		}
+		return match[group], nil
+	}, nil
+}
+
+// castKey is the only key of custom casts of type caster created for cast function
+const castKey = "value"
+
+func newCaster(castType string, cfg *nginx.CasterCfg) nginx.TypeCaster {
+	return nginx.NewTypeCaster(&nginx.CasterCfg{
+		CustomCasts:       map[string]string{castKey: castType},
+		CustomCastsEnable: true,
+		LocalTimeFormat:   cfg.LocalTimeFormat,
+		RemoveHyphen:      cfg.RemoveHyphen,
+	})
+}
+
+func castFunc(castType string) builder {
+	return func(args []Expression, env *Env) (evaluator, error) {
+		if err := argsCount(args, 1, 1); err != nil {
+			return nil, err
+		}
+		caster := newCaster(castType, &env.Caster)
+		return func(entry *nginx.LogEntry) (interface{}, error) {
+			value, err := evalString(args[0], entry)
+			if err != nil {
+				return nil, err
+			}
+			return caster.TryCast(castKey, value)
+		}, nil
+	}
+}
@@ -0,0 +1,162 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+)
+
+type nodeKind int
+
+const (
+	nodeField nodeKind = iota
+	nodeLiteral
+	nodeCall
+)
+
+type node struct {
+	kind  nodeKind
+	value string
+	args  []*node
+}
+
+var errUnexpectedEnd = errors.New("unexpected end of expression")
+
+type parser struct {
+	source string
+	pos    int
+}
+
+func parse(source string) (*node, error) {
+	p := &parser{source: source}
+	n, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.source) {
+		return nil, fmt.Errorf("unexpected '%c' at position %d", p.source[p.pos], p.pos)
+	}
+	return n, nil
+}
+
+func (p *parser) expression() (*node, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.source) {
+		return nil, errUnexpectedEnd
+	}
+	switch c := p.source[p.pos]; {
+	case c == '\'' || c == '"':
+		value, err := p.quoted(c)
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: nodeLiteral, value: value}, nil
+	case isDigit(c) || c == '-':
+		start := p.pos
+		for p.pos++; p.pos < len(p.source) && isDigit(p.source[p.pos]); p.pos++ {
+		}
+		// fractional part of number, e.g. 1.5
+		if p.pos+1 < len(p.source) && p.source[p.pos] == '.' && isDigit(p.source[p.pos+1]) {
+			for p.pos++; p.pos < len(p.source) && isDigit(p.source[p.pos]); p.pos++ {
+			}
+		}
+		return &node{kind: nodeLiteral, value: p.source[start:p.pos]}, nil
+	case isIdentStart(c):
+		name := p.ident()
+		p.skipSpaces()
+		if p.pos < len(p.source) && p.source[p.pos] == '(' {
+			p.pos++
+			args, err := p.arguments()
+			if err != nil {
+				return nil, err
+			}
+			return &node{kind: nodeCall, value: name, args: args}, nil
+		}
+		// nginx variables may be written as in log_format: $remote_addr
+		if name[0] == '$' {
+			name = name[1:]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty field name at position %d", p.pos)
+		}
+		return &node{kind: nodeField, value: name}, nil
+	default:
+		return nil, fmt.Errorf("unexpected '%c' at position %d", c, p.pos)
+	}
+}
+
+func (p *parser) arguments() ([]*node, error) {
+	var args []*node
+	p.skipSpaces()
+	if p.pos < len(p.source) && p.source[p.pos] == ')' {
+		p.pos++
+		return args, nil
+	}
+	for {
+		arg, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		p.skipSpaces()
+		if p.pos >= len(p.source) {
+			return nil, errUnexpectedEnd
+		}
+		switch p.source[p.pos] {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return args, nil
+		default:
+			return nil, fmt.Errorf("unexpected '%c' at position %d", p.source[p.pos], p.pos)
+		}
+	}
+}
+
+// quoted reads string literal, backslash escapes only quote character,
+// all other backslashes are kept as is, so regular expressions don't need double escaping
+func (p *parser) quoted(quote byte) (string, error) {
+	p.pos++
+	value := make([]byte, 0, 16)
+	for ; p.pos < len(p.source); p.pos++ {
+		c := p.source[p.pos]
+		if c == '\\' && p.pos+1 < len(p.source) && p.source[p.pos+1] == quote {
+			value = append(value, quote)
+			p.pos++
+			continue
+		}
+		if c == quote {
+			p.pos++
+			return string(value), nil
+		}
+		value = append(value, c)
+	}
+	return "", errUnexpectedEnd
+}
+
+func (p *parser) ident() string {
+	start := p.pos
+	for p.pos++; p.pos < len(p.source) && isIdentPart(p.source[p.pos]); p.pos++ {
+	}
+	return p.source[start:p.pos]
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.source) && (p.source[p.pos] == ' ' || p.source[p.pos] == '\t' || p.source[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// dot is part of field name, nested fields of JSON logs are joined by dot
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '.' || c == '-'
+}
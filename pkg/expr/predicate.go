@@ -0,0 +1,297 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+// Operators of comparisons, =~ and !~ match value by regular expression, which must be constant
+const (
+	OpEqual        = "=="
+	OpNotEqual     = "!="
+	OpGreater      = ">"
+	OpGreaterEqual = ">="
+	OpLess         = "<"
+	OpLessEqual    = "<="
+	OpMatch        = "=~"
+	OpNotMatch     = "!~"
+)
+
+// Predicate is condition of log entry: comparisons of expressions joined by and, or, not and parentheses, e.g.:
+//
+//	status >= 500 and request_time > 1.5
+//	http_user_agent =~ '/bot|crawler|spider/' or not (request_method == 'GET' or request_method == 'HEAD')
+//
+// Values are compared as numbers if both of them are numbers, otherwise they are compared as strings.
+type Predicate struct {
+	source    string
+	condition condition
+}
+
+func (p *Predicate) Match(entry *nginx.LogEntry) (bool, error) {
+	matched, err := p.condition.match(entry)
+	if err != nil {
+		return false, fmt.Errorf("predicate '%s': %w", p.source, err)
+	}
+	return matched, nil
+}
+
+func (p *Predicate) String() string {
+	return p.source
+}
+
+// Fields names of log fields which are referred by predicate
+func (p *Predicate) Fields() []string {
+	return p.condition.fields()
+}
+
+// CompilePredicate parses and prepares predicate, env can be nil if GeoIP functions are not used
+func CompilePredicate(source string, env *Env) (*Predicate, error) {
+	if env == nil {
+		env = &Env{}
+	}
+	p := &predicateParser{parser: parser{source: source}, env: env}
+	cond, err := p.or()
+	if err == nil {
+		p.skipSpaces()
+		if p.pos < len(p.source) {
+			err = fmt.Errorf("unexpected '%c' at position %d", p.source[p.pos], p.pos)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("predicate '%s': %w", source, err)
+	}
+	return &Predicate{source: source, condition: cond}, nil
+}
+
+type condition interface {
+	match(entry *nginx.LogEntry) (bool, error)
+	fields() []string
+}
+
+type and struct {
+	left, right condition
+}
+
+func (a *and) match(entry *nginx.LogEntry) (bool, error) {
+	matched, err := a.left.match(entry)
+	if err != nil || !matched {
+		return false, err
+	}
+	return a.right.match(entry)
+}
+
+func (a *and) fields() []string {
+	return append(a.left.fields(), a.right.fields()...)
+}
+
+type or struct {
+	left, right condition
+}
+
+func (o *or) match(entry *nginx.LogEntry) (bool, error) {
+	matched, err := o.left.match(entry)
+	if err != nil || matched {
+		return matched, err
+	}
+	return o.right.match(entry)
+}
+
+func (o *or) fields() []string {
+	return append(o.left.fields(), o.right.fields()...)
+}
+
+type not struct {
+	condition condition
+}
+
+func (n *not) match(entry *nginx.LogEntry) (bool, error) {
+	matched, err := n.condition.match(entry)
+	return !matched, err
+}
+
+func (n *not) fields() []string {
+	return n.condition.fields()
+}
+
+type comparison struct {
+	left  Expression
+	right Expression
+	op    string
+	re    *regexp.Regexp
+}
+
+func (c *comparison) match(entry *nginx.LogEntry) (bool, error) {
+	left, err := evalString(c.left, entry)
+	if err != nil {
+		return false, err
+	}
+	if c.re != nil {
+		return c.re.MatchString(left) == (c.op == OpMatch), nil
+	}
+	right, err := evalString(c.right, entry)
+	if err != nil {
+		return false, err
+	}
+	result := strings.Compare(left, right)
+	if l, err := strconv.ParseFloat(left, 64); err == nil {
+		if r, err := strconv.ParseFloat(right, 64); err == nil {
+			switch {
+			case l < r:
+				result = -1
+			case l > r:
+				result = 1
+			default:
+				result = 0
+			}
+		}
+	}
+	switch c.op {
+	case OpEqual:
+		return result == 0, nil
+	case OpNotEqual:
+		return result != 0, nil
+	case OpGreater:
+		return result > 0, nil
+	case OpGreaterEqual:
+		return result >= 0, nil
+	case OpLess:
+		return result < 0, nil
+	}
+	return result <= 0, nil
+}
+
+func (c *comparison) fields() []string {
+	return append(Fields(c.left), Fields(c.right)...)
+}
+
+// predicateParser parses predicate, `and` has priority over `or`
+type predicateParser struct {
+	parser
+	env *Env
+}
+
+func (p *predicateParser) or() (condition, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &or{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *predicateParser) and() (condition, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &and{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *predicateParser) unary() (condition, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.source) {
+		return nil, errUnexpectedEnd
+	}
+	if p.keyword("not") {
+		cond, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &not{condition: cond}, nil
+	}
+	if p.source[p.pos] != '(' {
+		return p.comparison()
+	}
+	p.pos++
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos >= len(p.source) || p.source[p.pos] != ')' {
+		return nil, fmt.Errorf("expected ')' at position %d", p.pos)
+	}
+	p.pos++
+	return cond, nil
+}
+
+func (p *predicateParser) comparison() (condition, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.source) && strings.IndexByte("=!<>~", p.source[p.pos]) >= 0 {
+		p.pos++
+	}
+	op := p.source[start:p.pos]
+	switch op {
+	case OpEqual, OpNotEqual, OpGreater, OpGreaterEqual, OpLess, OpLessEqual:
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return &comparison{left: left, right: right, op: op}, nil
+	case OpMatch, OpNotMatch:
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		pattern, err := literal(right)
+		if err != nil {
+			return nil, err
+		}
+		if len(pattern) > 1 && pattern[0] == '/' && pattern[len(pattern)-1] == '/' {
+			pattern = pattern[1 : len(pattern)-1]
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return &comparison{left: left, right: right, op: op, re: re}, nil
+	case "":
+		return nil, fmt.Errorf("expected comparison operator at position %d", start)
+	}
+	return nil, fmt.Errorf("unknown operator %s at position %d", op, start)
+}
+
+func (p *predicateParser) operand() (Expression, error) {
+	n, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return compile(n, p.env)
+}
+
+// keyword skips keyword if it is the next identifier, keywords are case insensitive
+func (p *predicateParser) keyword(word string) bool {
+	p.skipSpaces()
+	end := p.pos + len(word)
+	if end > len(p.source) || !strings.EqualFold(p.source[p.pos:end], word) {
+		return false
+	}
+	if end < len(p.source) && isIdentPart(p.source[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
@@ -0,0 +1,87 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+func TestCompilePredicate(t *testing.T) {
+	entry := nginx.NewEntry()
+	entry.SetField("status", "502")
+	entry.SetField("request_time", "1.25")
+	entry.SetField("request_method", "GET")
+	entry.SetField("http_user_agent", "Mozilla/5.0 (compatible; Googlebot/2.1)")
+	entry.SetField("host", "api.example.com")
+
+	t.Run("it should be matched predicates", func(t *testing.T) {
+		for source, expect := range map[string]bool{
+			"status >= 500":                                             true,
+			"$status<500":                                               false,
+			"status == 502.0":                                           true,
+			"request_time > 1.5":                                        false,
+			"request_time <= 1.25 and status != 200":                    true,
+			"host == 'api.example.com'":                                 true,
+			"host > 'web'":                                              false,
+			"http_user_agent =~ '/(?i)googlebot|yandexbot/'":            true,
+			"http_user_agent !~ 'bot'":                                  false,
+			"status < 500 or request_method == 'GET'":                   true,
+			"not (request_method == 'GET' or request_method == 'HEAD')": false,
+			"NOT status == 200 AND toUInt16(status) == 502":             true,
+			"status == 200 or status == 404 and host == 'api'":          false,
+			"RegExp('/^([a-z]+)\\./', host) == 'api'":                   true,
+		} {
+			predicate, err := CompilePredicate(source, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matched, err := predicate.Match(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched != expect {
+				t.Fatalf("%s: expect %v, got %v", source, expect, matched)
+			}
+		}
+	})
+
+	t.Run("it should be compilation error", func(t *testing.T) {
+		for _, source := range []string{
+			"",
+			"status",
+			"status >",
+			"status => 500",
+			"(status == 500",
+			"status == 500 and",
+			"status == 500 host == 'api'",
+			"host =~ http_host",
+			"host =~ '('",
+			"unknown(status) == 1",
+		} {
+			if _, err := CompilePredicate(source, nil); err == nil {
+				t.Fatalf("%s: expect error", source)
+			}
+		}
+	})
+
+	t.Run("it should be fields of predicate", func(t *testing.T) {
+		predicate, err := CompilePredicate("not (status >= 500 or RegExp('/^(api)/', host) == 'api') and arg_page == '1'", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fields := strings.Join(predicate.Fields(), ","); fields != "status,host,arg_page" {
+			t.Fatalf("expect fields status,host,arg_page, got %s", fields)
+		}
+	})
+
+	t.Run("it should be evaluation error of unknown field", func(t *testing.T) {
+		predicate, err := CompilePredicate("unknown_field == 1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := predicate.Match(entry); err == nil {
+			t.Fatal("expect error")
+		}
+	})
+}
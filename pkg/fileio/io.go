@@ -20,7 +20,8 @@ func logName(original string) string {
 	return fmt.Sprintf("%s-%s%s", original, time.Now().Format(timeLayout), extension)
 }
 
-func clearBackupFiles(original, directory string, maxBackups uint, maxAge time.Duration) error {
+// clearBackupFiles removes outdated backup files, files which are still being delivered are kept
+func clearBackupFiles(original, directory string, maxBackups uint, maxAge time.Duration, keep func(filename string) bool) error {
 	files, err := os.ReadDir(directory)
 	if err != nil {
 		return fmt.Errorf("failed read nginx logs dir: %w", err)
@@ -29,7 +30,7 @@ func clearBackupFiles(original, directory string, maxBackups uint, maxAge time.D
 	// the original file name is used as a prefix
 	original += "-"
 	for _, file := range files {
-		if file.IsDir() || filepath.Ext(file.Name()) != extension {
+		if file.IsDir() || filepath.Ext(file.Name()) != extension || keep(file.Name()) {
 			continue
 		}
 		// for example: access.log-2022_07_21_15_41_45.growerlog
@@ -0,0 +1,158 @@
+package fileio
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/log"
+)
+
+// LineCallback returns error if line is not accepted, then line will be read again,
+// mark must be acknowledged after the line is delivered
+type LineCallback func(line string, mark *checkpoint.Mark) error
+
+// Line of log file with mark, which is acknowledged after the line is delivered or rejected
+type Line struct {
+	Content string
+	Mark    *checkpoint.Mark
+}
+
+// lineFile reads lines of file from position and tracks their delivery
+type lineFile struct {
+	file      *os.File
+	reader    *bufio.Reader
+	path      string
+	inode     uint64
+	offset    int64
+	partial   []byte
+	tracker   *checkpoint.File
+	rotatedAt time.Time
+}
+
+func openLineFile(path string, position int64, onCommit func()) (*lineFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	// file was truncated while we did not read it
+	if position > info.Size() {
+		position = 0
+	}
+	if _, err := file.Seek(position, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &lineFile{
+		file:    file,
+		reader:  bufio.NewReader(file),
+		path:    path,
+		inode:   inode(info),
+		offset:  position,
+		tracker: checkpoint.NewFile(position, onCommit),
+	}, nil
+}
+
+// drain reads all complete lines, the incomplete last line is kept until it is written to the end
+func (f *lineFile) drain(callback LineCallback) error {
+	for {
+		line, err := f.reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				f.partial = append(f.partial, line...)
+			} else {
+				full := append(f.partial, line...)
+				f.partial = nil
+				if err := f.deliver(full, trimLineEnd(full), callback); err != nil {
+					return err
+				}
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Warning(err)
+			}
+			return nil
+		}
+	}
+}
+
+// drainAll reads file to the end, the last line without line break is delivered too
+func (f *lineFile) drainAll(callback LineCallback) error {
+	if err := f.drain(callback); err != nil {
+		return err
+	}
+	if len(f.partial) == 0 {
+		return nil
+	}
+	full := f.partial
+	f.partial = nil
+	return f.deliver(full, full, callback)
+}
+
+func (f *lineFile) deliver(full, content []byte, callback LineCallback) error {
+	mark := f.tracker.Next(int64(len(full)))
+	if len(content) > 0 {
+		if err := callback(string(content), mark); err != nil {
+			f.rewind()
+			return err
+		}
+	} else {
+		defer mark.Ack()
+	}
+	f.tracker.Track(mark)
+	f.offset += int64(len(full))
+	return nil
+}
+
+// rewind returns file to the position after the last accepted line
+func (f *lineFile) rewind() {
+	if _, err := f.file.Seek(f.offset, io.SeekStart); err != nil {
+		log.Warning(err)
+	}
+	f.reader.Reset(f.file)
+	f.partial = nil
+}
+
+// reset starts reading of truncated file from the beginning, lines read before truncation are tracked separately
+func (f *lineFile) reset(onCommit func()) error {
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f.reader.Reset(f.file)
+	f.offset = 0
+	f.partial = nil
+	f.tracker = checkpoint.NewFile(0, onCommit)
+	return nil
+}
+
+// close file, onDone is called when all read lines are delivered
+func (f *lineFile) close(onDone func()) error {
+	f.tracker.Close(onDone)
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *lineFile) checkpoint() Offset {
+	return Offset{Inode: f.inode, Position: f.tracker.Committed()}
+}
+
+func trimLineEnd(line []byte) []byte {
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line
+}
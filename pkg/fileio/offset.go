@@ -0,0 +1,57 @@
+package fileio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Offset position after the last read line in file identified by inode,
+// inode allows to find the file after it was renamed by logrotate
+type Offset struct {
+	Inode    uint64 `json:"inode"`
+	Position int64  `json:"position"`
+}
+
+// OffsetStore persists offsets of files, which are not read to the end: renamed files and the current file
+type OffsetStore struct {
+	filepath string
+}
+
+// Load returns no offsets if they have not been saved yet
+func (s *OffsetStore) Load() ([]Offset, error) {
+	content, err := os.ReadFile(s.filepath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var offsets []Offset
+	if err := json.Unmarshal(content, &offsets); err != nil {
+		return nil, fmt.Errorf("failed to read offset file %s: %w", s.filepath, err)
+	}
+	return offsets, nil
+}
+
+// Save writes offsets to temporary file and renames it, so offset file is never partially written
+func (s *OffsetStore) Save(offsets []Offset) error {
+	content, err := json.Marshal(offsets)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.filepath), 0o755); err != nil {
+		return err
+	}
+	tmp := s.filepath + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.filepath)
+}
+
+func NewOffsetStore(filepath string) *OffsetStore {
+	return &OffsetStore{filepath: filepath}
+}
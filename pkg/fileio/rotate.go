@@ -1,31 +1,53 @@
 package fileio
 
 import (
-	"fmt"
 	"os"
+	"path"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
 )
 
 type Rotator interface {
+	Resume() error
 	Rotate() error
+	Save()
 }
 
-type RotateCallback func(file *os.File) error
-
+// Rotate captures log file and reads it, captured files are removed (or kept as backups) only after all
+// their lines are delivered, offsets of delivered lines are persisted, so files, which were not delivered
+// before restart, are read again from the first undelivered line
 type Rotate struct {
+	mu                sync.Mutex
 	file              string
 	dir               string
 	backupFiles       uint
 	backupFilesMaxAge time.Duration
-	callback          RotateCallback
+	callback          LineCallback
+	store             *OffsetStore
+	inFlight          map[string]*lineFile
+	saved             []Offset
+	savedAt           time.Time
 	enableScratch     bool
 	enableRotate      bool
 	skipNginxReopen   bool
 }
 
 func (r *Rotate) Rotate() error {
+	start := time.Now()
+	err := r.rotate()
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	metrics.RotationDuration.WithLabelValues(status).Observe(time.Since(start).Seconds())
+	return err
+}
+
+func (r *Rotate) rotate() error {
 	if r.enableScratch {
 		// only for local development mode, each cyclo create new access.log from scratch
 		// will be removed in future
@@ -35,7 +57,7 @@ func (r *Rotate) Rotate() error {
 	defer func() {
 		// if rotation option is enabled, we delete outdated log files
 		if r.enableRotate {
-			if err := clearBackupFiles(r.file, r.dir, r.backupFiles, r.backupFilesMaxAge); err != nil {
+			if err := clearBackupFiles(r.file, r.dir, r.backupFiles, r.backupFilesMaxAge, r.isInFlight); err != nil {
 				log.Warning(err)
 			}
 		}
@@ -49,27 +71,100 @@ func (r *Rotate) Rotate() error {
 			return err
 		}
 	}
-	f, err := os.OpenFile(newFile, os.O_RDONLY, 0o777)
+	return r.read(newFile, 0)
+}
+
+// Resume reads captured files, which were not delivered to the end before restart
+func (r *Rotate) Resume() error {
+	offsets, err := r.store.Load()
 	if err != nil {
-		return fmt.Errorf("failed open log file: %w", err)
+		return err
 	}
-	defer func() {
-		if err := f.Close(); err != nil {
-			log.Warning(err)
+	r.saved = offsets
+	for _, offset := range offsets {
+		filepath := findByInode(r.dir, offset.Inode)
+		if filepath == "" || filepath == path.Join(r.dir, r.file) {
+			log.Warningf("captured file with inode %d is not found, it was probably removed", offset.Inode)
+			continue
 		}
-		// if rotation is not enabled, just delete the current index file
-		if !r.enableRotate {
-			if err := os.Remove(newFile); err != nil {
-				log.Warning(err)
-			}
+		log.Infof("continue reading of captured file %s from position %d", filepath, offset.Position)
+		if err := r.read(filepath, offset.Position); err != nil {
+			return err
 		}
-	}()
-	if err := r.callback(f); err != nil {
-		return err
 	}
 	return nil
 }
 
+func (r *Rotate) read(filepath string, position int64) error {
+	file, err := openLineFile(filepath, position, r.commit)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.inFlight[filepath] = file
+	r.save(true)
+	r.mu.Unlock()
+	readErr := file.drainAll(r.callback)
+	if err := file.close(func() {
+		r.done(filepath)
+	}); err != nil {
+		log.Warning(err)
+	}
+	return readErr
+}
+
+// done all lines of file are delivered, if rotation is not enabled, just delete the current index file
+func (r *Rotate) done(filepath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.inFlight, filepath)
+	if !r.enableRotate {
+		if err := os.Remove(filepath); err != nil {
+			log.Warning(err)
+		}
+	}
+	r.save(true)
+}
+
+func (r *Rotate) commit() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.save(false)
+}
+
+func (r *Rotate) isInFlight(filename string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.inFlight[path.Join(r.dir, filename)]
+	return ok
+}
+
+// Save persists offsets of delivered lines, it is called after clickhouse buffer is flushed on shutdown
+func (r *Rotate) Save() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.save(true)
+}
+
+func (r *Rotate) save(force bool) {
+	if !force && time.Since(r.savedAt) < saveInterval {
+		return
+	}
+	offsets := make([]Offset, 0, len(r.inFlight))
+	for _, file := range r.inFlight {
+		offsets = append(offsets, file.checkpoint())
+	}
+	r.savedAt = time.Now()
+	if reflect.DeepEqual(offsets, r.saved) {
+		return
+	}
+	if err := r.store.Save(offsets); err != nil {
+		log.Warning(err)
+		return
+	}
+	r.saved = offsets
+}
+
 func New(
 	file string,
 	dir string,
@@ -78,7 +173,8 @@ func New(
 	enableScratch bool,
 	enableRotate bool,
 	skipNginxReopen bool,
-	callback RotateCallback,
+	offsetFile string,
+	callback LineCallback,
 ) Rotator {
 	return &Rotate{
 		file:              file,
@@ -86,6 +182,8 @@ func New(
 		backupFiles:       backupFiles,
 		backupFilesMaxAge: backupFilesMaxAge,
 		callback:          callback,
+		store:             NewOffsetStore(offsetFile),
+		inFlight:          map[string]*lineFile{},
 		enableScratch:     enableScratch,
 		enableRotate:      enableRotate,
 		skipNginxReopen:   skipNginxReopen,
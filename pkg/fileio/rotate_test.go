@@ -0,0 +1,76 @@
+package fileio
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zikwall/grower/pkg/checkpoint"
+)
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	offsetFile := filepath.Join(dir, "offset.json")
+	var lines []string
+	var marks []*checkpoint.Mark
+	newRotator := func() Rotator {
+		return New("access.log", dir, 0, time.Hour, false, false, true, offsetFile,
+			func(line string, mark *checkpoint.Mark) error {
+				lines = append(lines, line)
+				marks = append(marks, mark)
+				return nil
+			},
+		)
+	}
+	captured := func() []string {
+		files, _ := filepath.Glob(filepath.Join(dir, "*"+extension))
+		return files
+	}
+
+	t.Run("it should be kept captured file until lines are delivered", func(t *testing.T) {
+		appendLines(t, filepath.Join(dir, "access.log"), "first\nsecond\nthird")
+		rotator := newRotator()
+		if err := rotator.Resume(); err != nil {
+			t.Fatal(err)
+		}
+		if err := rotator.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(lines, []string{"first", "second", "third"}) {
+			t.Fatalf("expect all lines, got %v", lines)
+		}
+		// the second line is lost, e.g. clickhouse is not available
+		marks[0].Ack()
+		marks[2].Ack()
+		rotator.Save()
+		if len(captured()) != 1 {
+			t.Fatalf("expect captured file is kept, got %v", captured())
+		}
+	})
+
+	t.Run("it should be resumed from the first undelivered line", func(t *testing.T) {
+		lines, marks = nil, nil
+		rotator := newRotator()
+		if err := rotator.Resume(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(lines, []string{"second", "third"}) {
+			t.Fatalf("expect undelivered lines, got %v", lines)
+		}
+		for _, mark := range marks {
+			mark.Ack()
+		}
+		if len(captured()) != 0 {
+			t.Fatalf("expect captured file is removed, got %v", captured())
+		}
+		content, err := os.ReadFile(offsetFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "[]" {
+			t.Fatalf("expect no offsets, got %s", content)
+		}
+	})
+}
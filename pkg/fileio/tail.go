@@ -0,0 +1,284 @@
+package fileio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/zikwall/grower/pkg/log"
+)
+
+// rotateWait how long renamed file is still read, nginx writes to the old file until it is reopened
+const rotateWait = 5 * time.Second
+
+// saveInterval limits frequency of offset saving, offset is also saved on close
+const saveInterval = time.Second
+
+type watcher interface {
+	Events() <-chan struct{}
+	Close() error
+}
+
+// Tail follows log file like `tail -F`: new lines are read as soon as inotify reports changes of the file,
+// or every poll interval if inotify is not available.
+// Files renamed by logrotate are read to the end, truncated files (copytruncate) are read from the beginning,
+// offsets of delivered lines are persisted, so after restart reading continues from the first undelivered line
+type Tail struct {
+	mu           sync.Mutex
+	filepath     string
+	pollInterval time.Duration
+	store        *OffsetStore
+	callback     LineCallback
+	current      *lineFile
+	rotated      []*lineFile
+	saved        []Offset
+	savedAt      time.Time
+}
+
+// Follow reads file until context is canceled
+func (t *Tail) Follow(ctx context.Context) error {
+	if err := t.resume(); err != nil {
+		return err
+	}
+	var events <-chan struct{}
+	w, err := newWatcher(filepath.Dir(t.filepath))
+	if err != nil {
+		log.Warningf("fallback to polling of %s every %s: %s", t.filepath, t.pollInterval, err)
+	} else {
+		events = w.Events()
+		defer func() {
+			if err := w.Close(); err != nil {
+				log.Warning(err)
+			}
+		}()
+	}
+	// polling is kept together with inotify, events may be lost in case of overflow
+	ticker := time.NewTicker(t.pollInterval)
+	defer ticker.Stop()
+	for {
+		t.step()
+		select {
+		case <-ctx.Done():
+			return t.close()
+		case <-ticker.C:
+		case _, ok := <-events:
+			if !ok {
+				// watcher is stopped because of error, nil channel is never selected, so only polling is left
+				log.Warningf("inotify of %s is stopped, fallback to polling every %s", t.filepath, t.pollInterval)
+				events = nil
+			}
+		}
+	}
+}
+
+// resume opens files at saved offsets, if file was renamed while grower was stopped,
+// the rest of the renamed file is read first, and then the new file from the beginning
+func (t *Tail) resume() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	offsets, err := t.store.Load()
+	if err != nil {
+		return err
+	}
+	t.saved = offsets
+	info, err := os.Stat(t.filepath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, offset := range offsets {
+		if info != nil && inode(info) == offset.Inode {
+			if t.current, err = openLineFile(t.filepath, offset.Position, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		rotated := findByInode(filepath.Dir(t.filepath), offset.Inode)
+		if rotated == "" {
+			log.Warningf("file with inode %d is not found, it was probably removed", offset.Inode)
+			continue
+		}
+		file, err := openLineFile(rotated, offset.Position, nil)
+		if err != nil {
+			return err
+		}
+		log.Infof("continue reading of rotated file %s from position %d", rotated, file.offset)
+		file.rotatedAt = time.Now()
+		t.rotated = append(t.rotated, file)
+	}
+	return nil
+}
+
+func (t *Tail) step() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rotated := t.rotated[:0]
+	for _, file := range t.rotated {
+		if file.file != nil {
+			t.drain(file)
+			if time.Since(file.rotatedAt) >= rotateWait {
+				if err := file.close(nil); err != nil {
+					log.Warning(err)
+				}
+			}
+		}
+		// offset of renamed file is kept until all its lines are delivered
+		if file.file == nil && file.tracker.Done() {
+			continue
+		}
+		rotated = append(rotated, file)
+	}
+	t.rotated = rotated
+	if t.current == nil {
+		file, err := openLineFile(t.filepath, 0, nil)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Warning(err)
+			}
+			return
+		}
+		t.current = file
+	}
+	t.drain(t.current)
+	if err := t.checkTruncate(); err != nil {
+		log.Warning(err)
+	}
+	if err := t.checkRename(); err != nil {
+		log.Warning(err)
+	}
+	if time.Since(t.savedAt) >= saveInterval {
+		t.save()
+	}
+}
+
+func (t *Tail) drain(file *lineFile) {
+	if err := file.drain(t.callback); err != nil {
+		log.Warningf("stop reading of %s: %s", file.path, err)
+	}
+}
+
+// checkTruncate file size less than read position means that file was truncated, e.g. by logrotate copytruncate
+func (t *Tail) checkTruncate() error {
+	info, err := t.current.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() >= t.current.offset+int64(len(t.current.partial)) {
+		return nil
+	}
+	log.Infof("%s was truncated, read from the beginning", t.filepath)
+	if err := t.current.reset(nil); err != nil {
+		return err
+	}
+	t.drain(t.current)
+	return nil
+}
+
+// checkRename path points to another file means that file was renamed and new one was created
+func (t *Tail) checkRename() error {
+	pathInfo, err := os.Stat(t.filepath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// file is renamed, but new one is not created yet
+			return nil
+		}
+		return err
+	}
+	info, err := t.current.file.Stat()
+	if err != nil {
+		return err
+	}
+	if os.SameFile(pathInfo, info) {
+		return nil
+	}
+	log.Infof("%s was rotated, read new file", t.filepath)
+	file, err := openLineFile(t.filepath, 0, nil)
+	if err != nil {
+		return err
+	}
+	t.current.rotatedAt = time.Now()
+	t.rotated = append(t.rotated, t.current)
+	t.current = file
+	t.drain(t.current)
+	return nil
+}
+
+// save offsets of delivered lines of all files, which are not delivered to the end
+func (t *Tail) save() {
+	offsets := make([]Offset, 0, len(t.rotated)+1)
+	for _, file := range t.rotated {
+		offsets = append(offsets, file.checkpoint())
+	}
+	if t.current != nil {
+		offsets = append(offsets, t.current.checkpoint())
+	}
+	t.savedAt = time.Now()
+	if reflect.DeepEqual(offsets, t.saved) {
+		return
+	}
+	if err := t.store.Save(offsets); err != nil {
+		log.Warning(err)
+		return
+	}
+	t.saved = offsets
+}
+
+// Save persists offsets of delivered lines, it is called after clickhouse buffer is flushed on shutdown
+func (t *Tail) Save() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.save()
+}
+
+func (t *Tail) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.save()
+	var err error
+	for _, file := range append(t.rotated, t.current) {
+		if file == nil {
+			continue
+		}
+		if closeErr := file.close(nil); closeErr != nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to close %s: %w", t.filepath, err)
+	}
+	return nil
+}
+
+func findByInode(dir string, ino uint64) string {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		log.Warning(err)
+		return ""
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		if inode(info) == ino {
+			return filepath.Join(dir, file.Name())
+		}
+	}
+	return ""
+}
+
+func NewTail(filepath, offsetFile string, pollInterval time.Duration, callback LineCallback) *Tail {
+	return &Tail{
+		filepath:     filepath,
+		pollInterval: pollInterval,
+		store:        NewOffsetStore(offsetFile),
+		callback:     callback,
+	}
+}
@@ -0,0 +1,147 @@
+package fileio
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zikwall/grower/pkg/checkpoint"
+)
+
+func appendLines(t *testing.T, filepath, content string) {
+	t.Helper()
+	f, err := os.OpenFile(filepath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTail(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "access.log")
+	offsetFile := filepath.Join(dir, "offset.json")
+	var lines []string
+	var marks []*checkpoint.Mark
+	newTail := func() *Tail {
+		return NewTail(logFile, offsetFile, time.Second, func(line string, mark *checkpoint.Mark) error {
+			lines = append(lines, line)
+			marks = append(marks, mark)
+			return nil
+		})
+	}
+	ack := func() {
+		for _, mark := range marks {
+			mark.Ack()
+		}
+		marks = nil
+	}
+	expect := func(t *testing.T, expected ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(lines, expected) {
+			t.Fatalf("expect %v, got %v", expected, lines)
+		}
+		lines = nil
+	}
+
+	tail := newTail()
+	if err := tail.resume(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it should be successfully read only complete lines", func(t *testing.T) {
+		tail.step()
+		expect(t)
+		appendLines(t, logFile, "first\nsecond\nthi")
+		tail.step()
+		expect(t, "first", "second")
+		appendLines(t, logFile, "rd\r\n")
+		tail.step()
+		expect(t, "third")
+	})
+
+	t.Run("it should be successfully read renamed and new files", func(t *testing.T) {
+		appendLines(t, logFile, "fourth\n")
+		if err := os.Rename(logFile, logFile+".1"); err != nil {
+			t.Fatal(err)
+		}
+		// nginx writes to old file until reopen
+		appendLines(t, logFile+".1", "fifth\n")
+		appendLines(t, logFile, "sixth\n")
+		tail.step()
+		expect(t, "fourth", "fifth", "sixth")
+		appendLines(t, logFile+".1", "seventh\n")
+		tail.step()
+		expect(t, "seventh")
+	})
+
+	t.Run("it should be successfully read truncated file from the beginning", func(t *testing.T) {
+		if err := os.Truncate(logFile, 0); err != nil {
+			t.Fatal(err)
+		}
+		tail.step()
+		appendLines(t, logFile, "eighth\n")
+		tail.step()
+		expect(t, "eighth")
+	})
+
+	t.Run("it should be successfully resumed from saved offset", func(t *testing.T) {
+		ack()
+		if err := tail.close(); err != nil {
+			t.Fatal(err)
+		}
+		appendLines(t, logFile, "ninth\n")
+		tail = newTail()
+		if err := tail.resume(); err != nil {
+			t.Fatal(err)
+		}
+		tail.step()
+		expect(t, "ninth")
+	})
+
+	t.Run("it should be successfully resumed from file renamed while stopped", func(t *testing.T) {
+		appendLines(t, logFile, "tenth\n")
+		tail.step()
+		ack()
+		if err := tail.close(); err != nil {
+			t.Fatal(err)
+		}
+		appendLines(t, logFile, "eleventh\n")
+		if err := os.Rename(logFile, logFile+".2"); err != nil {
+			t.Fatal(err)
+		}
+		appendLines(t, logFile, "twelfth\n")
+		tail = newTail()
+		if err := tail.resume(); err != nil {
+			t.Fatal(err)
+		}
+		tail.step()
+		expect(t, "tenth", "eleventh", "twelfth")
+	})
+
+	t.Run("it should be read again if lines are not delivered", func(t *testing.T) {
+		ack()
+		appendLines(t, logFile, "thirteenth\n")
+		tail.step()
+		expect(t, "thirteenth")
+		if err := tail.close(); err != nil {
+			t.Fatal(err)
+		}
+		tail = newTail()
+		if err := tail.resume(); err != nil {
+			t.Fatal(err)
+		}
+		tail.step()
+		expect(t, "thirteenth")
+		if err := tail.close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package fileio
+
+import (
+	"os"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE
+
+// inotify watches directory of log file, so events of renamed and created files are also received
+type inotify struct {
+	file   *os.File
+	events chan struct{}
+}
+
+func (i *inotify) Events() <-chan struct{} {
+	return i.events
+}
+
+func (i *inotify) Close() error {
+	return i.file.Close()
+}
+
+func (i *inotify) loop() {
+	defer close(i.events)
+	buf := make([]byte, syscall.SizeofInotifyEvent*128)
+	for {
+		if _, err := i.file.Read(buf); err != nil {
+			return
+		}
+		// the content of events doesn't matter, reader checks file state by itself,
+		// so several events are merged into one
+		select {
+		case i.events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func newWatcher(dir string) (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+	// non-blocking descriptor is registered in runtime poller, so Close interrupts blocked Read
+	i := &inotify{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan struct{}, 1),
+	}
+	go i.loop()
+	return i, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package fileio
+
+import "errors"
+
+func newWatcher(_ string) (watcher, error) {
+	return nil, errors.New("inotify is supported only on linux")
+}
@@ -0,0 +1,182 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// data types of MaxMind DB data section
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeFloat64
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeSlice
+	typeContainer
+	typeMarker
+	typeBool
+	typeFloat32
+)
+
+type decoder struct {
+	buffer []byte
+}
+
+// decode returns value at offset and offset of the next value
+// nolint:gocyclo // it's ok, one case per data type
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	kind, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if kind == typePointer {
+		pointer, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+	if kind == typeMap {
+		return d.decodeMap(size, offset)
+	}
+	if kind == typeSlice {
+		return d.decodeSlice(size, offset)
+	}
+	if kind == typeBool {
+		return size != 0, offset, nil
+	}
+	if offset+size > uint(len(d.buffer)) {
+		return nil, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalidDatabase)
+	}
+	payload, next := d.buffer[offset:offset+size], offset+size
+	switch kind {
+	case typeString:
+		return string(payload), next, nil
+	case typeFloat64:
+		if size != 8 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), next, nil
+	case typeFloat32:
+		if size != 4 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(payload)), next, nil
+	case typeBytes:
+		return append([]byte{}, payload...), next, nil
+	case typeUint16:
+		return uint16(unsigned(payload)), next, nil
+	case typeUint32:
+		return uint32(unsigned(payload)), next, nil
+	case typeInt32:
+		return int32(uint32(unsigned(payload))), next, nil
+	case typeUint64:
+		return unsigned(payload), next, nil
+	case typeUint128:
+		return new(big.Int).SetBytes(payload), next, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unsupported data type %d", ErrInvalidDatabase, kind)
+}
+
+func (d *decoder) decodeMap(size, offset uint) (interface{}, uint, error) {
+	value := make(map[string]interface{}, size)
+	for i := uint(0); i < size; i++ {
+		key, next, err := d.decode(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: map key is not string", ErrInvalidDatabase)
+		}
+		value[name], offset, err = d.decode(next)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return value, offset, nil
+}
+
+func (d *decoder) decodeSlice(size, offset uint) (interface{}, uint, error) {
+	value := make([]interface{}, size)
+	for i := range value {
+		var err error
+		if value[i], offset, err = d.decode(offset); err != nil {
+			return nil, 0, err
+		}
+	}
+	return value, offset, nil
+}
+
+// control reads control byte, returns data type, payload size and payload offset
+func (d *decoder) control(offset uint) (kind, size, next uint, err error) {
+	if offset >= uint(len(d.buffer)) {
+		return 0, 0, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalidDatabase)
+	}
+	ctrl := uint(d.buffer[offset])
+	offset++
+	kind = ctrl >> 5
+	if kind == typeExtended {
+		if offset >= uint(len(d.buffer)) {
+			return 0, 0, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalidDatabase)
+		}
+		kind = uint(d.buffer[offset]) + 7
+		offset++
+	}
+	size = ctrl & 0x1f
+	// pointer size is decoded separately
+	if kind == typePointer || size < 29 {
+		return kind, size, offset, nil
+	}
+	extra := size - 28
+	if offset+extra > uint(len(d.buffer)) {
+		return 0, 0, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalidDatabase)
+	}
+	value := uint(unsigned(d.buffer[offset : offset+extra]))
+	switch extra {
+	case 1:
+		size = 29 + value
+	case 2:
+		size = 285 + value
+	default:
+		size = 65821 + value
+	}
+	return kind, size, offset + extra, nil
+}
+
+func (d *decoder) pointer(size, offset uint) (pointer, next uint, err error) {
+	length := (size>>3)&0x3 + 1
+	if offset+length > uint(len(d.buffer)) {
+		return 0, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalidDatabase)
+	}
+	value := uint(unsigned(d.buffer[offset : offset+length]))
+	switch length {
+	case 1:
+		pointer = (size&0x7)<<8 | value
+	case 2:
+		pointer = ((size&0x7)<<16 | value) + 2048
+	case 3:
+		pointer = ((size&0x7)<<24 | value) + 526336
+	default:
+		pointer = value
+	}
+	return pointer, offset + length, nil
+}
+
+func unsigned(payload []byte) uint64 {
+	var value uint64
+	for _, b := range payload {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
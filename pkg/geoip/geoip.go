@@ -0,0 +1,100 @@
+// Package geoip reads MaxMind DB files (GeoLite2/GeoIP2 City and ASN) without external dependencies
+package geoip
+
+import (
+	"errors"
+	"net"
+)
+
+const defaultLanguage = "en"
+
+var (
+	ErrNoCityDatabase = errors.New("GeoIP city database is not loaded")
+	ErrNoASNDatabase  = errors.New("GeoIP ASN database is not loaded")
+)
+
+// DB looks up locations in City database and autonomous systems in ASN database,
+// any of databases may be omitted
+type DB struct {
+	city *Reader
+	asn  *Reader
+}
+
+func (d *DB) Country(ip net.IP) (string, error) {
+	record, err := d.cityRecord(ip)
+	if err != nil {
+		return "", err
+	}
+	return stringValue(path(record, "country", "iso_code")), nil
+}
+
+func (d *DB) Region(ip net.IP) (string, error) {
+	record, err := d.cityRecord(ip)
+	if err != nil {
+		return "", err
+	}
+	subdivisions, _ := path(record, "subdivisions").([]interface{})
+	if len(subdivisions) == 0 {
+		return "", nil
+	}
+	return stringValue(path(subdivisions[0], "names", defaultLanguage)), nil
+}
+
+func (d *DB) City(ip net.IP) (string, error) {
+	record, err := d.cityRecord(ip)
+	if err != nil {
+		return "", err
+	}
+	return stringValue(path(record, "city", "names", defaultLanguage)), nil
+}
+
+func (d *DB) AS(ip net.IP) (number uint, organization string, err error) {
+	if d.asn == nil {
+		return 0, "", ErrNoASNDatabase
+	}
+	record, err := d.asn.Lookup(ip)
+	if err != nil {
+		return 0, "", err
+	}
+	return uintValue(path(record, "autonomous_system_number")),
+		stringValue(path(record, "autonomous_system_organization")), nil
+}
+
+func (d *DB) cityRecord(ip net.IP) (interface{}, error) {
+	if d.city == nil {
+		return nil, ErrNoCityDatabase
+	}
+	return d.city.Lookup(ip)
+}
+
+func path(record interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		object, ok := record.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		record = object[key]
+	}
+	return record
+}
+
+// New opens City and ASN databases, empty path skips database
+func New(cityDatabase, asnDatabase string) (*DB, error) {
+	db := &DB{}
+	var err error
+	if cityDatabase != "" {
+		if db.city, err = Open(cityDatabase); err != nil {
+			return nil, err
+		}
+	}
+	if asnDatabase != "" {
+		if db.asn, err = Open(asnDatabase); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+func NewFromReaders(city, asn *Reader) *DB {
+	return &DB{city: city, asn: asn}
+}
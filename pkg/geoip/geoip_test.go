@@ -0,0 +1,210 @@
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// buildDatabase builds MaxMind DB with single network prefix for tests
+func buildDatabase(t *testing.T, recordSize, ipVersion int, network *net.IPNet, data []byte) *Reader {
+	t.Helper()
+	ip, ones := network.IP.To4(), 0
+	if ip != nil {
+		ones, _ = network.Mask.Size()
+		if ipVersion == 6 {
+			ip = append(make([]byte, 12), ip...)
+			ones += 96
+		}
+	} else {
+		ip = network.IP.To16()
+		ones, _ = network.Mask.Size()
+	}
+	nodeCount := ones
+	tree := &bytes.Buffer{}
+	for i := 0; i < ones; i++ {
+		next := i + 1
+		if i == ones-1 {
+			// pointer to the first value of data section
+			next = nodeCount + 16
+		}
+		bit := int(ip[i>>3]>>(7-uint(i)%8)) & 1
+		records := [2]int{nodeCount, nodeCount}
+		records[bit] = next
+		writeNode(tree, recordSize, records[0], records[1])
+	}
+	content := append(tree.Bytes(), make([]byte, 16)...)
+	content = append(content, data...)
+	content = append(content, metadataMarker...)
+	content = append(content, encodeMap(4)...)
+	content = append(content, encodeString("database_type")...)
+	content = append(content, encodeString("Test")...)
+	content = append(content, encodeString("node_count")...)
+	content = append(content, encodeUint32(uint32(nodeCount))...)
+	content = append(content, encodeString("record_size")...)
+	content = append(content, encodeUint16(uint16(recordSize))...)
+	content = append(content, encodeString("ip_version")...)
+	content = append(content, encodeUint16(uint16(ipVersion))...)
+	reader, err := FromBytes(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+func writeNode(buf *bytes.Buffer, recordSize, left, right int) {
+	switch recordSize {
+	case 24:
+		buf.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+	case 28:
+		buf.Write([]byte{
+			byte(left >> 16), byte(left >> 8), byte(left),
+			byte((left>>24)&0xF)<<4 | byte((right>>24)&0xF),
+			byte(right >> 16), byte(right >> 8), byte(right),
+		})
+	case 32:
+		buf.Write([]byte{
+			byte(left >> 24), byte(left >> 16), byte(left >> 8), byte(left),
+			byte(right >> 24), byte(right >> 16), byte(right >> 8), byte(right),
+		})
+	}
+}
+
+func encodeString(value string) []byte {
+	if len(value) < 29 {
+		return append([]byte{typeString<<5 | byte(len(value))}, value...)
+	}
+	return append([]byte{typeString<<5 | 29, byte(len(value) - 29)}, value...)
+}
+
+func encodeUint16(value uint16) []byte {
+	return []byte{typeUint16<<5 | 2, byte(value >> 8), byte(value)}
+}
+
+func encodeUint32(value uint32) []byte {
+	return []byte{typeUint32<<5 | 4, byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}
+}
+
+func encodeMap(size int) []byte {
+	return []byte{typeMap<<5 | byte(size)}
+}
+
+func encodeSlice(size int) []byte {
+	return []byte{byte(size), typeSlice - 7}
+}
+
+func encodePointer(pointer int) []byte {
+	return []byte{typePointer<<5 | byte(pointer>>8)&0x7, byte(pointer)}
+}
+
+func cityData() []byte {
+	var data []byte
+	data = append(data, encodeMap(3)...)
+	data = append(data, encodeString("country")...)
+	data = append(data, encodeMap(1)...)
+	data = append(data, encodeString("iso_code")...)
+	data = append(data, encodeString("RU")...)
+	data = append(data, encodeString("subdivisions")...)
+	data = append(data, encodeSlice(1)...)
+	data = append(data, encodeMap(1)...)
+	data = append(data, encodeString("names")...)
+	data = append(data, encodeMap(1)...)
+	data = append(data, encodeString("en")...)
+	data = append(data, encodeString("Moscow Oblast")...)
+	data = append(data, encodeString("city")...)
+	data = append(data, encodeMap(1)...)
+	data = append(data, encodeString("names")...)
+	data = append(data, encodeMap(1)...)
+	data = append(data, encodeString("en")...)
+	// the city name is stored after the record and referenced by pointer
+	pointerAt := len(data)
+	data = append(data, encodePointer(pointerAt+2)...)
+	data = append(data, encodeString("Moscow")...)
+	return data
+}
+
+func asnData() []byte {
+	var data []byte
+	data = append(data, encodeMap(2)...)
+	data = append(data, encodeString("autonomous_system_number")...)
+	data = append(data, encodeUint32(13238)...)
+	data = append(data, encodeString("autonomous_system_organization")...)
+	data = append(data, encodeString("YANDEX LLC")...)
+	return data
+}
+
+func TestDB(t *testing.T) {
+	_, network, _ := net.ParseCIDR("5.45.192.0/18")
+	for _, test := range []struct {
+		recordSize int
+		ipVersion  int
+	}{
+		{recordSize: 24, ipVersion: 4},
+		{recordSize: 28, ipVersion: 6},
+		{recordSize: 32, ipVersion: 6},
+	} {
+		db := NewFromReaders(
+			buildDatabase(t, test.recordSize, test.ipVersion, network, cityData()),
+			buildDatabase(t, test.recordSize, test.ipVersion, network, asnData()),
+		)
+		t.Run("it should be successfully found location", func(t *testing.T) {
+			ip := net.ParseIP("5.45.207.1")
+			country, err := db.Country(ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if country != "RU" {
+				t.Fatalf("expect RU, got %s", country)
+			}
+			region, err := db.Region(ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if region != "Moscow Oblast" {
+				t.Fatalf("expect Moscow Oblast, got %s", region)
+			}
+			city, err := db.City(ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if city != "Moscow" {
+				t.Fatalf("expect Moscow, got %s", city)
+			}
+		})
+		t.Run("it should be successfully found autonomous system", func(t *testing.T) {
+			number, organization, err := db.AS(net.ParseIP("5.45.255.255"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if number != 13238 || organization != "YANDEX LLC" {
+				t.Fatalf("expect 13238 YANDEX LLC, got %d %s", number, organization)
+			}
+		})
+		t.Run("it should be empty for unknown address", func(t *testing.T) {
+			city, err := db.City(net.ParseIP("8.8.8.8"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if city != "" {
+				t.Fatalf("expect empty city, got %s", city)
+			}
+			number, _, err := db.AS(net.ParseIP("5.45.128.1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if number != 0 {
+				t.Fatalf("expect empty autonomous system, got %d", number)
+			}
+		})
+	}
+	t.Run("it should be error if database is not loaded", func(t *testing.T) {
+		if _, err := NewFromReaders(nil, nil).City(net.ParseIP("5.45.207.1")); err != ErrNoCityDatabase {
+			t.Fatalf("expect %v, got %v", ErrNoCityDatabase, err)
+		}
+	})
+	t.Run("it should be error for invalid file", func(t *testing.T) {
+		if _, err := FromBytes([]byte("not a database")); err != ErrInvalidDatabase {
+			t.Fatalf("expect %v, got %v", ErrInvalidDatabase, err)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package geoip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// metadataMarker separates search tree and data section from metadata of MaxMind DB file,
+// see https://maxmind.github.io/MaxMind-DB/
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+const dataSectionSeparatorSize = 16
+
+var (
+	ErrInvalidDatabase = errors.New("invalid MaxMind DB file")
+	ErrInvalidIP       = errors.New("invalid IP address")
+)
+
+type Metadata struct {
+	DatabaseType string
+	NodeCount    uint
+	RecordSize   uint
+	IPVersion    uint
+}
+
+// Reader of MaxMind DB (.mmdb) files, whole file is loaded in memory
+// Reader is safe for concurrent use
+type Reader struct {
+	Metadata  Metadata
+	tree      []byte
+	data      *decoder
+	ipv4Start uint
+}
+
+// Lookup returns decoded record for IP address, nil if address is not found
+func (r *Reader) Lookup(ip net.IP) (interface{}, error) {
+	if ip == nil {
+		return nil, ErrInvalidIP
+	}
+	node, bits := uint(0), 128
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip, node, bits = ipv4, r.ipv4Start, 32
+	} else if r.Metadata.IPVersion == 4 {
+		return nil, fmt.Errorf("IPv6 address %s in IPv4 database", ip)
+	}
+	for i := 0; i < bits && node < r.Metadata.NodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i)%8)) & 1
+		next, err := r.record(node, bit)
+		if err != nil {
+			return nil, err
+		}
+		node = next
+	}
+	if node == r.Metadata.NodeCount {
+		return nil, nil
+	}
+	if node < r.Metadata.NodeCount {
+		return nil, ErrInvalidDatabase
+	}
+	offset := node - r.Metadata.NodeCount - dataSectionSeparatorSize
+	value, _, err := r.data.decode(offset)
+	return value, err
+}
+
+func (r *Reader) record(node, bit uint) (uint, error) {
+	switch r.Metadata.RecordSize {
+	case 24:
+		offset := node*6 + bit*3
+		b := r.tree[offset : offset+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+	case 28:
+		b := r.tree[node*7 : node*7+7]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6]), nil
+	case 32:
+		offset := node*8 + bit*4
+		b := r.tree[offset : offset+4]
+		return uint(b[0])<<24 | uint(b[1])<<16 | uint(b[2])<<8 | uint(b[3]), nil
+	}
+	return 0, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, r.Metadata.RecordSize)
+}
+
+func Open(filepath string) (*Reader, error) {
+	content, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(content)
+}
+
+func FromBytes(content []byte) (*Reader, error) {
+	markerAt := bytes.LastIndex(content, metadataMarker)
+	if markerAt == -1 {
+		return nil, ErrInvalidDatabase
+	}
+	metadataDecoder := &decoder{buffer: content[markerAt+len(metadataMarker):]}
+	raw, _, err := metadataDecoder.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidDatabase
+	}
+	r := &Reader{
+		Metadata: Metadata{
+			DatabaseType: stringValue(metadata["database_type"]),
+			NodeCount:    uintValue(metadata["node_count"]),
+			RecordSize:   uintValue(metadata["record_size"]),
+			IPVersion:    uintValue(metadata["ip_version"]),
+		},
+	}
+	treeSize := r.Metadata.RecordSize * 2 / 8 * r.Metadata.NodeCount
+	if treeSize+dataSectionSeparatorSize > uint(markerAt) {
+		return nil, ErrInvalidDatabase
+	}
+	r.tree = content[:treeSize]
+	r.data = &decoder{buffer: content[treeSize+dataSectionSeparatorSize : markerAt]}
+	if _, err := r.record(0, 0); err != nil {
+		return nil, err
+	}
+	// IPv4 addresses in IPv6 database are stored in ::/96 subtree
+	if r.Metadata.IPVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.Metadata.NodeCount; i++ {
+			if node, err = r.record(node, 0); err != nil {
+				return nil, err
+			}
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+func stringValue(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+func uintValue(value interface{}) uint {
+	switch it := value.(type) {
+	case uint16:
+		return uint(it)
+	case uint32:
+		return uint(it)
+	case uint64:
+		return uint(it)
+	}
+	return 0
+}
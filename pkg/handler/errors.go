@@ -0,0 +1,35 @@
+package handler
+
+import "errors"
+
+// Kinds of errors, which may occur while handling a row
+const (
+	ErrorKindParse      = "parse"
+	ErrorKindField      = "field"
+	ErrorKindExpression = "expression"
+	ErrorKindCast       = "cast"
+	ErrorKindUnknown    = "unknown"
+)
+
+// Error keeps the original error message and the stage of handling where it occurred
+type Error struct {
+	Kind string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKind returns kind of handling error, for example: parse, field or cast
+func ErrorKind(err error) string {
+	var handleErr *Error
+	if errors.As(err, &handleErr) {
+		return handleErr.Kind
+	}
+	return ErrorKindUnknown
+}
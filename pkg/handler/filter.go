@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/expr"
+	"github.com/zikwall/grower/pkg/nginx"
+	"github.com/zikwall/grower/pkg/sample"
+)
+
+// Reasons of filtered rows
+const (
+	FilterReasonInclude = "include"
+	FilterReasonExclude = "exclude"
+	FilterReasonSample  = "sample"
+)
+
+type filter struct {
+	include    []*expr.Predicate
+	exclude    []*expr.Predicate
+	sampleKey  expr.Expression
+	sampleRate float64
+}
+
+func compileFilter(scheme *config.Scheme, env *expr.Env) (*filter, error) {
+	f := &filter{sampleRate: scheme.Sample.Rate}
+	var err error
+	if f.include, err = compilePredicates(scheme.Filter.Include, env); err != nil {
+		return nil, fmt.Errorf("filter include: %w", err)
+	}
+	if f.exclude, err = compilePredicates(scheme.Filter.Exclude, env); err != nil {
+		return nil, fmt.Errorf("filter exclude: %w", err)
+	}
+	if scheme.Sample.Key != "" {
+		if f.sampleKey, err = expr.Compile(scheme.Sample.Key, env); err != nil {
+			return nil, fmt.Errorf("sample key: %w", err)
+		}
+	}
+	return f, nil
+}
+
+func compilePredicates(sources []string, env *expr.Env) ([]*expr.Predicate, error) {
+	predicates := make([]*expr.Predicate, 0, len(sources))
+	for _, source := range sources {
+		predicate, err := expr.CompilePredicate(source, env)
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, predicate)
+	}
+	return predicates, nil
+}
+
+// sampleRateSource column expression of sample rate, so the column is casted and validated as other columns
+func sampleRateSource(rate float64) string {
+	return "toFloat64(" + strconv.FormatFloat(rate, 'f', -1, 64) + ")"
+}
+
+// apply returns reason of filtered entry, it is empty if entry is kept
+func (f *filter) apply(entry *nginx.LogEntry) (string, error) {
+	if len(f.include) > 0 {
+		matched, err := matchAny(f.include, entry)
+		if err != nil {
+			return "", err
+		}
+		if !matched {
+			return FilterReasonInclude, nil
+		}
+	}
+	matched, err := matchAny(f.exclude, entry)
+	if err != nil {
+		return "", err
+	}
+	if matched {
+		return FilterReasonExclude, nil
+	}
+	if f.sampleKey == nil {
+		return "", nil
+	}
+	value, err := f.sampleKey.Eval(entry)
+	if err != nil {
+		return "", err
+	}
+	key, ok := value.(string)
+	if !ok {
+		key = fmt.Sprint(value)
+	}
+	if !sample.Keep(key, f.sampleRate) {
+		return FilterReasonSample, nil
+	}
+	return "", nil
+}
+
+func matchAny(predicates []*expr.Predicate, entry *nginx.LogEntry) (bool, error) {
+	for _, predicate := range predicates {
+		matched, err := predicate.Match(entry)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
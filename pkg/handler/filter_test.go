@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/sample"
+)
+
+func TestFilter(t *testing.T) {
+	t.Run("it should be returned reason of filtered rows", func(t *testing.T) {
+		rowHandler := newTestHandler(t, config.Scheme{
+			LogsTable: "logs",
+			Columns:   map[string]string{"status": "status"},
+			Filter: config.Filter{
+				Include: []string{"request_method == 'GET'", "request_method == 'POST'"},
+				Exclude: []string{"request_uri =~ '/^/health/'"},
+			},
+		})
+		for line, reason := range map[string]string{
+			`{"status":200,"request_method":"GET","request_uri":"/"}`:        "",
+			`{"status":200,"request_method":"POST","request_uri":"/"}`:       "",
+			`{"status":200,"request_method":"HEAD","request_uri":"/"}`:       FilterReasonInclude,
+			`{"status":200,"request_method":"HEAD","request_uri":"/health"}`: FilterReasonInclude,
+			// exclude takes precedence over include
+			`{"status":200,"request_method":"GET","request_uri":"/health"}`: FilterReasonExclude,
+		} {
+			row, err := rowHandler.Handle(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if row.Filtered != reason {
+				t.Fatalf("%s: expect reason %q, got %q", line, reason, row.Filtered)
+			}
+			if (reason == "") == (row.Vector == nil) {
+				t.Fatalf("%s: expect values only of kept row, got %v", line, row.Vector)
+			}
+		}
+	})
+
+	t.Run("it should be acknowledged filtered row without write", func(t *testing.T) {
+		rowHandler := newTestHandler(t, config.Scheme{
+			LogsTable: "logs",
+			Columns:   map[string]string{"status": "status"},
+			Filter:    config.Filter{Exclude: []string{"status == 200"}},
+		})
+		writer, writers := newTestWriter(rowHandler)
+		row, err := rowHandler.Handle(`{"status":200}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acked := false
+		writer.Write(row, checkpoint.NewMark(func() {
+			acked = true
+		}))
+		if !acked || len(writers["logs"].vectors) != 0 {
+			t.Fatalf("expect acknowledged mark and no rows, got %v", writers["logs"].vectors)
+		}
+	})
+
+	t.Run("it should be kept the same rows of sample every time", func(t *testing.T) {
+		scheme := config.Scheme{
+			LogsTable: "logs",
+			Columns:   map[string]string{"status": "status", "remote_addr": "remote_addr"},
+			Sample:    config.Sample{Key: "remote_addr", Rate: 0.5},
+		}
+		sampled := func(rowHandler *RowHandler) map[string]bool {
+			kept := map[string]bool{}
+			for i := 0; i < 100; i++ {
+				addr := fmt.Sprintf("10.0.0.%d", i)
+				row, err := rowHandler.Handle(`{"status":200,"remote_addr":"` + addr + `"}`)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if row.Filtered != "" && row.Filtered != FilterReasonSample {
+					t.Fatalf("expect sample reason, got %q", row.Filtered)
+				}
+				kept[addr] = row.Filtered == ""
+				if kept[addr] != sample.Keep(addr, 0.5) {
+					t.Fatalf("%s: expect sample by hash of key", addr)
+				}
+			}
+			return kept
+		}
+		first := sampled(newTestHandler(t, scheme))
+		// another process keeps the same rows
+		second := sampled(newTestHandler(t, scheme))
+		count := 0
+		for addr, kept := range first {
+			if second[addr] != kept {
+				t.Fatalf("%s: expect the same decision, got %v and %v", addr, kept, second[addr])
+			}
+			if kept {
+				count++
+			}
+		}
+		if count == 0 || count == 100 {
+			t.Fatalf("expect part of rows is kept, got %d", count)
+		}
+	})
+
+	t.Run("it should be filled sample rate of kept rows", func(t *testing.T) {
+		rowHandler := newTestHandler(t, config.Scheme{
+			LogsTable: "logs",
+			Columns:   map[string]string{"status": "status"},
+			Sample:    config.Sample{Key: "remote_addr", Rate: 0.25},
+		})
+		columns := rowHandler.Columns()
+		if columns[len(columns)-1] != config.SampleRateColumn {
+			t.Fatalf("expect column %s, got %v", config.SampleRateColumn, columns)
+		}
+		kept := 0
+		for i := 0; i < 100; i++ {
+			row, err := rowHandler.Handle(fmt.Sprintf(`{"status":200,"remote_addr":"10.0.1.%d"}`, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if row.Filtered != "" {
+				continue
+			}
+			kept++
+			if len(row.Vector) != 2 || row.Vector[1] != float64(0.25) {
+				t.Fatalf("expect status and sample rate, got %v", row.Vector)
+			}
+		}
+		if kept == 0 {
+			t.Fatal("expect kept rows")
+		}
+	})
+}
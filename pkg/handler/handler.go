@@ -1,53 +1,261 @@
 package handler
 
 import (
+	"errors"
+
 	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
 
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/expr"
+	"github.com/zikwall/grower/pkg/geoip"
+	"github.com/zikwall/grower/pkg/metrics"
 	"github.com/zikwall/grower/pkg/nginx"
+	"github.com/zikwall/grower/pkg/privacy"
+	"github.com/zikwall/grower/pkg/useragent"
 )
 
 type Handler interface {
-	Handle(content string) (cx.Vector, error)
+	Handle(content string) (*Row, error)
+}
+
+// FieldsHandler also handles fields of transport, e.g. structured data of syslog message, which are added to parsed fields
+type FieldsHandler interface {
+	Handler
+	HandleFields(content string, fields nginx.Fields) (*Row, error)
+}
+
+// Row is handled line: values of columns with decisions of filter and routes,
+// row which is filtered out or dropped by route has no values
+type Row struct {
+	Vector cx.Vector
+	// Route matched by row, it is nil if row matches no route
+	Route *Route
+	// Filtered reason of filter, e.g. sample, it is empty if row is kept
+	Filtered string
+}
+
+// Redactor hides personal data of raw lines, e.g. lines of dead letters
+type Redactor interface {
+	Redact(line string) string
 }
 
 type RowHandler struct {
-	template   *nginx.Template
+	parser     nginx.StringParser
 	typeCaster nginx.TypeCaster
 	columns    []string
-	scheme     map[string]string
+	sources    map[string]expr.Expression
+	routes     []*Route
+	filter     *filter
+	enrichers  []nginx.Enricher
+	privacy    *privacy.Scrubber
 }
 
-func (r *RowHandler) Handle(content string) (cx.Vector, error) {
-	entry, err := r.template.ParseString(content)
+func (r *RowHandler) Handle(content string) (*Row, error) {
+	return r.HandleFields(content, nil)
+}
+
+// HandleFields parses content and adds fields, parsed fields have priority over added fields with the same name
+func (r *RowHandler) HandleFields(content string, fields nginx.Fields) (*Row, error) {
+	row, err := r.handle(content, fields)
 	if err != nil {
+		metrics.LinesFailed.WithLabelValues(ErrorKind(err)).Inc()
+		// messages of errors may contain line or fields, which are logged
+		if r.privacy != nil {
+			err = &Error{Kind: ErrorKind(err), Err: errors.New(r.redact(err.Error(), content, fields))}
+		}
 		return nil, err
 	}
-	vector := make(cx.Vector, 0, len(r.columns))
+	metrics.LinesParsed.Inc()
+	return row, nil
+}
+
+func (r *RowHandler) handle(content string, fields nginx.Fields) (*Row, error) {
+	entry, err := r.parser.ParseString(content)
+	if err != nil {
+		return nil, &Error{Kind: ErrorKindParse, Err: err}
+	}
+	for name, value := range fields {
+		if _, ok := entry.Fields()[name]; !ok {
+			entry.SetField(name, value)
+		}
+	}
+	if r.privacy != nil {
+		r.privacy.Scrub(entry)
+	}
+	for _, enricher := range r.enrichers {
+		enricher.Enrich(entry)
+	}
+	if r.filter != nil {
+		reason, err := r.filter.apply(entry)
+		if err != nil {
+			return nil, &Error{Kind: ErrorKindExpression, Err: err}
+		}
+		if reason != "" {
+			return &Row{Filtered: reason}, nil
+		}
+	}
+	route, err := r.route(entry)
+	if err != nil {
+		return nil, &Error{Kind: ErrorKindExpression, Err: err}
+	}
+	// values of dropped row are not needed
+	if route != nil && route.Drop {
+		return &Row{Route: route}, nil
+	}
+	// capacity is enough for checkpoint mark
+	vector := make(cx.Vector, 0, len(r.columns)+1)
 	for _, column := range r.columns {
-		columnAlias := r.scheme[column]
-		value, err := entry.Field(columnAlias)
+		source := r.sources[column]
+		value, err := source.Eval(entry)
 		if err != nil {
-			return nil, err
+			if _, ok := source.(*expr.Field); ok {
+				return nil, &Error{Kind: ErrorKindField, Err: err}
+			}
+			return nil, &Error{Kind: ErrorKindExpression, Err: err}
+		}
+		// value of expression wrapped by cast function is already casted
+		raw, ok := value.(string)
+		if !ok {
+			vector = append(vector, value)
+			continue
 		}
-		casted, err := r.typeCaster.TryCast(column, value)
+		casted, err := r.typeCaster.TryCast(column, raw)
 		if err != nil {
-			return nil, err
+			return nil, &Error{Kind: ErrorKindCast, Err: err}
 		}
 		vector = append(vector, casted)
 	}
-	return vector, nil
+	return &Row{Vector: vector, Route: route}, nil
 }
 
 func NewRowHandler(
 	columns []string,
-	scheme map[string]string,
-	template *nginx.Template,
+	sources map[string]expr.Expression,
+	parser nginx.StringParser,
 	typeCaster nginx.TypeCaster,
 ) *RowHandler {
 	return &RowHandler{
 		columns:    columns,
-		scheme:     scheme,
-		template:   template,
+		sources:    sources,
+		parser:     parser,
 		typeCaster: typeCaster,
 	}
 }
+
+// New creates row handler by configuration with given parser of log type, see nginx.NewParser:
+// compiled column expressions and type caster, GeoIP databases are loaded only if they are configured
+func New(cfg *config.Config, parser nginx.StringParser) (*RowHandler, error) {
+	casterCfg := &nginx.CasterCfg{
+		CustomCasts:       cfg.Nginx.LogCustomCasts,
+		LocalTimeFormat:   cfg.Nginx.LogTimeFormat,
+		CustomCastsEnable: cfg.Nginx.LogCustomCastsEnable,
+		RemoveHyphen:      cfg.Nginx.LogRemoveHyphen,
+	}
+	env := &expr.Env{Caster: *casterCfg}
+	if cfg.GeoIP.CityDatabase != "" || cfg.GeoIP.ASNDatabase != "" {
+		db, err := geoip.New(cfg.GeoIP.CityDatabase, cfg.GeoIP.ASNDatabase)
+		if err != nil {
+			return nil, err
+		}
+		env.GeoIP = db
+	}
+	columns, scheme := cfg.Scheme.MapKeys()
+	sources, err := expr.CompileScheme(scheme, env)
+	if err != nil {
+		return nil, err
+	}
+	// rate of sample is written to every row, so aggregations can be re-weighted
+	if cfg.Scheme.Sample.Key != "" {
+		sources[config.SampleRateColumn], err = expr.Compile(sampleRateSource(cfg.Scheme.Sample.Rate), env)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, config.SampleRateColumn)
+	}
+	routes, err := compileRoutes(cfg.Scheme.Routes, env)
+	if err != nil {
+		return nil, err
+	}
+	rowFilter, err := compileFilter(&cfg.Scheme, env)
+	if err != nil {
+		return nil, err
+	}
+	rowHandler := NewRowHandler(columns, sources, parser, nginx.NewTypeCaster(casterCfg))
+	rowHandler.routes = routes
+	rowHandler.filter = rowFilter
+	rowHandler.privacy, err = privacy.New(&cfg.Privacy)
+	if err != nil {
+		return nil, err
+	}
+	fields := rowHandler.fields()
+	if enricher := nginx.NewRequestEnricher(fields); enricher != nil {
+		rowHandler.enrichers = append(rowHandler.enrichers, enricher)
+	}
+	uaEnricher, err := useragent.NewEnricher(cfg.UserAgent.Database, cfg.UserAgent.CacheSize, fields)
+	if err != nil {
+		return nil, err
+	}
+	if uaEnricher != nil {
+		rowHandler.enrichers = append(rowHandler.enrichers, uaEnricher)
+	}
+	return rowHandler, nil
+}
+
+// fields referred by columns, filter, sample and routes
+func (r *RowHandler) fields() []string {
+	var fields []string
+	for _, source := range r.sources {
+		fields = append(fields, expr.Fields(source)...)
+	}
+	for _, route := range r.routes {
+		fields = append(fields, route.predicate.Fields()...)
+	}
+	for _, predicate := range append(append([]*expr.Predicate(nil), r.filter.include...), r.filter.exclude...) {
+		fields = append(fields, predicate.Fields()...)
+	}
+	if r.filter.sampleKey != nil {
+		fields = append(fields, expr.Fields(r.filter.sampleKey)...)
+	}
+	return fields
+}
+
+// Redact applies privacy rules to raw line
+func (r *RowHandler) Redact(line string) string {
+	if r.privacy == nil {
+		return line
+	}
+	return r.redact(line, line, nil)
+}
+
+// redact applies privacy rules to text of content, e.g. content itself or message of its error,
+// content is parsed again to find values of pseudonymized fields
+func (r *RowHandler) redact(text, content string, fields nginx.Fields) string {
+	entry, err := r.parser.ParseString(content)
+	if err != nil {
+		return r.privacy.ScrubLine(text, nil)
+	}
+	for name, value := range fields {
+		if _, ok := entry.Fields()[name]; !ok {
+			entry.SetField(name, value)
+		}
+	}
+	return r.privacy.ScrubLine(text, entry)
+}
+
+// Columns returns columns of table in the order of values in vector
+func (r *RowHandler) Columns() []string {
+	return r.columns
+}
+
+// Types returns clickhouse types of values of columns, string values of expressions are casted by column name
+func (r *RowHandler) Types() map[string]string {
+	types := make(map[string]string, len(r.columns))
+	for _, column := range r.columns {
+		valueType := expr.Type(r.sources[column])
+		if valueType == "" {
+			valueType = r.typeCaster.Type(column)
+		}
+		types[column] = valueType
+	}
+	return types
+}
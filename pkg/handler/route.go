@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"fmt"
+
+	clickhousebuffer "github.com/zikwall/clickhouse-buffer/v4"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/expr"
+	"github.com/zikwall/grower/pkg/metrics"
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+// routeDropped table label of dropped rows in metrics
+const routeDropped = "drop"
+
+// Route of rows which match predicate, it is returned in row by handler, dropped row has no values
+type Route struct {
+	Table     string
+	Drop      bool
+	predicate *expr.Predicate
+}
+
+func compileRoutes(routes []config.Route, env *expr.Env) ([]*Route, error) {
+	compiled := make([]*Route, 0, len(routes))
+	for i, route := range routes {
+		predicate, err := expr.CompilePredicate(route.Match, env)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %w", i, err)
+		}
+		compiled = append(compiled, &Route{Table: route.Table, Drop: route.Drop, predicate: predicate})
+	}
+	return compiled, nil
+}
+
+// route the first route which matches entry, it is nil if entry matches no route
+func (r *RowHandler) route(entry *nginx.LogEntry) (*Route, error) {
+	for _, route := range r.routes {
+		matched, err := route.predicate.Match(entry)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			return route, nil
+		}
+	}
+	return nil, nil
+}
+
+// Tables of routes, rows are written to them besides logs table
+func (r *RowHandler) Tables() []string {
+	var tables []string
+	seen := map[string]struct{}{}
+	for _, route := range r.routes {
+		if _, ok := seen[route.Table]; ok || route.Drop {
+			continue
+		}
+		seen[route.Table] = struct{}{}
+		tables = append(tables, route.Table)
+	}
+	return tables
+}
+
+// RowWriter writes handled rows, mark of checkpoint is attached to written row and acknowledged after insert,
+// mark of row which is not written is acknowledged at once, mark may be nil
+type RowWriter interface {
+	Write(row *Row, mark *checkpoint.Mark)
+}
+
+// Writer writes rows to writers of tables of their routes, rows without route are written to writer of logs table
+type Writer struct {
+	writer clickhousebuffer.Writer
+	tables map[string]clickhousebuffer.Writer
+}
+
+// NewWriter creates writers of route tables of row handler by newWriter, writer is used for rows without route
+func NewWriter(
+	rowHandler *RowHandler,
+	writer clickhousebuffer.Writer,
+	newWriter func(table string) clickhousebuffer.Writer,
+) *Writer {
+	w := &Writer{
+		writer: writer,
+		tables: map[string]clickhousebuffer.Writer{},
+	}
+	for _, table := range rowHandler.Tables() {
+		w.tables[table] = newWriter(table)
+	}
+	return w
+}
+
+func (w *Writer) Write(row *Row, mark *checkpoint.Mark) {
+	if row.Filtered != "" {
+		mark.Ack()
+		metrics.RowsFiltered.WithLabelValues(row.Filtered).Inc()
+		return
+	}
+	if row.Route != nil && row.Route.Drop {
+		mark.Ack()
+		metrics.RowsRouted.WithLabelValues(routeDropped).Inc()
+		return
+	}
+	vector := row.Vector
+	if mark != nil {
+		vector = checkpoint.Attach(vector, mark)
+	}
+	if row.Route != nil {
+		metrics.RowsRouted.WithLabelValues(row.Route.Table).Inc()
+		w.tables[row.Route.Table].WriteVector(vector)
+	} else {
+		w.writer.WriteVector(vector)
+	}
+	metrics.VectorsWritten.Inc()
+}
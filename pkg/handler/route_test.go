@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"testing"
+
+	clickhousebuffer "github.com/zikwall/clickhouse-buffer/v4"
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/checkpoint"
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+// fakeWriter collects vectors written to table
+type fakeWriter struct {
+	vectors []cx.Vector
+}
+
+func (f *fakeWriter) WriteRow(_ cx.Vectorable) {}
+
+func (f *fakeWriter) WriteVector(vector cx.Vector) {
+	f.vectors = append(f.vectors, vector)
+}
+
+func (f *fakeWriter) Errors() <-chan error {
+	return nil
+}
+
+func (f *fakeWriter) Close() {}
+
+// newTestHandler creates row handler of JSON lines with given scheme
+func newTestHandler(t *testing.T, scheme config.Scheme) *RowHandler {
+	t.Helper()
+	parser, err := nginx.NewParser(nginx.LogTypeJSON, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rowHandler, err := New(&config.Config{Scheme: scheme}, parser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rowHandler
+}
+
+// newTestWriter creates writer of row handler, writers of tables are collected by names of tables
+func newTestWriter(rowHandler *RowHandler) (*Writer, map[string]*fakeWriter) {
+	writers := map[string]*fakeWriter{"logs": {}}
+	writer := NewWriter(rowHandler, writers["logs"], func(table string) clickhousebuffer.Writer {
+		writers[table] = &fakeWriter{}
+		return writers[table]
+	})
+	return writer, writers
+}
+
+func TestRoutes(t *testing.T) {
+	rowHandler := newTestHandler(t, config.Scheme{
+		LogsTable: "logs",
+		Columns:   map[string]string{"status": "status"},
+		Routes: []config.Route{
+			{Match: "http_user_agent =~ '/(?i)bot/'", Drop: true},
+			{Match: "status >= 500", Table: "errors"},
+			{Match: "request_time > 1.5", Table: "slow"},
+			{Match: "status == 504", Table: "timeouts"},
+		},
+	})
+
+	t.Run("it should be created writers of route tables", func(t *testing.T) {
+		tables := rowHandler.Tables()
+		if len(tables) != 3 || tables[0] != "errors" || tables[1] != "slow" || tables[2] != "timeouts" {
+			t.Fatalf("expect tables of routes which are not dropped, got %v", tables)
+		}
+	})
+
+	t.Run("it should be written rows to tables of the first matched routes", func(t *testing.T) {
+		for _, c := range []struct {
+			name  string
+			line  string
+			table string
+		}{
+			{"row matches no route", `{"status":200,"request_time":0.1,"http_user_agent":"curl"}`, "logs"},
+			{"server error", `{"status":502,"request_time":0.1,"http_user_agent":"curl"}`, "errors"},
+			{"slow request", `{"status":200,"request_time":2.5,"http_user_agent":"curl"}`, "slow"},
+			{"the first route has priority", `{"status":504,"request_time":2.5,"http_user_agent":"curl"}`, "errors"},
+			{"dropped row", `{"status":502,"request_time":0.1,"http_user_agent":"Googlebot/2.1"}`, ""},
+		} {
+			writer, writers := newTestWriter(rowHandler)
+			row, err := rowHandler.Handle(c.line)
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			acked := false
+			writer.Write(row, checkpoint.NewMark(func() {
+				acked = true
+			}))
+			for table, w := range writers {
+				expect := 0
+				if table == c.table {
+					expect = 1
+				}
+				if len(w.vectors) != expect {
+					t.Fatalf("%s: expect %d rows in %s, got %v", c.name, expect, table, w.vectors)
+				}
+			}
+			if c.table == "" {
+				if !acked || row.Route == nil || !row.Route.Drop || row.Vector != nil {
+					t.Fatalf("%s: expect acknowledged dropped row without values, got %+v", c.name, row)
+				}
+				continue
+			}
+			vector := writers[c.table].vectors[0]
+			if len(vector) != 2 || vector[0] != row.Vector[0] {
+				t.Fatalf("%s: expect status with mark, got %v", c.name, vector)
+			}
+			if _, ok := vector[1].(*checkpoint.Mark); !ok || acked {
+				t.Fatalf("%s: expect mark is attached and not acknowledged before insert, got %v", c.name, vector)
+			}
+		}
+	})
+
+	t.Run("it should be written all rows to logs table without routes", func(t *testing.T) {
+		rowHandler := newTestHandler(t, config.Scheme{
+			LogsTable: "logs",
+			Columns:   map[string]string{"status": "status"},
+		})
+		writer, writers := newTestWriter(rowHandler)
+		for _, line := range []string{`{"status":200}`, `{"status":502}`} {
+			row, err := rowHandler.Handle(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if row.Route != nil {
+				t.Fatalf("expect no route, got %v", row.Route)
+			}
+			writer.Write(row, nil)
+		}
+		if len(writers) != 1 || len(writers["logs"].vectors) != 2 {
+			t.Fatalf("expect all rows in logs table, got %v", writers)
+		}
+		if vector := writers["logs"].vectors[1]; len(vector) != 1 || vector[0] != uint16(502) {
+			t.Fatalf("expect status without mark, got %v", vector)
+		}
+	})
+}
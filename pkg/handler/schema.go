@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/nginx"
+	"github.com/zikwall/grower/pkg/schema"
+)
+
+// Validate checks columns of config and its pipelines against tables of ClickHouse,
+// virtual fields are added to parsed fields by transport, see schema.Scheme, all problems are reported by one error
+func Validate(ctx context.Context, options *clickhouse.Options, cfg *config.Config, virtual ...string) error {
+	conn, err := clickhouse.Open(options)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	configs := []*config.Config{cfg}
+	if len(cfg.Pipelines) > 0 {
+		configs = configs[:0]
+		for i := range cfg.Pipelines {
+			configs = append(configs, cfg.Pipeline(&cfg.Pipelines[i]))
+		}
+	}
+	var problems []string
+	for _, c := range configs {
+		parser, err := nginx.NewParser(c.Nginx.LogType, c.Nginx.LogFormat)
+		if err != nil {
+			return err
+		}
+		rowHandler, err := New(c, parser)
+		if err != nil {
+			return err
+		}
+		scheme := &schema.Scheme{
+			Columns: rowHandler.columns,
+			Sources: rowHandler.sources,
+			Caster:  rowHandler.typeCaster,
+			Parser:  rowHandler.parser,
+			Virtual: append(append([]string(nil), virtual...), derived(rowHandler)...),
+		}
+		tables := append([]string{c.Scheme.LogsTable}, rowHandler.Tables()...)
+		// rows of syslog tags are routed to tables with the same columns as logs table
+		if len(cfg.Pipelines) == 0 {
+			for _, table := range c.Syslog.TagTables {
+				tables = append(tables, table)
+			}
+		}
+		checked := map[string]struct{}{}
+		for _, table := range tables {
+			if _, ok := checked[table]; ok {
+				continue
+			}
+			checked[table] = struct{}{}
+			columns, err := schema.Columns(ctx, conn, table)
+			if err != nil {
+				return err
+			}
+			problems = append(problems, schema.Check(table, columns, scheme)...)
+		}
+	}
+	if len(problems) > 0 {
+		return &schema.Error{Problems: problems}
+	}
+	return nil
+}
+
+// derived fields of enrichers are known if any of their sources appears in log format
+func derived(rowHandler *RowHandler) []string {
+	var fields []string
+	parser, ok := rowHandler.parser.(nginx.FieldsParser)
+	for _, enricher := range rowHandler.enrichers {
+		if !ok || appears(enricher.Sources(), parser.Fields()) {
+			fields = append(fields, enricher.Fields()...)
+		}
+	}
+	return fields
+}
+
+func appears(sources, fields []string) bool {
+	for _, source := range sources {
+		for _, field := range fields {
+			if source == field {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+)
+
+var (
+	LinesRead = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grower_lines_read_total", Help: "Number of lines read from source",
+	}, []string{"source"})
+	LinesParsed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "grower_lines_parsed_total", Help: "Number of lines successfully parsed and cast",
+	})
+	LinesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grower_lines_failed_total", Help: "Number of lines failed parsing or casting by error type",
+	}, []string{"error"})
+	VectorsWritten = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "grower_vectors_written_total", Help: "Number of vectors written to clickhouse buffer",
+	})
+	FlushDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "grower_clickhouse_flush_duration_seconds", Help: "Clickhouse batch insert latency",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"status"})
+	FlushBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "grower_clickhouse_flush_batch_size", Help: "Number of rows in clickhouse batch insert",
+		Buckets: prometheus.ExponentialBuckets(10, 2, 14),
+	})
+	RotationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "grower_rotation_duration_seconds", Help: "Duration of log file rotation and handling",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"status"})
+	ChannelBacklog = NewGaugeFuncVec(
+		"grower_channel_backlog", "Number of items waiting in internal channel", "channel",
+	)
+	SyslogClients = NewGaugeFuncVec(
+		"grower_syslog_clients", "Number of open connections of syslog TCP and TLS listeners",
+	)
+	GRPCStreams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grower_grpc_streams", Help: "Number of active gRPC streams",
+	}, []string{"side"})
+	GRPCBatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grower_grpc_batches_total", Help: "Number of gRPC batches by operation",
+	}, []string{"op"})
+	KafkaLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grower_kafka_lag", Help: "Kafka reader lag",
+	}, []string{"reader"})
+	IngestRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grower_ingest_rejected_total", Help: "Number of rejected gRPC streams, syslog connections and datagrams by reason",
+	}, []string{"source", "reason"})
+	SpoolBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "grower_spool_bytes", Help: "Size of batches waiting on disk for clickhouse",
+	})
+	SpoolRows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grower_spool_rows_total", Help: "Number of rows written to disk spool, replayed or dropped by limits",
+	}, []string{"op"})
+	RowsRouted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grower_rows_routed_total", Help: "Number of rows matched by routes of scheme by table, dropped rows have table drop",
+	}, []string{"table"})
+	RowsFiltered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grower_rows_filtered_total", Help: "Number of rows filtered out by include or exclude predicates or by sample",
+	}, []string{"reason"})
+)
+
+// Default registry contains all grower metrics
+var Default = prometheus.NewRegistry()
+
+func init() {
+	Default.MustRegister(
+		LinesRead,
+		LinesParsed,
+		LinesFailed,
+		VectorsWritten,
+		FlushDuration,
+		FlushBatchSize,
+		RotationDuration,
+		ChannelBacklog,
+		SyslogClients,
+		GRPCStreams,
+		GRPCBatches,
+		KafkaLag,
+		IngestRejected,
+		SpoolBytes,
+		SpoolRows,
+		RowsRouted,
+		RowsFiltered,
+	)
+}
+
+// Handler exposes default registry on fiber HTTP server in text format
+func Handler(ctx *fiber.Ctx) error {
+	families, err := Default.Gather()
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	format := expfmt.FmtText
+	encoder := expfmt.NewEncoder(buf, format)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+	ctx.Set(fiber.HeaderContentType, string(format))
+	return ctx.Send(buf.Bytes())
+}
+
+// Clickhouse measures latency and size of batches, which buffer flushes to clickhouse
+type Clickhouse struct {
+	cx.Clickhouse
+}
+
+func (c *Clickhouse) Insert(ctx context.Context, view cx.View, rows []cx.Vector) (uint64, error) {
+	start := time.Now()
+	affected, err := c.Clickhouse.Insert(ctx, view, rows)
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	FlushDuration.WithLabelValues(status).Observe(time.Since(start).Seconds())
+	FlushBatchSize.Observe(float64(len(rows)))
+	return affected, err
+}
+
+func NewClickhouse(conn cx.Clickhouse) *Clickhouse {
+	return &Clickhouse{Clickhouse: conn}
+}
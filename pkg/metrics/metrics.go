@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GaugeFuncVec gauge, which values are calculated by callbacks at collection time,
+// callbacks registered with the same label values are summed, e.g. backlogs of channels of several servers
+type GaugeFuncVec struct {
+	desc   *prometheus.Desc
+	labels int
+	mu     sync.Mutex
+	funcs  map[string]*gaugeFuncs
+}
+
+type gaugeFuncs struct {
+	values []string
+	funcs  map[int]func() float64
+	next   int
+}
+
+// Func registers callback for label values, returned function removes callback, e.g. when owner is dropped
+func (g *GaugeFuncVec) Func(fn func() float64, values ...string) (remove func()) {
+	if len(values) != g.labels {
+		panic(fmt.Sprintf("gauge expects %d label values, receive %d", g.labels, len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	set, ok := g.funcs[key]
+	if !ok {
+		set = &gaugeFuncs{values: values, funcs: map[int]func() float64{}}
+		g.funcs[key] = set
+	}
+	id := set.next
+	set.next++
+	set.funcs[id] = fn
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		delete(set.funcs, id)
+	}
+}
+
+func (g *GaugeFuncVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+func (g *GaugeFuncVec) Collect(ch chan<- prometheus.Metric) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, set := range g.funcs {
+		if len(set.funcs) == 0 {
+			continue
+		}
+		var sum float64
+		for _, fn := range set.funcs {
+			sum += fn()
+		}
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, sum, set.values...)
+	}
+}
+
+func NewGaugeFuncVec(name, help string, labels ...string) *GaugeFuncVec {
+	return &GaugeFuncVec{
+		desc:   prometheus.NewDesc(name, help, labels, nil),
+		labels: len(labels),
+		funcs:  map[string]*gaugeFuncs{},
+	}
+}
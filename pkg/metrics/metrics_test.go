@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGaugeFuncVec(t *testing.T) {
+	t.Run("it should be successfully sum callbacks of the same label values", func(t *testing.T) {
+		gauge := NewGaugeFuncVec("test_backlog", "Backlog", "channel")
+		removeFirst := gauge.Func(func() float64 {
+			return 40
+		}, "syslog")
+		removeSecond := gauge.Func(func() float64 {
+			return 2
+		}, "syslog")
+		expected := strings.Join([]string{
+			"# HELP test_backlog Backlog",
+			"# TYPE test_backlog gauge",
+			`test_backlog{channel="syslog"} 42`,
+		}, "\n") + "\n"
+		if err := testutil.CollectAndCompare(gauge, strings.NewReader(expected)); err != nil {
+			t.Fatal(err)
+		}
+		removeFirst()
+		if value := testutil.ToFloat64(gauge); value != 2 {
+			t.Fatalf("failed, expect 2, receive %v", value)
+		}
+		removeSecond()
+		if count := testutil.CollectAndCount(gauge); count != 0 {
+			t.Fatalf("failed, expect no metrics, receive %d", count)
+		}
+	})
+}
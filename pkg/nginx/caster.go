@@ -13,6 +13,8 @@ const (
 
 type TypeCaster interface {
 	TryCast(key, value string) (interface{}, error)
+	// Type of values casted for key, it is one of clickhouse native types
+	Type(key string) string
 }
 
 type CasterCfg struct {
@@ -103,7 +105,7 @@ func (c *caster) TryCast(key, value string) (interface{}, error) {
 			case Date:
 				return parseDateTime(value, defaultDateFormat)
 			case DateTime, DatetimeCustom:
-				return parseDateTime(value, defaultDatetimeFormat)
+				return c.parseCustomDateTime(value)
 			}
 			// special case for clickhouse FixedString type
 			if isFixedString(custom) && value != "" {
@@ -114,6 +116,51 @@ func (c *caster) TryCast(key, value string) (interface{}, error) {
 	return c.nnv(key, value)
 }
 
+// nolint:gocyclo // it's ok, it follows TryCast
+func (c *caster) Type(key string) string {
+	if c.cfg.CustomCastsEnable && c.hasCustoms {
+		if custom, ok := c.cfg.CustomCasts[key]; ok {
+			switch custom {
+			case UInt8, UInt16, UInt32, UInt64, Int8, Int16, Int32, Int64, Float32, Float64, String, Date, DateTime:
+				return custom
+			case IntegerCustom:
+				return Int32
+			case DatetimeCustom:
+				return DateTime
+			}
+			if isFixedString(custom) {
+				return String
+			}
+		}
+	}
+	switch key {
+	case TimeLocal, TimeISO8601, ErrorTime:
+		return DateTime
+	case UAIsBot:
+		return UInt8
+	case Status:
+		return UInt16
+	case BytesSent, BodyBytesSent, ErrorPID:
+		return UInt32
+	case ConnectionsWaiting, ConnectionsActive, Connection, RequestLength:
+		return Int32
+	case RequestTime, UpstreamConnectTime, UpstreamHeaderTime, UpstreamResponseTime, MSec:
+		return Float32
+	case ErrorTID, ErrorCID:
+		return UInt64
+	}
+	return String
+}
+
+// parseCustomDateTime values of custom casts may be in the local time format of log, e.g. toDateTime(time_local)
+func (c *caster) parseCustomDateTime(value string) (time.Time, error) {
+	parsed, err := parseDateTime(value, defaultDatetimeFormat)
+	if err != nil && c.cfg.LocalTimeFormat != "" {
+		return parseDateTime(value, c.cfg.LocalTimeFormat)
+	}
+	return parsed, err
+}
+
 // nnv - nginx native value
 func (c *caster) nnv(key, value string) (interface{}, error) {
 	switch key {
@@ -135,6 +182,14 @@ func (c *caster) nnv(key, value string) (interface{}, error) {
 		return parseInt32(value)
 	case RequestTime, UpstreamConnectTime, UpstreamHeaderTime, UpstreamResponseTime, MSec:
 		return parseFloat32(value)
+	case ErrorTime:
+		return parseLocalDateTime(value, errorTimeFormat)
+	case ErrorPID:
+		return parseUInt32(value)
+	case ErrorTID, ErrorCID:
+		return parseUInt64(value)
+	case UAIsBot:
+		return parseUInt8(value)
 	}
 	return value, nil
 }
@@ -301,6 +356,18 @@ func parseDateTime(value, format string) (time.Time, error) {
 	return parsedTime, nil
 }
 
+// parseLocalDateTime time without zone is parsed in local time zone, e.g. time of error.log
+func parseLocalDateTime(value, format string) (time.Time, error) {
+	if value == "" {
+		return time.Now(), nil
+	}
+	parsedTime, err := time.ParseInLocation(format, value, time.Local)
+	if err != nil {
+		return parsedTime, ErrCanNotParseTime
+	}
+	return parsedTime, nil
+}
+
 func NewTypeCaster(cfg *CasterCfg) TypeCaster {
 	return &caster{
 		cfg:        cfg,
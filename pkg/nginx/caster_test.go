@@ -205,4 +205,27 @@ func TestCasterTryCast(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("it should be reported types of casted values", func(t *testing.T) {
+		typeCaster := NewTypeCaster(&CasterCfg{
+			CustomCasts:       map[string]string{"t1": "Integer", "t2": "FixedString(2)", "status": "UInt8"},
+			CustomCastsEnable: true,
+		})
+		for key, expect := range map[string]string{
+			TimeLocal:   DateTime,
+			Status:      UInt8,
+			BytesSent:   UInt32,
+			RequestTime: Float32,
+			RemoteAddr:  String,
+			ErrorCID:    UInt64,
+			UAIsBot:     UInt8,
+			"t1":        Int32,
+			"t2":        String,
+			"unknown":   String,
+		} {
+			if receive := typeCaster.Type(key); receive != expect {
+				t.Fatalf("failed for %s, expect %s, receive %s", key, expect, receive)
+			}
+		}
+	})
 }
@@ -17,6 +17,28 @@ const (
 	HTTPS         = "https"
 )
 
+// Fields derived from request line or request_uri, see RequestEnricher, arguments of query are named as in nginx,
+// e.g. arg_utm_source, numeric and UUID segments of normalized path are replaced by PathID and PathUUID
+const (
+	RequestURI            = "request_uri"
+	RequestPath           = "request_path"
+	RequestQuery          = "request_query"
+	RequestProtocol       = "request_protocol"
+	RequestPathNormalized = "request_path_normalized"
+	QueryArgPrefix        = "arg_"
+	PathID                = "{id}"
+	PathUUID              = "{uuid}"
+)
+
+// Fields of parsed http_user_agent, see useragent package, ua_is_bot is UInt8 1 or 0
+const (
+	UABrowser        = "ua_browser"
+	UABrowserVersion = "ua_browser_version"
+	UAOS             = "ua_os"
+	UADeviceType     = "ua_device_type"
+	UAIsBot          = "ua_is_bot"
+)
+
 // Integer constants
 const (
 	ConnectionsWaiting = "connections_waiting"
@@ -42,3 +64,18 @@ const (
 	UpstreamResponseTime = "upstream_response_time"
 	MSec                 = "msec"
 )
+
+// Error log constants, see LogTypeError, time is DateTime, pid is UInt32, tid and connection number are UInt64
+const (
+	ErrorTime       = "error_time"
+	ErrorLevel      = "error_level"
+	ErrorPID        = "error_pid"
+	ErrorTID        = "error_tid"
+	ErrorCID        = "error_cid"
+	ErrorMessage    = "error_message"
+	ErrorClient     = "client"
+	ErrorServer     = "server"
+	ErrorSubrequest = "subrequest"
+	ErrorUpstream   = "upstream"
+	ErrorHost       = "host"
+)
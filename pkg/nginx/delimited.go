@@ -0,0 +1,96 @@
+package nginx
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+const (
+	commaSeparator = ','
+	tabSeparator   = '\t'
+)
+
+// Delimited parser of CSV and TSV lines, field names are taken from `log_format` by column position, e.g.:
+// log_format csv '$remote_addr,$remote_user,"$request",$status';
+type Delimited struct {
+	names     []string
+	separator rune
+}
+
+func (d *Delimited) ParseString(line string) (entry *LogEntry, err error) {
+	values, err := d.split(line)
+	if err != nil {
+		return nil, fmt.Errorf("access log line '%v' can't be split: %w", line, err)
+	}
+	if len(values) != len(d.names) {
+		err = fmt.Errorf("access log line '%v' has %d columns, expected %d", line, len(values), len(d.names))
+		return nil, err
+	}
+	entry = NewEntry()
+	for i, name := range d.names {
+		if name == "" {
+			continue
+		}
+		entry.SetField(name, values[i])
+	}
+	return entry, nil
+}
+
+func (d *Delimited) Fields() []string {
+	names := make([]string, 0, len(d.names))
+	for _, name := range d.names {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (d *Delimited) split(line string) ([]string, error) {
+	// nginx does not quote values itself, so TSV line can contain any quotes inside values
+	if d.separator == tabSeparator {
+		values := strings.Split(line, string(tabSeparator))
+		for i := range values {
+			values[i] = unquote(values[i])
+		}
+		return values, nil
+	}
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.Comma = d.separator
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	return reader.Read()
+}
+
+func NewCSVParser(format string) (*Delimited, error) {
+	return newDelimited(format, commaSeparator)
+}
+
+func NewTSVParser(format string) (*Delimited, error) {
+	return newDelimited(format, tabSeparator)
+}
+
+func newDelimited(format string, separator rune) (*Delimited, error) {
+	d := &Delimited{separator: separator}
+	columns, err := d.split(format)
+	if err != nil {
+		return nil, fmt.Errorf("can't split log format '%v': %w", format, err)
+	}
+	d.names = make([]string, len(columns))
+	for i, column := range columns {
+		column = strings.TrimSpace(column)
+		// columns with constant values in format are skipped
+		if strings.HasPrefix(column, "$") {
+			d.names[i] = strings.Trim(column[1:], "{}")
+		}
+	}
+	return d, nil
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,172 @@
+package nginx
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Enricher adds fields derived from parsed fields, e.g. parts of request
+type Enricher interface {
+	Enrich(entry *LogEntry)
+	// Fields derived by enricher
+	Fields() []string
+	// Sources fields of which derived fields are made, derived fields are known if any of them is in log format
+	Sources() []string
+}
+
+// RequestEnricher derives fields of request from request line, e.g. GET /users/42?page=2 HTTP/1.1,
+// or from request_uri if there is no request in log format: method, path, query, protocol, arguments of query
+// and normalized path. Only referenced fields are derived, fields of log format are not replaced,
+// derived fields of malformed request are empty
+type RequestEnricher struct {
+	fields []string
+	// names of referenced derived fields and arguments of query without prefix
+	derived map[string]struct{}
+	args    map[string]string
+}
+
+// NewRequestEnricher creates enricher of referenced fields, it is nil if none of them is derived from request
+func NewRequestEnricher(referenced []string) *RequestEnricher {
+	e := &RequestEnricher{derived: map[string]struct{}{}, args: map[string]string{}}
+	for _, field := range referenced {
+		if _, ok := e.derived[field]; ok {
+			continue
+		}
+		switch {
+		case field == RequestMethod, field == RequestPath, field == RequestQuery,
+			field == RequestProtocol, field == RequestPathNormalized:
+		case strings.HasPrefix(field, QueryArgPrefix) && len(field) > len(QueryArgPrefix):
+			e.args[field[len(QueryArgPrefix):]] = field
+		default:
+			continue
+		}
+		e.derived[field] = struct{}{}
+		e.fields = append(e.fields, field)
+	}
+	if len(e.fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Fields derived by enricher
+func (e *RequestEnricher) Fields() []string {
+	return e.fields
+}
+
+func (e *RequestEnricher) Sources() []string {
+	return []string{Request, RequestURI}
+}
+
+// Enrich adds derived fields to entry
+func (e *RequestEnricher) Enrich(entry *LogEntry) {
+	var method, uri, protocol string
+	if request, ok := entry.fields[Request]; ok {
+		method, uri, protocol = SplitRequest(request)
+	} else {
+		uri = entry.fields[RequestURI]
+	}
+	path, query, _ := strings.Cut(uri, "?")
+	e.set(entry, RequestMethod, method)
+	e.set(entry, RequestPath, path)
+	e.set(entry, RequestQuery, query)
+	e.set(entry, RequestProtocol, protocol)
+	if _, ok := e.derived[RequestPathNormalized]; ok {
+		e.set(entry, RequestPathNormalized, NormalizePath(path))
+	}
+	if len(e.args) == 0 {
+		return
+	}
+	for _, field := range e.args {
+		e.set(entry, field, "")
+	}
+	for query != "" {
+		var pair string
+		pair, query, _ = strings.Cut(query, "&")
+		name, value, _ := strings.Cut(pair, "=")
+		field, ok := e.args[name]
+		if !ok || entry.fields[field] != "" {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		entry.fields[field] = value
+	}
+}
+
+// set field if it is referenced and is not set by parser
+func (e *RequestEnricher) set(entry *LogEntry, field, value string) {
+	if _, ok := e.derived[field]; !ok {
+		return
+	}
+	if _, ok := entry.fields[field]; !ok {
+		entry.fields[field] = value
+	}
+}
+
+// SplitRequest splits request line to method, URI and protocol, protocol is empty for HTTP/0.9 requests,
+// all parts are empty if request is malformed, e.g. it is hyphen or TLS handshake sent to plain HTTP port
+func SplitRequest(request string) (method, uri, protocol string) {
+	parts := strings.Split(request, " ")
+	if (len(parts) != 2 && len(parts) != 3) || !strings.HasPrefix(parts[1], "/") {
+		return "", "", ""
+	}
+	if len(parts) == 3 {
+		protocol = parts[2]
+	}
+	return parts[0], parts[1], protocol
+}
+
+// NormalizePath replaces numeric and UUID segments of path by placeholders to limit cardinality of paths,
+// e.g. /users/42/orders becomes /users/{id}/orders
+func NormalizePath(path string) string {
+	var b strings.Builder
+	b.Grow(len(path))
+	for i, segment := range strings.Split(path, "/") {
+		if i > 0 {
+			b.WriteByte('/')
+		}
+		switch {
+		case isNumeric(segment):
+			b.WriteString(PathID)
+		case isUUID(segment):
+			b.WriteString(PathUUID)
+		default:
+			b.WriteString(segment)
+		}
+	}
+	return b.String()
+}
+
+func isNumeric(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	for i := 0; i < len(segment); i++ {
+		if segment[i] < '0' || segment[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isUUID(segment string) bool {
+	if len(segment) != 36 {
+		return false
+	}
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package nginx
+
+import "testing"
+
+func TestRequestEnricher(t *testing.T) {
+	t.Run("it should be derived referenced fields of request", func(t *testing.T) {
+		enricher := NewRequestEnricher([]string{
+			RemoteAddr, RequestMethod, RequestPath, RequestQuery, RequestProtocol, RequestPathNormalized,
+			"arg_page", "arg_utm_source", "arg_missing",
+		})
+		entry := NewEntry()
+		entry.SetField(Request, "GET /users/42/orders/3f2b8c1e-9d4a-4e8b-a1c2-0123456789ab?page=2&utm_source=mail%20list HTTP/1.1")
+		enricher.Enrich(entry)
+		for field, expect := range map[string]string{
+			RequestMethod:         "GET",
+			RequestPath:           "/users/42/orders/3f2b8c1e-9d4a-4e8b-a1c2-0123456789ab",
+			RequestQuery:          "page=2&utm_source=mail%20list",
+			RequestProtocol:       "HTTP/1.1",
+			RequestPathNormalized: "/users/{id}/orders/{uuid}",
+			"arg_page":            "2",
+			"arg_utm_source":      "mail list",
+			"arg_missing":         "",
+		} {
+			if value, err := entry.Field(field); err != nil || value != expect {
+				t.Fatalf("%s: expect %q, got %q (%v)", field, expect, value, err)
+			}
+		}
+		if _, err := entry.Field(RemoteAddr); err == nil {
+			t.Fatal("expect not derived remote_addr")
+		}
+	})
+
+	t.Run("it should be derived from request_uri and not replaced fields of log format", func(t *testing.T) {
+		enricher := NewRequestEnricher([]string{RequestMethod, RequestPath, RequestProtocol})
+		entry := NewEntry()
+		entry.SetField(RequestURI, "/search?q=go")
+		entry.SetField(RequestMethod, "POST")
+		enricher.Enrich(entry)
+		for field, expect := range map[string]string{RequestMethod: "POST", RequestPath: "/search", RequestProtocol: ""} {
+			if value, _ := entry.Field(field); value != expect {
+				t.Fatalf("%s: expect %q, got %q", field, expect, value)
+			}
+		}
+	})
+
+	t.Run("it should be empty fields of malformed request", func(t *testing.T) {
+		enricher := NewRequestEnricher([]string{RequestMethod, RequestPath})
+		for _, request := range []string{"-", "\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03", "GET  HTTP/1.1"} {
+			entry := NewEntry()
+			entry.SetField(Request, request)
+			enricher.Enrich(entry)
+			if method, _ := entry.Field(RequestMethod); method != "" {
+				t.Fatalf("%q: expect empty method, got %q", request, method)
+			}
+			if path, err := entry.Field(RequestPath); err != nil || path != "" {
+				t.Fatalf("%q: expect empty path, got %q (%v)", request, path, err)
+			}
+		}
+	})
+
+	t.Run("it should be nil enricher without derived fields", func(t *testing.T) {
+		if NewRequestEnricher([]string{RemoteAddr, Status, "arg_"}) != nil {
+			t.Fatal("expect nil enricher")
+		}
+	})
+}
+
+func TestNormalizePath(t *testing.T) {
+	t.Run("it should be replaced numeric and UUID segments", func(t *testing.T) {
+		for path, expect := range map[string]string{
+			"/":                 "/",
+			"/api/v2/items/123": "/api/v2/items/{id}",
+			"/a/1/b/22/":        "/a/{id}/b/{id}/",
+			"/files/3F2B8C1E-9D4A-4E8B-A1C2-0123456789AB/download": "/files/{uuid}/download",
+			"/files/3f2b8c1e9d4a4e8ba1c20123456789ab":              "/files/3f2b8c1e9d4a4e8ba1c20123456789ab",
+			"/posts/12abc": "/posts/12abc",
+		} {
+			if normalized := NormalizePath(path); normalized != expect {
+				t.Fatalf("%s: expect %s, got %s", path, expect, normalized)
+			}
+		}
+	})
+}
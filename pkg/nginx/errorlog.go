@@ -0,0 +1,170 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LogTypeError log type of nginx error.log, its format is fixed, so `log_format` is not used for parsing, e.g.:
+// 2022/07/21 18:41:45 [error] 1234#5678: *91011 connect() failed while connecting to upstream, client: 10.0.0.1, ...
+const LogTypeError = "error"
+
+// errorTimeFormat layout of error.log timestamp, it is written in local time of nginx server
+const errorTimeFormat = "2006/01/02 15:04:05"
+
+type errorSuffix struct {
+	name  string
+	field string
+}
+
+// errorSuffixes of message in order of nginx, they are fields of entry,
+// `referrer` of error.log is the same as `$http_referer` of access.log
+var errorSuffixes = []errorSuffix{
+	{"client", ErrorClient},
+	{"server", ErrorServer},
+	{"request", Request},
+	{"subrequest", ErrorSubrequest},
+	{"upstream", ErrorUpstream},
+	{"host", ErrorHost},
+	{"referrer", HTTPReferer},
+}
+
+// errorSuffixIndex position of suffix in order of nginx, it is -1 for unknown suffix
+func errorSuffixIndex(name string) int {
+	for i, suffix := range errorSuffixes {
+		if suffix.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ErrorLog parser of nginx error.log lines with fields of timestamp, level, pid, tid, connection number,
+// message and its suffixes, fields which are absent in line are empty
+type ErrorLog struct{}
+
+func (e *ErrorLog) ParseString(line string) (entry *LogEntry, err error) {
+	if len(line) < len(errorTimeFormat)+1 || line[len(errorTimeFormat)] != ' ' {
+		return nil, fmt.Errorf("error log line '%v' has no timestamp", line)
+	}
+	entry = NewEntry()
+	for _, field := range []string{ErrorTime, ErrorLevel, ErrorPID, ErrorTID, ErrorCID, ErrorMessage} {
+		entry.SetField(field, "")
+	}
+	for _, suffix := range errorSuffixes {
+		entry.SetField(suffix.field, "")
+	}
+	entry.SetField(ErrorTime, line[:len(errorTimeFormat)])
+	rest := line[len(errorTimeFormat)+1:]
+	if !strings.HasPrefix(rest, "[") {
+		return nil, fmt.Errorf("error log line '%v' has no level", line)
+	}
+	level, rest, ok := strings.Cut(rest[1:], "] ")
+	if !ok {
+		return nil, fmt.Errorf("error log line '%v' has no level", line)
+	}
+	entry.SetField(ErrorLevel, level)
+	process, rest, ok := strings.Cut(rest, ": ")
+	if !ok {
+		return nil, fmt.Errorf("error log line '%v' has no pid#tid", line)
+	}
+	pid, tid, ok := strings.Cut(process, "#")
+	if !ok {
+		return nil, fmt.Errorf("error log line '%v' has no pid#tid", line)
+	}
+	entry.SetField(ErrorPID, pid)
+	entry.SetField(ErrorTID, tid)
+	// connection number is absent in messages which are not related to connection, e.g. signals
+	if strings.HasPrefix(rest, "*") {
+		if cid, message, ok := strings.Cut(rest[1:], " "); ok {
+			entry.SetField(ErrorCID, cid)
+			rest = message
+		}
+	}
+	message, suffixes := splitErrorSuffixes(rest)
+	entry.SetField(ErrorMessage, message)
+	if err = parseErrorSuffixes(entry, suffixes); err != nil {
+		return nil, fmt.Errorf("error log line '%v': %w", line, err)
+	}
+	return entry, nil
+}
+
+func (e *ErrorLog) Fields() []string {
+	names := []string{ErrorTime, ErrorLevel, ErrorPID, ErrorTID, ErrorCID, ErrorMessage}
+	for _, suffix := range errorSuffixes {
+		names = append(names, suffix.field)
+	}
+	return names
+}
+
+// splitErrorSuffixes message is separated from suffixes by the first known suffix, which starts
+// suffixes in order of nginx up to the end of line, so suffixes inside of message are not matched,
+// if there is no such suffix, the first known one is used and its error is returned by parsing
+func splitErrorSuffixes(message string) (string, string) {
+	first := -1
+	for pos := 0; pos < len(message); {
+		i := strings.Index(message[pos:], ", ")
+		if i < 0 {
+			break
+		}
+		pos += i
+		if name, _, ok := strings.Cut(message[pos+2:], ": "); ok && errorSuffixIndex(name) >= 0 {
+			if scanErrorSuffixes(message[pos:], func(_, _ string) {}) == nil {
+				return message[:pos], message[pos:]
+			}
+			if first < 0 {
+				first = pos
+			}
+		}
+		pos += 2
+	}
+	if first < 0 {
+		return message, ""
+	}
+	return message[:first], message[first:]
+}
+
+// parseErrorSuffixes sets fields of suffixes to entry
+func parseErrorSuffixes(entry *LogEntry, suffixes string) error {
+	return scanErrorSuffixes(suffixes, entry.SetField)
+}
+
+// scanErrorSuffixes parses `, name: value` pairs, known suffixes must follow in order of nginx,
+// quoted values may contain commas, quotes inside of them are escaped by nginx as \x22
+func scanErrorSuffixes(suffixes string, fn func(name, value string)) error {
+	next := 0
+	for suffixes != "" {
+		if !strings.HasPrefix(suffixes, ", ") {
+			return fmt.Errorf("suffix '%s' is not separated", suffixes)
+		}
+		name, rest, ok := strings.Cut(suffixes[2:], ": ")
+		if !ok {
+			return fmt.Errorf("suffix '%s' has no value", suffixes)
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return fmt.Errorf("value of suffix '%s' is not closed", name)
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else if end := strings.Index(rest, ", "); end >= 0 {
+			value, rest = rest[:end], rest[end:]
+		} else {
+			value, rest = rest, ""
+		}
+		if i := errorSuffixIndex(name); i >= 0 {
+			if i < next {
+				return fmt.Errorf("suffix '%s' is out of order", name)
+			}
+			name, next = errorSuffixes[i].field, i+1
+		}
+		fn(name, value)
+		suffixes = rest
+	}
+	return nil
+}
+
+func NewErrorLogParser() *ErrorLog {
+	return &ErrorLog{}
+}
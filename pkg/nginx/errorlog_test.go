@@ -0,0 +1,120 @@
+package nginx
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// nolint:lll // it's OK
+func TestErrorLogParseString(t *testing.T) {
+	t.Run("it should be parsed error log line with message suffixes", func(t *testing.T) {
+		entry, err := NewErrorLogParser().ParseString(
+			`2022/07/21 18:41:45 [error] 1234#5678: *91011 connect() failed (111: Connection refused) while connecting to upstream, client: 10.0.0.1, server: example.com, request: "GET /api?a=1,2 HTTP/1.1", upstream: "http://127.0.0.1:8080/api?a=1,2", host: "example.com", referrer: "https://example.com/"`,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := map[string]string{
+			ErrorTime:     "2022/07/21 18:41:45",
+			ErrorLevel:    "error",
+			ErrorPID:      "1234",
+			ErrorTID:      "5678",
+			ErrorCID:      "91011",
+			ErrorMessage:  "connect() failed (111: Connection refused) while connecting to upstream",
+			ErrorClient:   "10.0.0.1",
+			ErrorServer:   "example.com",
+			Request:       "GET /api?a=1,2 HTTP/1.1",
+			ErrorUpstream: "http://127.0.0.1:8080/api?a=1,2",
+			ErrorHost:     "example.com",
+			HTTPReferer:   "https://example.com/",
+		}
+		for key, value := range expect {
+			if field, _ := entry.Field(key); field != value {
+				t.Fatalf("failed for key %s, expect %v, receive %v", key, value, field)
+			}
+		}
+	})
+
+	t.Run("it should not be matched suffixes inside of message", func(t *testing.T) {
+		entry, err := NewErrorLogParser().ParseString(
+			`2022/07/21 18:41:45 [error] 1#1: *2 open() "/var/www/a, host: b" failed (2: No such file or directory), client: 10.0.0.1, server: example.com, request: "GET /a, host: b HTTP/1.1", host: "example.com"`,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range map[string]string{
+			ErrorMessage: `open() "/var/www/a, host: b" failed (2: No such file or directory)`,
+			ErrorClient:  "10.0.0.1",
+			ErrorServer:  "example.com",
+			Request:      "GET /a, host: b HTTP/1.1",
+			ErrorHost:    "example.com",
+		} {
+			if field, _ := entry.Field(key); field != value {
+				t.Fatalf("failed for key %s, expect %v, receive %v", key, value, field)
+			}
+		}
+	})
+
+	t.Run("it should be returned fields in order of nginx", func(t *testing.T) {
+		expect := []string{
+			ErrorTime, ErrorLevel, ErrorPID, ErrorTID, ErrorCID, ErrorMessage,
+			ErrorClient, ErrorServer, Request, ErrorSubrequest, ErrorUpstream, ErrorHost, HTTPReferer,
+		}
+		if fields := NewErrorLogParser().Fields(); !reflect.DeepEqual(fields, expect) {
+			t.Fatalf("failed, expect %v, receive %v", expect, fields)
+		}
+	})
+
+	t.Run("it should be empty fields of line without connection and suffixes", func(t *testing.T) {
+		entry, err := NewErrorLogParser().ParseString(`2022/07/21 18:41:45 [notice] 1#1: signal process started`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range map[string]string{
+			ErrorLevel:    "notice",
+			ErrorCID:      "",
+			ErrorMessage:  "signal process started",
+			ErrorClient:   "",
+			ErrorUpstream: "",
+		} {
+			field, err := entry.Field(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if field != value {
+				t.Fatalf("failed for key %s, expect %v, receive %v", key, value, field)
+			}
+		}
+	})
+
+	t.Run("it should be failed malformed lines", func(t *testing.T) {
+		for _, line := range []string{
+			``,
+			`2022/07/21 18:41:45 error 1#1: message`,
+			`2022/07/21 18:41:45 [error] message`,
+			`2022/07/21 18:41:45 [error] 1#1: failed, client: 10.0.0.1, request: "GET / HTTP/1.1`,
+		} {
+			if _, err := NewErrorLogParser().ParseString(line); err == nil {
+				t.Fatalf("expect error for line '%s', receive nil", line)
+			}
+		}
+	})
+
+	t.Run("it should be casted typed fields of error log", func(t *testing.T) {
+		typeCaster := NewTypeCaster(&CasterCfg{})
+		errorTime, err := typeCaster.TryCast(ErrorTime, "2022/07/21 18:41:45")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := time.Date(2022, 7, 21, 18, 41, 45, 0, time.Local); !errorTime.(time.Time).Equal(expect) {
+			t.Fatalf("failed, expect %v, receive %v", expect, errorTime)
+		}
+		if pid, err := typeCaster.TryCast(ErrorPID, "1234"); err != nil || pid != uint32(1234) {
+			t.Fatalf("failed, expect uint32 1234, receive %v, %v", pid, err)
+		}
+		if cid, err := typeCaster.TryCast(ErrorCID, "91011"); err != nil || cid != uint64(91011) {
+			t.Fatalf("failed, expect uint64 91011, receive %v, %v", cid, err)
+		}
+	})
+}
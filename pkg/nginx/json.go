@@ -0,0 +1,116 @@
+package nginx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// LogTypeJSON log type for nginx formats declared with `escape=json` parameter, e.g.:
+// log_format json_combined escape=json '{"remote_addr":"$remote_addr","status":$status}';
+const LogTypeJSON = "json"
+
+// nestedFieldSeparator joins the keys of nested JSON objects into one flat field name,
+// for example {"upstream":{"addr":"127.0.0.1"}} can be accessed as `upstream.addr`
+const nestedFieldSeparator = "."
+
+// JSON parser of lines written by nginx with `escape=json` parameter,
+// field names are taken from the keys of JSON object, so `log_format` is not required for parsing
+type JSON struct{}
+
+func (j *JSON) ParseString(line string) (entry *LogEntry, err error) {
+	return parseJSON(line)
+}
+
+func (j *JSON) ParseJSON(line string) (entry *LogEntry, err error) {
+	return parseJSON(line)
+}
+
+func NewJSONParser() *JSON {
+	return &JSON{}
+}
+
+// ParseJSON parse single JSON object line to log entry
+func (t *Template) ParseJSON(line string) (entry *LogEntry, err error) {
+	return parseJSON(line)
+}
+
+// parseJSON nested objects are flattened, arrays are stored as raw JSON strings
+func parseJSON(line string) (entry *LogEntry, err error) {
+	decoder := json.NewDecoder(strings.NewReader(line))
+	// keep numbers as is, otherwise large integers lose precision in float64
+	decoder.UseNumber()
+	object := map[string]interface{}{}
+	if err = decoder.Decode(&object); err != nil {
+		err = fmt.Errorf("access log line '%v' is not valid JSON object: %w", line, err)
+		return nil, err
+	}
+	// the line must contain exactly one object, trailing data is not ignored
+	if _, err = decoder.Token(); !errors.Is(err, io.EOF) {
+		if err == nil {
+			err = errors.New("unexpected data after object")
+		}
+		err = fmt.Errorf("access log line '%v' is not valid JSON object: %w", line, err)
+		return nil, err
+	}
+	entry = NewEntry()
+	if err = flattenJSON(entry, "", object); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func flattenJSON(entry *LogEntry, prefix string, object map[string]interface{}) error {
+	for key, value := range object {
+		if prefix != "" {
+			key = prefix + nestedFieldSeparator + key
+		}
+		switch it := value.(type) {
+		case map[string]interface{}:
+			// the nested object itself is also available as raw JSON string
+			raw, err := marshalJSON(it)
+			if err != nil {
+				return err
+			}
+			entry.SetField(key, raw)
+			if err := flattenJSON(entry, key, it); err != nil {
+				return err
+			}
+		case []interface{}:
+			raw, err := marshalJSON(it)
+			if err != nil {
+				return err
+			}
+			entry.SetField(key, raw)
+		case string:
+			entry.SetField(key, it)
+		case json.Number:
+			entry.SetField(key, it.String())
+		case bool:
+			if it {
+				entry.SetField(key, "true")
+			} else {
+				entry.SetField(key, "false")
+			}
+		case nil:
+			entry.SetField(key, "")
+		default:
+			entry.SetField(key, fmt.Sprintf("%v", it))
+		}
+	}
+	return nil
+}
+
+func marshalJSON(value interface{}) (string, error) {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	// nginx does not escape HTML characters, so we don't
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,87 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Logfmt parser of `key=value` lines, field names are taken from the keys, e.g.:
+// log_format logfmt 'remote_addr=$remote_addr request="$request" status=$status';
+type Logfmt struct{}
+
+// nolint:gocyclo // it's ok, simple state machine
+func (l *Logfmt) ParseString(line string) (entry *LogEntry, err error) {
+	entry = NewEntry()
+	i := 0
+	for i < len(line) {
+		// skip spaces between pairs
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" {
+			return nil, fmt.Errorf("access log line '%v' has empty key at position %d", line, start)
+		}
+		// bare key without value
+		if i >= len(line) || line[i] == ' ' {
+			entry.SetField(key, "")
+			continue
+		}
+		// skip '='
+		i++
+		if i < len(line) && line[i] == '"' {
+			value, next, ok := readQuoted(line, i+1)
+			if !ok {
+				return nil, fmt.Errorf("access log line '%v' has unterminated quoted value for key '%s'", line, key)
+			}
+			entry.SetField(key, value)
+			i = next
+			continue
+		}
+		start = i
+		for i < len(line) && line[i] != ' ' {
+			i++
+		}
+		entry.SetField(key, line[start:i])
+	}
+	return entry, nil
+}
+
+// readQuoted reads value until closing quote, returns value and position after quote
+func readQuoted(line string, i int) (value string, next int, ok bool) {
+	builder := strings.Builder{}
+	for i < len(line) {
+		switch c := line[i]; c {
+		case '"':
+			return builder.String(), i + 1, true
+		case '\\':
+			if i+1 >= len(line) {
+				return "", i, false
+			}
+			i++
+			switch line[i] {
+			case 'n':
+				builder.WriteByte('\n')
+			case 't':
+				builder.WriteByte('\t')
+			default:
+				builder.WriteByte(line[i])
+			}
+		default:
+			builder.WriteByte(c)
+		}
+		i++
+	}
+	return "", i, false
+}
+
+func NewLogfmtParser() *Logfmt {
+	return &Logfmt{}
+}
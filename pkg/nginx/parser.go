@@ -0,0 +1,63 @@
+package nginx
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Supported values of `nginx.log_type` configuration option
+const (
+	LogTypeTemplate = "template"
+	LogTypeCSV      = "csv"
+	LogTypeTSV      = "tsv"
+	LogTypeLogfmt   = "logfmt"
+)
+
+// ParserFactory creates parser for given nginx `log_format` string
+type ParserFactory func(format string) (StringParser, error)
+
+var (
+	parsersMu sync.RWMutex
+	parsers   = map[string]ParserFactory{
+		LogTypeTemplate: func(format string) (StringParser, error) {
+			return NewTemplate(format), nil
+		},
+		LogTypeJSON: func(_ string) (StringParser, error) {
+			return NewJSONParser(), nil
+		},
+		LogTypeCSV: func(format string) (StringParser, error) {
+			return NewCSVParser(format)
+		},
+		LogTypeTSV: func(format string) (StringParser, error) {
+			return NewTSVParser(format)
+		},
+		LogTypeLogfmt: func(_ string) (StringParser, error) {
+			return NewLogfmtParser(), nil
+		},
+		LogTypeError: func(_ string) (StringParser, error) {
+			return NewErrorLogParser(), nil
+		},
+	}
+)
+
+// RegisterParser makes a parser available by the provided log type,
+// if RegisterParser is called twice with the same log type, previous factory will be replaced
+func RegisterParser(logType string, factory ParserFactory) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	parsers[logType] = factory
+}
+
+// NewParser creates parser registered for log type, empty log type means regular regex template
+func NewParser(logType, format string) (StringParser, error) {
+	if logType == "" {
+		logType = LogTypeTemplate
+	}
+	parsersMu.RLock()
+	factory, ok := parsers[logType]
+	parsersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported log type '%s'", logType)
+	}
+	return factory(format)
+}
@@ -0,0 +1,90 @@
+package nginx
+
+import (
+	"testing"
+)
+
+// nolint:lll // it's OK
+var parserCases = map[string]struct {
+	format string
+	line   string
+	expect map[string]string
+}{
+	LogTypeTemplate: {
+		format: `$remote_addr - $remote_user [$time_local] "$request" $status`,
+		line:   `114.119.133.192 - - [21/Jul/2022:00:30:43 +0300] "GET / HTTP/1.1" 200`,
+		expect: map[string]string{"remote_addr": "114.119.133.192", "request": "GET / HTTP/1.1", "status": "200"},
+	},
+	LogTypeJSON: {
+		format: `{"remote_addr":"$remote_addr","status":$status}`,
+		line:   `{"remote_addr":"114.119.133.192","status":200}`,
+		expect: map[string]string{"remote_addr": "114.119.133.192", "status": "200"},
+	},
+	LogTypeCSV: {
+		format: `$remote_addr,$remote_user,"$request",$status,constant`,
+		line:   `114.119.133.192,-,"GET /a,b HTTP/1.1",200,constant`,
+		expect: map[string]string{"remote_addr": "114.119.133.192", "remote_user": "-", "request": "GET /a,b HTTP/1.1", "status": "200"},
+	},
+	LogTypeTSV: {
+		format: "$remote_addr\t$remote_user\t\"$http_user_agent\"\t$status",
+		line:   "114.119.133.192\t-\t\"Mozilla \"5.0\"\"\t200",
+		expect: map[string]string{"remote_addr": "114.119.133.192", "remote_user": "-", "http_user_agent": `Mozilla "5.0"`, "status": "200"},
+	},
+	LogTypeLogfmt: {
+		format: `remote_addr=$remote_addr request="$request" status=$status`,
+		line:   `remote_addr=114.119.133.192  request="GET /\"quoted\" HTTP/1.1" status=200 empty= bare`,
+		expect: map[string]string{"remote_addr": "114.119.133.192", "request": `GET /"quoted" HTTP/1.1`, "status": "200", "empty": "", "bare": ""},
+	},
+}
+
+func TestNewParser(t *testing.T) {
+	t.Run("it should be successfully parse lines with all log types", func(t *testing.T) {
+		for logType, cas := range parserCases {
+			parser, err := NewParser(logType, cas.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry, err := parser.ParseString(cas.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, expect := range cas.expect {
+				field, err := entry.Field(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if field != expect {
+					t.Fatalf("failed for %s key %s, expect %v, receive %v", logType, key, expect, field)
+				}
+			}
+		}
+	})
+	t.Run("it should be used template by default", func(t *testing.T) {
+		parser, err := NewParser("", "$status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := parser.(*Template); !ok {
+			t.Fatalf("failed, expect *Template, receive %T", parser)
+		}
+	})
+	t.Run("it should be failed for unknown log type", func(t *testing.T) {
+		if _, err := NewParser("unknown", "$status"); err == nil {
+			t.Fatal("expected error, receive nil")
+		}
+	})
+	t.Run("it should be failed for mismatched columns count", func(t *testing.T) {
+		parser, err := NewParser(LogTypeCSV, "$remote_addr,$status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parser.ParseString("114.119.133.192"); err == nil {
+			t.Fatal("expected error, receive nil")
+		}
+	})
+	t.Run("it should be failed for unterminated logfmt value", func(t *testing.T) {
+		if _, err := NewLogfmtParser().ParseString(`request="GET /`); err == nil {
+			t.Fatal("expected error, receive nil")
+		}
+	})
+}
@@ -14,6 +14,12 @@ type JSONParser interface {
 	ParseJSON(line string) (entry *LogEntry, err error)
 }
 
+// FieldsParser parser which knows names of fields before parsing, they are taken from `log_format` or fixed format of log
+type FieldsParser interface {
+	StringParser
+	Fields() []string
+}
+
 type Template struct {
 	format string
 	regexp *regexp.Regexp
@@ -36,8 +42,8 @@ func (t *Template) ParseString(line string) (entry *LogEntry, err error) {
 	return
 }
 
-func (t *Template) ParseJSON(_ string) (entry *LogEntry, err error) {
-	return nil, err
+func (t *Template) Fields() []string {
+	return append([]string(nil), t.regexp.SubexpNames()[1:]...)
 }
 
 func NewTemplate(format string) *Template {
@@ -105,3 +105,55 @@ func TestTemplate(t *testing.T) {
 		}
 	})
 }
+
+// nolint:lll // it's OK
+const caseJSON = `{"remote_addr":"114.119.133.192","remote_user":"","status":444,"request_time":0.014,"http_user_agent":"Mozilla/5.0 \"quoted\" \u041f\u0440\u0438\u0432\u0435\u0442","ssl":false,"upstream":{"addr":"10.0.0.1:8080","status":"200"},"cookies":["a=1","b=2"],"referer":null}`
+
+func TestTemplateParseJSON(t *testing.T) {
+	t.Run("it should be successfully parse JSON line", func(t *testing.T) {
+		entry, err := NewTemplate("").ParseJSON(caseJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]string{
+			"remote_addr":     "114.119.133.192",
+			"remote_user":     "",
+			"status":          "444",
+			"request_time":    "0.014",
+			"http_user_agent": `Mozilla/5.0 "quoted" Привет`,
+			"ssl":             "false",
+			"upstream":        `{"addr":"10.0.0.1:8080","status":"200"}`,
+			"upstream.addr":   "10.0.0.1:8080",
+			"upstream.status": "200",
+			"cookies":         `["a=1","b=2"]`,
+			"referer":         "",
+		}
+		for key, expect := range expected {
+			field, err := entry.Field(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if field != expect {
+				t.Fatalf("failed for key %s, expect %v, receive %v", key, expect, field)
+			}
+		}
+	})
+	t.Run("it should be failed parse not JSON object", func(t *testing.T) {
+		if _, err := NewTemplate("").ParseJSON(`["not", "an", "object"]`); err == nil {
+			t.Fatal("expected error, receive nil")
+		}
+		if _, err := NewTemplate("").ParseJSON(caseOne); err == nil {
+			t.Fatal("expected error, receive nil")
+		}
+	})
+	t.Run("it should be failed parse line with data after JSON object", func(t *testing.T) {
+		for _, line := range []string{`{"status":200} garbage`, `{"status":200}{"status":404}`, `{"status":200} }`} {
+			if _, err := NewJSONParser().ParseString(line); err == nil {
+				t.Fatalf("%s: expected error, receive nil", line)
+			}
+		}
+		if _, err := NewJSONParser().ParseString(`{"status":200}` + " \t"); err != nil {
+			t.Fatalf("expected trailing spaces are allowed, receive %v", err)
+		}
+	})
+}
@@ -0,0 +1,283 @@
+// Package privacy anonymizes personal data of log lines: truncates and pseudonymizes IP addresses and users,
+// redacts matches of regular expressions and drops arguments of query
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+// Redacted default replacement of redacted matches
+const Redacted = "[REDACTED]"
+
+// pseudonymLen bytes of HMAC which are kept in pseudonym
+const pseudonymLen = 16
+
+// fields of nginx which contain query of request, args and query_string have no leading question mark
+const (
+	fieldArgs        = "args"
+	fieldQueryString = "query_string"
+)
+
+// ipToken candidate of IPv4 or IPv6 address in raw line, candidates are checked by net.ParseIP
+var ipToken = regexp.MustCompile(`[0-9A-Fa-f]*[:.][0-9A-Fa-f:.]+`)
+
+type redactRule struct {
+	fields      map[string]struct{}
+	re          *regexp.Regexp
+	replacement string
+}
+
+// Scrubber applies privacy rules to fields of parsed entries and to raw lines
+type Scrubber struct {
+	truncate     map[string]struct{}
+	pseudonymize map[string]struct{}
+	key          []byte
+	rules        []*redactRule
+	dropArgs     map[string]struct{}
+}
+
+// New creates scrubber by configuration, it is nil if there are no rules
+func New(cfg *config.Privacy) (*Scrubber, error) {
+	if len(cfg.TruncateIP) == 0 && len(cfg.Pseudonymize) == 0 && len(cfg.Redact) == 0 && len(cfg.DropQueryArgs) == 0 {
+		return nil, nil
+	}
+	s := &Scrubber{
+		truncate:     set(cfg.TruncateIP),
+		pseudonymize: set(cfg.Pseudonymize),
+		dropArgs:     set(cfg.DropQueryArgs),
+		key:          []byte(cfg.Key),
+	}
+	if cfg.KeyFile != "" {
+		key, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("privacy key: %w", err)
+		}
+		s.key = []byte(strings.TrimSpace(string(key)))
+	}
+	if len(s.pseudonymize) > 0 && len(s.key) == 0 {
+		return nil, fmt.Errorf("privacy key is empty")
+	}
+	for i, redact := range cfg.Redact {
+		re, err := regexp.Compile(redact.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("privacy redact %d: %w", i, err)
+		}
+		replacement := redact.Replacement
+		if replacement == "" {
+			replacement = Redacted
+		}
+		s.rules = append(s.rules, &redactRule{fields: set(redact.Fields), re: re, replacement: replacement})
+	}
+	return s, nil
+}
+
+func set(names []string) map[string]struct{} {
+	values := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		values[name] = struct{}{}
+	}
+	return values
+}
+
+// Scrub applies rules to fields of entry: drops arguments of query, redacts matches, truncates and pseudonymizes values
+func (s *Scrubber) Scrub(entry *nginx.LogEntry) {
+	fields := entry.Fields()
+	for name, value := range fields {
+		scrubbed := value
+		switch name {
+		case nginx.Request, nginx.RequestURI, nginx.HTTPReferer:
+			scrubbed = DropQueryArgs(scrubbed, s.dropArgs)
+		case fieldArgs, fieldQueryString:
+			scrubbed = dropArgs(scrubbed, s.dropArgs)
+		}
+		for _, rule := range s.rules {
+			if _, ok := rule.fields[name]; ok || len(rule.fields) == 0 {
+				scrubbed = rule.re.ReplaceAllString(scrubbed, rule.replacement)
+			}
+		}
+		if _, ok := s.truncate[name]; ok {
+			scrubbed = TruncateIP(scrubbed)
+		}
+		if _, ok := s.pseudonymize[name]; ok {
+			scrubbed = s.Pseudonym(scrubbed)
+		}
+		if scrubbed != value {
+			fields[name] = scrubbed
+		}
+	}
+}
+
+// ScrubLine applies rules to raw line, e.g. line of dead letter or error message, entry is the line parsed without scrubbing
+// or nil if line can't be parsed: values of pseudonymized fields of entry are replaced by their pseudonyms,
+// line which is not parsed is redacted entirely if fields are pseudonymized, because their values can't be found in it,
+// then arguments of query are dropped, matches of all rules are redacted and all IP addresses are anonymized,
+// addresses are truncated if any field is truncated, otherwise they are pseudonymized
+func (s *Scrubber) ScrubLine(line string, entry *nginx.LogEntry) string {
+	if len(s.pseudonymize) > 0 {
+		if entry == nil {
+			return Redacted
+		}
+		line = s.pseudonymizeValues(line, entry.Fields())
+	}
+	line = DropQueryArgs(line, s.dropArgs)
+	for _, rule := range s.rules {
+		line = rule.re.ReplaceAllString(line, rule.replacement)
+	}
+	if len(s.truncate) == 0 && len(s.pseudonymize) == 0 {
+		return line
+	}
+	anonymize := s.Pseudonym
+	if len(s.truncate) > 0 {
+		anonymize = TruncateIP
+	}
+	var b strings.Builder
+	last := 0
+	for _, loc := range ipToken.FindAllStringIndex(line, -1) {
+		// versions of products are not addresses, e.g. Chrome/120.0.0.0
+		if loc[0] > 0 && line[loc[0]-1] == '/' {
+			continue
+		}
+		token := line[loc[0]:loc[1]]
+		anonymized := anonymizeToken(token, anonymize)
+		if anonymized == token {
+			continue
+		}
+		b.WriteString(line[last:loc[0]])
+		b.WriteString(anonymized)
+		last = loc[1]
+	}
+	if last == 0 {
+		return line
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+// pseudonymizeValues replaces values of pseudonymized fields in line by their pseudonyms,
+// longer values are replaced first, so value which contains another one is replaced entirely
+func (s *Scrubber) pseudonymizeValues(line string, fields nginx.Fields) string {
+	values := make([]string, 0, len(s.pseudonymize))
+	for name := range s.pseudonymize {
+		// empty values are kept by Pseudonym
+		if value := fields[name]; value != "" && value != "-" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return line
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, value, s.Pseudonym(value))
+	}
+	return strings.NewReplacer(pairs...).Replace(line)
+}
+
+// anonymizeToken anonymizes address or address with port, e.g. upstream 10.0.0.1:8080
+func anonymizeToken(token string, anonymize func(string) string) string {
+	if net.ParseIP(token) != nil {
+		return anonymize(token)
+	}
+	if host, port, err := net.SplitHostPort(token); err == nil && net.ParseIP(host) != nil {
+		return net.JoinHostPort(anonymize(host), port)
+	}
+	return token
+}
+
+// Pseudonym keyed HMAC-SHA256 of value, the same values have the same pseudonyms, empty values are kept
+func (s *Scrubber) Pseudonym(value string) string {
+	if value == "" || value == "-" {
+		return value
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:pseudonymLen])
+}
+
+// TruncateIP truncates IPv4 address to /24 and IPv6 address to /48, e.g. 192.168.10.25 becomes 192.168.10.0,
+// addresses of list are truncated one by one, e.g. X-Forwarded-For, value which is not address is kept
+func TruncateIP(value string) string {
+	if !strings.Contains(value, ",") {
+		return truncateIP(value)
+	}
+	addresses := strings.Split(value, ",")
+	for i, address := range addresses {
+		trimmed := strings.TrimSpace(address)
+		addresses[i] = strings.Replace(address, trimmed, truncateIP(trimmed), 1)
+	}
+	return strings.Join(addresses, ",")
+}
+
+func truncateIP(value string) string {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return value
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// DropQueryArgs removes arguments of queries of URLs in value, e.g. request line GET /?token=1&page=2 HTTP/1.1
+func DropQueryArgs(value string, names map[string]struct{}) string {
+	if len(names) == 0 || !strings.Contains(value, "?") {
+		return value
+	}
+	var b strings.Builder
+	rest := value
+	for {
+		i := strings.IndexByte(rest, '?')
+		if i < 0 {
+			break
+		}
+		b.WriteString(rest[:i])
+		rest = rest[i+1:]
+		end := strings.IndexAny(rest, " \t\"'#")
+		if end < 0 {
+			end = len(rest)
+		}
+		query := dropArgs(rest[:end], names)
+		// question mark of empty query is removed with the last argument
+		if query != "" || end == 0 {
+			b.WriteByte('?')
+		}
+		b.WriteString(query)
+		rest = rest[end:]
+	}
+	b.WriteString(rest)
+	return b.String()
+}
+
+// dropArgs removes arguments from query without question mark, e.g. token=1&page=2
+func dropArgs(query string, names map[string]struct{}) string {
+	if len(names) == 0 || query == "" {
+		return query
+	}
+	args := strings.Split(query, "&")
+	kept := args[:0]
+	for _, arg := range args {
+		name, _, _ := strings.Cut(arg, "=")
+		if _, ok := names[name]; !ok {
+			kept = append(kept, arg)
+		}
+	}
+	if len(kept) == len(args) {
+		return query
+	}
+	return strings.Join(kept, "&")
+}
@@ -0,0 +1,139 @@
+package privacy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+func TestTruncateIP(t *testing.T) {
+	t.Run("it should be truncated addresses", func(t *testing.T) {
+		for value, expect := range map[string]string{
+			"192.168.10.25":                        "192.168.10.0",
+			"2001:db8:85a3:8d3:1319:8a2e:370:7348": "2001:db8:85a3::",
+			"10.1.2.3, 172.16.5.4":                 "10.1.2.0, 172.16.5.0",
+			"unix:":                                "unix:",
+			"-":                                    "-",
+		} {
+			if truncated := TruncateIP(value); truncated != expect {
+				t.Fatalf("%s: expect %s, got %s", value, expect, truncated)
+			}
+		}
+	})
+}
+
+func TestDropQueryArgs(t *testing.T) {
+	t.Run("it should be dropped named arguments", func(t *testing.T) {
+		names := map[string]struct{}{"token": {}, "email": {}}
+		for value, expect := range map[string]string{
+			"GET /login?token=abc&page=2 HTTP/1.1":               "GET /login?page=2 HTTP/1.1",
+			"GET /login?email=a%40b.com&token=abc HTTP/1.1":      "GET /login HTTP/1.1",
+			"https://example.com/?page=1&email=a@b.com#top":      "https://example.com/?page=1#top",
+			`"GET /a?token=1 HTTP/1.1" 200 "https://x/?token=2"`: `"GET /a HTTP/1.1" 200 "https://x/"`,
+			"/search?q=go&tokens=1":                              "/search?q=go&tokens=1",
+			"/empty?":                                            "/empty?",
+		} {
+			if dropped := DropQueryArgs(value, names); dropped != expect {
+				t.Fatalf("%s: expect %s, got %s", value, expect, dropped)
+			}
+		}
+	})
+}
+
+func TestScrubber(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	scrubber, err := New(&config.Privacy{
+		TruncateIP:   []string{nginx.RemoteAddr},
+		Pseudonymize: []string{nginx.RemoteUser},
+		KeyFile:      keyFile,
+		Redact: []config.Redact{
+			{Regex: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, Replacement: "[EMAIL]"},
+			{Fields: []string{nginx.HTTPReferer}, Regex: `session=[^&\s"]+`},
+		},
+		DropQueryArgs: []string{"token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it should be scrubbed fields of entry", func(t *testing.T) {
+		entry := nginx.NewEntry()
+		entry.SetField(nginx.RemoteAddr, "203.0.113.77")
+		entry.SetField(nginx.RemoteUser, "alice")
+		entry.SetField(nginx.Request, "GET /reset?token=abc&mail=alice@example.com HTTP/1.1")
+		entry.SetField(nginx.HTTPReferer, "https://example.com/?session=42")
+		entry.SetField("args", "token=abc&page=1")
+		scrubber.Scrub(entry)
+		for field, expect := range map[string]string{
+			nginx.RemoteAddr:  "203.0.113.0",
+			nginx.RemoteUser:  scrubber.Pseudonym("alice"),
+			nginx.Request:     "GET /reset?mail=[EMAIL] HTTP/1.1",
+			nginx.HTTPReferer: "https://example.com/?" + Redacted,
+			"args":            "page=1",
+		} {
+			if value, _ := entry.Field(field); value != expect {
+				t.Fatalf("%s: expect %s, got %s", field, expect, value)
+			}
+		}
+	})
+
+	t.Run("it should be keyed pseudonyms", func(t *testing.T) {
+		other, err := New(&config.Privacy{Pseudonymize: []string{nginx.RemoteUser}, Key: "other"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pseudonym := scrubber.Pseudonym("alice")
+		if len(pseudonym) != 2*pseudonymLen || pseudonym != scrubber.Pseudonym("alice") || pseudonym == other.Pseudonym("alice") {
+			t.Fatalf("unexpected pseudonym %s", pseudonym)
+		}
+		if scrubber.Pseudonym("-") != "-" {
+			t.Fatal("expect kept empty value")
+		}
+	})
+
+	t.Run("it should be scrubbed raw line", func(t *testing.T) {
+		line := `203.0.113.77 - alice [21/Jul/2022:00:30:43 +0300] "GET /reset?token=abc&mail=alice@example.com HTTP/1.1" 200 ` +
+			`"Chrome/120.0.0.0" upstream 10.0.0.15:8080`
+		entry := nginx.NewEntry()
+		entry.SetField(nginx.RemoteAddr, "203.0.113.77")
+		entry.SetField(nginx.RemoteUser, "alice")
+		scrubbed := scrubber.ScrubLine(line, entry)
+		expect := `203.0.113.0 - ` + scrubber.Pseudonym("alice") + ` [21/Jul/2022:00:30:43 +0300] "GET /reset?mail=[EMAIL] HTTP/1.1" 200 ` +
+			`"Chrome/120.0.0.0" upstream 10.0.0.0:8080`
+		if scrubbed != expect {
+			t.Fatalf("expect %s, got %s", expect, scrubbed)
+		}
+		if strings.Contains(scrubber.ScrubLine("client: 2001:db8:85a3:8d3::1, server: example.com", entry), "8d3") {
+			t.Fatal("expect truncated IPv6 address")
+		}
+	})
+
+	t.Run("it should be redacted entirely line which is not parsed", func(t *testing.T) {
+		if scrubbed := scrubber.ScrubLine("broken line of alice", nil); scrubbed != Redacted {
+			t.Fatalf("expect %s, got %s", Redacted, scrubbed)
+		}
+		truncator, err := New(&config.Privacy{TruncateIP: []string{nginx.RemoteAddr}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scrubbed := truncator.ScrubLine("broken 203.0.113.77", nil); scrubbed != "broken 203.0.113.0" {
+			t.Fatalf("expect line without pseudonymized fields is kept, got %s", scrubbed)
+		}
+	})
+
+	t.Run("it should be nil scrubber without rules", func(t *testing.T) {
+		if s, err := New(&config.Privacy{}); s != nil || err != nil {
+			t.Fatalf("expect nil scrubber, got %v, %v", s, err)
+		}
+		if _, err := New(&config.Privacy{Redact: []config.Redact{{Regex: "("}}}); err == nil {
+			t.Fatal("expect error of invalid regex")
+		}
+	})
+}
@@ -0,0 +1,32 @@
+// Package sample keeps deterministic part of rows by hash of key, so rows with the same key, e.g. client address,
+// are either all kept or all dropped by every process
+package sample
+
+const (
+	offset64 = 14695981039346656037
+	prime64  = 1099511628211
+)
+
+// Keep reports whether row with key is in sample, rate is part of kept rows from 0 to 1
+func Keep(key string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	return float64(hash(key))/(1<<64) < rate
+}
+
+// hash is FNV-1a with final mixing of bits, FNV-1a is inlined to avoid allocation of hash.Hash64 for every row,
+// mixing spreads short keys like addresses over all bits
+func hash(key string) uint64 {
+	h := uint64(offset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime64
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
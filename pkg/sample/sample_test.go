@@ -0,0 +1,40 @@
+package sample
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKeep(t *testing.T) {
+	t.Run("it should be kept part of keys by rate", func(t *testing.T) {
+		for _, rate := range []float64{0.01, 0.1, 0.5} {
+			kept := 0
+			for i := 0; i < 100000; i++ {
+				if Keep(fmt.Sprintf("10.0.%d.%d", i/256, i%256), rate) {
+					kept++
+				}
+			}
+			if expect := rate * 100000; float64(kept) < expect*0.9 || float64(kept) > expect*1.1 {
+				t.Fatalf("rate %v: expect about %v kept keys, got %d", rate, expect, kept)
+			}
+		}
+	})
+
+	t.Run("it should be deterministic", func(t *testing.T) {
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("192.168.1.%d", i)
+			if Keep(key, 0.3) != Keep(key, 0.3) {
+				t.Fatalf("expect the same result for key %s", key)
+			}
+			if Keep(key, 0.1) && !Keep(key, 0.3) {
+				t.Fatalf("expect key %s of smaller sample in larger sample", key)
+			}
+		}
+	})
+
+	t.Run("it should be kept all or none keys", func(t *testing.T) {
+		if !Keep("127.0.0.1", 1) || Keep("127.0.0.1", 0) {
+			t.Fatal("expect all keys kept by rate 1 and none by rate 0")
+		}
+	})
+}
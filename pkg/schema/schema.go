@@ -0,0 +1,141 @@
+// Package schema checks mapping of columns against table of ClickHouse before service is started,
+// so typos in column names and mismatched types are reported at once instead of failing inserts at runtime
+package schema
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+
+	"github.com/zikwall/grower/pkg/expr"
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+// Scheme of rows: columns, their sources, caster of values and parser of lines
+type Scheme struct {
+	Columns []string
+	Sources map[string]expr.Expression
+	Caster  nginx.TypeCaster
+	Parser  nginx.StringParser
+	// Virtual fields which are added to parsed fields by transport, e.g. syslog_hostname,
+	// name ending with dot is prefix of fields, e.g. sd.
+	Virtual []string
+}
+
+// Error report of all problems of tables
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "schema is not valid:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Columns of table with their types from system.columns, table may be prefixed with database, e.g. logs.access_log
+func Columns(ctx context.Context, conn driver.Conn, table string) (map[string]string, error) {
+	query := "SELECT name, type FROM system.columns WHERE database = currentDatabase() AND table = ?"
+	args := []interface{}{table}
+	if database, name, ok := strings.Cut(table, "."); ok {
+		query = "SELECT name, type FROM system.columns WHERE database = ? AND table = ?"
+		args = []interface{}{database, name}
+	}
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("columns of table %s: %w", table, err)
+	}
+	defer rows.Close()
+	columns := map[string]string{}
+	for rows.Next() {
+		var name, columnType string
+		if err := rows.Scan(&name, &columnType); err != nil {
+			return nil, fmt.Errorf("columns of table %s: %w", table, err)
+		}
+		columns[name] = columnType
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("columns of table %s: %w", table, err)
+	}
+	return columns, nil
+}
+
+// Check returns problems of scheme: columns which do not exist in table, types of values which are not compatible
+// with types of columns and fields which do not appear in log format, fields are checked only if parser knows them
+func Check(table string, columns map[string]string, scheme *Scheme) []string {
+	if len(columns) == 0 {
+		return []string{fmt.Sprintf("table %s does not exist or has no columns", table)}
+	}
+	var problems []string
+	var known map[string]struct{}
+	if parser, ok := scheme.Parser.(nginx.FieldsParser); ok {
+		known = map[string]struct{}{}
+		for _, field := range parser.Fields() {
+			known[field] = struct{}{}
+		}
+	}
+	names := append([]string(nil), scheme.Columns...)
+	sort.Strings(names)
+	for _, name := range names {
+		source := scheme.Sources[name]
+		columnType, ok := columns[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("table %s: column %s does not exist", table, name))
+		} else {
+			valueType := expr.Type(source)
+			if valueType == "" {
+				valueType = scheme.Caster.Type(name)
+			}
+			if !Compatible(valueType, columnType) {
+				problems = append(problems, fmt.Sprintf("table %s: column %s is %s, value of %s is %s",
+					table, name, columnType, source.String(), valueType))
+			}
+		}
+		if known == nil {
+			continue
+		}
+		for _, field := range expr.Fields(source) {
+			if _, ok := known[field]; !ok && !virtual(field, scheme.Virtual) {
+				problems = append(problems, fmt.Sprintf("table %s: column %s refers to field %s, which does not appear in log format",
+					table, name, field))
+			}
+		}
+	}
+	return problems
+}
+
+func virtual(field string, fields []string) bool {
+	for _, name := range fields {
+		if field == name || (strings.HasSuffix(name, ".") && strings.HasPrefix(field, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compatible reports whether value of type caster can be inserted to column,
+// nullable and low cardinality columns are compatible with values of nested type
+func Compatible(valueType, columnType string) bool {
+	columnType = unwrap(columnType)
+	switch valueType {
+	case nginx.String:
+		return columnType == "String" ||
+			strings.HasPrefix(columnType, "FixedString(") ||
+			strings.HasPrefix(columnType, "Enum8(") ||
+			strings.HasPrefix(columnType, "Enum16(")
+	case nginx.Date, nginx.DateTime:
+		return columnType == "Date" || columnType == "Date32" ||
+			columnType == "DateTime" || strings.HasPrefix(columnType, "DateTime(") || strings.HasPrefix(columnType, "DateTime64(")
+	}
+	return columnType == valueType
+}
+
+func unwrap(columnType string) string {
+	for _, wrapper := range []string{"LowCardinality(", "Nullable("} {
+		if strings.HasPrefix(columnType, wrapper) && strings.HasSuffix(columnType, ")") {
+			return unwrap(columnType[len(wrapper) : len(columnType)-1])
+		}
+	}
+	return columnType
+}
@@ -0,0 +1,117 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zikwall/grower/pkg/expr"
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+func newScheme(t *testing.T, parser nginx.StringParser, columns map[string]string, virtual ...string) *Scheme {
+	t.Helper()
+	sources, err := expr.CompileScheme(columns, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	return &Scheme{
+		Columns: names,
+		Sources: sources,
+		Caster:  nginx.NewTypeCaster(&nginx.CasterCfg{}),
+		Parser:  parser,
+		Virtual: virtual,
+	}
+}
+
+func TestCheck(t *testing.T) {
+	table := map[string]string{
+		"remote_addr": "String",
+		"time_local":  "DateTime('UTC')",
+		"status":      "UInt16",
+		"bytes_sent":  "Nullable(UInt32)",
+		"user_id":     "UInt64",
+		"host":        "LowCardinality(String)",
+		"hostname":    "String",
+	}
+
+	t.Run("it should be valid scheme of existing columns, compatible types and known fields", func(t *testing.T) {
+		scheme := newScheme(t, nginx.NewTemplate(`$remote_addr [$time_local] $status $bytes_sent "$http_x_user"`), map[string]string{
+			"remote_addr": "$remote_addr",
+			"time_local":  "time_local",
+			"status":      "status",
+			"bytes_sent":  "bytes_sent",
+			"user_id":     "JSONUInt64Field('id', http_x_user)",
+			"hostname":    "syslog_hostname",
+			"host":        "sd.origin.host",
+		}, "syslog_hostname", "sd.")
+		if problems := Check("logs.access_log", table, scheme); len(problems) > 0 {
+			t.Fatalf("expect no problems, got %v", problems)
+		}
+	})
+
+	t.Run("it should be reported missing columns, incompatible types and unknown fields", func(t *testing.T) {
+		scheme := newScheme(t, nginx.NewTemplate(`$remote_addr $status`), map[string]string{
+			"remote_adr": "remote_addr",
+			"status":     "toFloat32(status)",
+			"user_id":    "toUInt32(http_x_user)",
+		})
+		problems := Check("logs.access_log", table, scheme)
+		expect := []string{
+			"table logs.access_log: column remote_adr does not exist",
+			"table logs.access_log: column status is UInt16, value of toFloat32(status) is Float32",
+			"table logs.access_log: column user_id is UInt64, value of toUInt32(http_x_user) is UInt32",
+			"table logs.access_log: column user_id refers to field http_x_user, which does not appear in log format",
+		}
+		if strings.Join(problems, "\n") != strings.Join(expect, "\n") {
+			t.Fatalf("expect %v, got %v", expect, problems)
+		}
+		err := &Error{Problems: problems}
+		if !strings.HasPrefix(err.Error(), "schema is not valid:\n  - table logs.access_log: column remote_adr") {
+			t.Fatalf("unexpected report %s", err.Error())
+		}
+	})
+
+	t.Run("it should be skipped fields of parsers without known fields", func(t *testing.T) {
+		scheme := newScheme(t, nginx.NewJSONParser(), map[string]string{"remote_addr": "upstream.addr"})
+		if problems := Check("logs.access_log", table, scheme); len(problems) > 0 {
+			t.Fatalf("expect no problems, got %v", problems)
+		}
+	})
+
+	t.Run("it should be reported table without columns", func(t *testing.T) {
+		scheme := newScheme(t, nginx.NewJSONParser(), map[string]string{"status": "status"})
+		if problems := Check("logs.unknown", nil, scheme); len(problems) != 1 {
+			t.Fatalf("expect one problem, got %v", problems)
+		}
+	})
+}
+
+func TestCompatible(t *testing.T) {
+	t.Run("it should be compatible types of values and columns", func(t *testing.T) {
+		for valueType, columnTypes := range map[string][]string{
+			nginx.String:   {"String", "FixedString(2)", "LowCardinality(Nullable(String))", "Enum8('a' = 1)"},
+			nginx.DateTime: {"DateTime", "DateTime('Europe/Moscow')", "DateTime64(3)", "Date", "Nullable(DateTime)"},
+			nginx.UInt16:   {"UInt16", "Nullable(UInt16)"},
+		} {
+			for _, columnType := range columnTypes {
+				if !Compatible(valueType, columnType) {
+					t.Fatalf("expect %s is compatible with %s", valueType, columnType)
+				}
+			}
+		}
+		for valueType, columnType := range map[string]string{
+			nginx.String:  "UInt16",
+			nginx.UInt16:  "UInt32",
+			nginx.Float32: "Float64",
+			nginx.Date:    "String",
+		} {
+			if Compatible(valueType, columnType) {
+				t.Fatalf("expect %s is not compatible with %s", valueType, columnType)
+			}
+		}
+	})
+}
@@ -0,0 +1,282 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+)
+
+// batch is one failed insert, it is written as one record of segment file:
+// [payload length uint32][payload crc32 uint32][payload]
+type batch struct {
+	view cx.View
+	rows []cx.Vector
+}
+
+var errUnsupportedType = errors.New("unsupported type of value")
+
+// types of values in vectors
+const (
+	typeNil byte = iota
+	typeString
+	typeUInt8
+	typeUInt16
+	typeUInt32
+	typeUInt64
+	typeInt8
+	typeInt16
+	typeInt32
+	typeInt64
+	typeFloat32
+	typeFloat64
+	typeBool
+	typeTime
+	typeInt
+	typeUInt
+)
+
+const recordHeaderSize = 8
+
+func encodeBatch(b *batch) ([]byte, error) {
+	payload := make([]byte, recordHeaderSize, 512)
+	payload = appendString(payload, b.view.Name)
+	payload = appendUvarint(payload, uint64(len(b.view.Columns)))
+	for _, column := range b.view.Columns {
+		payload = appendString(payload, column)
+	}
+	payload = appendUvarint(payload, uint64(len(b.rows)))
+	for _, row := range b.rows {
+		payload = appendUvarint(payload, uint64(len(row)))
+		for _, value := range row {
+			var err error
+			if payload, err = appendValue(payload, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(payload[0:4], uint32(len(payload)-recordHeaderSize))
+	binary.BigEndian.PutUint32(payload[4:8], crc32.ChecksumIEEE(payload[recordHeaderSize:]))
+	return payload, nil
+}
+
+func appendString(buf []byte, value string) []byte {
+	buf = appendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// nolint:gocyclo // it's ok, one case per type
+func appendValue(buf []byte, value interface{}) ([]byte, error) {
+	switch it := value.(type) {
+	case nil:
+		return append(buf, typeNil), nil
+	case string:
+		return appendString(append(buf, typeString), it), nil
+	case uint8:
+		return append(buf, typeUInt8, it), nil
+	case uint16:
+		return appendUint16(append(buf, typeUInt16), it), nil
+	case uint32:
+		return appendUint32(append(buf, typeUInt32), it), nil
+	case uint64:
+		return appendUint64(append(buf, typeUInt64), it), nil
+	case uint:
+		return appendUint64(append(buf, typeUInt), uint64(it)), nil
+	case int8:
+		return append(buf, typeInt8, byte(it)), nil
+	case int16:
+		return appendUint16(append(buf, typeInt16), uint16(it)), nil
+	case int32:
+		return appendUint32(append(buf, typeInt32), uint32(it)), nil
+	case int64:
+		return appendUint64(append(buf, typeInt64), uint64(it)), nil
+	case int:
+		return appendUint64(append(buf, typeInt), uint64(it)), nil
+	case float32:
+		return appendUint32(append(buf, typeFloat32), math.Float32bits(it)), nil
+	case float64:
+		return appendUint64(append(buf, typeFloat64), math.Float64bits(it)), nil
+	case bool:
+		if it {
+			return append(buf, typeBool, 1), nil
+		}
+		return append(buf, typeBool, 0), nil
+	case time.Time:
+		encoded, err := it.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return append(appendUvarint(append(buf, typeTime), uint64(len(encoded))), encoded...), nil
+	}
+	return nil, fmt.Errorf("%w: %T", errUnsupportedType, value)
+}
+
+func appendUvarint(buf []byte, value uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], value)]...)
+}
+
+func appendUint16(buf []byte, value uint16) []byte {
+	return append(buf, byte(value>>8), byte(value))
+}
+
+func appendUint32(buf []byte, value uint32) []byte {
+	return append(buf, byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
+
+func appendUint64(buf []byte, value uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(value>>32)), uint32(value))
+}
+
+type payloadReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+var errCorruptedRecord = errors.New("corrupted record")
+
+func (r *payloadReader) next(n int) []byte {
+	if r.err != nil || n < 0 || r.pos+n > len(r.buf) {
+		r.err = errCorruptedRecord
+		return make([]byte, n)
+	}
+	value := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return value
+}
+
+func (r *payloadReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.err = errCorruptedRecord
+		return 0
+	}
+	r.pos += n
+	return value
+}
+
+// length reads length of collection, it can't be greater than rest of payload
+func (r *payloadReader) length() int {
+	value := r.uvarint()
+	if value > uint64(len(r.buf)-r.pos) {
+		r.err = errCorruptedRecord
+		return 0
+	}
+	return int(value)
+}
+
+func (r *payloadReader) string() string {
+	return string(r.next(r.length()))
+}
+
+// nolint:gocyclo // it's ok, one case per type
+func (r *payloadReader) value() interface{} {
+	switch tag := r.next(1)[0]; tag {
+	case typeNil:
+		return nil
+	case typeString:
+		return r.string()
+	case typeUInt8:
+		return r.next(1)[0]
+	case typeUInt16:
+		return binary.BigEndian.Uint16(r.next(2))
+	case typeUInt32:
+		return binary.BigEndian.Uint32(r.next(4))
+	case typeUInt64:
+		return binary.BigEndian.Uint64(r.next(8))
+	case typeUInt:
+		return uint(binary.BigEndian.Uint64(r.next(8)))
+	case typeInt8:
+		return int8(r.next(1)[0])
+	case typeInt16:
+		return int16(binary.BigEndian.Uint16(r.next(2)))
+	case typeInt32:
+		return int32(binary.BigEndian.Uint32(r.next(4)))
+	case typeInt64:
+		return int64(binary.BigEndian.Uint64(r.next(8)))
+	case typeInt:
+		return int(binary.BigEndian.Uint64(r.next(8)))
+	case typeFloat32:
+		return math.Float32frombits(binary.BigEndian.Uint32(r.next(4)))
+	case typeFloat64:
+		return math.Float64frombits(binary.BigEndian.Uint64(r.next(8)))
+	case typeBool:
+		return r.next(1)[0] == 1
+	case typeTime:
+		t := time.Time{}
+		if err := t.UnmarshalBinary(r.next(r.length())); err != nil && r.err == nil {
+			r.err = err
+		}
+		return t
+	}
+	r.err = errCorruptedRecord
+	return nil
+}
+
+func decodeBatch(payload []byte) (*batch, error) {
+	r := &payloadReader{buf: payload}
+	b := &batch{}
+	b.view.Name = r.string()
+	b.view.Columns = make([]string, r.length())
+	for i := range b.view.Columns {
+		b.view.Columns[i] = r.string()
+	}
+	b.rows = make([]cx.Vector, r.length())
+	for i := range b.rows {
+		row := make(cx.Vector, r.length())
+		for j := range row {
+			row[j] = r.value()
+		}
+		b.rows[i] = row
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return b, nil
+}
+
+// readSegment returns all valid batches of segment file, reading stops at the first broken record,
+// it may be partially written before crash
+func readSegment(filepath string) ([]*batch, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	reader := bufio.NewReader(file)
+	var batches []*batch
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return batches, nil
+			}
+			return batches, fmt.Errorf("%s: %w", filepath, errCorruptedRecord)
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return batches, fmt.Errorf("%s: %w", filepath, errCorruptedRecord)
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			return batches, fmt.Errorf("%s: %w", filepath, errCorruptedRecord)
+		}
+		b, err := decodeBatch(payload)
+		if err != nil {
+			return batches, fmt.Errorf("%s: %w", filepath, err)
+		}
+		batches = append(batches, b)
+	}
+}
@@ -0,0 +1,425 @@
+// Package spool keeps batches, which can't be inserted to ClickHouse, in append-only segment files on disk
+// and replays them in the same order once ClickHouse is available again
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/log"
+	"github.com/zikwall/grower/pkg/metrics"
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+const (
+	segmentPrefix    = "segment-"
+	segmentExtension = ".spool"
+	// segmentMaxSize size of segment file, after which new segment is started
+	segmentMaxSize = 16 << 20
+	replayInterval = time.Second
+	insertTimeout  = time.Minute
+)
+
+type segment struct {
+	path    string
+	size    int64
+	modTime time.Time
+	// replaying segment is claimed by replay and is not removed by limits until replay releases it
+	replaying bool
+	removed   bool
+}
+
+// Spool wraps clickhouse connection: batch is written to disk if insert fails or if too many rows
+// are already waiting for insert in memory, e.g. clickhouse is slow and buffers of writers are full,
+// while there are batches on disk, new batches are also written to disk, so the order of batches is kept
+type Spool struct {
+	cx.Clickhouse
+	mu         sync.Mutex
+	dir        string
+	maxSize    int64
+	maxAge     time.Duration
+	maxPending int64
+	// pending rows of inserts in progress
+	pending  int64
+	segments []*segment
+	active   *os.File
+	sequence uint64
+	size     int64
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+func (s *Spool) Insert(ctx context.Context, view cx.View, rows []cx.Vector) (uint64, error) {
+	s.mu.Lock()
+	backlog := len(s.segments) > 0
+	full := s.maxPending > 0 && s.pending > 0 && s.pending+int64(len(rows)) > s.maxPending
+	if backlog || full {
+		if full && !backlog {
+			log.Warningf("%d rows are waiting for clickhouse, batch of %d rows is written to spool", s.pending, len(rows))
+		}
+		err := s.write(&batch{view: view, rows: rows})
+		s.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		return uint64(len(rows)), nil
+	}
+	s.pending += int64(len(rows))
+	s.mu.Unlock()
+	affected, err := s.Clickhouse.Insert(ctx, view, rows)
+	s.mu.Lock()
+	s.pending -= int64(len(rows))
+	s.mu.Unlock()
+	if err == nil {
+		return affected, nil
+	}
+	log.Warningf("clickhouse is not available, batch of %d rows is written to spool: %s", len(rows), err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if spoolErr := s.write(&batch{view: view, rows: rows}); spoolErr != nil {
+		return 0, fmt.Errorf("%w, failed to write spool: %s", err, spoolErr)
+	}
+	return uint64(len(rows)), nil
+}
+
+// Close stops replaying and closes connection, batches which are not replayed stay on disk until the next start
+func (s *Spool) Close() error {
+	close(s.stop)
+	<-s.stopped
+	s.mu.Lock()
+	err := s.closeActive()
+	s.mu.Unlock()
+	if closeErr := s.Clickhouse.Close(); closeErr != nil {
+		return closeErr
+	}
+	return err
+}
+
+func (s *Spool) write(b *batch) error {
+	record, err := encodeBatch(b)
+	if err != nil {
+		return err
+	}
+	if s.active == nil || s.segments[len(s.segments)-1].size+int64(len(record)) > segmentMaxSize {
+		if err := s.openSegment(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.active.Write(record); err != nil {
+		return err
+	}
+	last := s.segments[len(s.segments)-1]
+	last.size += int64(len(record))
+	last.modTime = time.Now()
+	s.size += int64(len(record))
+	metrics.SpoolRows.WithLabelValues("written").Add(float64(len(b.rows)))
+	s.enforceMaxSize()
+	return nil
+}
+
+func (s *Spool) openSegment() error {
+	if err := s.closeActive(); err != nil {
+		log.Warning(err)
+	}
+	s.sequence++
+	path := filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", segmentPrefix, s.sequence, segmentExtension))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.active = file
+	s.segments = append(s.segments, &segment{path: path, modTime: time.Now()})
+	return nil
+}
+
+func (s *Spool) closeActive() error {
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Close()
+	s.active = nil
+	return err
+}
+
+// enforceMaxSize removes the oldest segments, if spool is larger than allowed,
+// the last segment and segment which is being replayed are never removed
+func (s *Spool) enforceMaxSize() {
+	for s.maxSize > 0 && s.size > s.maxSize {
+		var oldest *segment
+		for _, seg := range s.segments[:len(s.segments)-1] {
+			if !seg.replaying {
+				oldest = seg
+				break
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		s.drop(oldest, "spool size limit is exceeded")
+	}
+}
+
+// enforceMaxAge removes segments, which were not written for longer than allowed,
+// segment which is being replayed is removed by replay
+func (s *Spool) enforceMaxAge() {
+	if s.maxAge <= 0 {
+		return
+	}
+	for _, seg := range append([]*segment(nil), s.segments...) {
+		if time.Since(seg.modTime) <= s.maxAge {
+			return
+		}
+		if seg.replaying {
+			continue
+		}
+		if s.active != nil && seg == s.segments[len(s.segments)-1] {
+			if err := s.closeActive(); err != nil {
+				log.Warning(err)
+			}
+		}
+		s.drop(seg, "spool age limit is exceeded")
+	}
+}
+
+func (s *Spool) drop(seg *segment, reason string) {
+	batches, _ := readSegment(seg.path)
+	rows := 0
+	for _, b := range batches {
+		rows += len(b.rows)
+	}
+	log.Warningf("%s, segment %s with %d rows is removed", reason, seg.path, rows)
+	metrics.SpoolRows.WithLabelValues("dropped").Add(float64(rows))
+	s.remove(seg)
+}
+
+func (s *Spool) remove(seg *segment) {
+	seg.removed = true
+	for i := range s.segments {
+		if s.segments[i] == seg {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			s.size -= seg.size
+			break
+		}
+	}
+	if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warning(err)
+	}
+	metrics.SpoolBytes.Set(float64(s.size))
+}
+
+func (s *Spool) replayLoop() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(replayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.enforceMaxAge()
+			metrics.SpoolBytes.Set(float64(s.size))
+			s.mu.Unlock()
+			for s.replay() {
+			}
+		}
+	}
+}
+
+// replay inserts the oldest segment, returns true if segment is fully replayed and the next one may be replayed
+func (s *Spool) replay() bool {
+	s.mu.Lock()
+	if len(s.segments) == 0 {
+		s.mu.Unlock()
+		return false
+	}
+	seg := s.segments[0]
+	// new batches will be written to the next segment
+	if len(s.segments) == 1 {
+		if err := s.closeActive(); err != nil {
+			log.Warning(err)
+		}
+	}
+	// segment is claimed until it is rewritten or removed, so limits do not remove it under replay
+	seg.replaying = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		seg.replaying = false
+		s.mu.Unlock()
+	}()
+	batches, err := readSegment(seg.path)
+	if err != nil {
+		log.Warning(err)
+	}
+	for len(batches) > 0 {
+		select {
+		case <-s.stop:
+			return false
+		default:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), insertTimeout)
+		_, err := s.Clickhouse.Insert(ctx, batches[0].view, batches[0].rows)
+		cancel()
+		if err != nil {
+			// keep the rest of segment for the next attempt
+			if rewriteErr := s.rewrite(seg, batches); rewriteErr != nil {
+				log.Warning(rewriteErr)
+			}
+			return false
+		}
+		metrics.SpoolRows.WithLabelValues("replayed").Add(float64(len(batches[0].rows)))
+		batches = batches[1:]
+	}
+	s.mu.Lock()
+	s.remove(seg)
+	s.mu.Unlock()
+	log.Infof("spool segment %s is replayed", seg.path)
+	return true
+}
+
+// rewrite keeps only not replayed batches in segment, so they are not inserted twice
+func (s *Spool) rewrite(seg *segment, batches []*batch) error {
+	tmp := seg.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, b := range batches {
+		record, err := encodeBatch(b)
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		if _, err := file.Write(record); err != nil {
+			_ = file.Close()
+			return err
+		}
+		size += int64(len(record))
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// segment removed meanwhile must not be re-created
+	if seg.removed {
+		return os.Remove(tmp)
+	}
+	if err := os.Rename(tmp, seg.path); err != nil {
+		return err
+	}
+	s.size += size - seg.size
+	seg.size = size
+	return nil
+}
+
+// load finds segments, which were not replayed before restart
+func (s *Spool) load() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return err
+		}
+		var sequence uint64
+		if _, err := fmt.Sscanf(strings.TrimPrefix(name, segmentPrefix), "%d", &sequence); err != nil {
+			log.Warningf("unexpected spool file %s", name)
+			continue
+		}
+		if sequence > s.sequence {
+			s.sequence = sequence
+		}
+		s.segments = append(s.segments, &segment{
+			path:    filepath.Join(s.dir, name),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		s.size += info.Size()
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].path < s.segments[j].path
+	})
+	if len(s.segments) > 0 {
+		log.Infof("found %d spool segments of %d bytes, they will be replayed", len(s.segments), s.size)
+	}
+	return nil
+}
+
+// supportedTypes types of values, which can be written to segments, see appendValue
+var supportedTypes = map[string]struct{}{
+	nginx.UInt8:    {},
+	nginx.UInt16:   {},
+	nginx.UInt32:   {},
+	nginx.UInt64:   {},
+	nginx.Int8:     {},
+	nginx.Int16:    {},
+	nginx.Int32:    {},
+	nginx.Int64:    {},
+	nginx.Float32:  {},
+	nginx.Float64:  {},
+	nginx.String:   {},
+	nginx.Date:     {},
+	nginx.DateTime: {},
+}
+
+// Check returns error if spool is configured and values of some columns can't be written to it,
+// types are clickhouse types of columns, it is called at startup, so batches are never lost because of types
+func Check(cfg *config.Spool, types map[string]string) error {
+	if cfg.Dir == "" {
+		return nil
+	}
+	var unsupported []string
+	for column, valueType := range types {
+		if _, ok := supportedTypes[valueType]; !ok {
+			unsupported = append(unsupported, fmt.Sprintf("%s (%s)", column, valueType))
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return fmt.Errorf("%w of spool columns: %s", errUnsupportedType, strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// New wraps connection with spool if spool directory is configured, otherwise connection is returned as is
+func New(conn cx.Clickhouse, cfg *config.Spool) (cx.Clickhouse, error) {
+	if cfg.Dir == "" {
+		return conn, nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		Clickhouse: conn,
+		dir:        cfg.Dir,
+		maxSize:    cfg.MaxSize,
+		maxAge:     cfg.MaxAge,
+		maxPending: cfg.MaxPendingRows,
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	go s.replayLoop()
+	return s, nil
+}
@@ -0,0 +1,248 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zikwall/clickhouse-buffer/v4/src/cx"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+type fakeClickhouse struct {
+	rows []cx.Vector
+	err  error
+}
+
+func (f *fakeClickhouse) Insert(_ context.Context, _ cx.View, rows []cx.Vector) (uint64, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.rows = append(f.rows, rows...)
+	return uint64(len(rows)), nil
+}
+
+func (f *fakeClickhouse) Close() error {
+	return nil
+}
+
+func TestSegment(t *testing.T) {
+	t.Run("it should be successfully encoded and decoded", func(t *testing.T) {
+		now := time.Date(2022, 7, 21, 15, 41, 45, 0, time.FixedZone("MSK", 3*60*60))
+		expected := &batch{
+			view: cx.NewView("logs", []string{"a", "b"}),
+			rows: []cx.Vector{
+				{"GET", uint8(1), uint16(200), uint32(3), uint64(4), int8(-5), int16(-6), int32(-7), int64(-8)},
+				{float32(0.5), float64(1.5), true, now, nil, 9, uint(10)},
+			},
+		}
+		record, err := encodeBatch(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := decodeBatch(record[recordHeaderSize:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected.view, actual.view) {
+			t.Fatalf("expect %v, got %v", expected.view, actual.view)
+		}
+		for i := range expected.rows {
+			for j := range expected.rows[i] {
+				e, a := expected.rows[i][j], actual.rows[i][j]
+				if et, ok := e.(time.Time); ok {
+					if !et.Equal(a.(time.Time)) {
+						t.Fatalf("expect %v, got %v", e, a)
+					}
+					continue
+				}
+				if e != a {
+					t.Fatalf("expect %v (%T), got %v (%T)", e, e, a, a)
+				}
+			}
+		}
+	})
+
+	t.Run("it should be error for unsupported type", func(t *testing.T) {
+		if _, err := encodeBatch(&batch{rows: []cx.Vector{{struct{}{}}}}); !errors.Is(err, errUnsupportedType) {
+			t.Fatalf("expect %v, got %v", errUnsupportedType, err)
+		}
+	})
+
+	t.Run("it should be failed check of columns with unsupported types", func(t *testing.T) {
+		types := map[string]string{"status": nginx.UInt16, "headers": "Map(String, String)", "time": nginx.DateTime}
+		err := Check(&config.Spool{Dir: t.TempDir()}, types)
+		if !errors.Is(err, errUnsupportedType) || !strings.Contains(err.Error(), "headers (Map(String, String))") {
+			t.Fatalf("expect %v of headers, got %v", errUnsupportedType, err)
+		}
+		if err := Check(&config.Spool{}, types); err != nil {
+			t.Fatalf("expect no error without spool, got %v", err)
+		}
+		delete(types, "headers")
+		if err := Check(&config.Spool{Dir: t.TempDir()}, types); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	view := cx.NewView("logs", []string{"id"})
+	fake := &fakeClickhouse{err: errors.New("connection refused")}
+	newSpool := func() *Spool {
+		conn, err := New(fake, &config.Spool{Dir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn.(*Spool)
+	}
+	s := newSpool()
+
+	t.Run("it should be written to disk while clickhouse is not available", func(t *testing.T) {
+		if _, err := s.Insert(context.Background(), view, []cx.Vector{{1}, {2}}); err != nil {
+			t.Fatal(err)
+		}
+		fake.err = nil
+		// order is kept, so new batches are written after the previous ones
+		if _, err := s.Insert(context.Background(), view, []cx.Vector{{3}}); err != nil {
+			t.Fatal(err)
+		}
+		if len(fake.rows) != 0 {
+			t.Fatalf("expect nothing is inserted, got %v", fake.rows)
+		}
+	})
+
+	t.Run("it should be replayed after restart in the same order", func(t *testing.T) {
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		s = newSpool()
+		for s.replay() {
+		}
+		if !reflect.DeepEqual(fake.rows, []cx.Vector{{1}, {2}, {3}}) {
+			t.Fatalf("expect all rows in order, got %v", fake.rows)
+		}
+		if len(s.segments) != 0 || s.size != 0 {
+			t.Fatalf("expect empty spool, got %d segments of %d bytes", len(s.segments), s.size)
+		}
+		if _, err := s.Insert(context.Background(), view, []cx.Vector{{4}}); err != nil {
+			t.Fatal(err)
+		}
+		if len(fake.rows) != 4 {
+			t.Fatalf("expect direct insert, got %v", fake.rows)
+		}
+	})
+
+	t.Run("it should be kept the rest of segment if replay fails", func(t *testing.T) {
+		fake.err = errors.New("connection refused")
+		fake.rows = nil
+		for i := 0; i < 3; i++ {
+			if _, err := s.Insert(context.Background(), view, []cx.Vector{{i}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if s.replay() {
+			t.Fatal("expect replay fails")
+		}
+		fake.err = nil
+		for s.replay() {
+		}
+		if !reflect.DeepEqual(fake.rows, []cx.Vector{{0}, {1}, {2}}) {
+			t.Fatalf("expect all rows in order, got %v", fake.rows)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+type blockingClickhouse struct {
+	fakeClickhouse
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingClickhouse) Insert(ctx context.Context, view cx.View, rows []cx.Vector) (uint64, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return b.fakeClickhouse.Insert(ctx, view, rows)
+}
+
+func TestSpoolLimits(t *testing.T) {
+	view := cx.NewView("logs", []string{"id"})
+
+	t.Run("it should be written to disk while too many rows wait for insert", func(t *testing.T) {
+		fake := &blockingClickhouse{started: make(chan struct{}), release: make(chan struct{})}
+		conn, err := New(fake, &config.Spool{Dir: t.TempDir(), MaxPendingRows: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := conn.(*Spool)
+		done := make(chan error)
+		go func() {
+			_, err := s.Insert(context.Background(), view, []cx.Vector{{1}, {2}})
+			done <- err
+		}()
+		<-fake.started
+		if _, err := s.Insert(context.Background(), view, []cx.Vector{{3}}); err != nil {
+			t.Fatal(err)
+		}
+		s.mu.Lock()
+		segments := len(s.segments)
+		s.mu.Unlock()
+		if segments != 1 {
+			t.Fatalf("expect batch is written to disk, got %d segments", segments)
+		}
+		close(fake.release)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("it should not be removed or re-created segment under replay", func(t *testing.T) {
+		fake := &fakeClickhouse{err: errors.New("connection refused")}
+		conn, err := New(fake, &config.Spool{Dir: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := conn.(*Spool)
+		defer func() {
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		if _, err := s.Insert(context.Background(), view, []cx.Vector{{1}}); err != nil {
+			t.Fatal(err)
+		}
+		s.mu.Lock()
+		claimed := s.segments[0]
+		claimed.replaying = true
+		if err := s.openSegment(); err != nil {
+			t.Fatal(err)
+		}
+		s.maxSize = 1
+		if err := s.write(&batch{view: view, rows: []cx.Vector{{2}}}); err != nil {
+			t.Fatal(err)
+		}
+		if s.segments[0] != claimed {
+			t.Fatal("expect claimed segment is not removed by size limit")
+		}
+		s.remove(claimed)
+		s.mu.Unlock()
+		if err := s.rewrite(claimed, []*batch{{view: view, rows: []cx.Vector{{1}}}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(claimed.path); !os.IsNotExist(err) {
+			t.Fatalf("expect removed segment is not re-created, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+// Package tlsconfig builds TLS configurations of transports from certificate files,
+// files are checked on every handshake and reloaded after change, so certificates are rotated without restart
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/zikwall/grower/config"
+	"github.com/zikwall/grower/pkg/log"
+)
+
+// Server config presents certificate and key of options,
+// client certificates are required and verified by CA file, if it is provided
+func Server(opt *config.TLS) (*tls.Config, error) {
+	if opt.CertFile == "" || opt.KeyFile == "" {
+		return nil, errors.New("server TLS requires certificate and key files")
+	}
+	files := newCertificates(opt)
+	if _, _, err := files.load(); err != nil {
+		return nil, err
+	}
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _, err := files.load()
+			return cert, err
+		},
+	}
+	if opt.CAFile != "" {
+		// default verification uses fixed CA pool, so it is replaced with verification by the current pool
+		c.ClientAuth = tls.RequireAnyClientCert
+		c.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			_, pool, err := files.load()
+			if err != nil {
+				return err
+			}
+			certs := make([]*x509.Certificate, 0, len(rawCerts))
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs = append(certs, cert)
+			}
+			return verify(certs, x509.VerifyOptions{
+				Roots:     pool,
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			})
+		}
+	}
+	return c, nil
+}
+
+// Client config verifies server by CA file as the only trusted root or by system roots, if CA file is not provided,
+// certificate and key are presented to server, if they are provided.
+// Server name is taken from options or from host of connect address
+func Client(opt *config.TLS, address string) (*tls.Config, error) {
+	if (opt.CertFile == "") != (opt.KeyFile == "") {
+		return nil, errors.New("client TLS requires both certificate and key files or none of them")
+	}
+	serverName := opt.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("server name of %s: %w", address, err)
+		}
+		serverName = host
+	}
+	files := newCertificates(opt)
+	if _, _, err := files.load(); err != nil {
+		return nil, err
+	}
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+	if opt.CertFile != "" {
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _, err := files.load()
+			return cert, err
+		}
+	}
+	if opt.CAFile != "" {
+		// default verification uses fixed root pool, so it is replaced with verification by the current pinned pool
+		c.InsecureSkipVerify = true
+		c.VerifyConnection = func(state tls.ConnectionState) error {
+			_, pool, err := files.load()
+			if err != nil {
+				return err
+			}
+			return verify(state.PeerCertificates, x509.VerifyOptions{
+				DNSName: serverName,
+				Roots:   pool,
+			})
+		}
+	}
+	return c, nil
+}
+
+// verify leaf certificate of peer, the rest certificates of chain are intermediates
+func verify(certs []*x509.Certificate, opts x509.VerifyOptions) error {
+	if len(certs) == 0 {
+		return errors.New("peer did not present certificate")
+	}
+	opts.Intermediates = x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// certificates key pair and CA pool read from files, they are read again when modification time or size
+// of any file is changed, previous certificates are kept while new files are invalid, e.g. partially written
+type certificates struct {
+	opt    *config.TLS
+	mu     sync.Mutex
+	loaded bool
+	stamp  string
+	cert   *tls.Certificate
+	pool   *x509.CertPool
+}
+
+func newCertificates(opt *config.TLS) *certificates {
+	return &certificates{opt: opt}
+}
+
+func (c *certificates) load() (*tls.Certificate, *x509.CertPool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stamp, err := c.files()
+	if err == nil && c.loaded && stamp == c.stamp {
+		return c.cert, c.pool, nil
+	}
+	if err == nil {
+		var cert *tls.Certificate
+		var pool *x509.CertPool
+		if cert, pool, err = c.read(); err == nil {
+			if c.loaded {
+				log.Info("TLS certificates are reloaded")
+			}
+			c.loaded, c.stamp, c.cert, c.pool = true, stamp, cert, pool
+			return cert, pool, nil
+		}
+	}
+	if !c.loaded {
+		return nil, nil, err
+	}
+	log.Warningf("reload TLS certificates, previous are used: %v", err)
+	return c.cert, c.pool, nil
+}
+
+// files stamp of modification time and size of all files
+func (c *certificates) files() (string, error) {
+	stamp := ""
+	for _, name := range []string{c.opt.CertFile, c.opt.KeyFile, c.opt.CAFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%s:%d:%d;", name, info.ModTime().UnixNano(), info.Size())
+	}
+	return stamp, nil
+}
+
+func (c *certificates) read() (*tls.Certificate, *x509.CertPool, error) {
+	var cert *tls.Certificate
+	if c.opt.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(c.opt.CertFile, c.opt.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load key pair: %w", err)
+		}
+		cert = &pair
+	}
+	var pool *x509.CertPool
+	if c.opt.CAFile != "" {
+		content, err := os.ReadFile(c.opt.CAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, nil, fmt.Errorf("no certificates in CA file %s", c.opt.CAFile)
+		}
+	}
+	return cert, pool, nil
+}
@@ -0,0 +1,276 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zikwall/grower/config"
+)
+
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newAuthority(t *testing.T, name string) *authority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes certificate and key signed by authority to files
+func (a *authority) issue(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	write(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+// writes shifts modification time of every written file
+var writes int
+
+func write(t *testing.T, name string, content []byte) {
+	t.Helper()
+	if err := os.WriteFile(name, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// modification time may be the same on coarse file systems
+	writes++
+	future := time.Now().Add(time.Duration(writes) * time.Second)
+	if err := os.Chtimes(name, future, future); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake over loopback, in TLS 1.3 client finishes handshake before server verifies client certificate,
+// so the connection is also read to receive alert of server
+func handshake(t *testing.T, server, client *tls.Config) error {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+		err = conn.(*tls.Conn).Handshake()
+		if err == nil {
+			_, err = conn.Write([]byte{1})
+		}
+		errs <- err
+	}()
+	conn, err := tls.Dial("tcp", ln.Addr().String(), client)
+	if err != nil {
+		<-errs
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		<-errs
+		return err
+	}
+	return <-errs
+}
+
+type files struct {
+	dir string
+	ca  *authority
+}
+
+func newFiles(t *testing.T) *files {
+	f := &files{dir: t.TempDir(), ca: newAuthority(t, "grower CA")}
+	write(t, f.path("ca.pem"), f.ca.pem)
+	f.ca.issue(t, f.path("server.pem"), f.path("server.key"), "grower.local")
+	f.ca.issue(t, f.path("client.pem"), f.path("client.key"), "client")
+	return f
+}
+
+func (f *files) path(name string) string {
+	return filepath.Join(f.dir, name)
+}
+
+func (f *files) server() *config.TLS {
+	return &config.TLS{
+		Enabled:  true,
+		CertFile: f.path("server.pem"),
+		KeyFile:  f.path("server.key"),
+		CAFile:   f.path("ca.pem"),
+	}
+}
+
+func (f *files) client() *config.TLS {
+	return &config.TLS{
+		Enabled:  true,
+		CertFile: f.path("client.pem"),
+		KeyFile:  f.path("client.key"),
+		CAFile:   f.path("ca.pem"),
+	}
+}
+
+func TestTLS(t *testing.T) {
+	t.Run("it should be established mutual TLS with pinned CA", func(t *testing.T) {
+		f := newFiles(t)
+		server, err := Server(f.server())
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := Client(f.client(), "grower.local:3003")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := handshake(t, server, client); err != nil {
+			t.Fatal(err)
+		}
+		// server name is taken from IP address of connect address
+		client, err = Client(f.client(), "127.0.0.1:3003")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := handshake(t, server, client); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("it should be rejected client without certificate", func(t *testing.T) {
+		f := newFiles(t)
+		server, err := Server(f.server())
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt := f.client()
+		opt.CertFile, opt.KeyFile = "", ""
+		client, err := Client(opt, "grower.local:3003")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := handshake(t, server, client); err == nil {
+			t.Fatal("expect error, got nil")
+		}
+	})
+
+	t.Run("it should be rejected server which is not signed by pinned CA", func(t *testing.T) {
+		f := newFiles(t)
+		server, err := Server(f.server())
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt := f.client()
+		opt.CAFile = f.path("other.pem")
+		write(t, opt.CAFile, newAuthority(t, "other CA").pem)
+		client, err := Client(opt, "grower.local:3003")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := handshake(t, server, client); err == nil {
+			t.Fatal("expect error, got nil")
+		}
+		opt = f.client()
+		opt.ServerName = "other.local"
+		if client, err = Client(opt, "grower.local:3003"); err != nil {
+			t.Fatal(err)
+		}
+		if err := handshake(t, server, client); err == nil {
+			t.Fatal("expect error of server name, got nil")
+		}
+	})
+
+	t.Run("it should be reloaded certificates after change of files", func(t *testing.T) {
+		f := newFiles(t)
+		server, err := Server(f.server())
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := Client(f.client(), "grower.local:3003")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := handshake(t, server, client); err != nil {
+			t.Fatal(err)
+		}
+		// both sides are moved to new CA without restart
+		ca := newAuthority(t, "new CA")
+		write(t, f.path("ca.pem"), ca.pem)
+		ca.issue(t, f.path("server.pem"), f.path("server.key"), "grower.local")
+		ca.issue(t, f.path("client.pem"), f.path("client.key"), "client")
+		if err := handshake(t, server, client); err != nil {
+			t.Fatal(err)
+		}
+		// broken file keeps previous certificates
+		write(t, f.path("server.key"), []byte("broken"))
+		if err := handshake(t, server, client); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("it should be failed without files", func(t *testing.T) {
+		if _, err := Server(&config.TLS{Enabled: true}); err == nil {
+			t.Fatal("expect error, got nil")
+		}
+		if _, err := Client(&config.TLS{Enabled: true, CAFile: "/not/exists.pem"}, "grower.local:3003"); err == nil {
+			t.Fatal("expect error, got nil")
+		}
+		if _, err := Client(&config.TLS{Enabled: true, CertFile: "client.pem"}, "grower.local:3003"); err == nil {
+			t.Fatal("expect error, got nil")
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package useragent
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cache of the least recently used parsed headers
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	header string
+	ua     UserAgent
+}
+
+func newCache(size int) *cache {
+	return &cache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *cache) get(header string) (UserAgent, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[header]
+	if !ok {
+		return UserAgent{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).ua, true
+}
+
+// add header to cache, the least recently used header is evicted if cache is full
+func (c *cache) add(header string, ua UserAgent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[header]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).header)
+	}
+	c.entries[header] = c.order.PushFront(&cacheEntry{header: header, ua: ua})
+}
@@ -0,0 +1,68 @@
+package useragent
+
+import (
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+// Enricher derives fields of http_user_agent, only referenced fields are derived,
+// fields of log format are not replaced
+type Enricher struct {
+	parser  *Parser
+	fields  []string
+	derived map[string]struct{}
+}
+
+// NewEnricher loads database if any of referenced fields is field of User-Agent, otherwise enricher is nil
+func NewEnricher(path string, cacheSize int, referenced []string) (*Enricher, error) {
+	e := &Enricher{derived: map[string]struct{}{}}
+	for _, field := range referenced {
+		if _, ok := e.derived[field]; ok {
+			continue
+		}
+		switch field {
+		case nginx.UABrowser, nginx.UABrowserVersion, nginx.UAOS, nginx.UADeviceType, nginx.UAIsBot:
+			e.derived[field] = struct{}{}
+			e.fields = append(e.fields, field)
+		}
+	}
+	if len(e.fields) == 0 {
+		return nil, nil
+	}
+	parser, err := New(path, cacheSize)
+	if err != nil {
+		return nil, err
+	}
+	e.parser = parser
+	return e, nil
+}
+
+func (e *Enricher) Fields() []string {
+	return e.fields
+}
+
+func (e *Enricher) Sources() []string {
+	return []string{nginx.HTTPUserAgent}
+}
+
+func (e *Enricher) Enrich(entry *nginx.LogEntry) {
+	ua := e.parser.Parse(entry.Fields()[nginx.HTTPUserAgent])
+	bot := "0"
+	if ua.Bot {
+		bot = "1"
+	}
+	e.set(entry, nginx.UABrowser, ua.Browser)
+	e.set(entry, nginx.UABrowserVersion, ua.BrowserVersion)
+	e.set(entry, nginx.UAOS, ua.OS)
+	e.set(entry, nginx.UADeviceType, ua.DeviceType)
+	e.set(entry, nginx.UAIsBot, bot)
+}
+
+// set field if it is referenced and is not set by parser
+func (e *Enricher) set(entry *nginx.LogEntry, field, value string) {
+	if _, ok := e.derived[field]; !ok {
+		return
+	}
+	if _, ok := entry.Fields()[field]; !ok {
+		entry.SetField(field, value)
+	}
+}
@@ -0,0 +1,131 @@
+// Package useragent parses User-Agent header offline by database of regular expressions: browser and its version,
+// operating system, type of device and whether client is bot, database is embedded and may be replaced by newer file
+package useragent
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed regexes.yaml
+var embedded []byte
+
+// Types of devices which are not set by rules of database, device of unknown type is desktop
+const (
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+const defaultCacheSize = 10000
+
+// UserAgent parsed header, all fields are empty if header is empty
+type UserAgent struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	DeviceType     string
+	Bot            bool
+}
+
+// rule name and version are templates of submatches of regular expression, e.g. $1
+type rule struct {
+	Regex   string `yaml:"regex"`
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	re      *regexp.Regexp
+}
+
+// database the first matched rule of every section is used, names of devices are types of devices
+type database struct {
+	Bots     []*rule `yaml:"bots"`
+	Browsers []*rule `yaml:"browsers"`
+	OS       []*rule `yaml:"os"`
+	Devices  []*rule `yaml:"devices"`
+}
+
+func loadDatabase(path string) (*database, error) {
+	content := embedded
+	if path != "" {
+		var err error
+		if content, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	db := &database{}
+	if err := yaml.Unmarshal(content, db); err != nil {
+		return nil, fmt.Errorf("user agent database: %w", err)
+	}
+	for section, rules := range map[string][]*rule{"bots": db.Bots, "browsers": db.Browsers, "os": db.OS, "devices": db.Devices} {
+		for i, r := range rules {
+			re, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("user agent database: rule %d of %s: %w", i, section, err)
+			}
+			r.re = re
+		}
+	}
+	return db, nil
+}
+
+// match returns name and version of the first matched rule
+func match(rules []*rule, header string) (name, version string, ok bool) {
+	for _, r := range rules {
+		submatches := r.re.FindStringSubmatchIndex(header)
+		if submatches == nil {
+			continue
+		}
+		name = string(r.re.ExpandString(nil, r.Name, header, submatches))
+		version = string(r.re.ExpandString(nil, r.Version, header, submatches))
+		return name, version, true
+	}
+	return "", "", false
+}
+
+// Parser parses headers by database, parsed headers are kept in LRU cache, because headers repeat heavily
+type Parser struct {
+	db    *database
+	cache *cache
+}
+
+// New loads database from file, embedded database is used if path is empty
+func New(path string, cacheSize int) (*Parser, error) {
+	db, err := loadDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	if cacheSize <= 0 {
+		cacheSize = defaultCacheSize
+	}
+	return &Parser{db: db, cache: newCache(cacheSize)}, nil
+}
+
+// Parse is safe for concurrent use
+func (p *Parser) Parse(header string) UserAgent {
+	if header == "" || header == "-" {
+		return UserAgent{}
+	}
+	if ua, ok := p.cache.get(header); ok {
+		return ua
+	}
+	ua := p.parse(header)
+	p.cache.add(header, ua)
+	return ua
+}
+
+func (p *Parser) parse(header string) UserAgent {
+	ua := UserAgent{DeviceType: DeviceDesktop}
+	if name, version, ok := match(p.db.Bots, header); ok {
+		ua.Browser, ua.BrowserVersion, ua.DeviceType, ua.Bot = name, version, DeviceBot, true
+	} else {
+		ua.Browser, ua.BrowserVersion, _ = match(p.db.Browsers, header)
+		if device, _, ok := match(p.db.Devices, header); ok {
+			ua.DeviceType = device
+		}
+	}
+	ua.OS, _, _ = match(p.db.OS, header)
+	return ua
+}
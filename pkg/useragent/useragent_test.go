@@ -0,0 +1,124 @@
+package useragent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zikwall/grower/pkg/nginx"
+)
+
+// nolint:lll // it's OK
+var cases = map[string]UserAgent{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36": {
+		Browser: "Chrome", BrowserVersion: "120.0.0.0", OS: "Windows", DeviceType: DeviceDesktop,
+	},
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91": {
+		Browser: "Edge", BrowserVersion: "120.0.2210.91", OS: "Windows", DeviceType: DeviceDesktop,
+	},
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1": {
+		Browser: "Safari", BrowserVersion: "17.2", OS: "iOS", DeviceType: "mobile",
+	},
+	"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Safari/537.36": {
+		Browser: "Chrome", BrowserVersion: "120.0.6099.144", OS: "Android", DeviceType: "tablet",
+	},
+	"Mozilla/5.0 (Linux; Android 9; CUBOT X19) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Mobile Safari/537.36": {
+		Browser: "Chrome", BrowserVersion: "119.0.0.0", OS: "Android", DeviceType: "mobile",
+	},
+	"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0": {
+		Browser: "Firefox", BrowserVersion: "121.0", OS: "Linux", DeviceType: DeviceDesktop,
+	},
+	"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)": {
+		Browser: "Googlebot", BrowserVersion: "2.1", DeviceType: DeviceBot, Bot: true,
+	},
+	"Mozilla/5.0 (compatible; YandexBot/3.0; +http://yandex.com/bots)": {
+		Browser: "YandexBot", BrowserVersion: "3.0", DeviceType: DeviceBot, Bot: true,
+	},
+	"curl/8.4.0": {
+		Browser: "curl", BrowserVersion: "8.4.0", DeviceType: DeviceBot, Bot: true,
+	},
+	"kube-probe/1.28": {
+		Browser: "kube-probe", BrowserVersion: "1.28", DeviceType: DeviceBot, Bot: true,
+	},
+	"-": {},
+}
+
+func TestParser(t *testing.T) {
+	parser, err := New("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it should be parsed headers by embedded database", func(t *testing.T) {
+		for header, expect := range cases {
+			// the second time header may be taken from cache
+			for i := 0; i < 2; i++ {
+				if ua := parser.Parse(header); ua != expect {
+					t.Fatalf("%s: expect %+v, got %+v", header, expect, ua)
+				}
+			}
+		}
+	})
+
+	t.Run("it should be evicted the least recently used header", func(t *testing.T) {
+		cache := newCache(2)
+		cache.add("a", UserAgent{Browser: "a"})
+		cache.add("b", UserAgent{Browser: "b"})
+		cache.get("a")
+		cache.add("c", UserAgent{Browser: "c"})
+		if _, ok := cache.get("b"); ok {
+			t.Fatal("expect evicted header b")
+		}
+		for _, header := range []string{"a", "c"} {
+			if ua, ok := cache.get(header); !ok || ua.Browser != header {
+				t.Fatalf("expect cached header %s", header)
+			}
+		}
+	})
+
+	t.Run("it should be loaded database from file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "regexes.yaml")
+		if err := os.WriteFile(file, []byte("browsers:\n  - {regex: 'Grower/(\\d+)', name: Grower, version: $1}\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		custom, err := New(file, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ua := custom.Parse("Grower/7"); ua.Browser != "Grower" || ua.BrowserVersion != "7" || ua.DeviceType != DeviceDesktop {
+			t.Fatalf("unexpected user agent %+v", ua)
+		}
+		if err := os.WriteFile(file, []byte("os:\n  - {regex: '(', name: broken}\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(file, 0); err == nil {
+			t.Fatal("expect error of invalid regex")
+		}
+	})
+}
+
+func TestEnricher(t *testing.T) {
+	t.Run("it should be derived referenced fields", func(t *testing.T) {
+		enricher, err := NewEnricher("", 0, []string{nginx.RemoteAddr, nginx.UABrowser, nginx.UAIsBot})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := nginx.NewEntry()
+		entry.SetField(nginx.HTTPUserAgent, "Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)")
+		enricher.Enrich(entry)
+		for field, expect := range map[string]string{nginx.UABrowser: "Bingbot", nginx.UAIsBot: "1"} {
+			if value, err := entry.Field(field); err != nil || value != expect {
+				t.Fatalf("%s: expect %q, got %q (%v)", field, expect, value, err)
+			}
+		}
+		if _, ok := entry.Fields()[nginx.UAOS]; ok {
+			t.Fatal("expect not derived ua_os")
+		}
+	})
+
+	t.Run("it should be nil enricher without fields of user agent", func(t *testing.T) {
+		if enricher, err := NewEnricher("/does/not/exist.yaml", 0, []string{nginx.RemoteAddr}); enricher != nil || err != nil {
+			t.Fatalf("expect nil enricher, got %v, %v", enricher, err)
+		}
+	})
+}
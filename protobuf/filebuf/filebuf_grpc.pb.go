@@ -23,6 +23,7 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type FileBufferServiceClient interface {
 	CreateDataStreamer(ctx context.Context, opts ...grpc.CallOption) (FileBufferService_CreateDataStreamerClient, error)
+	CreateBatchStreamer(ctx context.Context, opts ...grpc.CallOption) (FileBufferService_CreateBatchStreamerClient, error)
 }
 
 type fileBufferServiceClient struct {
@@ -67,11 +68,43 @@ func (x *fileBufferServiceCreateDataStreamerClient) CloseAndRecv() (*Response, e
 	return m, nil
 }
 
+func (c *fileBufferServiceClient) CreateBatchStreamer(ctx context.Context, opts ...grpc.CallOption) (FileBufferService_CreateBatchStreamerClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileBufferService_ServiceDesc.Streams[1], "/filebuf.FileBufferService/CreateBatchStreamer", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileBufferServiceCreateBatchStreamerClient{stream}
+	return x, nil
+}
+
+type FileBufferService_CreateBatchStreamerClient interface {
+	Send(*Batch) error
+	Recv() (*Ack, error)
+	grpc.ClientStream
+}
+
+type fileBufferServiceCreateBatchStreamerClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileBufferServiceCreateBatchStreamerClient) Send(m *Batch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fileBufferServiceCreateBatchStreamerClient) Recv() (*Ack, error) {
+	m := new(Ack)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // FileBufferServiceServer is the server API for FileBufferService service.
 // All implementations must embed UnimplementedFileBufferServiceServer
 // for forward compatibility
 type FileBufferServiceServer interface {
 	CreateDataStreamer(FileBufferService_CreateDataStreamerServer) error
+	CreateBatchStreamer(FileBufferService_CreateBatchStreamerServer) error
 	mustEmbedUnimplementedFileBufferServiceServer()
 }
 
@@ -82,6 +115,9 @@ type UnimplementedFileBufferServiceServer struct {
 func (UnimplementedFileBufferServiceServer) CreateDataStreamer(FileBufferService_CreateDataStreamerServer) error {
 	return status.Errorf(codes.Unimplemented, "method CreateDataStreamer not implemented")
 }
+func (UnimplementedFileBufferServiceServer) CreateBatchStreamer(FileBufferService_CreateBatchStreamerServer) error {
+	return status.Errorf(codes.Unimplemented, "method CreateBatchStreamer not implemented")
+}
 func (UnimplementedFileBufferServiceServer) mustEmbedUnimplementedFileBufferServiceServer() {}
 
 // UnsafeFileBufferServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -121,6 +157,32 @@ func (x *fileBufferServiceCreateDataStreamerServer) Recv() (*Request, error) {
 	return m, nil
 }
 
+func _FileBufferService_CreateBatchStreamer_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileBufferServiceServer).CreateBatchStreamer(&fileBufferServiceCreateBatchStreamerServer{stream})
+}
+
+type FileBufferService_CreateBatchStreamerServer interface {
+	Send(*Ack) error
+	Recv() (*Batch, error)
+	grpc.ServerStream
+}
+
+type fileBufferServiceCreateBatchStreamerServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileBufferServiceCreateBatchStreamerServer) Send(m *Ack) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fileBufferServiceCreateBatchStreamerServer) Recv() (*Batch, error) {
+	m := new(Batch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // FileBufferService_ServiceDesc is the grpc.ServiceDesc for FileBufferService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -134,6 +196,12 @@ var FileBufferService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _FileBufferService_CreateDataStreamer_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "CreateBatchStreamer",
+			Handler:       _FileBufferService_CreateBatchStreamer_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "filebuf.proto",
 }